@@ -0,0 +1,450 @@
+// Package antientropy finds and repairs differences between two replicas of the key space.
+//
+// Each replica keeps a Merkle tree over ranges of key hashes. A repair walks both trees from the root,
+// only descending into nodes whose hashes differ, so replicas that agree are compared in one round trip
+// and replicas that differ by a few keys in a few more. The keys of the differing leaves are then
+// fetched from both sides and only the keys that differ are sent across.
+package antientropy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kvstore/store"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TreePath is where a replica serves the hashes of a level of its Merkle tree, relative to the
+	// server root.
+	TreePath = "/kvs/antientropy/tree"
+
+	// KeysPath is where a replica serves the keys in a set of leaves.
+	KeysPath = "/kvs/antientropy/keys"
+
+	// ApplyPath is where a replica takes the keys repaired from another replica.
+	ApplyPath = "/kvs/antientropy/apply"
+
+	// LeafBatch is how many leaves' keys are fetched per request.
+	LeafBatch = 64
+
+	// DefaultInterval is how often a scheduled repair runs.
+	DefaultInterval = 10 * time.Minute
+)
+
+// ErrNoPeer is returned for a repair without a peer to repair against.
+var ErrNoPeer = errors.New("antientropy: no peer to repair against")
+
+// Mode is the direction of a repair.
+type Mode string
+
+const (
+	// ModeSync repairs both replicas. A key held by one only is copied to the other, unless the other
+	// deleted it after it was last written, in which case it is deleted. A key held by both with different
+	// content is replaced by the most recently updated copy.
+	ModeSync Mode = "sync"
+
+	// ModePull makes the local replica match the peer, deleting keys the peer does not hold.
+	ModePull Mode = "pull"
+
+	// ModePush makes the peer match the local replica, deleting keys the local replica does not hold.
+	ModePush Mode = "push"
+)
+
+// ParseMode converts a string into a Mode, defaulting to ModeSync.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(s)); m {
+	case "":
+		return ModeSync, nil
+	case ModeSync, ModePull, ModePush:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown repair mode %q, want sync, pull or push", s)
+}
+
+// Replica is one side of a repair.
+type Replica interface {
+	// Hashes returns the hashes of the given nodes at a level of the Merkle tree.
+	Hashes(ctx context.Context, level int, nodes []int) ([]uint64, error)
+	// Items returns the keys in the given leaves with their metadata, and the keys in them deleted
+	// within the tombstone retention.
+	Items(ctx context.Context, leaves []int) (KeysResponse, error)
+	// Apply stores the given changes as they are, metadata included, and returns how many were applied.
+	Apply(ctx context.Context, changes []store.Change) (int, error)
+}
+
+// TreeRequest asks for the hashes of Nodes at Level of the Merkle tree.
+type TreeRequest struct {
+	Level int   `json:"level"`
+	Nodes []int `json:"nodes"`
+}
+
+// TreeResponse holds the hashes asked for, in the order asked for.
+type TreeResponse struct {
+	Hashes []uint64 `json:"hashes"`
+}
+
+// KeysRequest asks for the keys in Leaves.
+type KeysRequest struct {
+	Leaves []int `json:"leaves"`
+}
+
+// KeysResponse holds the keys asked for, and when the keys deleted among them were deleted.
+type KeysResponse struct {
+	Items   map[string]store.Item `json:"items"`
+	Deleted map[string]time.Time  `json:"deleted,omitempty"`
+}
+
+// ApplyRequest holds the changes a repair makes to a replica.
+type ApplyRequest struct {
+	Changes []store.Change `json:"changes"`
+}
+
+// ApplyResponse tells how many changes were applied.
+type ApplyResponse struct {
+	Applied int `json:"applied"`
+}
+
+// Peer is a replica on another server, reached over HTTP.
+type Peer struct {
+	URL    string       // Base URL, e.g. http://localhost:8081
+	Client *http.Client // http.DefaultClient if nil
+}
+
+func (p *Peer) Hashes(ctx context.Context, level int, nodes []int) ([]uint64, error) {
+	var resp TreeResponse
+	if err := p.post(ctx, TreePath, TreeRequest{Level: level, Nodes: nodes}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Hashes) != len(nodes) {
+		return nil, fmt.Errorf("%s returned %d hashes for %d nodes", p.URL, len(resp.Hashes), len(nodes))
+	}
+	return resp.Hashes, nil
+}
+
+func (p *Peer) Items(ctx context.Context, leaves []int) (KeysResponse, error) {
+	var resp KeysResponse
+	err := p.post(ctx, KeysPath, KeysRequest{Leaves: leaves}, &resp)
+	return resp, err
+}
+
+func (p *Peer) Apply(ctx context.Context, changes []store.Change) (int, error) {
+	var resp ApplyResponse
+	if err := p.post(ctx, ApplyPath, ApplyRequest{Changes: changes}, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Applied, nil
+}
+
+func (p *Peer) post(ctx context.Context, path string, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.URL, "/")+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s%s returned %s: %s", p.URL, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Report describes a finished repair.
+type Report struct {
+	Peer       string    `json:"peer"`
+	Mode       Mode      `json:"mode"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Leaves     int       `json:"leaves"` // Leaves whose hashes differed
+	Compared   int       `json:"compared"`
+	Repaired   int       `json:"repaired"`          // Keys changed on either side
+	Received   []string  `json:"received,omitzero"` // Keys stored locally
+	Sent       []string  `json:"sent,omitzero"`     // Keys stored on the peer
+	Deleted    []string  `json:"deleted,omitzero"`  // Keys deleted on the side being made to match
+}
+
+// Repair compares local with remote and sends each the keys it needs for mode. Peer only names the
+// remote replica in the report.
+func Repair(ctx context.Context, local, remote Replica, peer string, mode Mode) (rep Report, err error) {
+	rep = Report{Peer: peer, Mode: mode, StartedAt: time.Now()}
+	defer func() {
+		slices.Sort(rep.Received)
+		slices.Sort(rep.Sent)
+		slices.Sort(rep.Deleted)
+		rep.Repaired = len(rep.Received) + len(rep.Sent) + len(rep.Deleted)
+		rep.DurationMs = time.Since(rep.StartedAt).Milliseconds()
+	}()
+
+	leaves, err := differingLeaves(ctx, local, remote)
+	if err != nil {
+		return rep, err
+	}
+	rep.Leaves = len(leaves)
+
+	for batch := range slices.Chunk(leaves, LeafBatch) {
+		mine, err := local.Items(ctx, batch)
+		if err != nil {
+			return rep, err
+		}
+		theirs, err := remote.Items(ctx, batch)
+		if err != nil {
+			return rep, err
+		}
+
+		toLocal, toRemote := reconcile(mine, theirs, mode, &rep)
+		if len(toLocal) > 0 {
+			if _, err := local.Apply(ctx, toLocal); err != nil {
+				return rep, err
+			}
+		}
+		if len(toRemote) > 0 {
+			if _, err := remote.Apply(ctx, toRemote); err != nil {
+				return rep, err
+			}
+		}
+	}
+
+	return rep, nil
+}
+
+// differingLeaves walks both Merkle trees down from the root, one level per round trip, and returns the
+// leaves whose hashes differ.
+func differingLeaves(ctx context.Context, local, remote Replica) ([]int, error) {
+	nodes := []int{0}
+	for level := 0; ; level++ {
+		mine, err := local.Hashes(ctx, level, nodes)
+		if err != nil {
+			return nil, err
+		}
+		theirs, err := remote.Hashes(ctx, level, nodes)
+		if err != nil {
+			return nil, err
+		}
+
+		var differing []int
+		for i, n := range nodes {
+			if mine[i] != theirs[i] {
+				differing = append(differing, n)
+			}
+		}
+		if level == store.MerkleDepth || len(differing) == 0 {
+			return differing, nil
+		}
+
+		nodes = nodes[:0:0]
+		for _, n := range differing {
+			nodes = append(nodes, 2*n, 2*n+1)
+		}
+	}
+}
+
+// reconcile compares the keys of the same leaves on both sides and returns the changes each side needs.
+func reconcile(mine, theirs KeysResponse, mode Mode, rep *Report) (toLocal, toRemote []store.Change) {
+	set := func(k string, item store.Item) *store.Change {
+		return &store.Change{Op: store.ChangeSet, Key: k, Item: &item}
+	}
+	del := func(k string) *store.Change {
+		return &store.Change{Op: store.ChangeDelete, Key: k}
+	}
+	receive := func(c *store.Change) {
+		toLocal = append(toLocal, *c)
+		if c.Op == store.ChangeDelete {
+			rep.Deleted = append(rep.Deleted, c.Key)
+		} else {
+			rep.Received = append(rep.Received, c.Key)
+		}
+	}
+	send := func(c *store.Change) {
+		toRemote = append(toRemote, *c)
+		if c.Op == store.ChangeDelete {
+			rep.Deleted = append(rep.Deleted, c.Key)
+		} else {
+			rep.Sent = append(rep.Sent, c.Key)
+		}
+	}
+
+	for k, a := range mine.Items {
+		rep.Compared++
+		b, ok := theirs.Items[k]
+		switch {
+		case !ok && mode == ModePull:
+			receive(del(k))
+		case !ok && mode == ModeSync && deletedSince(theirs.Deleted, k, a):
+			receive(del(k))
+		case !ok:
+			send(set(k, a))
+		case store.Digest(k, a) == store.Digest(k, b):
+		case mode == ModePull:
+			receive(set(k, b))
+		case mode == ModePush:
+			send(set(k, a))
+		case newer(k, a, b):
+			send(set(k, a))
+		default:
+			receive(set(k, b))
+		}
+	}
+	for k, b := range theirs.Items {
+		if _, ok := mine.Items[k]; ok {
+			continue
+		}
+		rep.Compared++
+		if mode == ModePush || mode == ModeSync && deletedSince(mine.Deleted, k, b) {
+			send(del(k))
+		} else {
+			receive(set(k, b))
+		}
+	}
+	return toLocal, toRemote
+}
+
+// deletedSince reports whether deleted holds a deletion of key at or after the last write of item.
+func deletedSince(deleted map[string]time.Time, key string, item store.Item) bool {
+	t, ok := deleted[key]
+	return ok && !t.Before(item.Metadata.UpdatedAt)
+}
+
+// newer reports whether a is a more recent write of key than b. Ties are broken by write count and then
+// by digest, so both replicas pick the same winner.
+func newer(key string, a, b store.Item) bool {
+	if !a.Metadata.UpdatedAt.Equal(b.Metadata.UpdatedAt) {
+		return a.Metadata.UpdatedAt.After(b.Metadata.UpdatedAt)
+	}
+	if a.Metadata.WriteCount != b.Metadata.WriteCount {
+		return a.Metadata.WriteCount > b.Metadata.WriteCount
+	}
+	return store.Digest(key, a) > store.Digest(key, b)
+}
+
+// Repairer repairs the local replica against a peer on a schedule and on demand.
+type Repairer struct {
+	Local    Replica
+	Peer     string        // Base URL of the peer scheduled repairs run against, none if empty
+	Mode     Mode          // Mode of scheduled repairs
+	Interval time.Duration // Time between scheduled repairs, none if zero
+	Client   *http.Client  // Used to reach peers, http.DefaultClient if nil
+
+	run       sync.Mutex // Held while a repair runs, so repairs never overlap
+	mu        sync.Mutex
+	running   bool
+	last      *Report
+	lastError string
+	total     int
+}
+
+// Status describes the schedule and the last repair.
+type Status struct {
+	Peer       string  `json:"peer,omitempty"`
+	Mode       Mode    `json:"mode"`
+	IntervalMs int64   `json:"interval_ms"`
+	Running    bool    `json:"running"`
+	Repaired   int     `json:"repaired"` // Keys repaired since the server started
+	Last       *Report `json:"last,omitempty"`
+	LastError  string  `json:"last_error,omitempty"`
+}
+
+// Run repairs against Peer every Interval until ctx ends. It returns at once if either is unset.
+func (r *Repairer) Run(ctx context.Context) {
+	if r.Peer == "" || r.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := r.Repair(ctx, "", ""); err != nil {
+			log.Printf("Anti-entropy Error: %s", err)
+		}
+	}
+}
+
+// Repair repairs the local replica against peer in mode, defaulting to those of the schedule, and waits
+// for any repair already running to finish first.
+func (r *Repairer) Repair(ctx context.Context, peer string, mode Mode) (Report, error) {
+	if peer == "" {
+		peer = r.Peer
+	}
+	if mode == "" {
+		mode = r.Mode
+	}
+	if mode == "" {
+		mode = ModeSync
+	}
+	if peer == "" {
+		return Report{}, ErrNoPeer
+	}
+
+	r.run.Lock()
+	defer r.run.Unlock()
+	r.setRunning(true)
+	defer r.setRunning(false)
+
+	rep, err := Repair(ctx, r.Local, &Peer{URL: peer, Client: r.Client}, peer, mode)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last = &rep
+	r.total += rep.Repaired
+	r.lastError = ""
+	if err != nil {
+		r.lastError = err.Error()
+		return rep, err
+	}
+	log.Printf("Anti-entropy: repaired %d keys against %s (%s) in %dms", rep.Repaired, peer, mode, rep.DurationMs)
+	return rep, nil
+}
+
+func (r *Repairer) setRunning(running bool) {
+	r.mu.Lock()
+	r.running = running
+	r.mu.Unlock()
+}
+
+// Status returns the schedule and the report of the last repair.
+func (r *Repairer) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mode := r.Mode
+	if mode == "" {
+		mode = ModeSync
+	}
+	return Status{
+		Peer:       r.Peer,
+		Mode:       mode,
+		IntervalMs: r.Interval.Milliseconds(),
+		Running:    r.running,
+		Repaired:   r.total,
+		Last:       r.last,
+		LastError:  r.lastError,
+	}
+}
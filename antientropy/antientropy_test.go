@@ -0,0 +1,255 @@
+package antientropy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"kvstore/store"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// replica is a store with a lock standing in for the request loop.
+type replica struct {
+	mu    sync.Mutex
+	store *store.KVStore
+}
+
+func newReplica() *replica {
+	return &replica{store: store.NewKeyValueStore()}
+}
+
+func (r *replica) Hashes(_ context.Context, level int, nodes []int) ([]uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.store.MerkleHashes(level, nodes)
+}
+
+func (r *replica) Items(_ context.Context, leaves []int) (KeysResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	items, err := r.store.MerkleItems(leaves)
+	if err != nil {
+		return KeysResponse{}, err
+	}
+	deleted, err := r.store.MerkleTombstones(leaves)
+	return KeysResponse{Items: items, Deleted: deleted}, err
+}
+
+func (r *replica) Apply(_ context.Context, changes []store.Change) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range changes {
+		if err := r.store.ApplyChange(c); err != nil {
+			return i, err
+		}
+	}
+	return len(changes), nil
+}
+
+func (r *replica) add(key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store.Upsert(key, []byte(value))
+}
+
+func (r *replica) delete(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store.Delete(key)
+}
+
+func (r *replica) values() map[string]any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all, _ := r.store.GetAll()
+	b, _ := json.Marshal(all)
+	var values map[string]any
+	json.Unmarshal(b, &values)
+	return values
+}
+
+// serve exposes a replica the way the server does.
+func serve(t *testing.T, r *replica) *httptest.Server {
+	mux := http.NewServeMux()
+	handle := func(path string, f func(body json.RawMessage) (any, error)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+			var body json.RawMessage
+			json.NewDecoder(req.Body).Decode(&body)
+			out, err := f(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(out)
+		})
+	}
+	handle(TreePath, func(body json.RawMessage) (any, error) {
+		var req TreeRequest
+		json.Unmarshal(body, &req)
+		hashes, err := r.Hashes(context.Background(), req.Level, req.Nodes)
+		return TreeResponse{Hashes: hashes}, err
+	})
+	handle(KeysPath, func(body json.RawMessage) (any, error) {
+		var req KeysRequest
+		json.Unmarshal(body, &req)
+		return r.Items(context.Background(), req.Leaves)
+	})
+	handle(ApplyPath, func(body json.RawMessage) (any, error) {
+		var req ApplyRequest
+		json.Unmarshal(body, &req)
+		n, err := r.Apply(context.Background(), req.Changes)
+		return ApplyResponse{Applied: n}, err
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// diverged returns two replicas sharing most keys, each with a key of its own and a key they disagree
+// on, the remote copy of which is written later.
+func diverged() (local, remote *replica) {
+	local, remote = newReplica(), newReplica()
+	for i := range 500 {
+		key := fmt.Sprintf("key-%d", i)
+		local.add(key, fmt.Sprint(i))
+		remote.add(key, fmt.Sprint(i))
+	}
+	local.add("only-local", `"l"`)
+	remote.add("only-remote", `"r"`)
+	local.add("key-7", `"old"`)
+	time.Sleep(time.Millisecond)
+	remote.add("key-7", `"new"`)
+	return local, remote
+}
+
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]Mode{"": ModeSync, "sync": ModeSync, "PULL": ModePull, "push": ModePush} {
+		if got, err := ParseMode(s); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v, want %q", s, got, err, want)
+		}
+	}
+	if _, err := ParseMode("both"); err == nil {
+		t.Error("ParseMode(both) returned no error")
+	}
+}
+
+func TestRepairInSync(t *testing.T) {
+	local, remote := newReplica(), newReplica()
+	for i := range 100 {
+		local.add(fmt.Sprint(i), "1")
+		remote.add(fmt.Sprint(i), "1")
+	}
+
+	rep, err := Repair(context.Background(), local, remote, "remote", ModeSync)
+	if err != nil {
+		t.Fatalf("Repair() returned an error: %v", err)
+	}
+	if rep.Leaves != 0 || rep.Compared != 0 || rep.Repaired != 0 {
+		t.Errorf("Repair() of replicas in sync = %+v, want nothing compared", rep)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	tests := []struct {
+		mode     Mode
+		received []string
+		sent     []string
+		deleted  []string
+		key7     string
+		keys     int // Keys on both replicas afterwards
+	}{
+		{ModeSync, []string{"key-7", "only-remote"}, []string{"only-local"}, nil, "new", 502},
+		{ModePull, []string{"key-7", "only-remote"}, nil, []string{"only-local"}, "new", 501},
+		{ModePush, nil, []string{"key-7", "only-local"}, []string{"only-remote"}, "old", 501},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			local, remote := diverged()
+
+			rep, err := Repair(context.Background(), local, remote, "remote", tt.mode)
+			if err != nil {
+				t.Fatalf("Repair() returned an error: %v", err)
+			}
+			if !slices.Equal(rep.Received, tt.received) || !slices.Equal(rep.Sent, tt.sent) || !slices.Equal(rep.Deleted, tt.deleted) {
+				t.Errorf("Repair() received %v, sent %v, deleted %v, want %v, %v, %v",
+					rep.Received, rep.Sent, rep.Deleted, tt.received, tt.sent, tt.deleted)
+			}
+			if want := len(tt.received) + len(tt.sent) + len(tt.deleted); rep.Repaired != want {
+				t.Errorf("Repaired = %d, want %d", rep.Repaired, want)
+			}
+
+			a, b := local.values(), remote.values()
+			if len(a) != tt.keys || len(b) != tt.keys {
+				t.Errorf("replicas hold %d and %d keys, want %d", len(a), len(b), tt.keys)
+			}
+			if a["key-7"] != tt.key7 || b["key-7"] != tt.key7 {
+				t.Errorf("key-7 = %v and %v, want %q", a["key-7"], b["key-7"], tt.key7)
+			}
+
+			// A second pass finds nothing left to repair
+			rep, err = Repair(context.Background(), local, remote, "remote", tt.mode)
+			if err != nil || rep.Leaves != 0 {
+				t.Errorf("second Repair() = %+v, %v, want no differing leaves", rep, err)
+			}
+		})
+	}
+}
+
+func TestSyncKeepsDeletes(t *testing.T) {
+	local, remote := newReplica(), newReplica()
+	for i := range 100 {
+		local.add(fmt.Sprintf("key-%d", i), "1")
+		remote.add(fmt.Sprintf("key-%d", i), "1")
+	}
+	time.Sleep(time.Millisecond)
+	local.delete("key-3")
+	remote.delete("key-4")
+	local.delete("key-5")
+	time.Sleep(time.Millisecond)
+	remote.add("key-5", "2") // Written again after the delete, so it wins
+
+	rep, err := Repair(context.Background(), local, remote, "remote", ModeSync)
+	if err != nil {
+		t.Fatalf("Repair() returned an error: %v", err)
+	}
+	if !slices.Equal(rep.Deleted, []string{"key-3", "key-4"}) || !slices.Equal(rep.Received, []string{"key-5"}) {
+		t.Errorf("Repair() deleted %v and received %v, want key-3 and key-4 deleted and key-5 received", rep.Deleted, rep.Received)
+	}
+	a, b := local.values(), remote.values()
+	if len(a) != 98 || len(b) != 98 || a["key-5"] != float64(2) {
+		t.Errorf("replicas hold %d and %d keys and key-5 = %v, want 98 keys and key-5 = 2", len(a), len(b), a["key-5"])
+	}
+}
+
+func TestRepairer(t *testing.T) {
+	local, remote := diverged()
+	srv := serve(t, remote)
+
+	r := &Repairer{Local: local}
+	if _, err := r.Repair(context.Background(), "", ""); err != ErrNoPeer {
+		t.Errorf("Repair() without a peer = %v, want ErrNoPeer", err)
+	}
+
+	r.Peer = srv.URL
+	rep, err := r.Repair(context.Background(), "", "")
+	if err != nil {
+		t.Fatalf("Repair() returned an error: %v", err)
+	}
+	if rep.Mode != ModeSync || rep.Repaired != 3 {
+		t.Errorf("Repair() = %+v, want 3 keys repaired in sync mode", rep)
+	}
+
+	st := r.Status()
+	if st.Last == nil || st.Repaired != 3 || st.Running || st.LastError != "" {
+		t.Errorf("Status() = %+v", st)
+	}
+	if a, b := local.values(), remote.values(); len(a) != 502 || len(b) != 502 {
+		t.Errorf("replicas hold %d and %d keys, want 502", len(a), len(b))
+	}
+}
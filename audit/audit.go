@@ -0,0 +1,275 @@
+// Package audit records every mutation of the store to a rotating file and keeps a queryable index in memory.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxSize    = 10 << 20 // Rotate the file once it reaches 10MB
+	DefaultMaxBackups = 5        // Rotated files kept as <path>.1 ... <path>.N
+	DefaultMaxRecords = 100000   // Records kept in the in-memory index
+
+	// FlushInterval is how long a record may wait in memory before it is written to the file.
+	FlushInterval = time.Second
+)
+
+// Record is a single audited mutation.
+type Record struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Key      string    `json:"key,omitempty"`
+	OldHash  string    `json:"old_hash,omitempty"` // Hash of the value before the mutation, empty if there was none
+	NewHash  string    `json:"new_hash,omitempty"` // Hash of the value after the mutation, empty if the key is gone
+	Client   string    `json:"client,omitempty"`
+	Identity string    `json:"identity,omitempty"`
+}
+
+// Query filters records. Zero values match everything.
+type Query struct {
+	Key   string
+	Op    string
+	Since time.Time
+	Until time.Time
+	Limit int // Keep only the most recent Limit matches
+}
+
+// Log writes records to a rotating file and indexes them in memory. A Log is safe for concurrent use.
+//
+// Records are indexed at once but written to the file in batches, at most FlushInterval after they
+// were taken, so that recording a mutation never waits for the disk. Close writes the rest.
+type Log struct {
+	mu sync.Mutex
+
+	path       string
+	file       *os.File
+	buf        *bufio.Writer // Records not yet written to file
+	size       int64         // Size of the file once buf is flushed
+	quit       chan struct{} // Closed by Close to stop the flusher
+	MaxSize    int64
+	MaxBackups int
+
+	records    []Record            // Oldest first
+	byKey      map[string][]uint64 // Sequence numbers of the records for each key
+	seq        uint64
+	MaxRecords int
+}
+
+// Open creates a Log appending to the file at path. An empty path keeps records in memory only.
+func Open(path string) (*Log, error) {
+	l := &Log{
+		path:       path,
+		MaxSize:    DefaultMaxSize,
+		MaxBackups: DefaultMaxBackups,
+		byKey:      make(map[string][]uint64),
+		MaxRecords: DefaultMaxRecords,
+	}
+
+	if path == "" {
+		return l, nil
+	}
+
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	l.quit = make(chan struct{})
+	go l.flushEvery(FlushInterval)
+	return l, nil
+}
+
+// Close writes the buffered records and closes the underlying file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	close(l.quit)
+	err := l.buf.Flush()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
+
+// flushEvery writes the buffered records to the file every interval until the Log is closed. A failed
+// write is reported by the next call to Write.
+func (l *Log) flushEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			l.mu.Lock()
+			if l.file != nil {
+				l.buf.Flush()
+			}
+			l.mu.Unlock()
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// Write stamps rec with a sequence number, appends it to the file and indexes it.
+func (l *Log) Write(rec Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	rec.Seq = l.seq
+
+	l.records = append(l.records, rec)
+	l.byKey[rec.Key] = append(l.byKey[rec.Key], rec.Seq)
+	if len(l.records) > l.MaxRecords {
+		l.evict(len(l.records) - l.MaxRecords)
+	}
+
+	if l.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.MaxSize > 0 && l.size+int64(len(line)) > l.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.buf.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// Query returns the indexed records matching q, oldest first.
+func (l *Log) Query(q Query) []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var candidates []Record
+	if q.Key != "" {
+		first := l.firstSeq()
+		for _, seq := range l.byKey[q.Key] {
+			candidates = append(candidates, l.records[seq-first])
+		}
+	} else {
+		// Records are appended in time order, so skip straight to Since
+		start := 0
+		if !q.Since.IsZero() {
+			start = sort.Search(len(l.records), func(i int) bool { return !l.records[i].Time.Before(q.Since) })
+		}
+		candidates = l.records[start:]
+	}
+
+	matches := []Record{}
+	for _, rec := range candidates {
+		if q.Op != "" && rec.Op != q.Op {
+			continue
+		}
+		if !q.Since.IsZero() && rec.Time.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && rec.Time.After(q.Until) {
+			continue
+		}
+		matches = append(matches, rec)
+	}
+
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[len(matches)-q.Limit:]
+	}
+	return matches
+}
+
+// Hash returns a stable digest of a value for audit records. A nil value hashes to the empty string.
+func Hash(value any) string {
+	if value == nil {
+		return ""
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		b = fmt.Appendf(nil, "%v", value)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (l *Log) firstSeq() uint64 {
+	if len(l.records) == 0 {
+		return l.seq + 1
+	}
+	return l.records[0].Seq
+}
+
+// evict drops the n oldest records from the index.
+func (l *Log) evict(n int) {
+	for _, rec := range l.records[:n] {
+		seqs := l.byKey[rec.Key][1:] // The oldest record is always first in its key's list
+		if len(seqs) == 0 {
+			delete(l.byKey, rec.Key)
+		} else {
+			l.byKey[rec.Key] = seqs
+		}
+	}
+	l.records = append([]Record(nil), l.records[n:]...)
+}
+
+func (l *Log) openFile() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+
+	l.file = f
+	l.buf = bufio.NewWriter(f)
+	l.size = info.Size()
+	return nil
+}
+
+// rotate shifts <path>.N-1 to <path>.N, moves the live file to <path>.1 and starts a new one.
+func (l *Log) rotate() error {
+	if err := l.buf.Flush(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if l.MaxBackups > 0 {
+		for i := l.MaxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+
+	return l.openFile()
+}
@@ -0,0 +1,152 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	l, _ := Open("")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	records := []Record{
+		{Time: start, Op: "add", Key: "a"},
+		{Time: start.Add(time.Minute), Op: "add", Key: "b"},
+		{Time: start.Add(2 * time.Minute), Op: "update", Key: "a"},
+		{Time: start.Add(3 * time.Minute), Op: "delete", Key: "b"},
+	}
+	for _, rec := range records {
+		l.Write(rec)
+	}
+
+	tests := []struct {
+		description string
+		query       Query
+		want        []uint64
+	}{
+		{
+			description: "TestAll",
+			query:       Query{},
+			want:        []uint64{1, 2, 3, 4},
+		},
+		{
+			description: "TestKey",
+			query:       Query{Key: "a"},
+			want:        []uint64{1, 3},
+		},
+		{
+			description: "TestOp",
+			query:       Query{Op: "add"},
+			want:        []uint64{1, 2},
+		},
+		{
+			description: "TestTimeRange",
+			query:       Query{Since: start.Add(time.Minute), Until: start.Add(2 * time.Minute)},
+			want:        []uint64{2, 3},
+		},
+		{
+			description: "TestLimit",
+			query:       Query{Limit: 1},
+			want:        []uint64{4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			got := l.Query(tt.query)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Query() returned %d records, want %d", len(got), len(tt.want))
+			}
+			for i, rec := range got {
+				if rec.Seq != tt.want[i] {
+					t.Errorf("Query()[%d].Seq = %v, want %v", i, rec.Seq, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEvict(t *testing.T) {
+	l, _ := Open("")
+	l.MaxRecords = 2
+
+	l.Write(Record{Op: "add", Key: "a"})
+	l.Write(Record{Op: "add", Key: "b"})
+	l.Write(Record{Op: "update", Key: "a"})
+
+	if got := l.Query(Query{}); len(got) != 2 || got[0].Seq != 2 {
+		t.Errorf("Query() = %v, want records 2 and 3", got)
+	}
+	if got := l.Query(Query{Key: "a"}); len(got) != 1 || got[0].Seq != 3 {
+		t.Errorf("Query() by key = %v, want record 3", got)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() returned an error: %v", err)
+	}
+	defer l.Close()
+	l.MaxSize = 200
+	l.MaxBackups = 2
+
+	for range 20 {
+		if err := l.Write(Record{Op: "upsert", Key: "key", NewHash: Hash("value")}); err != nil {
+			t.Fatalf("Write() returned an error: %v", err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Errorf("Stat(%s) returned an error: %v", name, err)
+			continue
+		}
+		if info.Size() > l.MaxSize {
+			t.Errorf("%s is %d bytes, want at most %d", name, info.Size(), l.MaxSize)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s.3) error = %v, want not exist", path, err)
+	}
+}
+
+func TestBufferedWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() returned an error: %v", err)
+	}
+
+	// The record is indexed at once and reaches the file on Close at the latest
+	l.Write(Record{Op: "add", Key: "a"})
+	if got := l.Query(Query{Key: "a"}); len(got) != 1 {
+		t.Errorf("Query() = %v, want the record", got)
+	}
+	if b, _ := os.ReadFile(path); len(b) != 0 {
+		t.Errorf("file holds %q before a flush, want nothing", b)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() returned an error: %v", err)
+	}
+	if b, _ := os.ReadFile(path); !strings.Contains(string(b), `"key":"a"`) {
+		t.Errorf("file holds %q after Close(), want the record", b)
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash(nil) != "" {
+		t.Errorf("Hash(nil) = %q, want empty", Hash(nil))
+	}
+	if Hash("a") == Hash("b") {
+		t.Errorf("Hash() collided for different values")
+	}
+	if Hash(map[string]any{"x": 1.0, "y": 2.0}) != Hash(map[string]any{"y": 2.0, "x": 1.0}) {
+		t.Errorf("Hash() differs for equal maps")
+	}
+}
@@ -0,0 +1,88 @@
+package channels
+
+import (
+	"context"
+	"kvstore/antientropy"
+	"kvstore/store"
+)
+
+// AntiEntropy repairs this server against its peers, see the antientropy package.
+var AntiEntropy *antientropy.Repairer
+
+var (
+	MerkleHashesChannel     = make(chan Request)
+	MerkleItemsChannel      = make(chan Request)
+	AntiEntropyApplyChannel = make(chan Request)
+)
+
+// MerkleHashesRequest returns the hashes of the given nodes at level of the Merkle tree of the store.
+func MerkleHashesRequest(level int, nodes []int) (response Response) {
+	responseCh := make(chan Response)
+	MerkleHashesChannel <- Request{Options: antientropy.TreeRequest{Level: level, Nodes: nodes}, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// MerkleItemsRequest returns the keys in the given leaves of the Merkle tree with their metadata, and
+// the keys deleted in them, as an antientropy.KeysResponse.
+func MerkleItemsRequest(leaves []int) (response Response) {
+	responseCh := make(chan Response)
+	MerkleItemsChannel <- Request{Options: leaves, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// AntiEntropyApplyRequest applies the changes of a repair to the store and returns how many were applied.
+func AntiEntropyApplyRequest(changes []store.Change) (response Response) {
+	responseCh := make(chan Response)
+	AntiEntropyApplyChannel <- Request{Options: changes, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// merkleKeys returns the keys and the deletions in the given leaves of the Merkle tree.
+func merkleKeys(leaves []int) (antientropy.KeysResponse, error) {
+	items, err := store.Store.MerkleItems(leaves)
+	if err != nil {
+		return antientropy.KeysResponse{}, err
+	}
+	deleted, err := store.Store.MerkleTombstones(leaves)
+	return antientropy.KeysResponse{Items: items, Deleted: deleted}, err
+}
+
+// applyRepair applies changes made by a repair, stopping at the first that fails.
+func applyRepair(changes []store.Change) (int, error) {
+	for i, c := range changes {
+		if err := applyAudited("repair", c); err != nil {
+			return i, err
+		}
+	}
+	return len(changes), nil
+}
+
+// LocalReplica is the store of this server as one side of a repair.
+type LocalReplica struct{}
+
+func (LocalReplica) Hashes(_ context.Context, level int, nodes []int) ([]uint64, error) {
+	resp := MerkleHashesRequest(level, nodes)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return resp.Value.([]uint64), nil
+}
+
+func (LocalReplica) Items(_ context.Context, leaves []int) (antientropy.KeysResponse, error) {
+	resp := MerkleItemsRequest(leaves)
+	if resp.Error != nil {
+		return antientropy.KeysResponse{}, resp.Error
+	}
+	return resp.Value.(antientropy.KeysResponse), nil
+}
+
+func (LocalReplica) Apply(_ context.Context, changes []store.Change) (int, error) {
+	resp := AntiEntropyApplyRequest(changes)
+	if resp.Error != nil {
+		return 0, resp.Error
+	}
+	return resp.Value.(int), nil
+}
@@ -2,46 +2,133 @@
 package channels
 
 import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"iter"
+	"kvstore/antientropy"
+	"kvstore/audit"
+	"kvstore/crdt"
+	"kvstore/helpers"
+	"kvstore/partition"
+	"kvstore/replication"
 	"kvstore/store"
+	"log"
+	"time"
 )
 
+// SweepInterval is how often the request loop removes expired state from the store.
+const SweepInterval = time.Minute
+
 var (
-	GetChannel    = make(chan Request) // Create unbuffered GET channel
 	AddChannel    = make(chan Request)
-	GetAllChannel = make(chan Request)
 	ExistChannel  = make(chan Request)
 	CountChannel  = make(chan Request)
 	ClearChannel  = make(chan Request)
 	DeleteChannel = make(chan Request)
 	UpdateChannel = make(chan Request)
 	UpsertChannel = make(chan Request)
+	ImportChannel = make(chan Request)
+	IncrChannel   = make(chan Request)
+	ExpireChannel = make(chan Request)
+
+	WriteChannel   = make(chan Request)
+	PersistChannel = make(chan Request)
+
+	SoftDeleteChannel   = make(chan Request)
+	SoftClearChannel    = make(chan Request)
+	TrashChannel        = make(chan Request)
+	RestoreChannel      = make(chan Request)
+	RestoreClearChannel = make(chan Request)
+	PurgeChannel        = make(chan Request)
+
+	MetaChannel        = make(chan Request)
+	GetWithMetaChannel = make(chan Request)
+	SnapshotChannel    = make(chan Request)
+
+	TagsChannel         = make(chan Request)
+	SetTagsChannel      = make(chan Request)
+	RemoveTagsChannel   = make(chan Request)
+	FindByTagsChannel   = make(chan Request)
+	CountByTagsChannel  = make(chan Request)
+	DeleteByTagsChannel = make(chan Request)
+
+	AcquireLeaseChannel = make(chan Request)
+	RenewLeaseChannel   = make(chan Request)
+	ReleaseLeaseChannel = make(chan Request)
+	GetLeaseChannel     = make(chan Request)
+
+	EnqueueChannel    = make(chan Request)
+	DequeueChannel    = make(chan Request)
+	AckChannel        = make(chan Request)
+	NackChannel       = make(chan Request)
+	QueueStatsChannel = make(chan Request)
+
+	StreamAddChannel   = make(chan Request)
+	StreamRangeChannel = make(chan Request)
+	StreamReadChannel  = make(chan Request)
+	StreamTailChannel  = make(chan Request)
+	StreamTrimChannel  = make(chan Request)
+	StreamInfoChannel  = make(chan Request)
+	CreateGroupChannel = make(chan Request)
+	ReadGroupChannel   = make(chan Request)
+	StreamAckChannel   = make(chan Request)
+	PendingChannel     = make(chan Request)
+	ClaimChannel       = make(chan Request)
+
+	RateLimitChannel = make(chan Request)
+
+	SubscribeChannel = make(chan Request)
+	ReplicateChannel = make(chan Request)
+)
+
+// LeasePollInterval caps how long a blocking lease acquisition waits before trying again.
+const LeasePollInterval = 50 * time.Millisecond
+
+// Audit receives a record of every successful mutation. Nil disables auditing.
+var Audit *audit.Log
+
+// Leader streams the changes made to the store to followers. Follower is set when this server is itself
+// a follower, in which case it only takes writes from its leader.
+var (
+	Leader   *replication.Leader
+	Follower *replication.Follower
 )
 
 type Request struct {
 	Key      string
 	Value    []byte
+	Options  any    // Operation specific options, e.g. store.ImportOptions
+	Caller   Caller // Who asked for a mutation, for the audit log
 	Response chan Response
 }
 
+// Caller identifies the client behind a mutation.
+type Caller struct {
+	Addr     string // Remote address of the client
+	Identity string // Authenticated identity, empty if the request was anonymous
+}
+
 type Response struct {
 	Value any
 	Error error
 }
 
 func Requests() {
+	sweep := time.NewTicker(SweepInterval)
+	defer sweep.Stop()
+
 	for {
 		select {
-		case req := <-GetChannel:
-			value, err := store.Store.Get(req.Key)
-			req.Response <- Response{value, err}
-			close(req.Response)
+		case <-sweep.C:
+			sweepStore()
 		case req := <-AddChannel:
 			value, err := store.Store.Add(req.Key, req.Value)
+			auditRecord("add", req, nil, value, err)
 			req.Response <- Response{value, err}
 			close(req.Response)
-		case req := <-GetAllChannel:
-			value, err := store.Store.GetAll()
-			req.Response <- Response{value, err}
+		case req := <-SnapshotChannel:
+			req.Response <- Response{store.Store.Snapshot(), nil}
 			close(req.Response)
 		case req := <-ExistChannel:
 			value, err := store.Store.Exists(req.Key)
@@ -52,44 +139,373 @@ func Requests() {
 			req.Response <- Response{value, err}
 			close(req.Response)
 		case req := <-ClearChannel:
-			value, err := store.Store.Clear()
+			value, err := auditedClear("clear", req.Caller, store.Store.Clear)
 			req.Response <- Response{value, err}
 			close(req.Response)
 		case req := <-DeleteChannel:
+			old, _ := store.Store.Peek(req.Key)
 			err := store.Store.Delete(req.Key)
+			auditRecord("delete", req, old, nil, err)
 			req.Response <- Response{nil, err}
 			close(req.Response)
 		case req := <-UpdateChannel:
+			old, _ := store.Store.Peek(req.Key)
 			value, err := store.Store.Update(req.Key, req.Value)
+			auditRecord("update", req, old, value, err)
 			req.Response <- Response{value, err}
 			close(req.Response)
 		case req := <-UpsertChannel:
+			old, _ := store.Store.Peek(req.Key)
 			value, err := store.Store.Upsert(req.Key, req.Value)
+			auditRecord("upsert", req, old, value, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-IncrChannel:
+			delta, _ := req.Options.(int64)
+			old, _ := store.Store.Peek(req.Key)
+			value, err := store.Store.Incr(req.Key, delta)
+			newValue, _ := store.Store.Peek(req.Key)
+			auditRecord("incr", req, old, newValue, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-ExpireChannel:
+			ttl, _ := req.Options.(time.Duration)
+			err := store.Store.Expire(req.Key, ttl)
+			auditRecord("expire", req, nil, nil, err)
+			req.Response <- Response{nil, err}
+			close(req.Response)
+		case req := <-WriteChannel:
+			opts, _ := req.Options.(store.WriteOptions)
+			old, _ := store.Store.Peek(req.Key)
+			value, err := store.Store.Write(req.Key, req.Value, opts)
+			auditRecord("write", req, old, value, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-PersistChannel:
+			value, err := store.Store.Persist(req.Key)
+			auditRecord("persist", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-ImportChannel:
+			opts, _ := req.Options.(store.ImportOptions)
+			old, _ := store.Store.Peek(req.Key)
+			value, err := store.Store.Import(req.Key, req.Value, opts)
+			if !opts.DryRun && value != store.ImportSkipped {
+				newValue, _ := store.Store.Peek(req.Key)
+				auditRecord("import", req, old, newValue, err)
+			}
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-SoftDeleteChannel:
+			old, _ := store.Store.Peek(req.Key)
+			err := store.Store.SoftDelete(req.Key)
+			auditRecord("soft_delete", req, old, nil, err)
+			req.Response <- Response{nil, err}
+			close(req.Response)
+		case req := <-SoftClearChannel:
+			value, err := auditedClear("soft_clear", req.Caller, softClear)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-TrashChannel:
+			value, err := store.Store.Trash()
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-RestoreChannel:
+			value, err := store.Store.Restore(req.Key)
+			auditRecord("restore", req, nil, value, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-RestoreClearChannel:
+			value, err := store.Store.RestoreClear(req.Key)
+			auditRecord("restore_clear", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-PurgeChannel:
+			value, err := store.Store.Purge(req.Key)
+			auditRecord("purge", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-MetaChannel:
+			value, err := store.Store.Meta(req.Key)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-GetWithMetaChannel:
+			value, err := store.Store.GetWithMeta(req.Key)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-TagsChannel:
+			value, err := store.Store.Tags(req.Key)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-SetTagsChannel:
+			tags, _ := req.Options.(map[string]string)
+			value, err := store.Store.SetTags(req.Key, tags)
+			auditRecord("set_tags", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-RemoveTagsChannel:
+			names, _ := req.Options.([]string)
+			value, err := store.Store.RemoveTags(req.Key, names)
+			auditRecord("remove_tags", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-FindByTagsChannel:
+			sel, _ := req.Options.(store.TagSelector)
+			value, err := store.Store.FindByTags(sel)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-CountByTagsChannel:
+			sel, _ := req.Options.(store.TagSelector)
+			value, err := store.Store.CountByTags(sel)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-DeleteByTagsChannel:
+			opts, _ := req.Options.(DeleteByTagsOptions)
+			value, err := store.Store.DeleteByTags(opts.Selector, opts.Soft)
+			op := "delete"
+			if opts.Soft {
+				op = "soft_delete"
+			}
+			for _, e := range value {
+				auditRecord(op, Request{Key: e.Key, Caller: req.Caller}, e.Value, nil, err)
+			}
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-AcquireLeaseChannel:
+			opts, _ := req.Options.(LeaseOptions)
+			value, err := store.Store.AcquireLease(req.Key, opts.Owner, opts.TTL)
+			auditRecord("lease_acquire", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-RenewLeaseChannel:
+			opts, _ := req.Options.(LeaseOptions)
+			value, err := store.Store.RenewLease(req.Key, opts.Owner, opts.TTL)
+			auditRecord("lease_renew", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-ReleaseLeaseChannel:
+			opts, _ := req.Options.(LeaseOptions)
+			err := store.Store.ReleaseLease(req.Key, opts.Owner)
+			auditRecord("lease_release", req, nil, nil, err)
+			req.Response <- Response{nil, err}
+			close(req.Response)
+		case req := <-GetLeaseChannel:
+			value, err := store.Store.GetLease(req.Key)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-EnqueueChannel:
+			value, err := store.Store.Enqueue(req.Key, req.Value)
+			auditRecord("enqueue", req, nil, value.Body, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-DequeueChannel:
+			visibility, _ := req.Options.(time.Duration)
+			value, err := store.Store.Dequeue(req.Key, visibility)
+			auditRecord("dequeue", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-AckChannel:
+			receipt, _ := req.Options.(string)
+			err := store.Store.Ack(req.Key, receipt)
+			auditRecord("ack", req, nil, nil, err)
+			req.Response <- Response{nil, err}
+			close(req.Response)
+		case req := <-NackChannel:
+			receipt, _ := req.Options.(string)
+			err := store.Store.Nack(req.Key, receipt)
+			auditRecord("nack", req, nil, nil, err)
+			req.Response <- Response{nil, err}
+			close(req.Response)
+		case req := <-QueueStatsChannel:
+			var value any
+			var err error
+			if req.Key == "" {
+				value, err = store.Store.AllQueueStats()
+			} else {
+				value, err = store.Store.QueueStats(req.Key)
+			}
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-StreamAddChannel:
+			opts, _ := req.Options.(StreamOptions)
+			value, err := store.Store.StreamAdd(req.Key, req.Value, opts.MaxLen)
+			auditRecord("stream_add", req, nil, value.Value, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-StreamRangeChannel:
+			opts, _ := req.Options.(StreamOptions)
+			value, err := store.Store.StreamRange(req.Key, opts.Start, opts.End, opts.Count)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-StreamReadChannel:
+			opts, _ := req.Options.(StreamOptions)
+			value, err := store.Store.StreamRead(req.Key, opts.Start, opts.Count)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-StreamTailChannel:
+			opts, _ := req.Options.(StreamOptions)
+			value, err := store.Store.StreamTail(req.Key, opts.Count)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-StreamTrimChannel:
+			opts, _ := req.Options.(StreamOptions)
+			value, err := store.Store.StreamTrim(req.Key, opts.MaxLen)
+			auditRecord("stream_trim", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-StreamInfoChannel:
+			value, err := store.Store.StreamInfo(req.Key)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-CreateGroupChannel:
+			opts, _ := req.Options.(StreamOptions)
+			err := store.Store.CreateGroup(req.Key, opts.Group, opts.Start)
+			auditRecord("group_create", req, nil, nil, err)
+			req.Response <- Response{nil, err}
+			close(req.Response)
+		case req := <-ReadGroupChannel:
+			opts, _ := req.Options.(StreamOptions)
+			value, err := store.Store.ReadGroup(req.Key, opts.Group, opts.Consumer, opts.Count)
+			auditRecord("group_read", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-StreamAckChannel:
+			opts, _ := req.Options.(StreamOptions)
+			value, err := store.Store.StreamAck(req.Key, opts.Group, opts.IDs)
+			auditRecord("group_ack", req, nil, nil, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-PendingChannel:
+			opts, _ := req.Options.(StreamOptions)
+			value, err := store.Store.Pending(req.Key, opts.Group)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-ClaimChannel:
+			opts, _ := req.Options.(StreamOptions)
+			value, err := store.Store.Claim(req.Key, opts.Group, opts.Consumer, opts.MinIdle, opts.IDs)
+			auditRecord("group_claim", req, nil, nil, err)
 			req.Response <- Response{value, err}
 			close(req.Response)
+		case req := <-RateLimitChannel:
+			limit, _ := req.Options.(store.RateLimit)
+			value, err := store.Store.AllowRate(req.Key, limit)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-SubscribeChannel:
+			// Snapshot and subscribe in one step so the follower misses no change in between
+			sub := Leader.Subscribe(req.Caller.Addr, store.Store.State())
+			req.Response <- Response{sub, nil}
+			close(req.Response)
+		case req := <-ReplicateChannel:
+			msg, _ := req.Options.(replication.Message)
+			err := applyReplication(msg)
+			req.Response <- Response{nil, err}
+			close(req.Response)
+		case req := <-ClusterApplyChannel:
+			cmd, _ := req.Options.(Command)
+			value, err := applyCommand(cmd)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-ClusterSnapshotChannel:
+			req.Response <- Response{store.Store.State(), nil}
+			close(req.Response)
+		case req := <-ClusterRestoreChannel:
+			st, _ := req.Options.(store.State)
+			store.Store.LoadState(st)
+			req.Response <- Response{nil, nil}
+			close(req.Response)
+		case req := <-PartitionReceiveChannel:
+			b, _ := req.Options.(partition.Batch)
+			value, err := receiveBatch(b)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-PartitionTakeChannel:
+			move, _ := req.Options.(func(string) bool)
+			req.Response <- Response{store.Store.TakeState(move), nil}
+			close(req.Response)
+		case req := <-PartitionDropChannel:
+			items, _ := req.Options.(map[string]store.Item)
+			value, err := dropItems(items)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-MerkleHashesChannel:
+			tr, _ := req.Options.(antientropy.TreeRequest)
+			value, err := store.Store.MerkleHashes(tr.Level, tr.Nodes)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-MerkleItemsChannel:
+			leaves, _ := req.Options.([]int)
+			value, err := merkleKeys(leaves)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-AntiEntropyApplyChannel:
+			changes, _ := req.Options.([]store.Change)
+			value, err := applyRepair(changes)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-CRDTDeclareChannel:
+			t, _ := req.Options.(crdt.Type)
+			value, err := store.Store.DeclareCRDT(req.Key, t)
+			auditRecord("crdt_declare", req, nil, value.Value, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-CRDTGetChannel:
+			var value any
+			var err error
+			if req.Key == "" {
+				value = store.Store.AllCRDTs()
+			} else {
+				value, err = store.Store.GetCRDT(req.Key)
+			}
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-CRDTUpdateChannel:
+			u, _ := req.Options.(store.CRDTUpdate)
+			old, _ := store.Store.GetCRDT(req.Key)
+			value, err := store.Store.UpdateCRDT(req.Key, u)
+			auditRecord("crdt_"+string(u.Op), req, old.Value, value.Value, err)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-CRDTMergeChannel:
+			states, _ := req.Options.(map[string]crdt.State)
+			value, err := mergeCRDTs(states)
+			req.Response <- Response{value, err}
+			close(req.Response)
+		case req := <-CRDTStatesChannel:
+			req.Response <- Response{store.Store.CRDTStates(), nil}
+			close(req.Response)
 		}
 	}
 }
 
+// GetRequest reads a key without going through the request loop, so reads run alongside each other
+// and alongside writes.
 func GetRequest(key string) (response Response) {
+	value, err := store.Store.Read(key)
+	return Response{value, err}
+}
+
+func AddRequest(key string, value []byte, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "add", Key: key, Value: value, Caller: caller})
+	}
 	responseCh := make(chan Response)
-	GetChannel <- Request{Key: key, Response: responseCh}
+	AddChannel <- Request{Key: key, Value: value, Caller: caller, Response: responseCh}
 	response = <-responseCh
 	return response
 }
 
-func AddRequest(key string, value []byte) (response Response) {
+// SnapshotRequest returns a snapshot of the key space. Reading it does not hold up the request loop.
+func SnapshotRequest() *store.Snapshot {
 	responseCh := make(chan Response)
-	AddChannel <- Request{Key: key, Value: value, Response: responseCh}
-	response = <-responseCh
-	return response
+	SnapshotChannel <- Request{Response: responseCh}
+	response := <-responseCh
+	return response.Value.(*store.Snapshot)
 }
 
+// GetAllRequest returns every value by key, as of a snapshot.
 func GetAllRequest() (response Response) {
-	responseCh := make(chan Response)
-	GetAllChannel <- Request{Response: responseCh}
-	response = <-responseCh
-	return response
+	return Response{SnapshotRequest().Values(), nil}
 }
 func ExistsRequest(key string) (response Response) {
 	responseCh := make(chan Response)
@@ -105,30 +521,574 @@ func CountRequest() (response Response) {
 	return response
 }
 
-func ClearRequest() (response Response) {
+func ClearRequest(caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "clear", Caller: caller})
+	}
+	responseCh := make(chan Response)
+	ClearChannel <- Request{Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func DeleteRequest(key string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "delete", Key: key, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	DeleteChannel <- Request{Key: key, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func UpdateRequest(key string, value []byte, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "update", Key: key, Value: value, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	UpdateChannel <- Request{Key: key, Value: value, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func UpsertRequest(key string, value []byte, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "upsert", Key: key, Value: value, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	UpsertChannel <- Request{Key: key, Value: value, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// IncrRequest adds delta to the integer stored under a key, which counts as zero if missing, and
+// returns the result.
+func IncrRequest(key string, delta int64, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "incr", Key: key, Delta: delta, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	IncrChannel <- Request{Key: key, Options: delta, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// ExpireRequest sets a key to expire once ttl has passed. A ttl of zero or less deletes it.
+func ExpireRequest(key string, ttl time.Duration, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "expire", Key: key, TTL: ttl, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	ExpireChannel <- Request{Key: key, Options: ttl, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// WriteRequest writes a key if the conditions of opts hold, along with its time to live and flags.
+func WriteRequest(key string, value []byte, opts store.WriteOptions, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "write", Key: key, Value: value, Write: opts, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	WriteChannel <- Request{Key: key, Value: value, Options: opts, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// PersistRequest removes the expiry of a key, reporting whether it had one.
+func PersistRequest(key string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "persist", Key: key, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	PersistChannel <- Request{Key: key, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// ExportRequest yields every key starting with prefix, in key order, as of a snapshot taken by the call.
+// Keys are read from the snapshot as they are yielded, so an export can be streamed to the client.
+func ExportRequest(prefix string) iter.Seq2[string, store.Item] {
+	return SnapshotRequest().Scan(prefix)
+}
+
+func ImportRequest(key string, value []byte, opts store.ImportOptions, caller Caller) (response Response) {
+	if Raft != nil && !opts.DryRun {
+		return propose(Command{Op: "import", Key: key, Value: value, Import: opts, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	ImportChannel <- Request{Key: key, Value: value, Options: opts, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// SoftDeleteRequest moves a key to the trash instead of deleting it.
+func SoftDeleteRequest(key string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "soft_delete", Key: key, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	SoftDeleteChannel <- Request{Key: key, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// SoftClearRequest moves every key to the trash and returns the clear ID.
+func SoftClearRequest(caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "soft_clear", Caller: caller})
+	}
+	responseCh := make(chan Response)
+	SoftClearChannel <- Request{Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func TrashRequest() (response Response) {
+	responseCh := make(chan Response)
+	TrashChannel <- Request{Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func RestoreRequest(key string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "restore", Key: key, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	RestoreChannel <- Request{Key: key, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// RestoreClearRequest restores every key removed by the soft clear with the given ID.
+func RestoreClearRequest(id string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "restore_clear", Key: id, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	RestoreClearChannel <- Request{Key: id, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// PurgeRequest removes a key from the trash, or empties the trash when key is empty.
+func PurgeRequest(key string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "purge", Key: key, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	PurgeChannel <- Request{Key: key, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func MetaRequest(key string) (response Response) {
 	responseCh := make(chan Response)
-	ClearChannel <- Request{Response: responseCh}
+	MetaChannel <- Request{Key: key, Response: responseCh}
 	response = <-responseCh
 	return response
 }
 
-func DeleteRequest(key string) (response Response) {
+// GetWithMetaRequest returns the value for key together with its metadata.
+func GetWithMetaRequest(key string) (response Response) {
 	responseCh := make(chan Response)
-	DeleteChannel <- Request{Key: key, Response: responseCh}
+	GetWithMetaChannel <- Request{Key: key, Response: responseCh}
 	response = <-responseCh
 	return response
 }
 
-func UpdateRequest(key string, value []byte) (response Response) {
+// GetAllWithMetaRequest returns every value together with its metadata.
+func GetAllWithMetaRequest() (response Response) {
+	return Response{SnapshotRequest().Items(), nil}
+}
+
+func TagsRequest(key string) (response Response) {
 	responseCh := make(chan Response)
-	UpdateChannel <- Request{Key: key, Value: value, Response: responseCh}
+	TagsChannel <- Request{Key: key, Response: responseCh}
 	response = <-responseCh
 	return response
 }
 
-func UpsertRequest(key string, value []byte) (response Response) {
+// SetTagsRequest adds tags to a key, replacing existing values of the same tags.
+func SetTagsRequest(key string, tags map[string]string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "set_tags", Key: key, Tags: tags, Caller: caller})
+	}
 	responseCh := make(chan Response)
-	UpsertChannel <- Request{Key: key, Value: value, Response: responseCh}
+	SetTagsChannel <- Request{Key: key, Options: tags, Caller: caller, Response: responseCh}
 	response = <-responseCh
 	return response
 }
+
+// RemoveTagsRequest removes the named tags from a key.
+func RemoveTagsRequest(key string, names []string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "remove_tags", Key: key, Names: names, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	RemoveTagsChannel <- Request{Key: key, Options: names, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// FindByTagsRequest returns the keys matching a tag selector.
+func FindByTagsRequest(sel store.TagSelector) (response Response) {
+	responseCh := make(chan Response)
+	FindByTagsChannel <- Request{Options: sel, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func CountByTagsRequest(sel store.TagSelector) (response Response) {
+	responseCh := make(chan Response)
+	CountByTagsChannel <- Request{Options: sel, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// DeleteByTagsOptions selects the keys for DeleteByTagsRequest.
+type DeleteByTagsOptions struct {
+	Selector store.TagSelector
+	Soft     bool // Move the keys to the trash instead of deleting them
+}
+
+// DeleteByTagsRequest deletes every key matching a tag selector.
+func DeleteByTagsRequest(sel store.TagSelector, soft bool, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "delete_by_tags", Selector: sel, Soft: soft, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	DeleteByTagsChannel <- Request{Options: DeleteByTagsOptions{sel, soft}, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// LeaseOptions carries the owner and TTL of a lease request.
+type LeaseOptions struct {
+	Owner string
+	TTL   time.Duration
+}
+
+// AcquireLeaseRequest takes a lease for owner. If the lease is held by someone else and wait is positive,
+// it keeps trying until the lease is free or wait has passed. The store decides every attempt, so a
+// waiting client never holds the lease until the store has handed it over.
+func AcquireLeaseRequest(name, owner string, ttl, wait time.Duration, caller Caller) (response Response) {
+	deadline := time.Now().Add(wait)
+	for {
+		response = acquireLease(name, owner, ttl, caller)
+
+		remaining := time.Until(deadline)
+		if !errors.Is(response.Error, helpers.LeaseHeldError) || remaining <= 0 {
+			return response
+		}
+
+		// Sleep until the lease expires, but poll in case it is released early
+		pause := min(remaining, LeasePollInterval)
+		if held, ok := response.Value.(store.Lease); ok {
+			pause = min(pause, max(time.Until(held.ExpiresAt), time.Millisecond))
+		}
+		time.Sleep(pause)
+	}
+}
+
+// acquireLease makes one attempt at taking a lease for owner.
+func acquireLease(name, owner string, ttl time.Duration, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "lease_acquire", Key: name, Lease: LeaseOptions{owner, ttl}, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	AcquireLeaseChannel <- Request{Key: name, Options: LeaseOptions{owner, ttl}, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func RenewLeaseRequest(name, owner string, ttl time.Duration, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "lease_renew", Key: name, Lease: LeaseOptions{owner, ttl}, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	RenewLeaseChannel <- Request{Key: name, Options: LeaseOptions{owner, ttl}, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func ReleaseLeaseRequest(name, owner string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "lease_release", Key: name, Lease: LeaseOptions{Owner: owner}, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	ReleaseLeaseChannel <- Request{Key: name, Options: LeaseOptions{Owner: owner}, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func GetLeaseRequest(name string) (response Response) {
+	responseCh := make(chan Response)
+	GetLeaseChannel <- Request{Key: name, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func EnqueueRequest(name string, value []byte, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "enqueue", Key: name, Value: value, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	EnqueueChannel <- Request{Key: name, Value: value, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// DequeueRequest takes the oldest message from a queue, hiding it from other consumers for visibility.
+func DequeueRequest(name string, visibility time.Duration, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "dequeue", Key: name, TTL: visibility, Caller: caller, Seed: rand.Text()})
+	}
+	responseCh := make(chan Response)
+	DequeueChannel <- Request{Key: name, Options: visibility, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func AckRequest(name, receipt string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "ack", Key: name, Receipt: receipt, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	AckChannel <- Request{Key: name, Options: receipt, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+func NackRequest(name, receipt string, caller Caller) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "nack", Key: name, Receipt: receipt, Caller: caller})
+	}
+	responseCh := make(chan Response)
+	NackChannel <- Request{Key: name, Options: receipt, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// QueueStatsRequest returns the stats of a queue, or of every queue when name is empty.
+func QueueStatsRequest(name string) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "queue_stats", Key: name})
+	}
+	responseCh := make(chan Response)
+	QueueStatsChannel <- Request{Key: name, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// StreamOptions carries the arguments of stream and consumer group requests. Start doubles as the
+// ID to read after for StreamReadRequest and the starting point of a new group.
+type StreamOptions struct {
+	Group    string
+	Consumer string
+	Start    string
+	End      string
+	Count    int
+	MaxLen   int
+	MinIdle  time.Duration
+	IDs      []string
+}
+
+// streamRequest sends a stream request and waits for its response.
+func streamRequest(ch chan Request, name string, value []byte, opts StreamOptions, caller Caller) Response {
+	responseCh := make(chan Response)
+	ch <- Request{Key: name, Value: value, Options: opts, Caller: caller, Response: responseCh}
+	return <-responseCh
+}
+
+// streamWrite is streamRequest for requests that change a stream, which a cluster proposes as op.
+func streamWrite(ch chan Request, op, name string, value []byte, opts StreamOptions, caller Caller) Response {
+	if Raft != nil {
+		return propose(Command{Op: op, Key: name, Value: value, Stream: opts, Caller: caller})
+	}
+	return streamRequest(ch, name, value, opts, caller)
+}
+
+// StreamAddRequest appends a value to a stream, trimming it to maxLen entries when maxLen is positive.
+func StreamAddRequest(name string, value []byte, maxLen int, caller Caller) Response {
+	return streamWrite(StreamAddChannel, "stream_add", name, value, StreamOptions{MaxLen: maxLen}, caller)
+}
+
+func StreamRangeRequest(name, start, end string, count int) Response {
+	return streamRequest(StreamRangeChannel, name, nil, StreamOptions{Start: start, End: end, Count: count}, Caller{})
+}
+
+// StreamReadRequest returns entries added after the ID after, or after the current end of the stream for
+// "$". If there are none and wait is positive, it polls until entries arrive or wait has passed.
+func StreamReadRequest(name, after string, count int, wait time.Duration) (response Response) {
+	if after == "$" && wait > 0 {
+		// Pin the end of the stream now, or entries added between polls would be skipped
+		info := streamRequest(StreamInfoChannel, name, nil, StreamOptions{}, Caller{})
+		if info.Error != nil {
+			return info
+		}
+		after = "0"
+		if last := info.Value.(store.StreamInfo).LastID; last != "" {
+			after = last
+		}
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		response = streamRequest(StreamReadChannel, name, nil, StreamOptions{Start: after, Count: count}, Caller{})
+
+		remaining := time.Until(deadline)
+		entries, _ := response.Value.([]store.StreamEntry)
+		if response.Error != nil || len(entries) > 0 || remaining <= 0 {
+			return response
+		}
+		time.Sleep(min(remaining, LeasePollInterval))
+	}
+}
+
+func StreamTailRequest(name string, count int) Response {
+	return streamRequest(StreamTailChannel, name, nil, StreamOptions{Count: count}, Caller{})
+}
+
+func StreamTrimRequest(name string, maxLen int, caller Caller) Response {
+	return streamWrite(StreamTrimChannel, "stream_trim", name, nil, StreamOptions{MaxLen: maxLen}, caller)
+}
+
+func StreamInfoRequest(name string) Response {
+	return streamRequest(StreamInfoChannel, name, nil, StreamOptions{}, Caller{})
+}
+
+func CreateGroupRequest(name, group, start string, caller Caller) Response {
+	return streamWrite(CreateGroupChannel, "group_create", name, nil, StreamOptions{Group: group, Start: start}, caller)
+}
+
+// ReadGroupRequest delivers up to count new entries of a stream to a consumer of a group.
+func ReadGroupRequest(name, group, consumer string, count int, caller Caller) Response {
+	opts := StreamOptions{Group: group, Consumer: consumer, Count: count}
+	return streamWrite(ReadGroupChannel, "group_read", name, nil, opts, caller)
+}
+
+func StreamAckRequest(name, group string, ids []string, caller Caller) Response {
+	return streamWrite(StreamAckChannel, "group_ack", name, nil, StreamOptions{Group: group, IDs: ids}, caller)
+}
+
+func PendingRequest(name, group string) Response {
+	return streamRequest(PendingChannel, name, nil, StreamOptions{Group: group}, Caller{})
+}
+
+// ClaimRequest moves pending entries idle for at least minIdle to consumer, every idle entry if ids is empty.
+func ClaimRequest(name, group, consumer string, minIdle time.Duration, ids []string, caller Caller) Response {
+	opts := StreamOptions{Group: group, Consumer: consumer, MinIdle: minIdle, IDs: ids}
+	return streamWrite(ClaimChannel, "group_claim", name, nil, opts, caller)
+}
+
+// RateLimitRequest decides whether a request against the rate limit of key is allowed. Rate checks are not
+// audited, they change no data and would drown out the mutations.
+func RateLimitRequest(key string, limit store.RateLimit) (response Response) {
+	if Raft != nil {
+		return propose(Command{Op: "rate_limit", Key: key, Limit: limit})
+	}
+	responseCh := make(chan Response)
+	RateLimitChannel <- Request{Key: key, Options: limit, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// SubscribeRequest registers a follower with the leader, returning a *replication.Subscription that
+// starts with a snapshot of the store.
+func SubscribeRequest(caller Caller) (response Response) {
+	responseCh := make(chan Response)
+	SubscribeChannel <- Request{Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// ReplicateRequest applies a message from the leader to the store.
+func ReplicateRequest(msg replication.Message) error {
+	responseCh := make(chan Response)
+	ReplicateChannel <- Request{Options: msg, Response: responseCh}
+	return (<-responseCh).Error
+}
+
+// applyReplication applies a snapshot or change from the leader. A snapshot replaces the whole store.
+func applyReplication(msg replication.Message) error {
+	if msg.Type == replication.MessageSnapshot {
+		if msg.State == nil {
+			return fmt.Errorf("%w: snapshot without a state", helpers.InvalidParamError)
+		}
+		auditedClear("replicate", Caller{}, store.Store.Clear)
+		store.Store.LoadState(*msg.State)
+		for k, item := range msg.State.Items {
+			auditRecord("replicate", Request{Key: k}, nil, item.Value, nil)
+		}
+	}
+	for _, c := range msg.Changes {
+		if err := applyAudited("replicate", c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyAudited applies a change made elsewhere, by the leader, another partition or a peer in a repair,
+// and records it as op. Changes outside the key space are applied unrecorded, the leader recorded the
+// operations that made them.
+func applyAudited(op string, c store.Change) error {
+	switch c.Op {
+	case store.ChangeSet, store.ChangeDelete:
+	case store.ChangeClear:
+		_, err := auditedClear(op, Caller{}, func() (any, error) { return nil, store.Store.ApplyChange(c) })
+		return err
+	default:
+		return store.Store.ApplyChange(c)
+	}
+
+	old, _ := store.Store.Peek(c.Key)
+	err := store.Store.ApplyChange(c)
+	var value any
+	if c.Item != nil {
+		value = c.Item.Value
+	}
+	auditRecord(op, Request{Key: c.Key}, old, value, err)
+	return err
+}
+
+// softClear is store.Store.SoftClear for auditedClear.
+func softClear() (any, error) {
+	return store.Store.SoftClear()
+}
+
+// auditedClear runs clear, which removes every key, and records op against each key it removed.
+func auditedClear(op string, caller Caller, clear func() (any, error)) (any, error) {
+	if Audit == nil {
+		return clear()
+	}
+
+	before := store.Store.Snapshot()
+	value, err := clear()
+	for k, item := range before.Scan("") {
+		auditRecord(op, Request{Key: k, Caller: caller}, item.Value, nil, err)
+	}
+	return value, err
+}
+
+// auditRecord writes an audit record for a mutation that succeeded.
+func auditRecord(op string, req Request, old, value any, err error) {
+	if Audit == nil || err != nil {
+		return
+	}
+
+	rec := audit.Record{
+		Time:     time.Now(),
+		Op:       op,
+		Key:      req.Key,
+		OldHash:  audit.Hash(old),
+		NewHash:  audit.Hash(value),
+		Client:   req.Caller.Addr,
+		Identity: req.Caller.Identity,
+	}
+	if err := Audit.Write(rec); err != nil {
+		log.Printf("Audit Error: %s", err)
+	}
+}
@@ -0,0 +1,308 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kvstore/helpers"
+	"kvstore/raft"
+	"kvstore/store"
+	"time"
+)
+
+// ProposeTimeout caps how long a write waits for the cluster to commit it.
+const ProposeTimeout = 5 * time.Second
+
+// Raft is set when this server is a member of a cluster. Writes to the key space are then proposed to
+// the cluster and applied on every member once a quorum has stored them.
+var Raft *raft.Node
+
+var (
+	ClusterApplyChannel    = make(chan Request)
+	ClusterSnapshotChannel = make(chan Request)
+	ClusterRestoreChannel  = make(chan Request)
+)
+
+// Command is a write to the key space as stored in the Raft log.
+type Command struct {
+	Op     string    `json:"op"`
+	Key    string    `json:"key,omitempty"`
+	Value  []byte    `json:"value,omitempty"`
+	Time   time.Time `json:"time"` // When the leader took the write, used as the clock on every member
+	Caller Caller    `json:"caller"`
+
+	Import   store.ImportOptions `json:"import,omitzero"`
+	Tags     map[string]string   `json:"tags,omitempty"`
+	Names    []string            `json:"names,omitempty"`
+	Selector store.TagSelector   `json:"selector,omitempty"`
+	Soft     bool                `json:"soft,omitempty"`
+	Delta    int64               `json:"delta,omitempty"`
+	TTL      time.Duration       `json:"ttl,omitempty"`
+	Write    store.WriteOptions  `json:"write,omitzero"`
+	Lease    LeaseOptions        `json:"lease,omitzero"`
+	Stream   StreamOptions       `json:"stream,omitzero"`
+	Limit    store.RateLimit     `json:"limit,omitzero"`
+	Receipt  string              `json:"receipt,omitempty"`
+	Seed     string              `json:"seed,omitempty"` // Receipt secret from the leader, used if the store has none yet
+}
+
+// propose replicates cmd and returns the result of applying it on this server.
+func propose(cmd Command) Response {
+	cmd.Time = time.Now()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return Response{nil, err}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ProposeTimeout)
+	defer cancel()
+
+	value, err := Raft.Propose(ctx, data)
+	if err != nil {
+		return Response{nil, clusterError(err)}
+	}
+	return value.(Response)
+}
+
+// sweepStore removes expired state from the store. In a cluster only the leader sweeps, through the log,
+// so that every member removes the same state.
+func sweepStore() {
+	switch {
+	case Raft == nil:
+		store.Store.Sweep()
+	case Raft.Status().State == raft.Leader.String():
+		// propose waits for the request loop, which is the caller
+		go propose(Command{Op: "sweep"})
+	}
+}
+
+// ReadBarrier waits until this server has applied every write committed before the call, and confirms
+// it is still the leader. Reads made after it returns are linearizable. It does nothing outside a cluster.
+func ReadBarrier(ctx context.Context) error {
+	if Raft == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ProposeTimeout)
+	defer cancel()
+
+	if err := Raft.ReadIndex(ctx); err != nil {
+		return fmt.Errorf("%w: %v", helpers.NotLeaderError, err)
+	}
+	return nil
+}
+
+// Admit reports whether a request that writes or reads the store may be served now, for the frontends
+// other than HTTP, which does the same in its middleware. Followers take writes from their leader only,
+// so refuse them with helpers.ReadOnlyError, and cluster members serve reads once they have caught up
+// with the leader.
+func Admit(ctx context.Context, write, read bool) error {
+	if write && Follower != nil {
+		return helpers.ReadOnlyError
+	}
+	if read {
+		return ReadBarrier(ctx)
+	}
+	return nil
+}
+
+// AddMember adds a server to the cluster. It must be called on the leader.
+func AddMember(id, addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ProposeTimeout)
+	defer cancel()
+	return clusterError(Raft.AddMember(ctx, raft.Member{ID: id, Addr: addr}))
+}
+
+// RemoveMember removes a server from the cluster. It must be called on the leader.
+func RemoveMember(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ProposeTimeout)
+	defer cancel()
+	return clusterError(Raft.RemoveMember(ctx, id))
+}
+
+// clusterError maps errors from Raft onto the errors the handlers know how to report.
+func clusterError(err error) error {
+	switch {
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrLeadershipLost), errors.Is(err, raft.ErrStopped):
+		return fmt.Errorf("%w: %v", helpers.NotLeaderError, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: not committed within %s, it may still be applied", helpers.NotLeaderError, ProposeTimeout)
+	case errors.Is(err, raft.ErrInvalidMember), errors.Is(err, raft.ErrConfigChangeInProgress):
+		return fmt.Errorf("%w: %v", helpers.InvalidParamError, err)
+	}
+	return err
+}
+
+// applyCommand applies a committed command to the store, with the clock of the store set to the time
+// the leader took it so every member ends up with the same metadata.
+func applyCommand(cmd Command) (value any, err error) {
+	req := Request{Key: cmd.Key, Value: cmd.Value, Caller: cmd.Caller}
+
+	store.Store.At(cmd.Time, func() {
+		switch cmd.Op {
+		case "add":
+			value, err = store.Store.Add(cmd.Key, cmd.Value)
+			auditRecord("add", req, nil, value, err)
+		case "update", "upsert":
+			old, _ := store.Store.Peek(cmd.Key)
+			if cmd.Op == "update" {
+				value, err = store.Store.Update(cmd.Key, cmd.Value)
+			} else {
+				value, err = store.Store.Upsert(cmd.Key, cmd.Value)
+			}
+			auditRecord(cmd.Op, req, old, value, err)
+		case "delete":
+			old, _ := store.Store.Peek(cmd.Key)
+			err = store.Store.Delete(cmd.Key)
+			auditRecord("delete", req, old, nil, err)
+		case "clear":
+			value, err = auditedClear("clear", cmd.Caller, store.Store.Clear)
+		case "incr":
+			old, _ := store.Store.Peek(cmd.Key)
+			value, err = store.Store.Incr(cmd.Key, cmd.Delta)
+			newValue, _ := store.Store.Peek(cmd.Key)
+			auditRecord("incr", req, old, newValue, err)
+		case "expire":
+			err = store.Store.Expire(cmd.Key, cmd.TTL)
+			auditRecord("expire", req, nil, nil, err)
+		case "write":
+			old, _ := store.Store.Peek(cmd.Key)
+			value, err = store.Store.Write(cmd.Key, cmd.Value, cmd.Write)
+			auditRecord("write", req, old, value, err)
+		case "persist":
+			value, err = store.Store.Persist(cmd.Key)
+			auditRecord("persist", req, nil, nil, err)
+		case "import":
+			old, _ := store.Store.Peek(cmd.Key)
+			value, err = store.Store.Import(cmd.Key, cmd.Value, cmd.Import)
+			if value != store.ImportSkipped {
+				newValue, _ := store.Store.Peek(cmd.Key)
+				auditRecord("import", req, old, newValue, err)
+			}
+		case "soft_delete":
+			old, _ := store.Store.Peek(cmd.Key)
+			err = store.Store.SoftDelete(cmd.Key)
+			auditRecord("soft_delete", req, old, nil, err)
+		case "soft_clear":
+			value, err = auditedClear("soft_clear", cmd.Caller, softClear)
+		case "restore":
+			value, err = store.Store.Restore(cmd.Key)
+			auditRecord("restore", req, nil, value, err)
+		case "restore_clear":
+			value, err = store.Store.RestoreClear(cmd.Key)
+			auditRecord("restore_clear", req, nil, nil, err)
+		case "purge":
+			value, err = store.Store.Purge(cmd.Key)
+			auditRecord("purge", req, nil, nil, err)
+		case "set_tags":
+			value, err = store.Store.SetTags(cmd.Key, cmd.Tags)
+			auditRecord("set_tags", req, nil, nil, err)
+		case "remove_tags":
+			value, err = store.Store.RemoveTags(cmd.Key, cmd.Names)
+			auditRecord("remove_tags", req, nil, nil, err)
+		case "delete_by_tags":
+			var entries []store.Entry
+			entries, err = store.Store.DeleteByTags(cmd.Selector, cmd.Soft)
+			op := "delete"
+			if cmd.Soft {
+				op = "soft_delete"
+			}
+			for _, e := range entries {
+				auditRecord(op, Request{Key: e.Key, Caller: cmd.Caller}, e.Value, nil, err)
+			}
+			value = entries
+		case "lease_acquire":
+			value, err = store.Store.AcquireLease(cmd.Key, cmd.Lease.Owner, cmd.Lease.TTL)
+			auditRecord("lease_acquire", req, nil, nil, err)
+		case "lease_renew":
+			value, err = store.Store.RenewLease(cmd.Key, cmd.Lease.Owner, cmd.Lease.TTL)
+			auditRecord("lease_renew", req, nil, nil, err)
+		case "lease_release":
+			err = store.Store.ReleaseLease(cmd.Key, cmd.Lease.Owner)
+			auditRecord("lease_release", req, nil, nil, err)
+		case "enqueue":
+			var msg store.Message
+			msg, err = store.Store.Enqueue(cmd.Key, cmd.Value)
+			auditRecord("enqueue", req, nil, msg.Body, err)
+			value = msg
+		case "dequeue":
+			store.Store.SeedReceipts(cmd.Seed)
+			value, err = store.Store.Dequeue(cmd.Key, cmd.TTL)
+			auditRecord("dequeue", req, nil, nil, err)
+		case "ack":
+			err = store.Store.Ack(cmd.Key, cmd.Receipt)
+			auditRecord("ack", req, nil, nil, err)
+		case "nack":
+			err = store.Store.Nack(cmd.Key, cmd.Receipt)
+			auditRecord("nack", req, nil, nil, err)
+		case "queue_stats":
+			// Stats put expired deliveries back in their queue first, so they are a write too
+			if cmd.Key == "" {
+				value, err = store.Store.AllQueueStats()
+			} else {
+				value, err = store.Store.QueueStats(cmd.Key)
+			}
+		case "stream_add":
+			var entry store.StreamEntry
+			entry, err = store.Store.StreamAdd(cmd.Key, cmd.Value, cmd.Stream.MaxLen)
+			auditRecord("stream_add", req, nil, entry.Value, err)
+			value = entry
+		case "stream_trim":
+			value, err = store.Store.StreamTrim(cmd.Key, cmd.Stream.MaxLen)
+			auditRecord("stream_trim", req, nil, nil, err)
+		case "group_create":
+			err = store.Store.CreateGroup(cmd.Key, cmd.Stream.Group, cmd.Stream.Start)
+			auditRecord("group_create", req, nil, nil, err)
+		case "group_read":
+			value, err = store.Store.ReadGroup(cmd.Key, cmd.Stream.Group, cmd.Stream.Consumer, cmd.Stream.Count)
+			auditRecord("group_read", req, nil, nil, err)
+		case "group_ack":
+			value, err = store.Store.StreamAck(cmd.Key, cmd.Stream.Group, cmd.Stream.IDs)
+			auditRecord("group_ack", req, nil, nil, err)
+		case "group_claim":
+			opts := cmd.Stream
+			value, err = store.Store.Claim(cmd.Key, opts.Group, opts.Consumer, opts.MinIdle, opts.IDs)
+			auditRecord("group_claim", req, nil, nil, err)
+		case "rate_limit":
+			value, err = store.Store.AllowRate(cmd.Key, cmd.Limit)
+		case "sweep":
+			store.Store.Sweep()
+		default:
+			err = fmt.Errorf("%w: unknown command %q", helpers.InvalidParamError, cmd.Op)
+		}
+	})
+
+	return value, err
+}
+
+// StateMachine is the store as seen by Raft. Every call goes through the request loop.
+type StateMachine struct{}
+
+func (StateMachine) Apply(command []byte) any {
+	var cmd Command
+	if err := json.Unmarshal(command, &cmd); err != nil {
+		return Response{nil, err}
+	}
+
+	responseCh := make(chan Response)
+	ClusterApplyChannel <- Request{Options: cmd, Response: responseCh}
+	return <-responseCh
+}
+
+func (StateMachine) Snapshot() ([]byte, error) {
+	responseCh := make(chan Response)
+	ClusterSnapshotChannel <- Request{Response: responseCh}
+	return json.Marshal((<-responseCh).Value)
+}
+
+func (StateMachine) Restore(data []byte) error {
+	var st store.State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	responseCh := make(chan Response)
+	ClusterRestoreChannel <- Request{Options: st, Response: responseCh}
+	return (<-responseCh).Error
+}
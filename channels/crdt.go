@@ -0,0 +1,84 @@
+package channels
+
+import (
+	"kvstore/audit"
+	"kvstore/crdt"
+	"kvstore/store"
+	"maps"
+	"slices"
+)
+
+// Sites sends updates to replicated keys to the other sites, see the crdt package.
+var Sites *crdt.Replicator
+
+var (
+	CRDTDeclareChannel = make(chan Request)
+	CRDTGetChannel     = make(chan Request)
+	CRDTUpdateChannel  = make(chan Request)
+	CRDTMergeChannel   = make(chan Request)
+	CRDTStatesChannel  = make(chan Request)
+)
+
+// CRDTDeclareRequest makes key a replicated value of type t.
+func CRDTDeclareRequest(key string, t crdt.Type, caller Caller) (response Response) {
+	responseCh := make(chan Response)
+	CRDTDeclareChannel <- Request{Key: key, Options: t, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// CRDTGetRequest returns the value of a replicated key, or of every replicated key if key is empty.
+func CRDTGetRequest(key string) (response Response) {
+	responseCh := make(chan Response)
+	CRDTGetChannel <- Request{Key: key, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// CRDTUpdateRequest applies an update to a replicated key.
+func CRDTUpdateRequest(key string, u store.CRDTUpdate, caller Caller) (response Response) {
+	responseCh := make(chan Response)
+	CRDTUpdateChannel <- Request{Key: key, Options: u, Caller: caller, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// CRDTMergeRequest merges states received from another site and returns how many keys it merged.
+func CRDTMergeRequest(states map[string]crdt.State) (response Response) {
+	responseCh := make(chan Response)
+	CRDTMergeChannel <- Request{Options: states, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// CRDTStates returns the state of every replicated key. It is the States function of Sites.
+func CRDTStates() (map[string]crdt.State, error) {
+	responseCh := make(chan Response)
+	CRDTStatesChannel <- Request{Response: responseCh}
+	response := <-responseCh
+	return response.Value.(map[string]crdt.State), response.Error
+}
+
+// mergeCRDTs merges states received from another site, recording the keys whose value it changed.
+func mergeCRDTs(states map[string]crdt.State) (int, error) {
+	old := make(map[string]any, len(states))
+	if Audit != nil {
+		for key := range states {
+			if c, err := store.Store.GetCRDT(key); err == nil {
+				old[key] = c.Value
+			}
+		}
+	}
+
+	n, err := store.Store.MergeCRDTs(states)
+	if Audit == nil || err != nil {
+		return n, err
+	}
+	for _, key := range slices.Sorted(maps.Keys(states)) {
+		c, _ := store.Store.GetCRDT(key)
+		if audit.Hash(c.Value) != audit.Hash(old[key]) {
+			auditRecord("crdt_merge", Request{Key: key}, old[key], c.Value, nil)
+		}
+	}
+	return n, nil
+}
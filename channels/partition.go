@@ -0,0 +1,95 @@
+package channels
+
+import (
+	"kvstore/partition"
+	"kvstore/store"
+)
+
+// Partitions is set when this server is part of a partitioned cluster, in which case it only holds the
+// keys the ring assigns to it.
+var Partitions *partition.Cluster
+
+var (
+	PartitionReceiveChannel = make(chan Request)
+	PartitionDropChannel    = make(chan Request)
+	PartitionTakeChannel    = make(chan Request)
+)
+
+// PartitionReceiveRequest stores keys and other state handed over by their previous owner and returns
+// how many were taken. A key that already exists here is only replaced by a more recently updated one,
+// while the state outside the key space is merged with what is here, see store.MergeChange.
+func PartitionReceiveRequest(b partition.Batch) (response Response) {
+	responseCh := make(chan Response)
+	PartitionReceiveChannel <- Request{Options: b, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// PartitionTakeRequest removes the leases, queues, streams, rate limits and trash that move reports
+// belong to another server and returns them as a []store.Change to hand over.
+func PartitionTakeRequest(move func(name string) bool) (response Response) {
+	responseCh := make(chan Response)
+	PartitionTakeChannel <- Request{Options: move, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// PartitionDropRequest removes keys that have been handed over to their new owner and returns how many
+// were removed. Keys written since they were handed over are kept.
+func PartitionDropRequest(items map[string]store.Item) (response Response) {
+	responseCh := make(chan Response)
+	PartitionDropChannel <- Request{Options: items, Response: responseCh}
+	response = <-responseCh
+	return response
+}
+
+// receiveItems applies handed over keys, keeping their metadata. The most recent write wins, so a key
+// written here since the handover began is kept, while a key handed over again after being written on
+// its previous owner replaces the copy received earlier.
+func receiveItems(items map[string]store.Item) (int, error) {
+	n := 0
+	for k, item := range items {
+		if md, err := store.Store.Meta(k); err == nil && !item.Metadata.UpdatedAt.After(md.UpdatedAt) {
+			continue
+		}
+		if err := applyAudited("partition_receive", store.Change{Op: store.ChangeSet, Key: k, Item: &item}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// receiveBatch applies a handed over batch of keys and other state.
+func receiveBatch(b partition.Batch) (int, error) {
+	n, err := receiveItems(b.Items)
+	if err != nil {
+		return n, err
+	}
+	for _, c := range b.Changes {
+		if err := store.Store.MergeChange(c); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// dropItems deletes handed over keys that are unchanged since they were sent.
+func dropItems(items map[string]store.Item) (int, error) {
+	n := 0
+	for k, item := range items {
+		md, err := store.Store.Meta(k)
+		if err != nil || md.WriteCount != item.Metadata.WriteCount || !md.UpdatedAt.Equal(item.Metadata.UpdatedAt) {
+			continue
+		}
+		old, _ := store.Store.Peek(k)
+		err = store.Store.Delete(k)
+		auditRecord("partition_drop", Request{Key: k}, old, nil, err)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"kvstore/db"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+)
+
+const defaultServer = "http://localhost:8080"
+
+// runCommand runs a CLI subcommand against a running server instead of starting one.
+func runCommand(name string, args []string) error {
+	switch name {
+	case "export":
+		return runExport(args)
+	case "import":
+		return runImport(args)
+	case "seed":
+		return runSeed(args)
+	case "bench":
+		return runBench(args)
+	default:
+		return fmt.Errorf("unknown command %q (want export, import, seed or bench)", name)
+	}
+}
+
+// runExport streams the keyspace from the server into a file or stdout.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	server := fs.String("server", defaultServer, "server base URL")
+	format := fs.String("format", "ndjson", "output format: ndjson or csv")
+	prefix := fs.String("prefix", "", "only export keys with this prefix")
+	file := fs.String("o", "", "output file (default stdout)")
+	fs.Parse(args)
+
+	q := url.Values{"format": {*format}, "prefix": {*prefix}}
+	resp, err := http.Get(*server + "/kvs/export?" + q.Encode())
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("export failed: %s: %s", resp.Status, msg)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// runImport streams a file or stdin to the server and prints the import summary.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	server := fs.String("server", defaultServer, "server base URL")
+	format := fs.String("format", "ndjson", "input format: ndjson or csv")
+	mode := fs.String("mode", "skip", "conflict mode: skip, overwrite or fail")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	file := fs.String("f", "", "input file (default stdin)")
+	fs.Parse(args)
+
+	in := io.Reader(os.Stdin)
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	q := url.Values{"format": {*format}, "mode": {*mode}, "dry_run": {strconv.FormatBool(*dryRun)}}
+	resp, err := http.Post(*server+"/kvs/import?"+q.Encode(), "application/octet-stream", in)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("import failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// benchFlags are the flags seed and bench share.
+type benchFlags struct {
+	server, prefix, valueSize, jsonFile *string
+	workers, keys                       *int
+	rate                                *float64
+}
+
+func addBenchFlags(fs *flag.FlagSet) benchFlags {
+	return benchFlags{
+		server:    fs.String("server", defaultServer, "server base URL"),
+		workers:   fs.Int("workers", db.DefaultWorkers, "concurrent clients"),
+		keys:      fs.Int("keys", db.DefaultKeys, "size of the key space"),
+		prefix:    fs.String("prefix", db.DefaultKeyPrefix, "prefix of every key"),
+		valueSize: fs.String("value-size", strconv.Itoa(db.DefaultValueSize), "value sizes in bytes: n, uniform:min-max or zipfian:min-max"),
+		rate:      fs.Float64("rate", 0, "target operations per second over all workers (0 for as fast as possible)"),
+		jsonFile:  fs.String("json", "", "also write the results as JSON to this file, - for stdout only"),
+	}
+}
+
+func (f benchFlags) config() (db.Config, error) {
+	size, err := db.ParseSizeDist(*f.valueSize)
+	if err != nil {
+		return db.Config{}, err
+	}
+	return db.Config{
+		Server:    *f.server,
+		Workers:   *f.workers,
+		Keys:      *f.keys,
+		KeyPrefix: *f.prefix,
+		ValueSize: size,
+		Rate:      *f.rate,
+	}, nil
+}
+
+// report prints res as text, or as JSON to the file given with -json.
+func (f benchFlags) report(res *db.Result) error {
+	if *f.jsonFile == "-" {
+		return res.WriteJSON(os.Stdout)
+	}
+	if err := res.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if *f.jsonFile == "" {
+		return nil
+	}
+	out, err := os.Create(*f.jsonFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return res.WriteJSON(out)
+}
+
+// runSeed loads the key space a benchmark runs over.
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	bf := addBenchFlags(fs)
+	fs.Parse(args)
+
+	cfg, err := bf.config()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := db.Seed(ctx, cfg)
+	if res != nil {
+		if err := bf.report(res); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// runBench runs a workload against a server and reports its throughput and latencies.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	bf := addBenchFlags(fs)
+	workload := fs.String("workload", "a", "YCSB workload a to f, or a mix such as read=0.8,update=0.2,dist=uniform")
+	duration := fs.Duration("duration", db.DefaultDuration, "how long to run")
+	operations := fs.Int("operations", 0, "stop after this many operations (0 for no limit)")
+	load := fs.Bool("load", false, "load the key space before the run")
+	fs.Parse(args)
+
+	cfg, err := bf.config()
+	if err != nil {
+		return err
+	}
+	if cfg.Workload, err = db.ParseWorkload(*workload); err != nil {
+		return err
+	}
+	cfg.Duration, cfg.Operations = *duration, *operations
+
+	// ^C stops the run early and still reports it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *load {
+		loadCfg := cfg
+		loadCfg.Rate = 0
+		if _, err := db.Seed(ctx, loadCfg); err != nil {
+			return fmt.Errorf("loading the key space: %w", err)
+		}
+	}
+	res, err := db.Run(ctx, cfg)
+	if res != nil {
+		if err := bf.report(res); err != nil {
+			return err
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
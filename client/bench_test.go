@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// The benchmarks compare the binary protocol with JSON over HTTP for small keys, one request at a time
+// and from many goroutines sharing a client. Run them with
+//
+//	go test ./client -run '^$' -bench . -benchmem
+
+func BenchmarkGet(b *testing.B) {
+	ctx := context.Background()
+	New(server.URL).Upsert(ctx, "bench:get", "a small value")
+
+	b.Run("HTTP", func(b *testing.B) {
+		c := New(server.URL)
+		for b.Loop() {
+			if _, err := c.Get(ctx, "bench:get"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Wire", func(b *testing.B) {
+		c := dial(b)
+		for b.Loop() {
+			if _, err := c.Get(ctx, "bench:get"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUpsert(b *testing.B) {
+	ctx := context.Background()
+
+	b.Run("HTTP", func(b *testing.B) {
+		c := New(server.URL)
+		for i := 0; b.Loop(); i++ {
+			if err := c.Upsert(ctx, fmt.Sprintf("bench:http:%d", i%1000), i); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Wire", func(b *testing.B) {
+		c := dial(b)
+		for i := 0; b.Loop(); i++ {
+			if err := c.Upsert(ctx, fmt.Sprintf("bench:wire:%d", i%1000), i); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	ctx := context.Background()
+	New(server.URL).Upsert(ctx, "bench:get", "a small value")
+
+	b.Run("HTTP", func(b *testing.B) {
+		c := New(server.URL)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := c.Get(ctx, "bench:get"); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+	b.Run("Wire", func(b *testing.B) {
+		c := dial(b)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := c.Get(ctx, "bench:get"); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
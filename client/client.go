@@ -0,0 +1,302 @@
+// Package client is a Go client for the key/value store server. It has a typed method for every
+// operation of the store, maps error responses back to the sentinel errors of the helpers package,
+// retries failures that may be transient with exponential backoff and reuses connections.
+//
+//	c := client.New("http://localhost:8080")
+//	if err := c.Add(ctx, "user:1", User{Name: "ada"}); errors.Is(err, helpers.DuplicateKeyError) {
+//		...
+//	}
+//	u, err := client.GetInto[User](ctx, c, "user:1")
+//
+// Conn speaks the binary protocol of package wire instead, for the key operations of the store.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kvstore/helpers"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRetries is how many times a request is retried after a failure that may be transient.
+	DefaultRetries = 3
+
+	// DefaultMinBackoff and DefaultMaxBackoff bound the wait between retries, which doubles with
+	// every attempt.
+	DefaultMinBackoff = 50 * time.Millisecond
+	DefaultMaxBackoff = 2 * time.Second
+
+	// basePath is where the server serves the store.
+	basePath = "/kvs"
+)
+
+// transport is shared by every client that does not bring its own, so they all reuse connections.
+// Go's default keeps only two idle connections per host, too few for a busy client of one server.
+var transport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 256
+	t.MaxIdleConnsPerHost = 64
+	return t
+}()
+
+// Client talks to one server. It is safe for concurrent use; its fields must not change once it is
+// in use.
+type Client struct {
+	BaseURL string       // e.g. http://localhost:8080
+	HTTP    *http.Client // Client with a shared, pooled transport if nil
+
+	Retries    int           // Retries after a transient failure, none if negative
+	MinBackoff time.Duration // Wait before the first retry
+	MaxBackoff time.Duration // Longest wait between retries
+
+	// User and Password are sent as basic auth, for an authenticating proxy in front of the server.
+	User, Password string
+}
+
+// New returns a client of the server at baseURL with the default retry policy.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTP:       &http.Client{Transport: transport},
+		Retries:    DefaultRetries,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+}
+
+// Error is an error response from the server. It unwraps to the sentinel error of the helpers package
+// the server answered with, so callers can test for it with errors.Is.
+type Error struct {
+	StatusCode int
+	Message    string
+	Err        error // Sentinel error, nil if the response matched none
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("kvstore: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// sentinels are the errors the server reports, by the message it starts the response with.
+var sentinels = []error{
+	helpers.MissingKeyError,
+	helpers.EmptyValueError,
+	helpers.MissingValueError,
+	helpers.NotExistError,
+	helpers.DuplicateKeyError,
+	helpers.MethodNotAllowed,
+	helpers.InvalidParamError,
+	helpers.LeaseHeldError,
+	helpers.NotLeaseOwnerError,
+	helpers.QueueEmptyError,
+	helpers.InvalidReceiptError,
+	helpers.NotLeaderError,
+	helpers.NoOwnerError,
+	helpers.RevisionMismatchError,
+}
+
+// responseError builds the error for a response that was not a success.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return newError(resp.StatusCode, string(body))
+}
+
+func newError(status int, body string) *Error {
+	msg := strings.TrimSpace(body)
+	e := &Error{StatusCode: status, Message: msg}
+	for _, s := range sentinels {
+		if strings.HasPrefix(msg, s.Error()) {
+			e.Err = s
+			break
+		}
+	}
+	return e
+}
+
+// request describes a call to the server.
+type request struct {
+	method string
+	path   string // Relative to basePath
+	query  url.Values
+	body   []byte    // JSON body, sent again on every attempt
+	stream io.Reader // Body read once, which rules out retries
+	accept []int     // Statuses of 300 and above that carry a result rather than an error
+}
+
+// do sends req, retrying transient failures, and returns the response, whose body the caller must close.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	u := c.BaseURL + basePath + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		body := req.stream
+		if req.body != nil {
+			body = bytes.NewReader(req.body)
+		}
+		hreq, err := http.NewRequestWithContext(ctx, req.method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		if req.body != nil {
+			hreq.Header.Set("Content-Type", "application/json")
+		}
+		if c.User != "" {
+			hreq.SetBasicAuth(c.User, c.Password)
+		}
+
+		resp, err := c.httpClient().Do(hreq)
+		if err == nil && (resp.StatusCode < 300 || containsStatus(req.accept, resp.StatusCode)) {
+			return resp, nil
+		}
+
+		var retryAfter time.Duration
+		if err == nil {
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			err = responseError(resp)
+			resp.Body.Close()
+		}
+		if attempt >= c.Retries || req.stream != nil || !retryable(req.method, err) {
+			return nil, err
+		}
+
+		wait := max(c.backoff(attempt), retryAfter)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP == nil {
+		return &http.Client{Transport: transport}
+	}
+	return c.HTTP
+}
+
+// backoff returns how long to wait before retry attempt+1: a random duration up to MinBackoff doubled
+// attempt times, capped at MaxBackoff. The randomness keeps clients that failed together from
+// retrying together.
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.MinBackoff << min(attempt, 30)
+	if c.MaxBackoff > 0 && (ceiling > c.MaxBackoff || ceiling <= 0) {
+		ceiling = c.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return ceiling/2 + rand.N(ceiling/2+1)
+}
+
+// retryable reports whether a request that failed with err may succeed if sent again. Requests that
+// never reached the server, and those the server turned away because it could not serve them yet
+// (no leader, no owner of the key), are always safe to repeat. Other failures are only retried for
+// methods that are idempotent, as the first attempt may have been applied. DELETE is not among them:
+// a delete repeated after one that was applied fails with helpers.NotExistError.
+func retryable(method string, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		switch e.StatusCode {
+		case http.StatusServiceUnavailable:
+			return true
+		case http.StatusBadGateway, http.StatusGatewayTimeout:
+			return idempotent(method)
+		}
+		return false
+	}
+
+	var op *net.OpError
+	if errors.As(err, &op) && op.Op == "dial" {
+		return true
+	}
+	return idempotent(method)
+}
+
+func idempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodPut
+}
+
+func containsStatus(statuses []int, status int) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return 0
+}
+
+// call sends req and decodes the JSON response into out, unless out is nil.
+func (c *Client) call(ctx context.Context, req request, out any) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("kvstore: decoding response of %s %s: %w", req.method, req.path, err)
+	}
+	// Drain what the decoder left, so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// encode returns the JSON encoding of a value to store.
+func encode(value any) ([]byte, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("kvstore: encoding value: %w", err)
+	}
+	return b, nil
+}
+
+func keyQuery(key string) url.Values {
+	return url.Values{"key": {key}}
+}
+
+func duration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// setIf sets name in q if value is not empty, so the server applies its default otherwise.
+func setIf(q url.Values, name, value string) url.Values {
+	if value != "" && value != "0" {
+		q.Set(name, value)
+	}
+	return q
+}
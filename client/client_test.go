@@ -0,0 +1,249 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	kvhttp "kvstore/http"
+	"kvstore/store"
+	"kvstore/transfer"
+	wireserver "kvstore/wire/server"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	server   *httptest.Server
+	wireAddr string // Address of the binary protocol
+)
+
+// TestMain serves the real handlers and the binary protocol, backed by the global store and request loop.
+func TestMain(m *testing.M) {
+	go channels.Requests()
+
+	mux := http.NewServeMux()
+	for path, h := range map[string]http.HandlerFunc{
+		"/ping": kvhttp.Ping, "/get": kvhttp.Get, "/add": kvhttp.Add, "/update": kvhttp.Update,
+		"/upsert": kvhttp.Upsert, "/delete": kvhttp.Delete, "/exists": kvhttp.Exists,
+		"/get_all": kvhttp.GetAll, "/export": kvhttp.Export, "/import": kvhttp.Import,
+		"/tags/set": kvhttp.SetTags, "/tags/find": kvhttp.FindByTags,
+		"/lease/acquire": kvhttp.AcquireLease, "/queue/dequeue": kvhttp.Dequeue,
+		"/ratelimit": kvhttp.RateLimit,
+	} {
+		mux.HandleFunc(basePath+path, h)
+	}
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	wsrv := &wireserver.Server{}
+	go wsrv.Serve(l)
+	defer wsrv.Close()
+	wireAddr = l.Addr().String()
+
+	os.Exit(m.Run())
+}
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	c := New(server.URL)
+
+	if err := c.Add(ctx, "client:user", user{"ada", 36}); err != nil {
+		t.Fatalf("Add() = %v", err)
+	}
+	if err := c.Add(ctx, "client:user", user{}); !errors.Is(err, helpers.DuplicateKeyError) {
+		t.Errorf("Add() of an existing key = %v, want DuplicateKeyError", err)
+	}
+
+	u, err := GetInto[user](ctx, c, "client:user")
+	if err != nil || u != (user{"ada", 36}) {
+		t.Errorf("GetInto() = %+v, %v", u, err)
+	}
+	if err := c.Upsert(ctx, "client:user", user{"ada", 37}); err != nil {
+		t.Fatalf("Upsert() = %v", err)
+	}
+	if raw, err := c.Get(ctx, "client:user"); err != nil || !strings.Contains(string(raw), `"age":37`) {
+		t.Errorf("Get() = %s, %v", raw, err)
+	}
+
+	if err := c.Delete(ctx, "client:user"); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if _, err := c.Get(ctx, "client:user"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Get() of a deleted key = %v, want NotExistError", err)
+	}
+	if ok, err := c.Exists(ctx, "client:user"); ok || err != nil {
+		t.Errorf("Exists() of a deleted key = %v, %v, want false, nil", ok, err)
+	}
+	if err := c.Update(ctx, "client:user", 1); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Update() of a deleted key = %v, want NotExistError", err)
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	ctx := context.Background()
+	c := New(server.URL)
+
+	if err := c.Add(ctx, "", 1); !errors.Is(err, helpers.MissingKeyError) {
+		t.Errorf("Add() with no key = %v, want MissingKeyError", err)
+	}
+	if _, err := c.Dequeue(ctx, "client:empty", 0); !errors.Is(err, helpers.QueueEmptyError) {
+		t.Errorf("Dequeue() of an empty queue = %v, want QueueEmptyError", err)
+	}
+	if _, err := c.FindByTags(ctx, "=="); !errors.Is(err, helpers.InvalidParamError) {
+		t.Errorf("FindByTags() with a bad selector = %v, want InvalidParamError", err)
+	}
+
+	if _, err := c.AcquireLease(ctx, "client:lease", "a", time.Minute, 0); err != nil {
+		t.Fatalf("AcquireLease() = %v", err)
+	}
+	_, err := c.AcquireLease(ctx, "client:lease", "b", time.Minute, 0)
+	var e *Error
+	if !errors.Is(err, helpers.LeaseHeldError) || !errors.As(err, &e) || e.StatusCode != http.StatusConflict {
+		t.Errorf("AcquireLease() of a held lease = %v, want LeaseHeldError with status 409", err)
+	}
+}
+
+func TestRateLimitDenialIsNotAnError(t *testing.T) {
+	ctx := context.Background()
+	c := New(server.URL)
+	limit := store.RateLimit{Capacity: 1, Rate: 0.001}
+	// Clearing the store leaves rate limits be, so every run takes a limit of its own
+	key := fmt.Sprintf("client:rate:%d", time.Now().UnixNano())
+
+	if res, err := c.AllowRate(ctx, key, limit); err != nil || !res.Allowed {
+		t.Fatalf("first AllowRate() = %+v, %v", res, err)
+	}
+	if res, err := c.AllowRate(ctx, key, limit); err != nil || res.Allowed || res.RetryAfterMs <= 0 {
+		t.Errorf("second AllowRate() = %+v, %v, want a denial", res, err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	c := New(server.URL)
+
+	for _, k := range []string{"client:x:1", "client:x:2"} {
+		if err := c.Upsert(ctx, k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := c.Export(ctx, &buf, transfer.NDJSON, "client:x:"); err != nil {
+		t.Fatalf("Export() = %v", err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Fatalf("Export() wrote %d records, want 2:\n%s", n, buf.String())
+	}
+
+	summary, err := c.Import(ctx, &buf, transfer.NDJSON, store.ImportOptions{Mode: store.ConflictSkip, DryRun: true})
+	if err != nil || summary.Total != 2 || summary.Skipped != 2 {
+		t.Errorf("Import() = %+v, %v", summary, err)
+	}
+
+	bad := strings.NewReader("{\"key\":\"client:x:1\",\"value\":1}\n")
+	summary, err = c.Import(ctx, bad, transfer.NDJSON, store.ImportOptions{Mode: store.ConflictFail})
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusBadRequest || summary.Aborted == "" {
+		t.Errorf("aborted Import() = %+v, %v, want the summary and a 400", summary, err)
+	}
+}
+
+// flaky fails the first n requests with status, then succeeds.
+func flaky(n int32, status int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= n {
+			http.Error(w, helpers.NotLeaderError.Error(), status)
+			return
+		}
+		w.Write([]byte("42\n"))
+	}))
+	return srv, &calls
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+
+	srv, calls := flaky(2, http.StatusServiceUnavailable)
+	defer srv.Close()
+	c := New(srv.URL)
+	c.MinBackoff = time.Millisecond
+
+	if n, err := GetInto[int](ctx, c, "k"); err != nil || n != 42 {
+		t.Errorf("GetInto() = %v, %v, want 42 after retries", n, err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("made %d requests, want 3", got)
+	}
+
+	// POSTs are not retried after a failure that may have been applied
+	srv, calls = flaky(1, http.StatusBadGateway)
+	defer srv.Close()
+	c = New(srv.URL)
+	c.MinBackoff = time.Millisecond
+	if err := c.Add(ctx, "k", 1); err == nil {
+		t.Error("Add() retried after a 502")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1", got)
+	}
+
+	// Nor are DELETEs, which would fail with NotExistError if the first attempt was applied
+	srv, calls = flaky(1, http.StatusBadGateway)
+	defer srv.Close()
+	c = New(srv.URL)
+	c.MinBackoff = time.Millisecond
+	if err := c.Delete(ctx, "k"); err == nil {
+		t.Error("Delete() retried after a 502")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1", got)
+	}
+
+	// Running out of retries returns the last error
+	srv, calls = flaky(10, http.StatusServiceUnavailable)
+	defer srv.Close()
+	c = New(srv.URL)
+	c.Retries, c.MinBackoff = 2, time.Millisecond
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, helpers.NotLeaderError) {
+		t.Errorf("Get() = %v, want NotLeaderError", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("made %d requests, want 3", got)
+	}
+}
+
+func TestContextCancelsBackoff(t *testing.T) {
+	srv, _ := flaky(100, http.StatusServiceUnavailable)
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Retries, c.MinBackoff, c.MaxBackoff = 100, time.Hour, time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Get() took %s after the context expired", elapsed)
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"kvstore/crdt"
+	"kvstore/store"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// The replicated keys below are only served by servers running in multi-master mode.
+
+// CRDT returns the type and value of the replicated key.
+func (c *Client) CRDT(ctx context.Context, key string) (store.CRDT, error) {
+	var v store.CRDT
+	err := c.call(ctx, request{method: http.MethodGet, path: "/crdt", query: keyQuery(key)}, &v)
+	return v, err
+}
+
+// AllCRDTs returns every replicated key.
+func (c *Client) AllCRDTs(ctx context.Context) ([]store.CRDT, error) {
+	var all []store.CRDT
+	err := c.call(ctx, request{method: http.MethodGet, path: "/crdt"}, &all)
+	return all, err
+}
+
+// DeclareCRDT makes key a replicated value of type t.
+func (c *Client) DeclareCRDT(ctx context.Context, key string, t crdt.Type) (store.CRDT, error) {
+	q := keyQuery(key)
+	q.Set("type", string(t))
+	return c.crdt(ctx, http.MethodPost, "/crdt/declare", q, nil)
+}
+
+// UpdateCRDT applies u to the replicated key and returns its new value. An increment By zero adds 1.
+func (c *Client) UpdateCRDT(ctx context.Context, key string, u store.CRDTUpdate) (store.CRDT, error) {
+	q := keyQuery(key)
+	setIf(q, "field", u.Field)
+
+	var body []byte
+	var err error
+	method, path := http.MethodPost, "/crdt/"+string(u.Op)
+	switch u.Op {
+	case store.CRDTSet, store.CRDTPut:
+		method = http.MethodPut
+		fallthrough
+	case store.CRDTAdd, store.CRDTRemove:
+		if body, err = encode(u.Value); err != nil {
+			return store.CRDT{}, err
+		}
+	case store.CRDTIncrement:
+		setIf(q, "by", strconv.FormatInt(u.By, 10))
+	case store.CRDTDelete:
+		method = http.MethodDelete
+	}
+	return c.crdt(ctx, method, path, q, body)
+}
+
+func (c *Client) crdt(ctx context.Context, method, path string, q url.Values, body []byte) (store.CRDT, error) {
+	var v store.CRDT
+	err := c.call(ctx, request{method: method, path: path, query: q, body: body}, &v)
+	return v, err
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"kvstore/helpers"
+	"kvstore/store"
+	"net/http"
+	"net/url"
+)
+
+// ServerInfo is the answer to a ping.
+type ServerInfo struct {
+	ServiceName string `json:"service_name"`
+	Version     string `json:"version"`
+	HostName    string `json:"hostname"`
+	DateTime    string `json:"datetime"`
+}
+
+// Ping checks the server is up and returns its name and version.
+func (c *Client) Ping(ctx context.Context) (ServerInfo, error) {
+	var info ServerInfo
+	err := c.call(ctx, request{method: http.MethodGet, path: "/ping"}, &info)
+	return info, err
+}
+
+// Get returns the JSON value stored under key.
+func (c *Client) Get(ctx context.Context, key string) (json.RawMessage, error) {
+	var v json.RawMessage
+	err := c.call(ctx, request{method: http.MethodGet, path: "/get", query: keyQuery(key)}, &v)
+	return v, err
+}
+
+// GetInto returns the value stored under key decoded into a T.
+func GetInto[T any](ctx context.Context, c *Client, key string) (T, error) {
+	var v T
+	err := c.call(ctx, request{method: http.MethodGet, path: "/get", query: keyQuery(key)}, &v)
+	return v, err
+}
+
+// GetAllInto returns every value in the store decoded into a T, by key.
+func GetAllInto[T any](ctx context.Context, c *Client) (map[string]T, error) {
+	var all map[string]T
+	err := c.call(ctx, request{method: http.MethodGet, path: "/get_all"}, &all)
+	return all, err
+}
+
+// GetWithMeta returns the value stored under key together with its metadata.
+func (c *Client) GetWithMeta(ctx context.Context, key string) (store.Item, error) {
+	var item store.Item
+	q := keyQuery(key)
+	q.Set("include_meta", "true")
+	err := c.call(ctx, request{method: http.MethodGet, path: "/get", query: q}, &item)
+	return item, err
+}
+
+// Meta returns the metadata of key.
+func (c *Client) Meta(ctx context.Context, key string) (store.Metadata, error) {
+	var m store.Metadata
+	err := c.call(ctx, request{method: http.MethodGet, path: "/meta", query: keyQuery(key)}, &m)
+	return m, err
+}
+
+// Add stores value, encoded as JSON, under a new key. It fails with helpers.DuplicateKeyError if the
+// key exists.
+func (c *Client) Add(ctx context.Context, key string, value any) error {
+	return c.write(ctx, http.MethodPost, "/add", key, value)
+}
+
+// Update replaces the value of an existing key. It fails with helpers.NotExistError if there is none.
+func (c *Client) Update(ctx context.Context, key string, value any) error {
+	return c.write(ctx, http.MethodPut, "/update", key, value)
+}
+
+// Upsert stores value under key, whether the key exists or not.
+func (c *Client) Upsert(ctx context.Context, key string, value any) error {
+	return c.write(ctx, http.MethodPut, "/upsert", key, value)
+}
+
+func (c *Client) write(ctx context.Context, method, path, key string, value any) error {
+	body, err := encode(value)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, request{method: method, path: path, query: keyQuery(key), body: body}, nil)
+}
+
+// GetAll returns every value in the store by key.
+func (c *Client) GetAll(ctx context.Context) (map[string]json.RawMessage, error) {
+	return GetAllInto[json.RawMessage](ctx, c)
+}
+
+// GetAllWithMeta returns every value in the store together with its metadata, by key.
+func (c *Client) GetAllWithMeta(ctx context.Context) (map[string]store.Item, error) {
+	var items map[string]store.Item
+	q := url.Values{"include_meta": {"true"}}
+	err := c.call(ctx, request{method: http.MethodGet, path: "/get_all", query: q}, &items)
+	return items, err
+}
+
+// Exists reports whether key is in the store.
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := c.call(ctx, request{method: http.MethodGet, path: "/exists", query: keyQuery(key)}, &ok)
+	if errors.Is(err, helpers.NotExistError) {
+		return false, nil
+	}
+	return ok, err
+}
+
+// Count returns the number of keys in the store.
+func (c *Client) Count(ctx context.Context) (int, error) {
+	var n int
+	err := c.call(ctx, request{method: http.MethodGet, path: "/count"}, &n)
+	return n, err
+}
+
+// Clear removes every key from the store.
+func (c *Client) Clear(ctx context.Context) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "/clear"}, nil)
+}
+
+// SoftClear moves every key to the trash and returns the ID to restore them all with RestoreClear.
+func (c *Client) SoftClear(ctx context.Context) (string, error) {
+	var res struct {
+		ClearID string `json:"clear_id"`
+	}
+	q := url.Values{"soft": {"true"}}
+	err := c.call(ctx, request{method: http.MethodPost, path: "/clear", query: q}, &res)
+	return res.ClearID, err
+}
+
+// Delete removes key from the store.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: "/delete", query: keyQuery(key)}, nil)
+}
+
+// SoftDelete moves key to the trash, from where Restore can bring it back.
+func (c *Client) SoftDelete(ctx context.Context, key string) error {
+	q := keyQuery(key)
+	q.Set("soft", "true")
+	return c.call(ctx, request{method: http.MethodDelete, path: "/delete", query: q}, nil)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"kvstore/store"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// GetLease returns the current holder of the lease name.
+func (c *Client) GetLease(ctx context.Context, name string) (store.Lease, error) {
+	var l store.Lease
+	q := url.Values{"name": {name}}
+	err := c.call(ctx, request{method: http.MethodGet, path: "/lease", query: q}, &l)
+	return l, err
+}
+
+// AcquireLease takes the lease name for owner for ttl. If another owner holds it, it waits up to wait
+// for the lease to be released or to expire before failing with helpers.LeaseHeldError.
+func (c *Client) AcquireLease(ctx context.Context, name, owner string, ttl, wait time.Duration) (store.Lease, error) {
+	q := url.Values{"name": {name}, "owner": {owner}}
+	setIf(q, "ttl", duration(ttl))
+	setIf(q, "wait", duration(wait))
+
+	var l store.Lease
+	err := c.call(ctx, request{method: http.MethodPost, path: "/lease/acquire", query: q}, &l)
+	return l, err
+}
+
+// RenewLease extends the lease name held by owner by ttl.
+func (c *Client) RenewLease(ctx context.Context, name, owner string, ttl time.Duration) (store.Lease, error) {
+	q := url.Values{"name": {name}, "owner": {owner}}
+	setIf(q, "ttl", duration(ttl))
+
+	var l store.Lease
+	err := c.call(ctx, request{method: http.MethodPost, path: "/lease/renew", query: q}, &l)
+	return l, err
+}
+
+// ReleaseLease frees the lease name held by owner.
+func (c *Client) ReleaseLease(ctx context.Context, name, owner string) error {
+	q := url.Values{"name": {name}, "owner": {owner}}
+	return c.call(ctx, request{method: http.MethodPost, path: "/lease/release", query: q}, nil)
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"kvstore/store"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Enqueue appends value, encoded as JSON, to the queue name.
+func (c *Client) Enqueue(ctx context.Context, name string, value any) (store.Message, error) {
+	body, err := encode(value)
+	if err != nil {
+		return store.Message{}, err
+	}
+	var m store.Message
+	q := url.Values{"name": {name}}
+	err = c.call(ctx, request{method: http.MethodPost, path: "/queue/enqueue", query: q, body: body}, &m)
+	return m, err
+}
+
+// Dequeue takes the oldest message from the queue name and hides it from other consumers for
+// visibility, or the server's default if zero. It fails with helpers.QueueEmptyError if there is none.
+func (c *Client) Dequeue(ctx context.Context, name string, visibility time.Duration) (store.Message, error) {
+	q := setIf(url.Values{"name": {name}}, "visibility", duration(visibility))
+	var m store.Message
+	err := c.call(ctx, request{method: http.MethodPost, path: "/queue/dequeue", query: q}, &m)
+	return m, err
+}
+
+// Ack confirms the message dequeued with receipt has been processed.
+func (c *Client) Ack(ctx context.Context, name, receipt string) error {
+	q := url.Values{"name": {name}, "receipt": {receipt}}
+	return c.call(ctx, request{method: http.MethodPost, path: "/queue/ack", query: q}, nil)
+}
+
+// Nack returns the message dequeued with receipt to the queue for another delivery.
+func (c *Client) Nack(ctx context.Context, name, receipt string) error {
+	q := url.Values{"name": {name}, "receipt": {receipt}}
+	return c.call(ctx, request{method: http.MethodPost, path: "/queue/nack", query: q}, nil)
+}
+
+// QueueStats returns the counters of the queue name.
+func (c *Client) QueueStats(ctx context.Context, name string) (store.QueueStats, error) {
+	var stats store.QueueStats
+	q := url.Values{"name": {name}}
+	err := c.call(ctx, request{method: http.MethodGet, path: "/queue/stats", query: q}, &stats)
+	return stats, err
+}
+
+// AllQueueStats returns the counters of every queue.
+func (c *Client) AllQueueStats(ctx context.Context) ([]store.QueueStats, error) {
+	var stats []store.QueueStats
+	err := c.call(ctx, request{method: http.MethodGet, path: "/queue/stats"}, &stats)
+	return stats, err
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"context"
+	"kvstore/store"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// AllowRate takes limit.Cost tokens from the rate limit of key. A denied request is not an error: the
+// result says whether it was allowed and, if not, when to try again.
+func (c *Client) AllowRate(ctx context.Context, key string, limit store.RateLimit) (store.RateLimitResult, error) {
+	q := url.Values{
+		"key":      {key},
+		"capacity": {strconv.Itoa(limit.Capacity)},
+		"rate":     {strconv.FormatFloat(limit.Rate, 'g', -1, 64)},
+	}
+	setIf(q, "algorithm", string(limit.Algorithm))
+	setIf(q, "cost", strconv.Itoa(limit.Cost))
+
+	var res store.RateLimitResult
+	req := request{method: http.MethodPost, path: "/ratelimit", query: q, accept: []int{http.StatusTooManyRequests}}
+	err := c.call(ctx, req, &res)
+	return res, err
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"kvstore/store"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// StreamInfo returns the length, ID range and consumer groups of the stream name.
+func (c *Client) StreamInfo(ctx context.Context, name string) (store.StreamInfo, error) {
+	var info store.StreamInfo
+	q := url.Values{"name": {name}}
+	err := c.call(ctx, request{method: http.MethodGet, path: "/stream", query: q}, &info)
+	return info, err
+}
+
+// StreamAdd appends value, encoded as JSON, to the stream name. If maxLen is positive the stream is
+// trimmed to that many entries afterwards.
+func (c *Client) StreamAdd(ctx context.Context, name string, value any, maxLen int) (store.StreamEntry, error) {
+	body, err := encode(value)
+	if err != nil {
+		return store.StreamEntry{}, err
+	}
+	q := setIf(url.Values{"name": {name}}, "maxlen", strconv.Itoa(maxLen))
+	var e store.StreamEntry
+	err = c.call(ctx, request{method: http.MethodPost, path: "/stream/add", query: q, body: body}, &e)
+	return e, err
+}
+
+// StreamRange returns up to count entries (all if zero) of the stream name with IDs from start to end,
+// both inclusive. "-" and "+" stand for the first and last entry.
+func (c *Client) StreamRange(ctx context.Context, name, start, end string, count int) ([]store.StreamEntry, error) {
+	q := url.Values{"name": {name}}
+	setIf(q, "start", start)
+	setIf(q, "end", end)
+	setIf(q, "count", strconv.Itoa(count))
+	return c.entries(ctx, http.MethodGet, "/stream/range", q)
+}
+
+// StreamRead returns up to count entries of the stream name added after the ID after, "$" for the
+// current end. If there are none it waits up to wait for new ones.
+func (c *Client) StreamRead(ctx context.Context, name, after string, count int, wait time.Duration) ([]store.StreamEntry, error) {
+	q := url.Values{"name": {name}}
+	setIf(q, "after", after)
+	setIf(q, "count", strconv.Itoa(count))
+	setIf(q, "wait", duration(wait))
+	return c.entries(ctx, http.MethodGet, "/stream/read", q)
+}
+
+// StreamTail returns the last count entries of the stream name, or all of them if count is zero.
+func (c *Client) StreamTail(ctx context.Context, name string, count int) ([]store.StreamEntry, error) {
+	q := setIf(url.Values{"name": {name}}, "count", strconv.Itoa(count))
+	return c.entries(ctx, http.MethodGet, "/stream/tail", q)
+}
+
+// StreamTrim drops the oldest entries of the stream name beyond maxLen and returns how many it dropped.
+func (c *Client) StreamTrim(ctx context.Context, name string, maxLen int) (int, error) {
+	var res struct {
+		Trimmed int `json:"trimmed"`
+	}
+	q := url.Values{"name": {name}, "maxlen": {strconv.Itoa(maxLen)}}
+	err := c.call(ctx, request{method: http.MethodPost, path: "/stream/trim", query: q}, &res)
+	return res.Trimmed, err
+}
+
+// CreateGroup adds the consumer group to the stream name, starting after the ID start: "0" for the
+// whole stream or "$" for new entries only.
+func (c *Client) CreateGroup(ctx context.Context, name, group, start string) error {
+	q := setIf(url.Values{"name": {name}, "group": {group}}, "start", start)
+	return c.call(ctx, request{method: http.MethodPost, path: "/stream/group/create", query: q}, nil)
+}
+
+// ReadGroup delivers up to count new entries of the stream name to consumer of group.
+func (c *Client) ReadGroup(ctx context.Context, name, group, consumer string, count int) ([]store.StreamEntry, error) {
+	q := url.Values{"name": {name}, "group": {group}, "consumer": {consumer}}
+	setIf(q, "count", strconv.Itoa(count))
+	return c.entries(ctx, http.MethodPost, "/stream/group/read", q)
+}
+
+// StreamAck acknowledges the entries ids delivered to group and returns how many were pending.
+func (c *Client) StreamAck(ctx context.Context, name, group string, ids ...string) (int, error) {
+	var res struct {
+		Acked int `json:"acked"`
+	}
+	q := url.Values{"name": {name}, "group": {group}, "id": ids}
+	err := c.call(ctx, request{method: http.MethodPost, path: "/stream/group/ack", query: q}, &res)
+	return res.Acked, err
+}
+
+// Pending lists the entries delivered to group that have not been acknowledged.
+func (c *Client) Pending(ctx context.Context, name, group string) ([]store.PendingEntry, error) {
+	var pending []store.PendingEntry
+	q := url.Values{"name": {name}, "group": {group}}
+	err := c.call(ctx, request{method: http.MethodGet, path: "/stream/group/pending", query: q}, &pending)
+	return pending, err
+}
+
+// Claim hands the pending entries ids of group (all of them if none are given) that have been idle
+// for minIdle over to consumer.
+func (c *Client) Claim(ctx context.Context, name, group, consumer string, minIdle time.Duration, ids ...string) ([]store.StreamEntry, error) {
+	q := url.Values{"name": {name}, "group": {group}, "consumer": {consumer}}
+	setIf(q, "min_idle", duration(minIdle))
+	if len(ids) > 0 {
+		q["id"] = ids
+	}
+	return c.entries(ctx, http.MethodPost, "/stream/group/claim", q)
+}
+
+func (c *Client) entries(ctx context.Context, method, path string, q url.Values) ([]store.StreamEntry, error) {
+	var entries []store.StreamEntry
+	err := c.call(ctx, request{method: method, path: path, query: q}, &entries)
+	return entries, err
+}
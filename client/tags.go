@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Tags returns the tags of key.
+func (c *Client) Tags(ctx context.Context, key string) (map[string]string, error) {
+	var tags map[string]string
+	err := c.call(ctx, request{method: http.MethodGet, path: "/tags", query: keyQuery(key)}, &tags)
+	return tags, err
+}
+
+// SetTags adds tags to key and returns all its tags.
+func (c *Client) SetTags(ctx context.Context, key string, tags map[string]string) (map[string]string, error) {
+	body, err := encode(tags)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]string
+	err = c.call(ctx, request{method: http.MethodPost, path: "/tags/set", query: keyQuery(key), body: body}, &all)
+	return all, err
+}
+
+// RemoveTags removes the tags called names from key and returns the tags left.
+func (c *Client) RemoveTags(ctx context.Context, key string, names ...string) (map[string]string, error) {
+	q := keyQuery(key)
+	q["name"] = names
+	var left map[string]string
+	err := c.call(ctx, request{method: http.MethodDelete, path: "/tags/remove", query: q}, &left)
+	return left, err
+}
+
+// FindByTags lists the keys matching selector, such as "env=prod,team=core|env=staging".
+func (c *Client) FindByTags(ctx context.Context, selector string) ([]string, error) {
+	var keys []string
+	q := url.Values{"selector": {selector}}
+	err := c.call(ctx, request{method: http.MethodGet, path: "/tags/find", query: q}, &keys)
+	return keys, err
+}
+
+// CountByTags counts the keys matching selector.
+func (c *Client) CountByTags(ctx context.Context, selector string) (int, error) {
+	var n int
+	q := url.Values{"selector": {selector}}
+	err := c.call(ctx, request{method: http.MethodGet, path: "/tags/count", query: q}, &n)
+	return n, err
+}
+
+// DeleteByTags deletes every key matching selector, moving them to the trash if soft is set, and
+// returns the keys deleted.
+func (c *Client) DeleteByTags(ctx context.Context, selector string, soft bool) ([]string, error) {
+	var res struct {
+		Keys []string `json:"keys"`
+	}
+	q := url.Values{"selector": {selector}}
+	if soft {
+		q.Set("soft", "true")
+	}
+	err := c.call(ctx, request{method: http.MethodDelete, path: "/tags/delete", query: q}, &res)
+	return res.Keys, err
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"kvstore/audit"
+	"kvstore/store"
+	"kvstore/transfer"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Export writes every key starting with prefix to w, in format.
+func (c *Client) Export(ctx context.Context, w io.Writer, format transfer.Format, prefix string) error {
+	q := setIf(url.Values{"format": {string(format)}}, "prefix", prefix)
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: "/export", query: q})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Import streams the records read from r, in format, into the store. An import the server aborts
+// returns its summary along with the error. As r is read only once, an import is never retried.
+func (c *Client) Import(ctx context.Context, r io.Reader, format transfer.Format, opts store.ImportOptions) (transfer.Summary, error) {
+	q := url.Values{"format": {string(format)}}
+	setIf(q, "mode", string(opts.Mode))
+	setIf(q, "dry_run", strconv.FormatBool(opts.DryRun))
+
+	req := request{method: http.MethodPost, path: "/import", query: q, stream: r, accept: []int{http.StatusBadRequest}}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return transfer.Summary{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return transfer.Summary{}, err
+	}
+
+	var summary transfer.Summary
+	if resp.StatusCode == http.StatusOK {
+		err = json.Unmarshal(body, &summary)
+		return summary, err
+	}
+	// A bad request is either an aborted import, answered with its summary, or bad parameters
+	if json.Unmarshal(body, &summary) == nil && summary.Aborted != "" {
+		return summary, newError(resp.StatusCode, summary.Aborted)
+	}
+	return transfer.Summary{}, newError(resp.StatusCode, string(body))
+}
+
+// Trash lists the soft deleted keys.
+func (c *Client) Trash(ctx context.Context) ([]store.TrashEntry, error) {
+	var entries []store.TrashEntry
+	err := c.call(ctx, request{method: http.MethodGet, path: "/trash"}, &entries)
+	return entries, err
+}
+
+// Restore moves key out of the trash and returns its value.
+func (c *Client) Restore(ctx context.Context, key string) (json.RawMessage, error) {
+	var v json.RawMessage
+	err := c.call(ctx, request{method: http.MethodPost, path: "/trash/restore", query: keyQuery(key)}, &v)
+	return v, err
+}
+
+// RestoreClear moves every key removed by the soft clear with id out of the trash.
+func (c *Client) RestoreClear(ctx context.Context, id string) (store.RestoreResult, error) {
+	var res store.RestoreResult
+	q := url.Values{"clear_id": {id}}
+	err := c.call(ctx, request{method: http.MethodPost, path: "/trash/restore", query: q}, &res)
+	return res, err
+}
+
+// Purge permanently removes key from the trash, or the whole trash if key is empty, and returns the
+// number of keys removed.
+func (c *Client) Purge(ctx context.Context, key string) (int, error) {
+	var res struct {
+		Purged int `json:"purged"`
+	}
+	q := setIf(url.Values{}, "key", key)
+	err := c.call(ctx, request{method: http.MethodDelete, path: "/trash/purge", query: q}, &res)
+	return res.Purged, err
+}
+
+// Audit returns the audit records matching query.
+func (c *Client) Audit(ctx context.Context, query audit.Query) ([]audit.Record, error) {
+	q := url.Values{}
+	setIf(q, "key", query.Key)
+	setIf(q, "op", query.Op)
+	if !query.Since.IsZero() {
+		q.Set("since", query.Since.Format(time.RFC3339Nano))
+	}
+	if !query.Until.IsZero() {
+		q.Set("until", query.Until.Format(time.RFC3339Nano))
+	}
+	setIf(q, "limit", strconv.Itoa(query.Limit))
+
+	var records []audit.Record
+	err := c.call(ctx, request{method: http.MethodGet, path: "/audit", query: q}, &records)
+	return records, err
+}
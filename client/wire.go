@@ -0,0 +1,608 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kvstore/helpers"
+	"kvstore/store"
+	"kvstore/transfer"
+	"kvstore/wire"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn is a connection to the binary protocol of a server, see package wire, for clients to which the
+// cost of JSON over HTTP matters. Any number of goroutines may use it at once: their requests share the
+// connection and are answered as the server completes them. Errors from the server unwrap to the
+// sentinel errors of the helpers package, as with Client. Requests are not retried, and once the
+// connection fails every call fails with the error that broke it.
+type Conn struct {
+	nc     net.Conn
+	frames chan []byte // Requests for writeLoop to send
+
+	mu      sync.Mutex
+	pending map[uint32]chan wire.Response // By request ID
+	nextID  uint32
+	err     error         // Why the connection failed, once it has
+	done    chan struct{} // Closed once it has
+}
+
+// Dial connects to the binary protocol of the server at addr, e.g. localhost:7070.
+func Dial(ctx context.Context, addr string) (*Conn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		nc:      nc,
+		frames:  make(chan []byte, 256),
+		pending: make(map[uint32]chan wire.Response),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.writeLoop()
+	return c, nil
+}
+
+// Close closes the connection. Calls in flight fail with net.ErrClosed.
+func (c *Conn) Close() error {
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+// fail closes the connection because of err, failing the calls in flight. Only the first error counts.
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	c.err = fmt.Errorf("kvstore: connection failed: %w", err)
+	close(c.done)
+	c.nc.Close()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+func (c *Conn) readLoop() {
+	r := bufio.NewReader(c.nc)
+	for {
+		resp, err := wire.ReadResponse(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// writeLoop sends requests as they come, flushing once none are waiting, so that requests made
+// together are sent together.
+func (c *Conn) writeLoop() {
+	w := bufio.NewWriter(c.nc)
+	for {
+		select {
+		case <-c.done:
+			return
+		case frame := <-c.frames:
+			_, err := w.Write(frame)
+			if err == nil && len(c.frames) == 0 {
+				err = w.Flush()
+			}
+			if err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// do sends req and returns the value of its response.
+func (c *Conn) do(ctx context.Context, req wire.Request) ([]byte, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	ch := make(chan wire.Response, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	req.ID = c.nextID
+	c.pending[req.ID] = ch
+	c.mu.Unlock()
+
+	select {
+	case c.frames <- req.Append(nil):
+	case <-c.done:
+	case <-ctx.Done():
+		c.forget(req.ID)
+		return nil, ctx.Err()
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, c.failure()
+		}
+		return resp.Value, resp.Err()
+	case <-ctx.Done():
+		// The response is dropped when it comes
+		c.forget(req.ID)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Conn) forget(id uint32) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Conn) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Ping checks the server is up and returns its name.
+func (c *Conn) Ping(ctx context.Context) (string, error) {
+	b, err := c.do(ctx, wire.Request{Op: wire.OpPing})
+	return string(b), err
+}
+
+// Get returns the JSON value stored under key.
+func (c *Conn) Get(ctx context.Context, key string) (json.RawMessage, error) {
+	return c.do(ctx, wire.Request{Op: wire.OpGet, Key: key})
+}
+
+// GetBytes returns the bytes stored under key by Write or by the Redis and memcached protocols. Other
+// values are returned as their JSON encoding, strings aside.
+func (c *Conn) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return c.do(ctx, wire.Request{Op: wire.OpGet, Flags: wire.FlagRaw, Key: key})
+}
+
+// GetWithMeta returns the value stored under key together with its metadata.
+func (c *Conn) GetWithMeta(ctx context.Context, key string) (store.Item, error) {
+	var item store.Item
+	b, err := c.do(ctx, wire.Request{Op: wire.OpGetWithMeta, Key: key})
+	if err != nil {
+		return item, err
+	}
+	if len(b) < 4 || len(b)-4 < int(binary.BigEndian.Uint32(b)) {
+		return item, wire.ErrMalformed
+	}
+	n := 4 + int(binary.BigEndian.Uint32(b)) // End of the metadata
+	if err := json.Unmarshal(b[4:n], &item.Metadata); err != nil {
+		return item, err
+	}
+	return item, json.Unmarshal(b[n:], &item.Value)
+}
+
+// Meta returns the metadata of key.
+func (c *Conn) Meta(ctx context.Context, key string) (store.Metadata, error) {
+	var md store.Metadata
+	b, err := c.do(ctx, wire.Request{Op: wire.OpMeta, Key: key})
+	if err != nil {
+		return md, err
+	}
+	return md, json.Unmarshal(b, &md)
+}
+
+// Exists reports whether key is in the store.
+func (c *Conn) Exists(ctx context.Context, key string) (bool, error) {
+	b, err := c.do(ctx, wire.Request{Op: wire.OpExists, Key: key})
+	return len(b) == 1 && b[0] == 1, err
+}
+
+// Count returns the number of keys in the store.
+func (c *Conn) Count(ctx context.Context) (int, error) {
+	b, err := c.do(ctx, wire.Request{Op: wire.OpCount})
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 8 {
+		return 0, wire.ErrMalformed
+	}
+	return int(binary.BigEndian.Uint64(b)), nil
+}
+
+// GetAll returns every value in the store by key, as of a single point in time.
+func (c *Conn) GetAll(ctx context.Context) (map[string]json.RawMessage, error) {
+	b, err := c.do(ctx, wire.Request{Op: wire.OpGetAll})
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]json.RawMessage)
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return nil, wire.ErrMalformed
+		}
+		k := int(binary.BigEndian.Uint16(b))
+		if b = b[2:]; len(b) < k+4 {
+			return nil, wire.ErrMalformed
+		}
+		key := string(b[:k])
+		n := int(binary.BigEndian.Uint32(b[k:]))
+		if b = b[k+4:]; len(b) < n {
+			return nil, wire.ErrMalformed
+		}
+		all[key] = json.RawMessage(b[:n:n])
+		b = b[n:]
+	}
+	return all, nil
+}
+
+// Add stores value, encoded as JSON, under a new key. It fails with helpers.DuplicateKeyError if the
+// key exists.
+func (c *Conn) Add(ctx context.Context, key string, value any) error {
+	return c.put(ctx, wire.OpAdd, key, value)
+}
+
+// Update replaces the value of an existing key. It fails with helpers.NotExistError if there is none.
+func (c *Conn) Update(ctx context.Context, key string, value any) error {
+	return c.put(ctx, wire.OpUpdate, key, value)
+}
+
+// Upsert stores value under key, whether the key exists or not.
+func (c *Conn) Upsert(ctx context.Context, key string, value any) error {
+	return c.put(ctx, wire.OpUpsert, key, value)
+}
+
+func (c *Conn) put(ctx context.Context, op wire.Op, key string, value any) error {
+	body, err := encode(value)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, wire.Request{Op: op, Key: key, Value: body})
+	return err
+}
+
+// Write stores value, as bytes, under key if the conditions of opts hold. It fails with
+// helpers.DuplicateKeyError, helpers.NotExistError or helpers.RevisionMismatchError when they do not;
+// the revision of a key is that of its metadata, see store.Metadata.Revision.
+func (c *Conn) Write(ctx context.Context, key string, value []byte, opts store.WriteOptions) error {
+	_, err := c.do(ctx, wire.Request{
+		Op:     wire.OpWrite,
+		Flags:  wire.FlagRaw,
+		Extras: wire.AppendWriteOptions(nil, opts),
+		Key:    key,
+		Value:  value,
+	})
+	return err
+}
+
+// Delete removes key from the store.
+func (c *Conn) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, wire.Request{Op: wire.OpDelete, Key: key})
+	return err
+}
+
+// Clear removes every key from the store.
+func (c *Conn) Clear(ctx context.Context) error {
+	_, err := c.do(ctx, wire.Request{Op: wire.OpClear})
+	return err
+}
+
+// Incr adds delta to the integer stored under key, which counts as zero if missing, and returns the
+// result.
+func (c *Conn) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	b, err := c.do(ctx, wire.Request{Op: wire.OpIncr, Extras: wire.AppendInt64(nil, delta), Key: key})
+	if err != nil {
+		return 0, err
+	}
+	return wire.Int64(b)
+}
+
+// Expire sets key to expire once ttl has passed. A ttl of zero or less removes it at once.
+func (c *Conn) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := c.do(ctx, wire.Request{Op: wire.OpExpire, Extras: wire.AppendInt64(nil, int64(ttl)), Key: key})
+	return err
+}
+
+// Persist removes the expiry of key, reporting whether it had one.
+func (c *Conn) Persist(ctx context.Context, key string) (bool, error) {
+	b, err := c.do(ctx, wire.Request{Op: wire.OpPersist, Key: key})
+	return len(b) == 1 && b[0] == 1, err
+}
+
+// call sends a request with args, for the operations beyond the key space, and decodes the JSON it is
+// answered with into out unless out is nil.
+func (c *Conn) call(ctx context.Context, op wire.Op, key string, args wire.Args, out any) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("kvstore: encoding arguments: %w", err)
+	}
+	b, err := c.do(ctx, wire.Request{Op: op, Key: key, Value: body})
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// SoftDelete moves key to the trash.
+func (c *Conn) SoftDelete(ctx context.Context, key string) error {
+	return c.call(ctx, wire.OpSoftDelete, key, wire.Args{}, nil)
+}
+
+// SoftClear moves every key to the trash and returns the ID that restores them all.
+func (c *Conn) SoftClear(ctx context.Context) (string, error) {
+	var id string
+	err := c.call(ctx, wire.OpSoftClear, "", wire.Args{}, &id)
+	return id, err
+}
+
+// Trash lists the soft deleted keys.
+func (c *Conn) Trash(ctx context.Context) ([]store.TrashEntry, error) {
+	var entries []store.TrashEntry
+	err := c.call(ctx, wire.OpTrash, "", wire.Args{}, &entries)
+	return entries, err
+}
+
+// Restore moves key out of the trash and returns its value.
+func (c *Conn) Restore(ctx context.Context, key string) (json.RawMessage, error) {
+	var v json.RawMessage
+	err := c.call(ctx, wire.OpRestore, key, wire.Args{}, &v)
+	return v, err
+}
+
+// RestoreClear moves every key removed by the soft clear with id out of the trash.
+func (c *Conn) RestoreClear(ctx context.Context, id string) (store.RestoreResult, error) {
+	var res store.RestoreResult
+	err := c.call(ctx, wire.OpRestoreClear, id, wire.Args{}, &res)
+	return res, err
+}
+
+// Purge permanently removes key from the trash, or the whole trash if key is empty, and returns the
+// number of keys removed.
+func (c *Conn) Purge(ctx context.Context, key string) (int, error) {
+	var n int
+	err := c.call(ctx, wire.OpPurge, key, wire.Args{}, &n)
+	return n, err
+}
+
+// Tags returns the tags of key.
+func (c *Conn) Tags(ctx context.Context, key string) (map[string]string, error) {
+	var tags map[string]string
+	err := c.call(ctx, wire.OpTags, key, wire.Args{}, &tags)
+	return tags, err
+}
+
+// SetTags adds tags to key and returns all its tags.
+func (c *Conn) SetTags(ctx context.Context, key string, tags map[string]string) (map[string]string, error) {
+	var all map[string]string
+	err := c.call(ctx, wire.OpSetTags, key, wire.Args{Tags: tags}, &all)
+	return all, err
+}
+
+// RemoveTags removes the tags called names from key and returns the tags left.
+func (c *Conn) RemoveTags(ctx context.Context, key string, names ...string) (map[string]string, error) {
+	var left map[string]string
+	err := c.call(ctx, wire.OpRemoveTags, key, wire.Args{Names: names}, &left)
+	return left, err
+}
+
+// FindByTags lists the keys matching selector, such as "env=prod,team=core|env=staging".
+func (c *Conn) FindByTags(ctx context.Context, selector string) ([]string, error) {
+	var keys []string
+	err := c.call(ctx, wire.OpFindByTags, "", wire.Args{Selector: selector}, &keys)
+	return keys, err
+}
+
+// CountByTags counts the keys matching selector.
+func (c *Conn) CountByTags(ctx context.Context, selector string) (int, error) {
+	var n int
+	err := c.call(ctx, wire.OpCountByTags, "", wire.Args{Selector: selector}, &n)
+	return n, err
+}
+
+// DeleteByTags deletes every key matching selector, moving them to the trash if soft is set, and
+// returns the keys deleted.
+func (c *Conn) DeleteByTags(ctx context.Context, selector string, soft bool) ([]string, error) {
+	var keys []string
+	err := c.call(ctx, wire.OpDeleteByTags, "", wire.Args{Selector: selector, Soft: soft}, &keys)
+	return keys, err
+}
+
+// GetLease returns the current holder of the lease name.
+func (c *Conn) GetLease(ctx context.Context, name string) (store.Lease, error) {
+	var l store.Lease
+	err := c.call(ctx, wire.OpLease, name, wire.Args{}, &l)
+	return l, err
+}
+
+// AcquireLease takes the lease name for owner for ttl. If another owner holds it, it waits up to wait
+// for the lease to be released or to expire before failing with helpers.LeaseHeldError.
+func (c *Conn) AcquireLease(ctx context.Context, name, owner string, ttl, wait time.Duration) (store.Lease, error) {
+	var l store.Lease
+	err := c.call(ctx, wire.OpAcquireLease, name, wire.Args{Owner: owner, TTL: ttl, Wait: wait}, &l)
+	return l, err
+}
+
+// RenewLease extends the lease name held by owner by ttl.
+func (c *Conn) RenewLease(ctx context.Context, name, owner string, ttl time.Duration) (store.Lease, error) {
+	var l store.Lease
+	err := c.call(ctx, wire.OpRenewLease, name, wire.Args{Owner: owner, TTL: ttl}, &l)
+	return l, err
+}
+
+// ReleaseLease frees the lease name held by owner.
+func (c *Conn) ReleaseLease(ctx context.Context, name, owner string) error {
+	return c.call(ctx, wire.OpReleaseLease, name, wire.Args{Owner: owner}, nil)
+}
+
+// Enqueue appends value, encoded as JSON, to the queue name.
+func (c *Conn) Enqueue(ctx context.Context, name string, value any) (store.Message, error) {
+	var m store.Message
+	body, err := encode(value)
+	if err != nil {
+		return m, err
+	}
+	err = c.call(ctx, wire.OpEnqueue, name, wire.Args{Value: body}, &m)
+	return m, err
+}
+
+// Dequeue takes the oldest message from the queue name and hides it from other consumers for
+// visibility, or store.DefaultVisibility if zero. It fails with helpers.QueueEmptyError if there is none.
+func (c *Conn) Dequeue(ctx context.Context, name string, visibility time.Duration) (store.Message, error) {
+	var m store.Message
+	err := c.call(ctx, wire.OpDequeue, name, wire.Args{TTL: visibility}, &m)
+	return m, err
+}
+
+// Ack confirms the message dequeued with receipt has been processed.
+func (c *Conn) Ack(ctx context.Context, name, receipt string) error {
+	return c.call(ctx, wire.OpAck, name, wire.Args{Receipt: receipt}, nil)
+}
+
+// Nack returns the message dequeued with receipt to the queue for another delivery.
+func (c *Conn) Nack(ctx context.Context, name, receipt string) error {
+	return c.call(ctx, wire.OpNack, name, wire.Args{Receipt: receipt}, nil)
+}
+
+// QueueStats returns the counters of the queue name.
+func (c *Conn) QueueStats(ctx context.Context, name string) (store.QueueStats, error) {
+	var stats store.QueueStats
+	if name == "" {
+		return stats, helpers.MissingKeyError
+	}
+	err := c.call(ctx, wire.OpQueueStats, name, wire.Args{}, &stats)
+	return stats, err
+}
+
+// AllQueueStats returns the counters of every queue.
+func (c *Conn) AllQueueStats(ctx context.Context) ([]store.QueueStats, error) {
+	var stats []store.QueueStats
+	err := c.call(ctx, wire.OpQueueStats, "", wire.Args{}, &stats)
+	return stats, err
+}
+
+// StreamInfo returns the length, ID range and consumer groups of the stream name.
+func (c *Conn) StreamInfo(ctx context.Context, name string) (store.StreamInfo, error) {
+	var info store.StreamInfo
+	err := c.call(ctx, wire.OpStreamInfo, name, wire.Args{}, &info)
+	return info, err
+}
+
+// StreamAdd appends value, encoded as JSON, to the stream name, then trims it to maxLen entries if
+// maxLen is positive.
+func (c *Conn) StreamAdd(ctx context.Context, name string, value any, maxLen int) (store.StreamEntry, error) {
+	var e store.StreamEntry
+	body, err := encode(value)
+	if err != nil {
+		return e, err
+	}
+	err = c.call(ctx, wire.OpStreamAdd, name, wire.Args{Value: body, MaxLen: maxLen}, &e)
+	return e, err
+}
+
+// StreamRange returns up to count entries of the stream name with IDs from start to end, both
+// inclusive. Empty bounds stand for the ends of the stream.
+func (c *Conn) StreamRange(ctx context.Context, name, start, end string, count int) ([]store.StreamEntry, error) {
+	return c.entries(ctx, wire.OpStreamRange, name, wire.Args{Start: start, End: end, Count: count})
+}
+
+// StreamRead returns up to count entries of the stream name added after the ID after, "$" or empty for
+// the current end. If there are none, it waits up to wait for new ones.
+func (c *Conn) StreamRead(ctx context.Context, name, after string, count int, wait time.Duration) ([]store.StreamEntry, error) {
+	return c.entries(ctx, wire.OpStreamRead, name, wire.Args{Start: after, Count: count, Wait: wait})
+}
+
+// StreamTail returns the last count entries of the stream name, or all of them if count is zero.
+func (c *Conn) StreamTail(ctx context.Context, name string, count int) ([]store.StreamEntry, error) {
+	return c.entries(ctx, wire.OpStreamTail, name, wire.Args{Count: count})
+}
+
+// StreamTrim drops the oldest entries of the stream name beyond maxLen and returns how many it dropped.
+func (c *Conn) StreamTrim(ctx context.Context, name string, maxLen int) (int, error) {
+	var n int
+	err := c.call(ctx, wire.OpStreamTrim, name, wire.Args{MaxLen: maxLen}, &n)
+	return n, err
+}
+
+// CreateGroup adds the consumer group to the stream name, starting after the ID start: "0" for the
+// whole stream or "$" for new entries only.
+func (c *Conn) CreateGroup(ctx context.Context, name, group, start string) error {
+	return c.call(ctx, wire.OpCreateGroup, name, wire.Args{Group: group, Start: start}, nil)
+}
+
+// ReadGroup delivers up to count new entries of the stream name to consumer of group.
+func (c *Conn) ReadGroup(ctx context.Context, name, group, consumer string, count int) ([]store.StreamEntry, error) {
+	return c.entries(ctx, wire.OpReadGroup, name, wire.Args{Group: group, Consumer: consumer, Count: count})
+}
+
+// StreamAck acknowledges the entries ids delivered to group and returns how many were pending.
+func (c *Conn) StreamAck(ctx context.Context, name, group string, ids ...string) (int, error) {
+	var n int
+	err := c.call(ctx, wire.OpStreamAck, name, wire.Args{Group: group, IDs: ids}, &n)
+	return n, err
+}
+
+// Pending lists the entries delivered to group that have not been acknowledged.
+func (c *Conn) Pending(ctx context.Context, name, group string) ([]store.PendingEntry, error) {
+	var pending []store.PendingEntry
+	err := c.call(ctx, wire.OpPending, name, wire.Args{Group: group}, &pending)
+	return pending, err
+}
+
+// Claim hands the pending entries ids of group (all of them if none are given) that have been idle
+// for minIdle over to consumer.
+func (c *Conn) Claim(ctx context.Context, name, group, consumer string, minIdle time.Duration, ids ...string) ([]store.StreamEntry, error) {
+	args := wire.Args{Group: group, Consumer: consumer, MinIdle: minIdle, IDs: ids}
+	return c.entries(ctx, wire.OpClaim, name, args)
+}
+
+func (c *Conn) entries(ctx context.Context, op wire.Op, name string, args wire.Args) ([]store.StreamEntry, error) {
+	var entries []store.StreamEntry
+	err := c.call(ctx, op, name, args, &entries)
+	return entries, err
+}
+
+// AllowRate takes limit.Cost tokens from the rate limit of key. A denied request is not an error: the
+// result says whether it was allowed and, if not, when to try again.
+func (c *Conn) AllowRate(ctx context.Context, key string, limit store.RateLimit) (store.RateLimitResult, error) {
+	var res store.RateLimitResult
+	err := c.call(ctx, wire.OpRateLimit, key, wire.Args{Limit: limit}, &res)
+	return res, err
+}
+
+// Export writes every key starting with prefix to w, in format. The keys are sent in a single
+// response, so a key space larger than wire.MaxFrameSize is exported with Client.Export instead.
+func (c *Conn) Export(ctx context.Context, w io.Writer, format transfer.Format, prefix string) error {
+	body, err := json.Marshal(wire.Args{Format: format})
+	if err != nil {
+		return err
+	}
+	b, err := c.do(ctx, wire.Request{Op: wire.OpExport, Key: prefix, Value: body})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Import reads the records from r, in format, and stores them one request at a time, returning a
+// summary as Client.Import does.
+func (c *Conn) Import(ctx context.Context, r io.Reader, format transfer.Format, opts store.ImportOptions) (transfer.Summary, error) {
+	return transfer.Import(transfer.NewReader(r, format), opts, func(rec transfer.Record, opts store.ImportOptions) (store.ImportResult, error) {
+		var res store.ImportResult
+		err := c.call(ctx, wire.OpImport, rec.Key, wire.Args{Value: rec.Value, Import: opts}, &res)
+		return res, err
+	})
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"kvstore/helpers"
+	"kvstore/store"
+	"kvstore/transfer"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func dial(t testing.TB) *Conn {
+	c, err := Dial(context.Background(), wireAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestConn(t *testing.T) {
+	ctx := context.Background()
+	c := dial(t)
+
+	// Start from an empty store, so that the keys below are new however many times the test runs
+	if err := c.Clear(ctx); err != nil {
+		t.Fatalf("Clear() = %v", err)
+	}
+	if name, err := c.Ping(ctx); err != nil || name != "kvstore" {
+		t.Fatalf("Ping() = %q, %v", name, err)
+	}
+	if err := c.Add(ctx, "wire:user", user{"ada", 36}); err != nil {
+		t.Fatalf("Add() = %v", err)
+	}
+	if err := c.Add(ctx, "wire:user", user{}); !errors.Is(err, helpers.DuplicateKeyError) {
+		t.Errorf("Add() of an existing key = %v, want DuplicateKeyError", err)
+	}
+	if err := c.Update(ctx, "wire:missing", 1); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Update() of a missing key = %v, want NotExistError", err)
+	}
+	if raw, err := c.Get(ctx, "wire:user"); err != nil || string(raw) != `{"age":36,"name":"ada"}` {
+		t.Errorf("Get() = %s, %v", raw, err)
+	}
+	if _, err := c.Get(ctx, "wire:missing"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Get() of a missing key = %v, want NotExistError", err)
+	}
+
+	// Keys and values are bytes, and keys written over HTTP read the same
+	if err := c.Write(ctx, "wire:\x00bin", []byte("\xff\x00\xfe"), store.WriteOptions{Flags: 3}); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if b, err := c.GetBytes(ctx, "wire:\x00bin"); err != nil || string(b) != "\xff\x00\xfe" {
+		t.Errorf("GetBytes() = %q, %v", b, err)
+	}
+	New(server.URL).Upsert(ctx, "wire:http", "from http")
+	if b, err := c.GetBytes(ctx, "wire:http"); err != nil || string(b) != "from http" {
+		t.Errorf("GetBytes() of a key written over HTTP = %q, %v", b, err)
+	}
+
+	item, err := c.GetWithMeta(ctx, "wire:\x00bin")
+	if err != nil || item.Metadata.Flags != 3 || item.Metadata.WriteCount != 1 {
+		t.Fatalf("GetWithMeta() = %+v, %v", item, err)
+	}
+	err = c.Write(ctx, "wire:\x00bin", []byte("new"), store.WriteOptions{Revision: item.Metadata.Revision()})
+	if err != nil {
+		t.Errorf("Write() at the revision read = %v", err)
+	}
+	err = c.Write(ctx, "wire:\x00bin", []byte("stale"), store.WriteOptions{Revision: item.Metadata.Revision()})
+	if !errors.Is(err, helpers.RevisionMismatchError) {
+		t.Errorf("Write() at a stale revision = %v, want RevisionMismatchError", err)
+	}
+
+	if n, err := c.Incr(ctx, "wire:n", 5); err != nil || n != 5 {
+		t.Errorf("Incr() = %d, %v, want 5", n, err)
+	}
+	if err := c.Expire(ctx, "wire:n", time.Hour); err != nil {
+		t.Errorf("Expire() = %v", err)
+	}
+	if md, err := c.Meta(ctx, "wire:n"); err != nil || md.ExpiresAt.IsZero() {
+		t.Errorf("Meta() = %+v, %v, want an expiry", md, err)
+	}
+	if ok, err := c.Persist(ctx, "wire:n"); !ok || err != nil {
+		t.Errorf("Persist() = %v, %v, want true", ok, err)
+	}
+
+	if err := c.Delete(ctx, "wire:n"); err != nil {
+		t.Errorf("Delete() = %v", err)
+	}
+	if ok, err := c.Exists(ctx, "wire:n"); ok || err != nil {
+		t.Errorf("Exists() after Delete() = %v, %v, want false", ok, err)
+	}
+	if ok, err := c.Exists(ctx, "wire:user"); !ok || err != nil {
+		t.Errorf("Exists() = %v, %v, want true", ok, err)
+	}
+
+	all, err := c.GetAll(ctx)
+	if err != nil || string(all["wire:user"]) != `{"age":36,"name":"ada"}` || string(all["wire:http"]) != `"from http"` {
+		t.Errorf("GetAll() = %d keys, %v", len(all), err)
+	}
+	if n, err := c.Count(ctx); err != nil || n != len(all) {
+		t.Errorf("Count() = %d, %v, want %d", n, err, len(all))
+	}
+}
+
+// TestConnMultiplexing has many goroutines share one connection, each checking it gets its own answers.
+func TestConnMultiplexing(t *testing.T) {
+	ctx := context.Background()
+	c := dial(t)
+
+	var wg sync.WaitGroup
+	for g := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				key := fmt.Sprintf("mux:%d:%d", g, i)
+				if err := c.Upsert(ctx, key, key); err != nil {
+					t.Errorf("Upsert(%s) = %v", key, err)
+					return
+				}
+				if b, err := c.GetBytes(ctx, key); err != nil || string(b) != key {
+					t.Errorf("GetBytes(%s) = %q, %v", key, b, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConnClosed(t *testing.T) {
+	ctx := context.Background()
+	c := dial(t)
+
+	if err := c.Upsert(ctx, strings.Repeat("k", 1<<16), 1); !errors.Is(err, helpers.InvalidParamError) {
+		t.Errorf("Upsert() of a key too long = %v, want InvalidParamError", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Get(canceled, "wire:user"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Get() with a canceled context = %v, want context.Canceled", err)
+	}
+
+	c.Close()
+	if _, err := c.Ping(ctx); err == nil {
+		t.Error("Ping() after Close() succeeded")
+	}
+}
+
+// TestConnFeatures checks the operations beyond the key space, which take their arguments as JSON.
+func TestConnFeatures(t *testing.T) {
+	ctx := context.Background()
+	c := dial(t)
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36) // Names unique to this run
+
+	// Trash and tags
+	key := "wire:feature:" + suffix
+	c.Upsert(ctx, key, 1)
+	if all, err := c.SetTags(ctx, key, map[string]string{"run": suffix}); err != nil || all["run"] != suffix {
+		t.Errorf("SetTags() = %v, %v", all, err)
+	}
+	if keys, err := c.FindByTags(ctx, "run="+suffix); err != nil || len(keys) != 1 || keys[0] != key {
+		t.Errorf("FindByTags() = %v, %v, want [%s]", keys, err, key)
+	}
+	if err := c.SoftDelete(ctx, key); err != nil {
+		t.Fatalf("SoftDelete() = %v", err)
+	}
+	if v, err := c.Restore(ctx, key); err != nil || string(v) != "1" {
+		t.Errorf("Restore() = %s, %v, want 1", v, err)
+	}
+	if keys, err := c.DeleteByTags(ctx, "run="+suffix, true); err != nil || len(keys) != 1 {
+		t.Errorf("DeleteByTags() = %v, %v", keys, err)
+	}
+	if n, err := c.Purge(ctx, key); err != nil || n != 1 {
+		t.Errorf("Purge() = %d, %v, want 1", n, err)
+	}
+
+	// Leases
+	lease := "wire:lease:" + suffix
+	l, err := c.AcquireLease(ctx, lease, "a", time.Minute, 0)
+	if err != nil || l.Owner != "a" {
+		t.Fatalf("AcquireLease() = %+v, %v", l, err)
+	}
+	if _, err := c.AcquireLease(ctx, lease, "b", time.Minute, 0); !errors.Is(err, helpers.LeaseHeldError) {
+		t.Errorf("AcquireLease() of a held lease = %v, want LeaseHeldError", err)
+	}
+	if err := c.ReleaseLease(ctx, lease, "b"); !errors.Is(err, helpers.NotLeaseOwnerError) {
+		t.Errorf("ReleaseLease() by another owner = %v, want NotLeaseOwnerError", err)
+	}
+	if err := c.ReleaseLease(ctx, lease, "a"); err != nil {
+		t.Errorf("ReleaseLease() = %v", err)
+	}
+
+	// Queues
+	queue := "wire:queue:" + suffix
+	if _, err := c.Enqueue(ctx, queue, "job"); err != nil {
+		t.Fatalf("Enqueue() = %v", err)
+	}
+	m, err := c.Dequeue(ctx, queue, 0)
+	if err != nil || m.Body != "job" {
+		t.Fatalf("Dequeue() = %+v, %v", m, err)
+	}
+	if _, err := c.Dequeue(ctx, queue, 0); !errors.Is(err, helpers.QueueEmptyError) {
+		t.Errorf("Dequeue() of an empty queue = %v, want QueueEmptyError", err)
+	}
+	if err := c.Ack(ctx, queue, m.Receipt); err != nil {
+		t.Errorf("Ack() = %v", err)
+	}
+	if stats, err := c.QueueStats(ctx, queue); err != nil || stats.Acked != 1 {
+		t.Errorf("QueueStats() = %+v, %v, want one acked", stats, err)
+	}
+
+	// Streams
+	stream := "wire:stream:" + suffix
+	e, err := c.StreamAdd(ctx, stream, map[string]int{"n": 1}, 0)
+	if err != nil {
+		t.Fatalf("StreamAdd() = %v", err)
+	}
+	if entries, err := c.StreamRange(ctx, stream, "", "", 0); err != nil || len(entries) != 1 || entries[0].ID != e.ID {
+		t.Errorf("StreamRange() = %+v, %v", entries, err)
+	}
+	if err := c.CreateGroup(ctx, stream, "g", "0"); err != nil {
+		t.Fatalf("CreateGroup() = %v", err)
+	}
+	if entries, err := c.ReadGroup(ctx, stream, "g", "c", 10); err != nil || len(entries) != 1 {
+		t.Errorf("ReadGroup() = %+v, %v", entries, err)
+	}
+	if n, err := c.StreamAck(ctx, stream, "g", e.ID); err != nil || n != 1 {
+		t.Errorf("StreamAck() = %d, %v, want 1", n, err)
+	}
+
+	// Rate limits
+	limit := store.RateLimit{Capacity: 1, Rate: 0.001}
+	if res, err := c.AllowRate(ctx, "wire:rate:"+suffix, limit); err != nil || !res.Allowed {
+		t.Errorf("AllowRate() = %+v, %v, want allowed", res, err)
+	}
+	if res, err := c.AllowRate(ctx, "wire:rate:"+suffix, limit); err != nil || res.Allowed {
+		t.Errorf("AllowRate() past the limit = %+v, %v, want denied", res, err)
+	}
+
+	// Import and export
+	prefix := "wire:transfer:" + suffix + ":"
+	in := fmt.Sprintf(`{"key":%q,"value":{"a":1}}`+"\n"+`{"key":%q,"value":2}`+"\n", prefix+"a", prefix+"b")
+	summary, err := c.Import(ctx, strings.NewReader(in), transfer.NDJSON, store.ImportOptions{})
+	if err != nil || summary.Added != 2 {
+		t.Errorf("Import() = %+v, %v, want 2 added", summary, err)
+	}
+	var out bytes.Buffer
+	if err := c.Export(ctx, &out, transfer.CSV, prefix); err != nil {
+		t.Fatalf("Export() = %v", err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 3 {
+		t.Errorf("Export() wrote %d CSV lines, want a header and 2 keys:\n%s", lines, out.String())
+	}
+}
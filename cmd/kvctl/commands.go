@@ -0,0 +1,650 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"kvstore/audit"
+	"kvstore/client"
+	"kvstore/store"
+	"kvstore/transfer"
+	"os"
+	"strings"
+	"time"
+)
+
+// errNotExist is returned by exists for a missing key. It is reported by the exit status alone, as
+// "false" has been printed already.
+var errNotExist = errors.New("key does not exist")
+
+var commands = map[string]command{}
+
+func init() {
+	for _, c := range []command{
+		{"ping", "", "show the server's name and version", ping},
+		{"get", "[-meta] <key>", "print the value of key", get},
+		{"meta", "<key>", "print the metadata of key", meta},
+		{"add", "[-f file] [-string] <key> [value]", "add key, failing if it exists", write("add")},
+		{"update", "[-f file] [-string] <key> [value]", "update key, failing if it is missing", write("update")},
+		{"upsert", "[-f file] [-string] <key> [value]", "add or update key", write("upsert")},
+		{"delete", "[-soft] <key>", "delete key, into the trash with -soft", del},
+		{"exists", "<key>", "print whether key exists", exists},
+		{"count", "", "print the number of keys", count},
+		{"clear", "-yes | -soft", "delete every key, into the trash with -soft", clear},
+		{"get-all", "[-meta]", "print every key and value", getAll},
+		{"scan", "[prefix]", "print the keys starting with prefix and their values", scan},
+		{"export", "[-format ndjson|csv] [-prefix p] [-f file]", "write the keyspace to a file or stdout", export},
+		{"import", "[-format ndjson|csv] [-mode m] [-dry-run] [-f file]", "load keys from a file or stdin", importKeys},
+		{"trash", "", "list soft deleted keys", trash},
+		{"restore", "<key> | -clear <id>", "restore a soft deleted key or a soft clear", restore},
+		{"purge", "[key]", "drop key, or everything, from the trash", purge},
+		{"tags", "<key>", "print the tags of key", tagsOf},
+		{"tag", "<key> <name=value>...", "set tags on key", tag},
+		{"untag", "<key> <name>...", "remove tags from key", untag},
+		{"find", "<selector>", "list the keys matching a tag selector, e.g. env=prod,tier", find},
+		{"delete-tagged", "[-soft] <selector>", "delete the keys matching a tag selector", deleteTagged},
+		{"audit", "[-key k] [-op op] [-since t] [-until t] [-limit n]", "query the audit log", auditLog},
+		{"lease", "<name>", "print the holder of a lease", lease},
+		{"acquire", "[-ttl d] [-wait d] <name> <owner>", "acquire a lease", acquire},
+		{"renew", "[-ttl d] <name> <owner>", "extend a held lease", renew},
+		{"release", "<name> <owner>", "release a held lease", release},
+		{"enqueue", "[-f file] [-string] <queue> [value]", "add a message to a queue", enqueue},
+		{"dequeue", "[-visibility d] <queue>", "take the next message from a queue", dequeue},
+		{"ack", "<queue> <receipt>", "acknowledge a dequeued message", ack(true)},
+		{"nack", "<queue> <receipt>", "return a dequeued message to its queue", ack(false)},
+		{"queues", "[queue]", "print queue statistics", queues},
+		{"xadd", "[-maxlen n] [-f file] [-string] <stream> [value]", "append an entry to a stream", xadd},
+		{"xrange", "[-start id] [-end id] [-count n] <stream>", "print entries of a stream", xrange},
+		{"xread", "[-after id] [-count n] [-wait d] <stream>", "print entries added after an ID", xread},
+		{"xtail", "[-count n] <stream>", "print the last entries of a stream", xtail},
+		{"allow", "[-algorithm a] [-capacity n] [-rate r] [-cost n] <key>", "take from a rate limit", allow},
+	} {
+		commands[c.name] = c
+	}
+}
+
+// parse parses args with fs and returns the positional arguments, which must number between min and
+// max (-1 for no limit). Unlike fs.Parse, it accepts flags after positional arguments; "--" ends the flags.
+func parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	var pos []string
+	for len(args) > 0 {
+		if err := fs.Parse(args); err != nil {
+			return nil, usagef("%s", err)
+		}
+		rest := fs.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			pos = append(pos, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			break
+		}
+		pos = append(pos, rest[0])
+		args = rest[1:]
+	}
+	if len(pos) < min || (max >= 0 && len(pos) > max) {
+		return nil, usagef("usage: kvctl %s %s", fs.Name(), commands[fs.Name()].usage)
+	}
+	return pos, nil
+}
+
+// valueFlags are the flags of commands that take a value.
+type valueFlags struct {
+	file *string
+	text *bool
+}
+
+func addValueFlags(fs *flag.FlagSet) valueFlags {
+	return valueFlags{
+		file: fs.String("f", "", "read the value from file, - for stdin"),
+		text: fs.Bool("string", false, "store the value as a JSON string rather than parse it as JSON"),
+	}
+}
+
+// read returns the value given as the only argument in args, or read from -f, or from stdin if
+// there is neither.
+func (v valueFlags) read(e *env, args []string) (json.RawMessage, error) {
+	var data []byte
+	var err error
+	switch {
+	case len(args) > 0 && *v.file != "":
+		return nil, usagef("give the value either as an argument or with -f, not both")
+	case len(args) > 0:
+		data = []byte(args[0])
+	case *v.file == "" || *v.file == "-":
+		data, err = io.ReadAll(e.stdin)
+		// A value typed or echoed in usually ends in a newline that is not part of it
+		data = []byte(strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"))
+	default:
+		data, err = os.ReadFile(*v.file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if *v.text {
+		return json.Marshal(string(data))
+	}
+	if !json.Valid(data) {
+		return nil, usagef("value is not valid JSON (use -string to store it as text)")
+	}
+	return data, nil
+}
+
+func ping(ctx context.Context, e *env, args []string) (any, error) {
+	if _, err := parse(flag.NewFlagSet("ping", flag.ContinueOnError), args, 0, 0); err != nil {
+		return nil, err
+	}
+	return e.client.Ping(ctx)
+}
+
+func get(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	withMeta := fs.Bool("meta", false, "include the metadata")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *withMeta {
+		return e.client.GetWithMeta(ctx, pos[0])
+	}
+	return e.client.Get(ctx, pos[0])
+}
+
+func meta(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("meta", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.Meta(ctx, pos[0])
+}
+
+// write returns the command that adds, updates or upserts a key.
+func write(name string) func(context.Context, *env, []string) (any, error) {
+	return func(ctx context.Context, e *env, args []string) (any, error) {
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		vf := addValueFlags(fs)
+		pos, err := parse(fs, args, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		value, err := vf.read(e, pos[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "add":
+			return nil, e.client.Add(ctx, pos[0], value)
+		case "update":
+			return nil, e.client.Update(ctx, pos[0], value)
+		}
+		return nil, e.client.Upsert(ctx, pos[0], value)
+	}
+}
+
+func del(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	soft := fs.Bool("soft", false, "move the key to the trash")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if *soft {
+		return nil, e.client.SoftDelete(ctx, pos[0])
+	}
+	return nil, e.client.Delete(ctx, pos[0])
+}
+
+func exists(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("exists", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := e.client.Exists(ctx, pos[0])
+	if err == nil && !ok {
+		return false, errNotExist
+	}
+	return ok, err
+}
+
+func count(ctx context.Context, e *env, args []string) (any, error) {
+	if _, err := parse(flag.NewFlagSet("count", flag.ContinueOnError), args, 0, 0); err != nil {
+		return nil, err
+	}
+	return e.client.Count(ctx)
+}
+
+func clear(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("clear", flag.ContinueOnError)
+	soft := fs.Bool("soft", false, "move every key to the trash")
+	yes := fs.Bool("yes", false, "confirm deleting every key for good")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return nil, err
+	}
+	if *soft {
+		id, err := e.client.SoftClear(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"clear_id": id}, nil
+	}
+	if !*yes {
+		return nil, usagef("clear deletes every key for good: pass -yes, or -soft to keep them in the trash")
+	}
+	return nil, e.client.Clear(ctx)
+}
+
+func getAll(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("get-all", flag.ContinueOnError)
+	withMeta := fs.Bool("meta", false, "include the metadata")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return nil, err
+	}
+	if *withMeta {
+		return e.client.GetAllWithMeta(ctx)
+	}
+	all, err := e.client.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]keyValue, 0, len(all))
+	for _, k := range sortedKeys(all) {
+		list = append(list, keyValue{k, all[k]})
+	}
+	return list, nil
+}
+
+// scan reads the keys with a prefix from an export, which the server streams from a snapshot rather
+// than building the whole keyspace in memory as get-all does.
+func scan(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("scan", flag.ContinueOnError), args, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if len(pos) > 0 {
+		prefix = pos[0]
+	}
+
+	list := []keyValue{}
+	err = records(ctx, e.client, prefix, func(rec transfer.Record) bool {
+		list = append(list, keyValue{rec.Key, rec.Value})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// records calls fn with each record of an export of the keys starting with prefix, until fn returns false.
+func records(ctx context.Context, c *client.Client, prefix string, fn func(transfer.Record) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(c.Export(ctx, pw, transfer.NDJSON, prefix))
+	}()
+	defer pr.Close()
+
+	r := transfer.NewReader(pr, transfer.NDJSON)
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(rec) {
+			return nil
+		}
+	}
+}
+
+func export(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "ndjson", "output format: ndjson or csv")
+	prefix := fs.String("prefix", "", "only export keys with this prefix")
+	file := fs.String("f", "", "output file (default stdout)")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return nil, err
+	}
+	f, err := transfer.ParseFormat(*format)
+	if err != nil {
+		return nil, usagef("%s", err)
+	}
+
+	out := e.stdout
+	if *file != "" && *file != "-" {
+		file, err := os.Create(*file)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		out = file
+	}
+	return nil, e.client.Export(ctx, out, f, *prefix)
+}
+
+func importKeys(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "ndjson", "input format: ndjson or csv")
+	mode := fs.String("mode", "skip", "conflict mode: skip, overwrite or fail")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	file := fs.String("f", "", "input file (default stdin)")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return nil, err
+	}
+	f, err := transfer.ParseFormat(*format)
+	if err != nil {
+		return nil, usagef("%s", err)
+	}
+	m, err := store.ParseConflictMode(*mode)
+	if err != nil {
+		return nil, usagef("%s", err)
+	}
+
+	in := e.stdin
+	if *file != "" && *file != "-" {
+		file, err := os.Open(*file)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+	// An aborted import returns its summary too, which is printed along with the error
+	summary, err := e.client.Import(ctx, in, f, store.ImportOptions{Mode: m, DryRun: *dryRun})
+	if err != nil && summary.Aborted == "" {
+		return nil, err
+	}
+	return summary, err
+}
+
+func trash(ctx context.Context, e *env, args []string) (any, error) {
+	if _, err := parse(flag.NewFlagSet("trash", flag.ContinueOnError), args, 0, 0); err != nil {
+		return nil, err
+	}
+	return e.client.Trash(ctx)
+}
+
+func restore(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	clearID := fs.String("clear", "", "restore every key removed by the soft clear with this ID")
+	pos, err := parse(fs, args, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case *clearID != "" && len(pos) == 0:
+		return e.client.RestoreClear(ctx, *clearID)
+	case *clearID == "" && len(pos) == 1:
+		return e.client.Restore(ctx, pos[0])
+	}
+	return nil, usagef("usage: kvctl restore %s", commands["restore"].usage)
+}
+
+func purge(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("purge", flag.ContinueOnError), args, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	key := ""
+	if len(pos) > 0 {
+		key = pos[0]
+	}
+	n, err := e.client.Purge(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int{"purged": n}, nil
+}
+
+func tagsOf(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("tags", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.Tags(ctx, pos[0])
+}
+
+func tag(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("tag", flag.ContinueOnError), args, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(pos)-1)
+	for _, t := range pos[1:] {
+		name, value, ok := strings.Cut(t, "=")
+		if !ok {
+			return nil, usagef("tag %q is not name=value", t)
+		}
+		tags[name] = value
+	}
+	return e.client.SetTags(ctx, pos[0], tags)
+}
+
+func untag(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("untag", flag.ContinueOnError), args, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.RemoveTags(ctx, pos[0], pos[1:]...)
+}
+
+func find(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("find", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.FindByTags(ctx, pos[0])
+}
+
+func deleteTagged(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("delete-tagged", flag.ContinueOnError)
+	soft := fs.Bool("soft", false, "move the keys to the trash")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.DeleteByTags(ctx, pos[0], *soft)
+}
+
+func auditLog(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	var q audit.Query
+	fs.StringVar(&q.Key, "key", "", "only records of this key")
+	fs.StringVar(&q.Op, "op", "", "only records of this operation")
+	since := fs.String("since", "", "only records from this time on, RFC 3339 or a duration ago such as 1h")
+	until := fs.String("until", "", "only records up to this time, RFC 3339 or a duration ago")
+	fs.IntVar(&q.Limit, "limit", 0, "only the most recent n records")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return nil, err
+	}
+	var err error
+	if q.Since, err = parseTime(*since); err != nil {
+		return nil, err
+	}
+	if q.Until, err = parseTime(*until); err != nil {
+		return nil, err
+	}
+	return e.client.Audit(ctx, q)
+}
+
+// parseTime parses an RFC 3339 time, or a duration before now.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, usagef("%q is neither an RFC 3339 time nor a duration", s)
+	}
+	return t, nil
+}
+
+func lease(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("lease", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.GetLease(ctx, pos[0])
+}
+
+func acquire(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("acquire", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 0, "how long the lease lasts (default: the server's)")
+	wait := fs.Duration("wait", 0, "how long to wait for the lease to become free")
+	pos, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.AcquireLease(ctx, pos[0], pos[1], *ttl, *wait)
+}
+
+func renew(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("renew", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 0, "how long the lease lasts from now (default: the server's)")
+	pos, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.RenewLease(ctx, pos[0], pos[1], *ttl)
+}
+
+func release(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("release", flag.ContinueOnError), args, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	return nil, e.client.ReleaseLease(ctx, pos[0], pos[1])
+}
+
+func enqueue(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("enqueue", flag.ContinueOnError)
+	vf := addValueFlags(fs)
+	pos, err := parse(fs, args, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+	value, err := vf.read(e, pos[1:])
+	if err != nil {
+		return nil, err
+	}
+	return e.client.Enqueue(ctx, pos[0], value)
+}
+
+func dequeue(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("dequeue", flag.ContinueOnError)
+	visibility := fs.Duration("visibility", 0, "how long the message stays hidden before it is redelivered (default: the server's)")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.Dequeue(ctx, pos[0], *visibility)
+}
+
+// ack returns the command that acks, or with ok false nacks, a dequeued message.
+func ack(ok bool) func(context.Context, *env, []string) (any, error) {
+	name := "ack"
+	if !ok {
+		name = "nack"
+	}
+	return func(ctx context.Context, e *env, args []string) (any, error) {
+		pos, err := parse(flag.NewFlagSet(name, flag.ContinueOnError), args, 2, 2)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nil, e.client.Ack(ctx, pos[0], pos[1])
+		}
+		return nil, e.client.Nack(ctx, pos[0], pos[1])
+	}
+}
+
+func queues(ctx context.Context, e *env, args []string) (any, error) {
+	pos, err := parse(flag.NewFlagSet("queues", flag.ContinueOnError), args, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(pos) == 0 {
+		return e.client.AllQueueStats(ctx)
+	}
+	stats, err := e.client.QueueStats(ctx, pos[0])
+	if err != nil {
+		return nil, err
+	}
+	return []store.QueueStats{stats}, nil
+}
+
+func xadd(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("xadd", flag.ContinueOnError)
+	maxLen := fs.Int("maxlen", 0, "trim the stream to this many entries (0 for no limit)")
+	vf := addValueFlags(fs)
+	pos, err := parse(fs, args, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+	value, err := vf.read(e, pos[1:])
+	if err != nil {
+		return nil, err
+	}
+	return e.client.StreamAdd(ctx, pos[0], value, *maxLen)
+}
+
+func xrange(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("xrange", flag.ContinueOnError)
+	start := fs.String("start", "-", "first ID, - for the oldest")
+	end := fs.String("end", "+", "last ID, + for the newest")
+	n := fs.Int("count", 0, "at most n entries (0 for no limit)")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.StreamRange(ctx, pos[0], *start, *end, *n)
+}
+
+func xread(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("xread", flag.ContinueOnError)
+	after := fs.String("after", "$", "print entries after this ID, $ for only new ones")
+	n := fs.Int("count", 0, "at most n entries (0 for no limit)")
+	wait := fs.Duration("wait", 0, "how long to wait for an entry")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.StreamRead(ctx, pos[0], *after, *n, *wait)
+}
+
+func xtail(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("xtail", flag.ContinueOnError)
+	n := fs.Int("count", 10, "number of entries")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.StreamTail(ctx, pos[0], *n)
+}
+
+func allow(ctx context.Context, e *env, args []string) (any, error) {
+	fs := flag.NewFlagSet("allow", flag.ContinueOnError)
+	algorithm := fs.String("algorithm", "", "token_bucket, sliding_window or gcra (default: the server's)")
+	var limit store.RateLimit
+	fs.IntVar(&limit.Capacity, "capacity", 0, "burst size")
+	fs.Float64Var(&limit.Rate, "rate", 0, "requests per second")
+	fs.IntVar(&limit.Cost, "cost", 1, "tokens taken by this request")
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	limit.Algorithm = store.RateAlgorithm(*algorithm)
+	return e.client.AllowRate(ctx, pos[0], limit)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const defaultServer = "http://localhost:8080"
+
+// config is the profiles file, e.g.
+//
+//	{
+//	  "current": "staging",
+//	  "profiles": {
+//	    "staging": {"server": "https://kv.staging.example.com", "user": "ops", "password": "..."},
+//	    "local": {"server": "http://localhost:8080"}
+//	  }
+//	}
+type config struct {
+	Current  string             `json:"current"`
+	Profiles map[string]profile `json:"profiles"`
+}
+
+// profile is a server and the credentials to use with it.
+type profile struct {
+	Server   string `json:"server"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// defaultConfigPath returns $KVCTL_CONFIG, or kvctl/config.json in the user's config directory.
+func defaultConfigPath() string {
+	if p := os.Getenv("KVCTL_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kvctl", "config.json")
+}
+
+// loadProfile returns the profile called name from the file at path, or its current profile if name
+// is empty. A missing file is fine as long as no profile is asked for by name; the local server is
+// used then.
+func loadProfile(path, name string) (profile, error) {
+	var cfg config
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) || path == "":
+		if name != "" {
+			return profile{}, fmt.Errorf("profile %q not found: no profiles file at %s", name, path)
+		}
+		return profile{Server: defaultServer}, nil
+	case err != nil:
+		return profile{}, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return profile{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if name == "" {
+		name = cfg.Current
+	}
+	if name == "" {
+		return profile{Server: defaultServer}, nil
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	if p.Server == "" {
+		p.Server = defaultServer
+	}
+	return p, nil
+}
+
+// override replaces the fields of p given on the command line.
+func (p *profile) override(server, user, password string) {
+	if server != "" {
+		p.Server = server
+	}
+	if user != "" {
+		p.User = user
+	}
+	if password != "" {
+		p.Password = password
+	}
+}
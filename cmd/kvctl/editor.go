@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned by readLine when ^C is pressed.
+var errInterrupted = errors.New("interrupted")
+
+// editor reads lines typed into a terminal in raw mode, with emacs-style editing keys, history and
+// completion. Raw mode is the caller's business, so that output in between lines is not affected.
+type editor struct {
+	r       *bufio.Reader
+	w       io.Writer
+	history []string
+	// complete returns the completions of the word ending line, and the byte offset it starts at
+	complete func(line string) (start int, candidates []string)
+
+	prompt string
+	buf    []rune
+	pos    int // Cursor position in buf
+}
+
+func ctrl(c rune) rune {
+	return c & 0x1f
+}
+
+// readLine reads a line. It returns io.EOF for ^D on an empty line and errInterrupted for ^C.
+func (ed *editor) readLine(prompt string) (string, error) {
+	ed.prompt, ed.buf, ed.pos = prompt, nil, 0
+	hist := len(ed.history) // The history entry shown, len(ed.history) for the line being typed
+	var typed []rune
+	recall := func(i int) {
+		if i < 0 || i > len(ed.history) || i == hist {
+			return
+		}
+		if hist == len(ed.history) {
+			typed = ed.buf
+		}
+		hist = i
+		if i == len(ed.history) {
+			ed.buf = typed
+		} else {
+			ed.buf = []rune(ed.history[i])
+		}
+		ed.pos = len(ed.buf)
+	}
+
+	ed.refresh()
+	for {
+		r, _, err := ed.r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(ed.w, "\r\n")
+			return string(ed.buf), nil
+		case ctrl('C'):
+			fmt.Fprint(ed.w, "^C\r\n")
+			return "", errInterrupted
+		case ctrl('D'):
+			if len(ed.buf) == 0 {
+				fmt.Fprint(ed.w, "\r\n")
+				return "", io.EOF
+			}
+			ed.delete(ed.pos, ed.pos+1)
+		case ctrl('A'):
+			ed.pos = 0
+		case ctrl('E'):
+			ed.pos = len(ed.buf)
+		case ctrl('B'):
+			ed.pos = max(ed.pos-1, 0)
+		case ctrl('F'):
+			ed.pos = min(ed.pos+1, len(ed.buf))
+		case ctrl('H'), 127:
+			if ed.pos > 0 {
+				ed.delete(ed.pos-1, ed.pos)
+			}
+		case ctrl('K'):
+			ed.buf = ed.buf[:ed.pos]
+		case ctrl('U'):
+			ed.delete(0, ed.pos)
+		case ctrl('W'):
+			start := ed.pos
+			for start > 0 && ed.buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && ed.buf[start-1] != ' ' {
+				start--
+			}
+			ed.delete(start, ed.pos)
+		case ctrl('L'):
+			fmt.Fprint(ed.w, "\x1b[H\x1b[2J")
+		case ctrl('P'):
+			recall(hist - 1)
+		case ctrl('N'):
+			recall(hist + 1)
+		case '\t':
+			ed.completeWord()
+		case 0x1b:
+			switch ed.escape() {
+			case 'A':
+				recall(hist - 1)
+			case 'B':
+				recall(hist + 1)
+			case 'C':
+				ed.pos = min(ed.pos+1, len(ed.buf))
+			case 'D':
+				ed.pos = max(ed.pos-1, 0)
+			case 'H':
+				ed.pos = 0
+			case 'F':
+				ed.pos = len(ed.buf)
+			case '3':
+				if ed.pos < len(ed.buf) {
+					ed.delete(ed.pos, ed.pos+1)
+				}
+			}
+		default:
+			if r >= ' ' {
+				ed.insert([]rune{r})
+			}
+		}
+		ed.refresh()
+	}
+}
+
+// escape reads the rest of an escape sequence and returns what it stands for: the arrow letters
+// A to D, H for home, F for end, or 3 for delete. Anything else is returned as 0.
+func (ed *editor) escape() rune {
+	if r, _, _ := ed.r.ReadRune(); r != '[' && r != 'O' {
+		return 0
+	}
+	r, _, _ := ed.r.ReadRune()
+	if r < '0' || r > '9' {
+		return r
+	}
+	// Numbered keys such as delete (ESC [ 3 ~), home (1 or 7) and end (4 or 8)
+	for next := r; next != '~'; {
+		var err error
+		if next, _, err = ed.r.ReadRune(); err != nil {
+			return 0
+		}
+	}
+	switch r {
+	case '1', '7':
+		return 'H'
+	case '4', '8':
+		return 'F'
+	}
+	return r
+}
+
+func (ed *editor) insert(rs []rune) {
+	ed.buf = append(ed.buf[:ed.pos], append(rs, ed.buf[ed.pos:]...)...)
+	ed.pos += len(rs)
+}
+
+func (ed *editor) delete(from, to int) {
+	ed.buf = append(ed.buf[:from:from], ed.buf[to:]...)
+	ed.pos = from
+}
+
+// refresh redraws the line and puts the cursor back. Lines wider than the terminal are not handled.
+func (ed *editor) refresh() {
+	fmt.Fprintf(ed.w, "\r%s%s\x1b[K", ed.prompt, string(ed.buf))
+	if back := len(ed.buf) - ed.pos; back > 0 {
+		fmt.Fprintf(ed.w, "\x1b[%dD", back)
+	}
+}
+
+// completeWord completes the word before the cursor as far as all candidates agree, and lists the
+// candidates if that does not get any further.
+func (ed *editor) completeWord() {
+	if ed.complete == nil {
+		return
+	}
+	line := string(ed.buf[:ed.pos])
+	start, candidates := ed.complete(line)
+	if len(candidates) == 0 {
+		fmt.Fprint(ed.w, "\a")
+		return
+	}
+
+	word := line[start:]
+	completion := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, completion) {
+			_, size := utf8.DecodeLastRuneInString(completion)
+			completion = completion[:len(completion)-size]
+		}
+	}
+	if len(candidates) == 1 {
+		completion += " "
+	}
+	if len(completion) > len(word) {
+		ed.delete(utf8.RuneCountInString(line[:start]), ed.pos)
+		ed.insert([]rune(completion))
+		return
+	}
+	fmt.Fprintf(ed.w, "\r\n%s\r\n", strings.Join(candidates, "  "))
+}
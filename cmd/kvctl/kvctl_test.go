@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"kvstore/channels"
+	kvhttp "kvstore/http"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var server *httptest.Server
+
+// TestMain serves the real handlers, backed by the global store and request loop.
+func TestMain(m *testing.M) {
+	go channels.Requests()
+
+	mux := http.NewServeMux()
+	for path, h := range map[string]http.HandlerFunc{
+		"/ping": kvhttp.Ping, "/get": kvhttp.Get, "/add": kvhttp.Add, "/update": kvhttp.Update,
+		"/upsert": kvhttp.Upsert, "/delete": kvhttp.Delete, "/exists": kvhttp.Exists,
+		"/count": kvhttp.Count, "/get_all": kvhttp.GetAll, "/export": kvhttp.Export,
+		"/tags/set": kvhttp.SetTags, "/tags/find": kvhttp.FindByTags,
+	} {
+		mux.HandleFunc("/kvs"+path, h)
+	}
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	os.Exit(m.Run())
+}
+
+// kvctl runs kvctl against the test server and returns its exit status and output.
+func kvctl(t *testing.T, stdin string, args ...string) (int, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-config", "", "-server", server.URL}, args...)
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	if code != exitOK {
+		t.Logf("kvctl %s: exit %d: %s", strings.Join(args, " "), code, stderr.String())
+	}
+	return code, stdout.String()
+}
+
+func TestCommands(t *testing.T) {
+	// Start from an empty store, so that the scan below lists only the keys written here
+	if resp := channels.ClearRequest(channels.Caller{}); resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	if code, _ := kvctl(t, "", "add", "kvctl:user", `{"name":"ada"}`); code != exitOK {
+		t.Fatalf("add exited %d", code)
+	}
+
+	for _, tt := range []struct {
+		args  []string
+		stdin string
+		code  int
+		out   string
+	}{
+		{[]string{"-o", "raw", "get", "kvctl:user"}, "", exitOK, `{"name":"ada"}` + "\n"},
+		{[]string{"add", "kvctl:user", "1"}, "", exitConflict, ""},
+		{[]string{"get", "kvctl:missing"}, "", exitNotFound, ""},
+		{[]string{"update", "kvctl:missing", "1"}, "", exitNotFound, ""},
+		{[]string{"exists", "kvctl:user"}, "", exitOK, "true\n"},
+		{[]string{"exists", "kvctl:missing"}, "", exitNotFound, "false\n"},
+		{[]string{"upsert", "kvctl:greeting", "-string"}, "hello\n", exitOK, ""},
+		{[]string{"-o", "raw", "get", "kvctl:greeting"}, "", exitOK, "hello\n"},
+		{[]string{"-o", "json", "get", "kvctl:greeting"}, "", exitOK, `"hello"` + "\n"},
+		{[]string{"upsert", "kvctl:bad", "not json"}, "", exitUsage, ""},
+		{[]string{"get"}, "", exitUsage, ""},
+		{[]string{"frobnicate"}, "", exitUsage, ""},
+		{[]string{"-o", "yaml", "count"}, "", exitUsage, ""},
+		{[]string{"clear"}, "", exitUsage, ""},
+		{[]string{"-o", "table", "scan", "kvctl:"}, "", exitOK,
+			"KEY             VALUE\nkvctl:greeting  hello\nkvctl:user      {\"name\":\"ada\"}\n"},
+	} {
+		code, out := kvctl(t, tt.stdin, tt.args...)
+		if code != tt.code || (tt.out != "" && out != tt.out) {
+			t.Errorf("kvctl %s = %d, %q, want %d, %q", strings.Join(tt.args, " "), code, out, tt.code, tt.out)
+		}
+	}
+}
+
+func TestValueFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "value.json")
+	os.WriteFile(path, []byte(`[1, 2, 3]`), 0o644)
+
+	if code, _ := kvctl(t, "", "upsert", "-f", path, "kvctl:list"); code != exitOK {
+		t.Fatalf("upsert -f exited %d", code)
+	}
+	code, out := kvctl(t, "", "get", "kvctl:list")
+	var got []int
+	if err := json.Unmarshal([]byte(out), &got); code != exitOK || err != nil || len(got) != 3 {
+		t.Errorf("get = %d, %q, want [1,2,3]", code, out)
+	}
+
+	if code, _ := kvctl(t, "", "upsert", "-f", path, "kvctl:list", "1"); code != exitUsage {
+		t.Errorf("upsert with both -f and a value exited %d, want %d", code, exitUsage)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := config{
+		Current: "down",
+		Profiles: map[string]profile{
+			"test": {Server: server.URL},
+			"down": {Server: "http://127.0.0.1:1"},
+		},
+	}
+	b, _ := json.Marshal(cfg)
+	os.WriteFile(path, b, 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-config", path, "-profile", "test", "ping"}, nil, &stdout, &stderr); code != exitOK {
+		t.Errorf("ping with the test profile exited %d: %s", code, stderr.String())
+	}
+	if code := run([]string{"-config", path, "-retries", "0", "ping"}, nil, &stdout, &stderr); code != exitUnavailable {
+		t.Errorf("ping with the current, unreachable profile exited %d, want %d", code, exitUnavailable)
+	}
+	if code := run([]string{"-config", path, "-profile", "nope", "ping"}, nil, &stdout, &stderr); code != exitUsage {
+		t.Errorf("ping with an unknown profile exited %d, want %d", code, exitUsage)
+	}
+}
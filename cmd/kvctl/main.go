@@ -0,0 +1,197 @@
+// Command kvctl is a command-line client for the key/value store server.
+//
+//	kvctl [-profile name] [-server url] [-o json|table|raw] <command> [arguments]
+//
+// Its exit status tells the kind of error the server answered with, so scripts can branch on it
+// without parsing messages: see the exit constants.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"kvstore/client"
+	"kvstore/helpers"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Exit statuses. They follow the kind of error, not the HTTP status, which the server shares between
+// kinds (404 is both a missing key parameter and a missing key).
+const (
+	exitOK          = 0
+	exitError       = 1 // Anything not covered below, e.g. an internal server error
+	exitUsage       = 2 // Bad command line
+	exitNotFound    = 3 // The key, value or message does not exist; also "exists" of a missing key
+	exitConflict    = 4 // The key exists already, or a lease or receipt belongs to someone else
+	exitInvalid     = 5 // The server rejected a parameter or value
+	exitUnavailable = 6 // The server could not be reached or cannot serve the request right now
+)
+
+// command is a kvctl subcommand. run returns the result to print, nil for none.
+type command struct {
+	name  string
+	usage string // Arguments, for the help text
+	help  string
+	run   func(ctx context.Context, e *env, args []string) (any, error)
+}
+
+// env is what commands run with.
+type env struct {
+	client  *client.Client
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	out     *printer
+	timeout time.Duration // Time limit for each command, 0 for none
+}
+
+// usageError is a bad command line, reported with exitUsage.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs kvctl with args and returns its exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("kvctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", defaultConfigPath(), "profiles file")
+	profileName := fs.String("profile", os.Getenv("KVCTL_PROFILE"), "profile to use (default: the file's current profile)")
+	server := fs.String("server", "", "server base URL, overriding the profile")
+	user := fs.String("user", "", "basic auth user, overriding the profile")
+	password := fs.String("password", "", "basic auth password, overriding the profile")
+	format := fs.String("o", "json", "output format: json, table or raw")
+	timeout := fs.Duration("timeout", 30*time.Second, "time limit for each command (0 for none)")
+	retries := fs.Int("retries", client.DefaultRetries, "retries of requests that fail transiently")
+	fs.Usage = func() { printUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok && fs.Arg(0) != "shell" {
+		fmt.Fprintf(stderr, "kvctl: unknown command %q\n", fs.Arg(0))
+		return exitUsage
+	}
+	out, err := newPrinter(*format, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "kvctl: %s\n", err)
+		return exitUsage
+	}
+
+	profile, err := loadProfile(*configPath, *profileName)
+	if err != nil {
+		fmt.Fprintf(stderr, "kvctl: %s\n", err)
+		return exitUsage
+	}
+	profile.override(*server, *user, *password)
+
+	c := client.New(profile.Server)
+	c.User, c.Password = profile.User, profile.Password
+	c.Retries = *retries
+
+	e := &env{client: c, stdin: stdin, stdout: stdout, stderr: stderr, out: out, timeout: *timeout}
+	if !ok {
+		return runShell(e, fs.Args()[1:])
+	}
+	return execute(context.Background(), e, cmd, fs.Args()[1:])
+}
+
+// execute runs cmd, prints its result and returns its exit status.
+func execute(ctx context.Context, e *env, cmd command, args []string) int {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	// A command can return a result along with its error, e.g. the summary of an aborted import
+	result, err := cmd.run(ctx, e, args)
+	if result != nil {
+		if err := e.out.print(result); err != nil {
+			fmt.Fprintf(e.stderr, "kvctl: %s\n", err)
+			return exitError
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, errNotExist) {
+			fmt.Fprintf(e.stderr, "kvctl %s: %s\n", cmd.name, err)
+		}
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+// exitCode returns the exit status for err.
+func exitCode(err error) int {
+	var usage usageError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, helpers.NotExistError), errors.Is(err, helpers.EmptyValueError),
+		errors.Is(err, helpers.QueueEmptyError), errors.Is(err, errNotExist):
+		return exitNotFound
+	case errors.Is(err, helpers.DuplicateKeyError), errors.Is(err, helpers.LeaseHeldError),
+		errors.Is(err, helpers.NotLeaseOwnerError), errors.Is(err, helpers.InvalidReceiptError):
+		return exitConflict
+	case errors.Is(err, helpers.InvalidParamError), errors.Is(err, helpers.MissingKeyError),
+		errors.Is(err, helpers.MissingValueError), errors.Is(err, helpers.MethodNotAllowed):
+		return exitInvalid
+	case errors.Is(err, helpers.NotLeaderError), errors.Is(err, helpers.NoOwnerError),
+		errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return exitUnavailable
+	}
+	return exitError
+}
+
+func printUsage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: kvctl [flags] <command> [arguments]\n\nCommands:\n")
+	printCommands(w)
+	fmt.Fprintf(w, "  %-34s %s\n", "shell [-f script] [-continue]", "run commands interactively, or from a script")
+	fmt.Fprintf(w, "\nFlags:\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nExit status: 0 ok, 1 error, 2 usage, 3 not found, 4 conflict, 5 invalid, 6 unavailable\n")
+}
+
+// printCommands lists the commands and what they do.
+func printCommands(w io.Writer) {
+	for _, name := range commandNames() {
+		c := commands[name]
+		fmt.Fprintf(w, "  %-34s %s\n", strings.TrimSpace(name+" "+c.usage), c.help)
+	}
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
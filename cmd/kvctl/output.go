@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kvstore/audit"
+	"kvstore/store"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printer writes command results in one of the output formats:
+//   - json: indented JSON, for tools such as jq
+//   - table: aligned columns with a header, for people
+//   - raw: values as stored, strings unquoted and everything else as compact JSON, for shell scripts
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+func newPrinter(format string, w io.Writer) (*printer, error) {
+	switch format {
+	case "json", "table", "raw":
+		return &printer{format: format, w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (want json, table or raw)", format)
+}
+
+// keyValue is a key with its value, as listed by get-all and scan.
+type keyValue struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (p *printer) print(v any) error {
+	switch p.format {
+	case "table":
+		return p.table(v)
+	case "raw":
+		return p.raw(v)
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", b)
+	return err
+}
+
+func (p *printer) raw(v any) error {
+	switch v := v.(type) {
+	case []string:
+		for _, s := range v {
+			fmt.Fprintln(p.w, s)
+		}
+		return nil
+	case []keyValue:
+		for _, kv := range v {
+			fmt.Fprintf(p.w, "%s\t%s\n", kv.Key, rawValue(kv.Value))
+		}
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(p.w, rawValue(b))
+	return err
+}
+
+// rawValue returns a JSON string unquoted and any other JSON value compacted.
+func rawValue(b []byte) string {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		return s
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, b) != nil {
+		return string(b)
+	}
+	return buf.String()
+}
+
+func (p *printer) table(v any) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	row := func(cols ...any) {
+		for i, c := range cols {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, c)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	switch v := v.(type) {
+	case []keyValue:
+		row("KEY", "VALUE")
+		for _, kv := range v {
+			row(kv.Key, rawValue(kv.Value))
+		}
+	case map[string]store.Item:
+		row("KEY", "VALUE", "SIZE", "UPDATED", "TAGS")
+		for _, k := range sortedKeys(v) {
+			item := v[k]
+			value, _ := json.Marshal(item.Value)
+			row(k, rawValue(value), item.Metadata.Size, timestamp(item.Metadata.UpdatedAt), tags(item.Metadata.Tags))
+		}
+	case store.Item:
+		value, _ := json.Marshal(v.Value)
+		row("VALUE", "SIZE", "UPDATED", "READS", "TAGS")
+		row(rawValue(value), v.Metadata.Size, timestamp(v.Metadata.UpdatedAt), v.Metadata.ReadCount, tags(v.Metadata.Tags))
+	case store.Metadata:
+		row("CREATED", "UPDATED", "LAST ACCESSED", "READS", "WRITES", "SIZE", "TAGS")
+		row(timestamp(v.CreatedAt), timestamp(v.UpdatedAt), timestamp(v.LastAccessedAt), v.ReadCount, v.WriteCount, v.Size, tags(v.Tags))
+	case map[string]string:
+		row("NAME", "VALUE")
+		for _, k := range sortedKeys(v) {
+			row(k, v[k])
+		}
+	case []string:
+		row("KEY")
+		for _, s := range v {
+			row(s)
+		}
+	case []store.TrashEntry:
+		row("KEY", "DELETED", "CLEAR ID", "VALUE")
+		for _, e := range v {
+			value, _ := json.Marshal(e.Value)
+			row(e.Key, timestamp(e.DeletedAt), e.ClearID, rawValue(value))
+		}
+	case []audit.Record:
+		row("SEQ", "TIME", "OP", "KEY", "IDENTITY", "CLIENT")
+		for _, r := range v {
+			row(r.Seq, timestamp(r.Time), r.Op, r.Key, r.Identity, r.Client)
+		}
+	case []store.QueueStats:
+		row("QUEUE", "DEPTH", "IN FLIGHT", "DEAD", "ENQUEUED", "ACKED")
+		for _, s := range v {
+			row(s.Name, s.Depth, s.InFlight, s.Dead, s.Enqueued, s.Acked)
+		}
+	case []store.StreamEntry:
+		row("ID", "VALUE")
+		for _, e := range v {
+			value, _ := json.Marshal(e.Value)
+			row(e.ID, rawValue(value))
+		}
+	default:
+		// Single values and objects have no columns to speak of
+		return p.raw(v)
+	}
+	return tw.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func tags(m map[string]string) string {
+	parts := make([]string, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		parts = append(parts, k+"="+m[k])
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"kvstore/transfer"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	historyFile  = ".kvctl_history"
+	historyLimit = 1000 // Lines kept in the history file
+
+	// completionLimit caps the key names fetched from the server for a single completion.
+	completionLimit = 200
+)
+
+// errIncomplete is returned by splitWords for text that ends inside a quote or JSON value, which the
+// shell reads as a command continued on the next line.
+var errIncomplete = errors.New("unterminated quote or JSON value")
+
+// keyCommands are the commands whose arguments are completed with key names.
+var keyCommands = map[string]bool{
+	"get": true, "meta": true, "add": true, "update": true, "upsert": true, "delete": true,
+	"exists": true, "scan": true, "restore": true, "purge": true, "tags": true, "tag": true, "untag": true,
+}
+
+// shell runs kvctl commands typed in one after the other, or read from a script.
+type shell struct {
+	e      *env
+	timing bool // Print how long each command took
+}
+
+// runShell runs the shell command: interactive if stdin is a terminal, otherwise a script read from
+// stdin or the file given with -f.
+func runShell(e *env, args []string) int {
+	fs := flag.NewFlagSet("shell", flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	script := fs.String("f", "", "run the commands in this file, - for stdin")
+	keepGoing := fs.Bool("continue", false, "carry on with a script after a command fails")
+	timing := fs.Bool("timing", false, "print how long each command of a script takes (always on interactively)")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		fmt.Fprintln(e.stderr, "usage: kvctl shell [-f script] [-continue] [-timing]")
+		return exitUsage
+	}
+
+	s := &shell{e: e, timing: *timing}
+	if f, ok := e.stdin.(*os.File); ok && *script == "" && isTerminal(f) {
+		s.timing = true
+		return s.interactive(f)
+	}
+
+	in, name := e.stdin, "stdin"
+	if *script != "" && *script != "-" {
+		f, err := os.Open(*script)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "kvctl shell: %s\n", err)
+			return exitError
+		}
+		defer f.Close()
+		in, name = f, *script
+	}
+	return s.runScript(in, name, *keepGoing)
+}
+
+// runScript runs the commands read from r, one per line; blank lines and lines starting with # are
+// skipped. It stops at the first command that fails, with its exit status, unless keepGoing is set,
+// in which case it returns the status of the last command that failed.
+func (s *shell) runScript(r io.Reader, name string, keepGoing bool) int {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	line := 0
+	next := func(string) (string, error) {
+		if !sc.Scan() {
+			if err := sc.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		line++
+		return sc.Text(), nil
+	}
+
+	status := exitOK
+	for {
+		first := line + 1
+		words, _, err := readCommand(next, "")
+		if errors.Is(err, io.EOF) {
+			return status
+		}
+		if err != nil {
+			fmt.Fprintf(s.e.stderr, "kvctl shell: %s:%d: %s\n", name, first, err)
+			return exitUsage
+		}
+		if isExit(words) {
+			return status
+		}
+		if code := s.exec(context.Background(), words); code != exitOK {
+			if !keepGoing {
+				return code
+			}
+			status = code
+		}
+	}
+}
+
+// interactive reads commands from the terminal until exit or ^D.
+func (s *shell) interactive(in *os.File) int {
+	ed := &editor{r: bufio.NewReader(in), w: s.e.stdout, complete: s.complete}
+	path := historyPath()
+	ed.history = loadHistory(path)
+
+	prompt := s.e.client.BaseURL
+	if u, err := url.Parse(prompt); err == nil && u.Host != "" {
+		prompt = u.Host
+	}
+	prompt += "> "
+	fmt.Fprintf(s.e.stdout, "Connected to %s. Type help for the commands, exit or ^D to leave.\n", s.e.client.BaseURL)
+
+	next := func(p string) (string, error) {
+		state, err := makeRaw(in)
+		if err != nil {
+			return "", err
+		}
+		defer restoreTerm(in, state)
+		return ed.readLine(p)
+	}
+	for {
+		words, text, err := readCommand(next, prompt)
+		switch {
+		case errors.Is(err, io.EOF):
+			return exitOK
+		case errors.Is(err, errInterrupted):
+			continue
+		case err != nil:
+			fmt.Fprintf(s.e.stderr, "kvctl shell: %s\n", err)
+			return exitError
+		}
+
+		// Multi-line commands are remembered as one line, which is still a complete command
+		entry := strings.TrimSpace(strings.ReplaceAll(strings.ReplaceAll(text, "\\\n", ""), "\n", " "))
+		if entry != "" &&
+			(len(ed.history) == 0 || ed.history[len(ed.history)-1] != entry) {
+			ed.history = append(ed.history, entry)
+			appendHistory(path, entry)
+		}
+		if isExit(words) {
+			return exitOK
+		}
+
+		// ^C while a command runs cancels it rather than ending the shell
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		s.exec(ctx, words)
+		stop()
+	}
+}
+
+// readCommand reads lines with next until they make a complete command, one that does not end
+// inside a quote or JSON value, and returns its words along with the text read.
+func readCommand(next func(prompt string) (string, error), prompt string) ([]string, string, error) {
+	var text string
+	for {
+		line, err := next(prompt)
+		if errors.Is(err, io.EOF) && text != "" {
+			return nil, text, errIncomplete
+		}
+		if err != nil {
+			return nil, text, err
+		}
+		if text == "" && strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if text != "" {
+			text += "\n"
+		}
+		text += line
+
+		words, err := splitWords(text)
+		if !errors.Is(err, errIncomplete) {
+			return words, text, err
+		}
+		if prompt != "" {
+			prompt = strings.Repeat(" ", max(len(prompt)-4, 0)) + "... "
+		}
+	}
+}
+
+func isExit(words []string) bool {
+	return len(words) > 0 && (words[0] == "exit" || words[0] == "quit")
+}
+
+// exec runs the command made of words and returns its exit status.
+func (s *shell) exec(ctx context.Context, words []string) int {
+	if len(words) == 0 {
+		return exitOK
+	}
+
+	switch words[0] {
+	case "help":
+		if len(words) > 1 {
+			if c, ok := commands[words[1]]; ok {
+				fmt.Fprintf(s.e.stdout, "%s %s\n  %s\n", c.name, c.usage, c.help)
+				return exitOK
+			}
+		}
+		printCommands(s.e.stdout)
+		fmt.Fprintf(s.e.stdout, "  %-34s %s\n  %-34s %s\n", "timing [on|off]", "show how long each command takes",
+			"exit", "leave the shell")
+		return exitOK
+	case "timing":
+		if len(words) != 2 || (words[1] != "on" && words[1] != "off") {
+			fmt.Fprintln(s.e.stderr, "usage: timing on|off")
+			return exitUsage
+		}
+		s.timing = words[1] == "on"
+		return exitOK
+	}
+
+	cmd, ok := commands[words[0]]
+	if !ok {
+		fmt.Fprintf(s.e.stderr, "kvctl: unknown command %q, try help\n", words[0])
+		return exitUsage
+	}
+	start := time.Now()
+	code := execute(ctx, s.e, cmd, words[1:])
+	if s.timing {
+		fmt.Fprintf(s.e.stdout, "(%s)\n", time.Since(start).Round(10*time.Microsecond))
+	}
+	return code
+}
+
+// complete returns the completions of the last word of line: command names for the first word, and
+// key names fetched from the server for the arguments of commands that take a key.
+func (s *shell) complete(line string) (int, []string) {
+	start := strings.LastIndexAny(line, " \t\n") + 1
+	word, before := line[start:], strings.Fields(line[:start])
+
+	if len(before) == 0 {
+		var names []string
+		for _, name := range append(commandNames(), "help", "timing", "exit") {
+			if strings.HasPrefix(name, word) {
+				names = append(names, name)
+			}
+		}
+		return start, names
+	}
+	if !keyCommands[before[0]] || strings.HasPrefix(word, "-") {
+		return start, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var keys []string
+	records(ctx, s.e.client, word, func(rec transfer.Record) bool {
+		keys = append(keys, quoteWord(rec.Key))
+		return len(keys) < completionLimit
+	})
+	return start, keys
+}
+
+// splitWords splits a command into words. Words are separated by white space and may be quoted with
+// '...', taken literally, or "...", in which \ escapes the next character. A word starting with { or [
+// runs to the matching bracket, so JSON values can be typed in without quoting, over several lines.
+func splitWords(text string) ([]string, error) {
+	var words []string
+	i := 0
+	for {
+		for i < len(text) && isSpace(text[i]) {
+			i++
+		}
+		if i == len(text) {
+			return words, nil
+		}
+
+		if text[i] == '{' || text[i] == '[' {
+			end := jsonEnd(text, i)
+			if end < 0 {
+				return nil, errIncomplete
+			}
+			words = append(words, text[i:end])
+			i = end
+			continue
+		}
+
+		var w strings.Builder
+		for i < len(text) && !isSpace(text[i]) {
+			switch c := text[i]; c {
+			case '\'':
+				end := strings.IndexByte(text[i+1:], '\'')
+				if end < 0 {
+					return nil, errIncomplete
+				}
+				w.WriteString(text[i+1 : i+1+end])
+				i += end + 2
+			case '"':
+				for i++; ; i++ {
+					if i == len(text) {
+						return nil, errIncomplete
+					}
+					if text[i] == '"' {
+						i++
+						break
+					}
+					if text[i] == '\\' && i+1 < len(text) {
+						i++
+					}
+					w.WriteByte(text[i])
+				}
+			case '\\':
+				switch {
+				case i+1 == len(text):
+					return nil, errIncomplete
+				case text[i+1] != '\n': // A backslash at the end of a line continues the command
+					w.WriteByte(text[i+1])
+				}
+				i += 2
+			default:
+				w.WriteByte(c)
+				i++
+			}
+		}
+		words = append(words, w.String())
+	}
+}
+
+// jsonEnd returns the offset just past the JSON object or array starting at text[start], or -1 if
+// text ends first.
+func jsonEnd(text string, start int) int {
+	depth := 0
+	inString := false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// quoteWord quotes s if splitWords would not read it back as a single word.
+func quoteWord(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\r\n'\"\\") && s[0] != '{' && s[0] != '[' {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFile)
+}
+
+// loadHistory returns the last historyLimit lines of the history file, trimming the file to them.
+func loadHistory(path string) []string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(lines) > historyLimit {
+		lines = lines[len(lines)-historyLimit:]
+		os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	}
+	return lines
+}
+
+func appendHistory(path, line string) {
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want []string
+		err  error
+	}{
+		{"get user:1", []string{"get", "user:1"}, nil},
+		{`upsert k {"a": [1, "}"], "b": true}`, []string{"upsert", "k", `{"a": [1, "}"], "b": true}`}, nil},
+		{"upsert k [1,\n 2]", []string{"upsert", "k", "[1,\n 2]"}, nil},
+		{`get 'my key' "say \"hi\"" a\ b`, []string{"get", "my key", `say "hi"`, "a b"}, nil},
+		{"get \\\nkey", []string{"get", "key"}, nil},
+		{"  ", nil, nil},
+		{`upsert k {"a": 1`, nil, errIncomplete},
+		{`upsert k {"a": "}`, nil, errIncomplete},
+		{"get 'key", nil, errIncomplete},
+		{"get key \\", nil, errIncomplete},
+	} {
+		got, err := splitWords(tt.text)
+		if !errors.Is(err, tt.err) || !slices.Equal(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, %v, want %q, %v", tt.text, got, err, tt.want, tt.err)
+		}
+	}
+
+	for _, key := range []string{"plain", "with space", `q"uote`, "{brace", `back\slash`} {
+		if got, _ := splitWords(quoteWord(key)); !slices.Equal(got, []string{key}) {
+			t.Errorf("quoteWord(%q) = %s, read back as %q", key, quoteWord(key), got)
+		}
+	}
+}
+
+func TestEditor(t *testing.T) {
+	keys := strings.Join([]string{
+		"hllo\x01\x06e\r",      // ^A, ^F, insert: hello
+		"gone\x15back\r",       // ^U kills the line
+		"\x1b[A\x1b[A world\r", // Up twice recalls "hello"
+		"two words\x17\x17x\r", // ^W deletes words
+		"ab\x1b[D\x1b[3~\r",    // Left, then delete under the cursor
+		"ups\t\t\r",            // Completes the command, then its key as far as the candidates agree
+		"partial\x03",          // ^C
+	}, "")
+	ed := &editor{
+		r:       bufio.NewReader(strings.NewReader(keys)),
+		w:       io.Discard,
+		history: []string{"hello"},
+		complete: func(line string) (int, []string) {
+			if line == "ups" {
+				return 0, []string{"upsert"}
+			}
+			start := strings.LastIndexByte(line, ' ') + 1
+			return start, []string{"user:1", "user:2"}
+		},
+	}
+
+	var lines []string
+	for {
+		line, err := ed.readLine("> ")
+		if err != nil {
+			if !errors.Is(err, errInterrupted) {
+				t.Errorf("readLine() = %v, want errInterrupted at ^C", err)
+			}
+			break
+		}
+		lines = append(lines, line)
+		ed.history = append(ed.history, line)
+	}
+
+	want := []string{"hello", "back", "hello world", "x", "a", "upsert user:"}
+	if !slices.Equal(lines, want) {
+		t.Errorf("read %q, want %q", lines, want)
+	}
+}
+
+func TestScript(t *testing.T) {
+	script := `# Comments and blank lines are skipped
+
+upsert shell:doc {
+  "title": "multi-line",
+  "tags": ["a", "b"]
+}
+upsert -string shell:note 'a note'
+get shell:note
+exists shell:missing
+get shell:doc
+`
+	code, out := kvctl(t, script, "-o", "raw", "shell")
+	if code != exitNotFound || out != "a note\nfalse\n" {
+		t.Errorf("script stopping at exists = %d, %q, want %d, %q", code, out, exitNotFound, "a note\nfalse\n")
+	}
+
+	path := filepath.Join(t.TempDir(), "script.kv")
+	os.WriteFile(path, []byte(script), 0o644)
+	code, out = kvctl(t, "", "-o", "raw", "shell", "-continue", "-f", path)
+	want := "a note\nfalse\n" + `{"tags":["a","b"],"title":"multi-line"}` + "\n"
+	if code != exitNotFound || out != want {
+		t.Errorf("script with -continue = %d, %q, want %d, %q", code, out, exitNotFound, want)
+	}
+
+	code, _ = kvctl(t, "upsert shell:x {\n", "shell")
+	if code != exitUsage {
+		t.Errorf("script ending inside a value exited %d, want %d", code, exitUsage)
+	}
+	code, out = kvctl(t, "count\nexit\nfrobnicate\n", "shell", "-timing")
+	if code != exitOK || !strings.HasSuffix(out, ")\n") {
+		t.Errorf("script with exit = %d, %q, want a timed count", code, out)
+	}
+}
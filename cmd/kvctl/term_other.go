@@ -0,0 +1,23 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// termState is unused where there is no raw mode; the shell reads whole lines without editing.
+type termState struct{}
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func makeRaw(f *os.File) (*termState, error) {
+	return nil, errors.New("raw terminal mode is not supported on this system")
+}
+
+func restoreTerm(f *os.File, state *termState) error {
+	return nil
+}
@@ -0,0 +1,51 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// termState is the mode of a terminal, saved by makeRaw to be put back by restoreTerm.
+type termState struct {
+	termios syscall.Termios
+}
+
+func isTerminal(f *os.File) bool {
+	var t syscall.Termios
+	return ioctl(f.Fd(), ioctlGetTermios, &t) == nil
+}
+
+// makeRaw puts the terminal in raw mode, where input is read a key at a time, is not echoed and
+// ^C is a key rather than a signal. Output processing stays on, so "\n" still starts a new line.
+func makeRaw(f *os.File) (*termState, error) {
+	var old termState
+	if err := ioctl(f.Fd(), ioctlGetTermios, &old.termios); err != nil {
+		return nil, err
+	}
+
+	t := old.termios
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := ioctl(f.Fd(), ioctlSetTermios, &t); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+func restoreTerm(f *os.File, state *termState) error {
+	return ioctl(f.Fd(), ioctlSetTermios, &state.termios)
+}
+
+func ioctl(fd, req uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package crdt
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timestamp is a reading of a hybrid logical clock: wall clock time, a logical counter that orders
+// events within the same wall clock time, and the node that made the reading to break remaining ties.
+// Readings of the same node never repeat, so a timestamp also identifies the event that took it.
+type Timestamp struct {
+	Wall    int64 // Unix nanoseconds
+	Logical uint32
+	Node    string
+}
+
+// Compare orders timestamps by wall time, then logical counter, then node.
+func (t Timestamp) Compare(u Timestamp) int {
+	if c := cmp.Compare(t.Wall, u.Wall); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(t.Logical, u.Logical); c != 0 {
+		return c
+	}
+	return cmp.Compare(t.Node, u.Node)
+}
+
+// IsZero reports whether t is the zero timestamp, which sorts before every reading.
+func (t Timestamp) IsZero() bool {
+	return t == Timestamp{}
+}
+
+// String formats t as wall.logical@node.
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d@%s", t.Wall, t.Logical, t.Node)
+}
+
+func (t Timestamp) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *Timestamp) UnmarshalText(b []byte) error {
+	s := string(b)
+	clock, node, ok := strings.Cut(s, "@")
+	wall, logical, ok2 := strings.Cut(clock, ".")
+	if !ok || !ok2 {
+		return fmt.Errorf("invalid timestamp %q, want wall.logical@node", s)
+	}
+	w, err := strconv.ParseInt(wall, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	l, err := strconv.ParseUint(logical, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	*t = Timestamp{Wall: w, Logical: uint32(l), Node: node}
+	return nil
+}
+
+// Clock is a hybrid logical clock. Its readings follow the wall clock where it moves forward, never go
+// backwards, and always come after every timestamp the clock has observed from other nodes, so an
+// event caused by another is always ordered after it even when the nodes' clocks disagree.
+type Clock struct {
+	Node string
+	Now  func() time.Time // Wall clock, time.Now if nil
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+// Tick returns a new reading for a local event.
+func (c *Clock) Tick() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.wall()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	c.last.Node = c.Node
+	return c.last
+}
+
+// Observe moves the clock past a timestamp received from another node.
+func (c *Clock) Observe(t Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := max(c.wall(), c.last.Wall, t.Wall)
+	switch {
+	case wall == c.last.Wall && wall == t.Wall:
+		c.last.Logical = max(c.last.Logical, t.Logical) + 1
+	case wall == c.last.Wall:
+		c.last.Logical++
+	case wall == t.Wall:
+		c.last.Logical = t.Logical + 1
+	default:
+		c.last.Logical = 0
+	}
+	c.last.Wall = wall
+	c.last.Node = c.Node
+}
+
+func (c *Clock) wall() int64 {
+	if c.Now == nil {
+		return time.Now().UnixNano()
+	}
+	return c.Now().UnixNano()
+}
@@ -0,0 +1,467 @@
+// Package crdt provides conflict-free replicated data types, so several sites can accept writes to the
+// same keys and still converge without coordinating.
+//
+// Every type is a state that only grows: Merge takes the least upper bound of two states, which is
+// commutative, associative and idempotent, so replicas that have seen the same updates hold the same
+// state no matter in which order, how often or how batched the updates arrived. Each update returns
+// a delta, a small state holding only what the update changed, which is all a replica needs to send
+// to the others.
+package crdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Type is the kind of a replicated value.
+type Type string
+
+const (
+	// LWW is a last-writer-wins register holding any JSON value. Concurrent writes are ordered by their
+	// hybrid logical clock timestamps.
+	LWW Type = "lww"
+
+	// GCounter is a counter that only grows.
+	GCounter Type = "gcounter"
+
+	// PNCounter is a counter that can be incremented and decremented.
+	PNCounter Type = "pncounter"
+
+	// ORSet is an observed-remove set of JSON values. An element added concurrently with its removal
+	// stays in the set.
+	ORSet Type = "orset"
+
+	// ORMap is a JSON object whose fields are added and removed as in an ORSet and whose values are
+	// last-writer-wins registers.
+	ORMap Type = "ormap"
+)
+
+// ParseType converts a string into a Type.
+func ParseType(s string) (Type, error) {
+	switch t := Type(strings.ToLower(s)); t {
+	case LWW, GCounter, PNCounter, ORSet, ORMap:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown CRDT type %q, want lww, gcounter, pncounter, orset or ormap", s)
+}
+
+var (
+	// ErrWrongType is returned for an update the type of a value does not support.
+	ErrWrongType = errors.New("crdt: operation not supported by the type")
+
+	// ErrInvalid is returned for an update with invalid arguments.
+	ErrInvalid = errors.New("crdt: invalid update")
+)
+
+// Register is the state of a last-writer-wins register.
+type Register struct {
+	Value json.RawMessage `json:"value,omitempty"`
+	Time  Timestamp       `json:"time,omitzero"`
+}
+
+func (r Register) merge(o Register) Register {
+	if o.Time.Compare(r.Time) > 0 {
+		return o
+	}
+	return r
+}
+
+// Counter is the state of a G-Counter or PN-Counter: the increments (P) and decrements (N) made by
+// each node. A node only ever raises its own entries, so the larger of two entries is the newer.
+type Counter struct {
+	P map[string]uint64 `json:"p,omitempty"`
+	N map[string]uint64 `json:"n,omitempty"`
+}
+
+func (c Counter) merge(o Counter) Counter {
+	return Counter{P: maxEntries(c.P, o.P), N: maxEntries(c.N, o.N)}
+}
+
+func (c Counter) value() int64 {
+	var v int64
+	for _, n := range c.P {
+		v += int64(n)
+	}
+	for _, n := range c.N {
+		v -= int64(n)
+	}
+	return v
+}
+
+func maxEntries(a, b map[string]uint64) map[string]uint64 {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	m := maps.Clone(a)
+	if m == nil {
+		m = make(map[string]uint64, len(b))
+	}
+	for k, v := range b {
+		m[k] = max(m[k], v)
+	}
+	return m
+}
+
+// Dots is a sorted set of timestamps, each identifying the update that added an element.
+type Dots []Timestamp
+
+func (d Dots) union(o Dots) Dots {
+	u := append(slices.Clone(d), o...)
+	slices.SortFunc(u, Timestamp.Compare)
+	return slices.Compact(u)
+}
+
+func (d Dots) without(removed Dots) Dots {
+	var live Dots
+	for _, t := range d {
+		if _, found := slices.BinarySearchFunc(removed, t, Timestamp.Compare); !found {
+			live = append(live, t)
+		}
+	}
+	return live
+}
+
+// Set is the state of an observed-remove set. Every add tags the element with a new dot, and a remove
+// moves the dots it has seen to Removed. An element is in the set while it has a dot that is not removed.
+type Set struct {
+	Elements map[string]Dots `json:"elements,omitempty"` // By the element's canonical JSON
+	Removed  Dots            `json:"removed,omitempty"`
+}
+
+func (s Set) merge(o Set) Set {
+	removed := s.Removed.union(o.Removed)
+	return Set{Elements: mergeDots(s.Elements, o.Elements, removed), Removed: removed}
+}
+
+// Map is the state of an observed-remove map. Fields are tracked as the elements of a Set, and each
+// field keeps its value in a register. The register outlives the removal of its field, so a field
+// added back concurrently with its removal keeps the newest value.
+type Map struct {
+	Fields  map[string]Dots     `json:"fields,omitempty"`
+	Removed Dots                `json:"removed,omitempty"`
+	Values  map[string]Register `json:"values,omitempty"`
+}
+
+func (m Map) merge(o Map) Map {
+	removed := m.Removed.union(o.Removed)
+	values := maps.Clone(m.Values)
+	for f, r := range o.Values {
+		if values == nil {
+			values = make(map[string]Register, len(o.Values))
+		}
+		values[f] = values[f].merge(r)
+	}
+	return Map{Fields: mergeDots(m.Fields, o.Fields, removed), Removed: removed, Values: values}
+}
+
+// mergeDots unions the dots of each element and drops those removed, along with elements left without any.
+func mergeDots(a, b map[string]Dots, removed Dots) map[string]Dots {
+	var merged map[string]Dots
+	for _, m := range []map[string]Dots{a, b} {
+		for e, dots := range m {
+			live := merged[e].union(dots).without(removed)
+			if len(live) == 0 {
+				continue
+			}
+			if merged == nil {
+				merged = make(map[string]Dots)
+			}
+			merged[e] = live
+		}
+	}
+	return merged
+}
+
+// State is a replicated value of any type. Exactly one of its fields is set, according to Type.
+type State struct {
+	Type     Type      `json:"type"`
+	Register *Register `json:"register,omitempty"`
+	Counter  *Counter  `json:"counter,omitempty"`
+	Set      *Set      `json:"set,omitempty"`
+	Map      *Map      `json:"map,omitempty"`
+}
+
+// New returns the empty state of type t.
+func New(t Type) State {
+	s := State{Type: t}
+	switch t {
+	case LWW:
+		s.Register = &Register{}
+	case GCounter, PNCounter:
+		s.Counter = &Counter{}
+	case ORSet:
+		s.Set = &Set{}
+	case ORMap:
+		s.Map = &Map{}
+	}
+	return s
+}
+
+// Merge returns the least upper bound of a and b. States of different types can only come from the
+// same key being declared differently on two sites at once; the type sorting first wins, so every
+// site still settles on the same one.
+func Merge(a, b State) State {
+	switch {
+	case a.Type == "":
+		return b.Clone()
+	case b.Type == "":
+		return a.Clone()
+	case a.Type != b.Type:
+		if b.Type < a.Type {
+			a = b
+		}
+		return a.Clone()
+	}
+	a = a.fill()
+	b = b.fill()
+
+	switch a.Type {
+	case LWW:
+		r := a.Register.merge(*b.Register)
+		a.Register = &r
+	case GCounter, PNCounter:
+		c := a.Counter.merge(*b.Counter)
+		a.Counter = &c
+	case ORSet:
+		s := a.Set.merge(*b.Set)
+		a.Set = &s
+	case ORMap:
+		m := a.Map.merge(*b.Map)
+		a.Map = &m
+	}
+	return a.Clone()
+}
+
+// fill sets the field of the type if a state decoded from JSON left it out.
+func (s State) fill() State {
+	empty := New(s.Type)
+	if s.Register == nil {
+		s.Register = empty.Register
+	}
+	if s.Counter == nil {
+		s.Counter = empty.Counter
+	}
+	if s.Set == nil {
+		s.Set = empty.Set
+	}
+	if s.Map == nil {
+		s.Map = empty.Map
+	}
+	return s
+}
+
+// Clone returns a deep copy of s.
+func (s State) Clone() State {
+	c := State{Type: s.Type}
+	switch {
+	case s.Register != nil && s.Type == LWW:
+		r := Register{Value: bytes.Clone(s.Register.Value), Time: s.Register.Time}
+		c.Register = &r
+	case s.Counter != nil && (s.Type == GCounter || s.Type == PNCounter):
+		c.Counter = &Counter{P: maps.Clone(s.Counter.P), N: maps.Clone(s.Counter.N)}
+	case s.Set != nil && s.Type == ORSet:
+		c.Set = &Set{Elements: cloneDots(s.Set.Elements), Removed: slices.Clone(s.Set.Removed)}
+	case s.Map != nil && s.Type == ORMap:
+		c.Map = &Map{Fields: cloneDots(s.Map.Fields), Removed: slices.Clone(s.Map.Removed), Values: maps.Clone(s.Map.Values)}
+	default:
+		c = New(s.Type)
+	}
+	return c
+}
+
+func cloneDots(m map[string]Dots) map[string]Dots {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]Dots, len(m))
+	for k, v := range m {
+		c[k] = slices.Clone(v)
+	}
+	return c
+}
+
+// Value returns the current value: the JSON value of a register, the count of a counter, the sorted
+// elements of a set or the fields of a map.
+func (s State) Value() any {
+	s = s.fill()
+	switch s.Type {
+	case LWW:
+		return decode(s.Register.Value)
+	case GCounter, PNCounter:
+		return s.Counter.value()
+	case ORSet:
+		elements := make([]any, 0, len(s.Set.Elements))
+		for _, e := range slices.Sorted(maps.Keys(s.Set.Elements)) {
+			elements = append(elements, decode(json.RawMessage(e)))
+		}
+		return elements
+	case ORMap:
+		fields := make(map[string]any, len(s.Map.Fields))
+		for f := range s.Map.Fields {
+			fields[f] = decode(s.Map.Values[f].Value)
+		}
+		return fields
+	}
+	return nil
+}
+
+// Latest returns the newest timestamp in s, so a replica merging s can move its clock past it.
+func (s State) Latest() Timestamp {
+	var latest Timestamp
+	see := func(t Timestamp) {
+		if t.Compare(latest) > 0 {
+			latest = t
+		}
+	}
+	seeAll := func(m map[string]Dots, removed Dots) {
+		for _, dots := range m {
+			if len(dots) > 0 {
+				see(dots[len(dots)-1])
+			}
+		}
+		if len(removed) > 0 {
+			see(removed[len(removed)-1])
+		}
+	}
+
+	switch {
+	case s.Register != nil:
+		see(s.Register.Time)
+	case s.Set != nil:
+		seeAll(s.Set.Elements, s.Set.Removed)
+	case s.Map != nil:
+		seeAll(s.Map.Fields, s.Map.Removed)
+		for _, r := range s.Map.Values {
+			see(r.Time)
+		}
+	}
+	return latest
+}
+
+// SetValue writes a register, returning the delta.
+func (s *State) SetValue(v json.RawMessage, now Timestamp) (State, error) {
+	if s.Type != LWW {
+		return State{}, fmt.Errorf("%w: set on %s", ErrWrongType, s.Type)
+	}
+	c, err := canonical(v)
+	if err != nil {
+		return State{}, err
+	}
+
+	delta := State{Type: LWW, Register: &Register{Value: json.RawMessage(c), Time: now}}
+	*s = Merge(*s, delta)
+	return delta, nil
+}
+
+// Increment adds by to the count of node, returning the delta. A G-Counter only takes positive values.
+func (s *State) Increment(node string, by int64) (State, error) {
+	switch {
+	case s.Type != GCounter && s.Type != PNCounter:
+		return State{}, fmt.Errorf("%w: increment on %s", ErrWrongType, s.Type)
+	case s.Type == GCounter && by < 0:
+		return State{}, fmt.Errorf("%w: a gcounter cannot be decremented", ErrInvalid)
+	case by == 0:
+		return State{}, fmt.Errorf("%w: increment by zero", ErrInvalid)
+	}
+	*s = s.fill()
+
+	delta := State{Type: s.Type, Counter: &Counter{}}
+	if by > 0 {
+		delta.Counter.P = map[string]uint64{node: s.Counter.P[node] + uint64(by)}
+	} else {
+		delta.Counter.N = map[string]uint64{node: s.Counter.N[node] + uint64(-by)}
+	}
+	*s = Merge(*s, delta)
+	return delta, nil
+}
+
+// Add adds an element to a set, tagged with dot, returning the delta.
+func (s *State) Add(v json.RawMessage, dot Timestamp) (State, error) {
+	if s.Type != ORSet {
+		return State{}, fmt.Errorf("%w: add on %s", ErrWrongType, s.Type)
+	}
+	e, err := canonical(v)
+	if err != nil {
+		return State{}, err
+	}
+
+	delta := State{Type: ORSet, Set: &Set{Elements: map[string]Dots{e: {dot}}}}
+	*s = Merge(*s, delta)
+	return delta, nil
+}
+
+// Remove removes an element from a set, returning the delta. Only the adds seen here are undone.
+func (s *State) Remove(v json.RawMessage) (State, error) {
+	if s.Type != ORSet {
+		return State{}, fmt.Errorf("%w: remove on %s", ErrWrongType, s.Type)
+	}
+	e, err := canonical(v)
+	if err != nil {
+		return State{}, err
+	}
+	*s = s.fill()
+
+	delta := State{Type: ORSet, Set: &Set{Removed: slices.Clone(s.Set.Elements[e])}}
+	*s = Merge(*s, delta)
+	return delta, nil
+}
+
+// Put writes a field of a map, tagged with dot, returning the delta.
+func (s *State) Put(field string, v json.RawMessage, dot Timestamp) (State, error) {
+	if s.Type != ORMap {
+		return State{}, fmt.Errorf("%w: put on %s", ErrWrongType, s.Type)
+	}
+	if field == "" {
+		return State{}, fmt.Errorf("%w: field not provided", ErrInvalid)
+	}
+	c, err := canonical(v)
+	if err != nil {
+		return State{}, err
+	}
+
+	delta := State{Type: ORMap, Map: &Map{
+		Fields: map[string]Dots{field: {dot}},
+		Values: map[string]Register{field: {Value: json.RawMessage(c), Time: dot}},
+	}}
+	*s = Merge(*s, delta)
+	return delta, nil
+}
+
+// Delete removes a field of a map, returning the delta. Only the puts seen here are undone.
+func (s *State) Delete(field string) (State, error) {
+	if s.Type != ORMap {
+		return State{}, fmt.Errorf("%w: delete on %s", ErrWrongType, s.Type)
+	}
+	*s = s.fill()
+
+	delta := State{Type: ORMap, Map: &Map{Removed: slices.Clone(s.Map.Fields[field])}}
+	*s = Merge(*s, delta)
+	return delta, nil
+}
+
+// canonical re-encodes a JSON value so equal values have equal encodings, whatever their spacing or
+// the order of their object keys.
+func canonical(v json.RawMessage) (string, error) {
+	d := json.NewDecoder(bytes.NewReader(v))
+	d.UseNumber()
+	var x any
+	if err := d.Decode(&x); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	b, err := json.Marshal(x)
+	return string(b), err
+}
+
+func decode(v json.RawMessage) any {
+	if len(v) == 0 {
+		return nil
+	}
+	var x any
+	json.Unmarshal(v, &x)
+	return x
+}
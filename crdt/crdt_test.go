@@ -0,0 +1,314 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+const replicas = 3
+
+var types = []Type{LWW, GCounter, PNCounter, ORSet, ORMap}
+
+// step is one event of a history: a replica either updates its value or merges in another replica's.
+type step struct {
+	Replica int
+	Merge   bool
+	From    int  // Replica merged in
+	Arg     int  // Picks the element, field, value or amount of an update
+	Undo    bool // Remove or delete rather than add or put, decrement rather than increment
+	Tick    bool // Whether the wall clock moves before the step
+}
+
+// history is a random sequence of steps on replicas holding a value of one type.
+type history struct {
+	Type  Type
+	Steps []step
+}
+
+func (history) Generate(r *rand.Rand, size int) reflect.Value {
+	h := history{Type: types[r.Intn(len(types))]}
+	for range r.Intn(size*2 + 1) {
+		h.Steps = append(h.Steps, step{
+			Replica: r.Intn(replicas),
+			Merge:   r.Intn(4) == 0,
+			From:    r.Intn(replicas),
+			Arg:     r.Intn(4),
+			Undo:    r.Intn(3) == 0,
+			Tick:    r.Intn(2) == 0,
+		})
+	}
+	return reflect.ValueOf(h)
+}
+
+// run plays h and returns the state of every replica, and the deltas each one produced.
+func run(h history) (states [replicas]State, deltas [replicas][]State) {
+	// Replicas share a wall clock that sometimes stands still, so logical counters get exercised
+	wall := time.Unix(0, 0)
+	var clocks [replicas]*Clock
+	for i := range replicas {
+		states[i] = New(h.Type)
+		clocks[i] = &Clock{Node: fmt.Sprintf("n%d", i), Now: func() time.Time { return wall }}
+	}
+
+	for _, s := range h.Steps {
+		if s.Tick {
+			wall = wall.Add(time.Millisecond)
+		}
+		if s.Merge {
+			clocks[s.Replica].Observe(states[s.From].Latest())
+			states[s.Replica] = Merge(states[s.Replica], states[s.From])
+			continue
+		}
+
+		st := &states[s.Replica]
+		node := clocks[s.Replica].Node
+		value := json.RawMessage(fmt.Sprintf(`{"v":%d}`, s.Arg))
+		field := fmt.Sprintf("f%d", s.Arg)
+		var delta State
+		var err error
+		switch {
+		case h.Type == LWW:
+			delta, err = st.SetValue(value, clocks[s.Replica].Tick())
+		case h.Type == GCounter:
+			delta, err = st.Increment(node, int64(s.Arg+1))
+		case h.Type == PNCounter && s.Undo:
+			delta, err = st.Increment(node, -int64(s.Arg+1))
+		case h.Type == PNCounter:
+			delta, err = st.Increment(node, int64(s.Arg+1))
+		case h.Type == ORSet && s.Undo:
+			delta, err = st.Remove(value)
+		case h.Type == ORSet:
+			delta, err = st.Add(value, clocks[s.Replica].Tick())
+		case h.Type == ORMap && s.Undo:
+			delta, err = st.Delete(field)
+		case h.Type == ORMap:
+			delta, err = st.Put(field, value, clocks[s.Replica].Tick())
+		}
+		if err != nil {
+			panic(err)
+		}
+		deltas[s.Replica] = append(deltas[s.Replica], delta)
+	}
+	return states, deltas
+}
+
+func encode(s State) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func check(t *testing.T, f any) {
+	t.Helper()
+	if err := quick.Check(f, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMergeCommutative(t *testing.T) {
+	check(t, func(h history) bool {
+		s, _ := run(h)
+		return encode(Merge(s[0], s[1])) == encode(Merge(s[1], s[0]))
+	})
+}
+
+func TestMergeAssociative(t *testing.T) {
+	check(t, func(h history) bool {
+		s, _ := run(h)
+		return encode(Merge(Merge(s[0], s[1]), s[2])) == encode(Merge(s[0], Merge(s[1], s[2])))
+	})
+}
+
+func TestMergeIdempotent(t *testing.T) {
+	check(t, func(h history) bool {
+		s, _ := run(h)
+		once := Merge(s[0], s[1])
+		return encode(Merge(s[0], s[0])) == encode(Merge(s[0], New(h.Type))) &&
+			encode(Merge(once, s[1])) == encode(once)
+	})
+}
+
+// Replicas that have merged every other replica's state hold the same value, whichever order they
+// merged in.
+func TestConvergence(t *testing.T) {
+	check(t, func(h history) bool {
+		s, _ := run(h)
+		forward := Merge(Merge(s[0], s[1]), s[2])
+		backward := Merge(Merge(s[2], s[1]), s[0])
+		return encode(forward) == encode(backward) &&
+			reflect.DeepEqual(forward.Value(), backward.Value())
+	})
+}
+
+// Shipping only the deltas of each update ends in the same state as shipping whole states.
+func TestDeltas(t *testing.T) {
+	check(t, func(h history) bool {
+		s, deltas := run(h)
+		full := Merge(Merge(s[0], s[1]), s[2])
+
+		fromDeltas := New(h.Type)
+		for _, ds := range deltas {
+			for _, d := range ds {
+				fromDeltas = Merge(fromDeltas, d)
+			}
+		}
+		return encode(fromDeltas) == encode(full)
+	})
+}
+
+// A state survives a round trip through JSON, as it does between sites.
+func TestJSONRoundTrip(t *testing.T) {
+	check(t, func(h history) bool {
+		s, _ := run(h)
+		var decoded State
+		if err := json.Unmarshal([]byte(encode(s[0])), &decoded); err != nil {
+			return false
+		}
+		return encode(Merge(decoded, New(h.Type))) == encode(Merge(s[0], New(h.Type)))
+	})
+}
+
+// The count of a counter is the sum of every increment, however the replicas merged.
+func TestCounterValue(t *testing.T) {
+	check(t, func(h history) bool {
+		if h.Type != PNCounter && h.Type != GCounter {
+			h.Type = PNCounter
+		}
+		s, _ := run(h)
+
+		var want int64
+		for _, st := range h.Steps {
+			switch {
+			case st.Merge:
+			case st.Undo && h.Type == PNCounter:
+				want -= int64(st.Arg + 1)
+			default:
+				want += int64(st.Arg + 1)
+			}
+		}
+		return Merge(Merge(s[0], s[1]), s[2]).Value() == want
+	})
+}
+
+func TestORSetAddWins(t *testing.T) {
+	a, b := New(ORSet), New(ORSet)
+	clock := &Clock{Node: "a"}
+	x := json.RawMessage(`"x"`)
+
+	a.Add(x, clock.Tick())
+	b = Merge(b, a)
+
+	// b removes x while a adds it again without having seen the removal
+	b.Remove(x)
+	a.Add(x, clock.Tick())
+
+	if got := Merge(a, b).Value(); !reflect.DeepEqual(got, []any{"x"}) {
+		t.Errorf("concurrent add and remove = %v, want [x]", got)
+	}
+
+	// A remove that has seen every add wins
+	merged := Merge(a, b)
+	merged.Remove(x)
+	if got := Merge(merged, a).Value(); len(got.([]any)) != 0 {
+		t.Errorf("remove after every add = %v, want []", got)
+	}
+}
+
+func TestORMap(t *testing.T) {
+	a, b := New(ORMap), New(ORMap)
+	ca, cb := &Clock{Node: "a"}, &Clock{Node: "b"}
+
+	a.Put("name", json.RawMessage(`"ada"`), ca.Tick())
+	a.Put("age", json.RawMessage(`36`), ca.Tick())
+	b = Merge(b, a)
+
+	b.Delete("age")
+	cb.Observe(a.Latest())
+	b.Put("name", json.RawMessage(`"grace"`), cb.Tick())
+
+	got := Merge(a, b).Value()
+	want := map[string]any{"name": "grace"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Value() = %v, want %v", got, want)
+	}
+}
+
+func TestLWWLatestWins(t *testing.T) {
+	a, b := New(LWW), New(LWW)
+
+	// b's wall clock is behind, but it has seen a's write, so its own comes later
+	ca := &Clock{Node: "a", Now: func() time.Time { return time.Unix(100, 0) }}
+	cb := &Clock{Node: "b", Now: func() time.Time { return time.Unix(50, 0) }}
+
+	a.SetValue(json.RawMessage(`1`), ca.Tick())
+	b = Merge(b, a)
+	cb.Observe(b.Latest())
+	b.SetValue(json.RawMessage(`2`), cb.Tick())
+
+	if got := Merge(a, b).Value(); got != float64(2) {
+		t.Errorf("Value() = %v, want 2", got)
+	}
+}
+
+func TestMergeTypeConflict(t *testing.T) {
+	a, b := New(ORSet), New(GCounter)
+	if Merge(a, b).Type != GCounter || Merge(b, a).Type != GCounter {
+		t.Error("merging different types does not settle on the same one")
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	s := New(GCounter)
+	if _, err := s.Add(json.RawMessage(`1`), Timestamp{}); err == nil {
+		t.Error("Add() on a gcounter returned no error")
+	}
+	if _, err := s.Increment("a", -1); err == nil {
+		t.Error("decrementing a gcounter returned no error")
+	}
+}
+
+func TestParseType(t *testing.T) {
+	for _, typ := range types {
+		if got, err := ParseType(string(typ)); err != nil || got != typ {
+			t.Errorf("ParseType(%q) = %q, %v", typ, got, err)
+		}
+	}
+	if _, err := ParseType("list"); err == nil {
+		t.Error("ParseType(list) returned no error")
+	}
+}
+
+// Readings only go forward, and come after anything observed, whatever the wall clock does.
+func TestClock(t *testing.T) {
+	check(t, func(walls []int8, observed []int8) bool {
+		var wall time.Time
+		c := &Clock{Node: "a", Now: func() time.Time { return wall }}
+		var last Timestamp
+		for i, w := range walls {
+			wall = time.Unix(0, int64(w))
+			if i < len(observed) {
+				seen := Timestamp{Wall: int64(observed[i]), Logical: 3, Node: "b"}
+				c.Observe(seen)
+				if c.Tick().Compare(seen) <= 0 {
+					return false
+				}
+			}
+			now := c.Tick()
+			if now.Compare(last) <= 0 {
+				return false
+			}
+			last = now
+		}
+		return true
+	})
+
+	var ts Timestamp
+	want := Timestamp{Wall: 12, Logical: 3, Node: "site@1"}
+	if err := ts.UnmarshalText([]byte(want.String())); err != nil || ts != want {
+		t.Errorf("UnmarshalText(%s) = %v, %v", want, ts, err)
+	}
+}
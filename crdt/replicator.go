@@ -0,0 +1,250 @@
+package crdt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MergePath is where a site takes states from its peers, relative to the server root.
+	MergePath = "/kvs/crdt/merge"
+
+	// FlushInterval is how long updates are gathered before they are sent to the peers.
+	FlushInterval = 100 * time.Millisecond
+
+	// FullSyncInterval is how often every state is sent to every peer, repairing whatever deltas were lost.
+	FullSyncInterval = time.Minute
+)
+
+// Batch carries states from one site to another, by key. A batch with Reply set asks the receiving
+// site to answer with a batch of all its own states.
+type Batch struct {
+	From   string           `json:"from"`
+	States map[string]State `json:"states"`
+	Reply  bool             `json:"reply,omitempty"`
+}
+
+// Replicator sends the updates of a site to its peers. Deltas are merged per peer until they are
+// sent, so a peer that is unreachable for a while gets a single batch holding everything it missed.
+// Every state is also sent in full on start and every FullSyncInterval. The sync on start also takes
+// the states of the peers, so a restarted site gets back what it held before.
+type Replicator struct {
+	Self   string
+	Peers  []string     // Base URLs of the other sites
+	Client *http.Client // http.DefaultClient if nil
+
+	// States returns every local state, for full syncs.
+	States func() (map[string]State, error)
+
+	// Merge merges the states of a peer into the local ones.
+	Merge func(map[string]State) error
+
+	mu      sync.Mutex
+	pending map[string]map[string]State // By peer, then key
+	peers   map[string]*PeerStatus
+	kick    chan struct{}
+}
+
+// PeerStatus describes the replication to one peer.
+type PeerStatus struct {
+	Peer     string    `json:"peer"`
+	Pending  int       `json:"pending"` // Keys with updates not yet sent
+	Sent     uint64    `json:"sent"`    // States sent since start
+	LastSent time.Time `json:"last_sent,omitzero"`
+	LastFull time.Time `json:"last_full,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// NewReplicator returns a replicator from site self to peers.
+func NewReplicator(self string, peers []string) *Replicator {
+	r := &Replicator{
+		Self:    self,
+		Peers:   peers,
+		pending: make(map[string]map[string]State),
+		peers:   make(map[string]*PeerStatus),
+		kick:    make(chan struct{}, 1),
+	}
+	for _, p := range peers {
+		r.pending[p] = make(map[string]State)
+		r.peers[p] = &PeerStatus{Peer: p}
+	}
+	return r
+}
+
+// Publish queues the delta of an update to key for every peer. It is meant to be set as the store's
+// OnCRDTChange hook.
+func (r *Replicator) Publish(key string, delta State) {
+	r.mu.Lock()
+	for _, p := range r.Peers {
+		r.pending[p][key] = Merge(r.pending[p][key], delta)
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends queued deltas and full syncs to the peers until ctx ends.
+func (r *Replicator) Run(ctx context.Context) {
+	full := time.NewTicker(FullSyncInterval)
+	defer full.Stop()
+
+	r.fullSync(ctx, true)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-full.C:
+			r.fullSync(ctx, false)
+		case <-r.kick:
+			// Let updates made in quick succession share a batch
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(FlushInterval):
+			}
+			r.flush(ctx)
+		}
+	}
+}
+
+// flush sends each peer its queued deltas. Deltas a peer did not take stay queued for the next flush.
+func (r *Replicator) flush(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range r.Peers {
+		r.mu.Lock()
+		states := r.pending[p]
+		r.pending[p] = make(map[string]State)
+		r.mu.Unlock()
+		if len(states) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.send(ctx, p, states, false)
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if err != nil {
+				for k, s := range states {
+					r.pending[p][k] = Merge(r.pending[p][k], s)
+				}
+			}
+			r.record(p, len(states), err, false)
+		}()
+	}
+	wg.Wait()
+}
+
+// fullSync sends every local state to every peer, merging theirs in return if reply is set.
+func (r *Replicator) fullSync(ctx context.Context, reply bool) {
+	if r.States == nil {
+		return
+	}
+	states, err := r.States()
+	if err != nil {
+		log.Printf("CRDT Error: reading states: %s", err)
+		return
+	}
+	if len(states) == 0 && !reply {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range r.Peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			theirs, err := r.send(ctx, p, states, reply)
+			if err == nil && len(theirs) > 0 && r.Merge != nil {
+				if err = r.Merge(theirs); err == nil {
+					log.Printf("CRDT: merged %d keys from %s", len(theirs), p)
+				}
+			}
+			r.mu.Lock()
+			r.record(p, len(states), err, true)
+			r.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+// record notes the outcome of sending n states to peer. r.mu must be held.
+func (r *Replicator) record(peer string, n int, err error, full bool) {
+	st := r.peers[peer]
+	if err != nil {
+		st.Error = err.Error()
+		log.Printf("CRDT Error: sending %d states to %s: %s", n, peer, err)
+		return
+	}
+	now := time.Now()
+	st.Error = ""
+	st.Sent += uint64(n)
+	st.LastSent = now
+	if full {
+		st.LastFull = now
+	}
+}
+
+// send posts states to peer and returns the peer's own states if reply is set.
+func (r *Replicator) send(ctx context.Context, peer string, states map[string]State, reply bool) (map[string]State, error) {
+	body, err := json.Marshal(Batch{From: r.Self, States: states, Reply: reply})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(peer, "/")+MergePath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s answered %s: %s", peer, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if !reply {
+		return nil, nil
+	}
+
+	var theirs Batch
+	if err := json.NewDecoder(resp.Body).Decode(&theirs); err != nil {
+		return nil, fmt.Errorf("decoding states from %s: %w", peer, err)
+	}
+	return theirs.States, nil
+}
+
+// Status returns the state of replication to every peer.
+func (r *Replicator) Status() []PeerStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make([]PeerStatus, 0, len(r.Peers))
+	for _, p := range r.Peers {
+		st := *r.peers[p]
+		st.Pending = len(r.pending[p])
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
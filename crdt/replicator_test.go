@@ -0,0 +1,118 @@
+package crdt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// peer is a site that merges every batch it receives, or fails them all while down.
+type peer struct {
+	mu      sync.Mutex
+	down    bool
+	batches int
+	states  map[string]State
+}
+
+func servePeer(t *testing.T, p *peer) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if r.URL.Path != MergePath || p.down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var b Batch
+		json.NewDecoder(r.Body).Decode(&b)
+		p.batches++
+		for k, s := range b.States {
+			p.states[k] = Merge(p.states[k], s)
+		}
+		if b.Reply {
+			json.NewEncoder(w).Encode(Batch{States: p.states})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (p *peer) value(key string) any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.states[key].Value()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestReplicator(t *testing.T) {
+	// The peer that is up holds increments made on this site before it restarted
+	before := New(PNCounter)
+	before.Increment("a", 10)
+	up := &peer{states: map[string]State{"visits": before}}
+	down := &peer{states: make(map[string]State), down: true}
+	upSrv, downSrv := servePeer(t, up), servePeer(t, down)
+
+	local := New(PNCounter)
+	r := NewReplicator("a", []string{upSrv.URL, downSrv.URL})
+	var mu sync.Mutex
+	r.States = func() (map[string]State, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return map[string]State{"visits": local.Clone()}, nil
+	}
+	r.Merge = func(states map[string]State) error {
+		mu.Lock()
+		defer mu.Unlock()
+		local = Merge(local, states["visits"])
+		return nil
+	}
+	increment := func(by int64) {
+		mu.Lock()
+		delta, _ := local.Increment("a", by)
+		mu.Unlock()
+		r.Publish("visits", delta)
+	}
+
+	increment(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// The full sync on start brings back the increments made before the restart, later updates go as
+	// deltas
+	waitFor(t, "the full sync", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return local.Value() == int64(10)
+	})
+	increment(2)
+	increment(-1)
+	waitFor(t, "the deltas", func() bool { return up.value("visits") == int64(11) })
+
+	// Deltas for a peer that is down stay queued, merged into one state per key
+	waitFor(t, "the failed flush", func() bool {
+		st := r.Status()
+		return st[0].Error == "" && st[1].Error != "" && st[1].Pending == 1
+	})
+
+	down.mu.Lock()
+	down.down = false
+	down.mu.Unlock()
+	increment(4)
+	waitFor(t, "the queued deltas", func() bool { return down.value("visits") == int64(15) })
+	if st := r.Status(); st[1].Error != "" || st[1].Pending != 0 {
+		t.Errorf("Status() after recovery = %+v", st[1])
+	}
+}
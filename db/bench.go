@@ -0,0 +1,383 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kvstore/client"
+	"kvstore/transfer"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Defaults for the zero fields of a Config.
+const (
+	DefaultWorkers   = 16
+	DefaultKeys      = 10000
+	DefaultKeyPrefix = "bench:"
+	DefaultValueSize = 100
+	DefaultDuration  = 10 * time.Second
+)
+
+// Config describes a load or benchmark run against a server.
+type Config struct {
+	Server    string   // Base URL of the server, e.g. http://localhost:8080
+	Workers   int      // Concurrent clients, each with one request in flight at a time
+	Keys      int      // Size of the key space the workload runs over
+	KeyPrefix string   // Prefix of every key, followed by the key number
+	ValueSize SizeDist // Sizes of the values written, in bytes
+
+	Workload   Workload
+	Duration   time.Duration // How long Run runs for
+	Operations int           // Operations after which Run stops, if not zero, even if Duration has not passed
+	// Rate is the operations per second to aim for, over all workers, or 0 for as fast as they go.
+	// Latencies are then measured from when each operation was due rather than when it was sent, so a
+	// server that falls behind shows it in its latencies rather than being sent fewer requests.
+	Rate float64
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.Server == "" {
+		cfg.Server = "http://localhost:8080"
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.Keys <= 0 {
+		cfg.Keys = DefaultKeys
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = DefaultKeyPrefix
+	}
+	if cfg.ValueSize == (SizeDist{}) {
+		cfg.ValueSize = SizeDist{Min: DefaultValueSize, Max: DefaultValueSize}
+	}
+	if cfg.Workload.Mix == nil {
+		cfg.Workload = Workloads["a"]
+	}
+	if cfg.Duration <= 0 && cfg.Operations <= 0 {
+		cfg.Duration = DefaultDuration
+	}
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	Workload   string           `json:"workload"`
+	Mix        string           `json:"mix"`
+	Server     string           `json:"server"`
+	Workers    int              `json:"workers"`
+	Keys       int              `json:"keys"`
+	ValueSize  string           `json:"value_size"`
+	TargetRate float64          `json:"target_rate,omitempty"`
+	Started    time.Time        `json:"started"`
+	Elapsed    time.Duration    `json:"-"`
+	Seconds    float64          `json:"seconds"`
+	Operations uint64           `json:"operations"`
+	Errors     uint64           `json:"errors"`
+	Throughput float64          `json:"throughput"` // Operations per second
+	Latency    Latency          `json:"latency"`    // Of every operation
+	Ops        map[Op]*OpResult `json:"ops"`
+
+	all *Histogram
+}
+
+// OpResult is the outcome of the operations of one kind.
+type OpResult struct {
+	Operations uint64            `json:"operations"`
+	Errors     uint64            `json:"errors"`
+	ErrorKinds map[string]uint64 `json:"error_kinds,omitempty"` // Errors by HTTP status or cause
+	Latency    Latency           `json:"latency"`
+
+	hist *Histogram
+}
+
+// Latency summarizes a Histogram, in milliseconds.
+type Latency struct {
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P95  float64 `json:"p95_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p999_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+func summarize(h *Histogram) Latency {
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	return Latency{
+		Mean: ms(h.Mean()),
+		P50:  ms(h.Quantile(0.5)),
+		P95:  ms(h.Quantile(0.95)),
+		P99:  ms(h.Quantile(0.99)),
+		P999: ms(h.Quantile(0.999)),
+		Max:  ms(h.Max()),
+	}
+}
+
+// Run runs cfg.Workload against the server until cfg.Duration has passed, cfg.Operations have been
+// done or ctx is done. The key space should have been loaded with Seed first, or reads fail.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	cfg.setDefaults()
+	inserted := new(atomic.Uint64)
+	keys := newKeyChooser(cfg.Workload.Distribution, cfg.Keys, inserted)
+	return run(ctx, cfg, func(w *worker, _ uint64) (Op, error) {
+		op := cfg.Workload.pick(w.rng.Float64())
+		var err error
+		switch op {
+		case OpRead:
+			_, err = w.c.Get(w.ctx, w.key(keys.next(w.rng)))
+		case OpUpdate:
+			err = w.c.Update(w.ctx, w.key(keys.next(w.rng)), w.value())
+		case OpInsert:
+			// Inserted keys are upserted, so that runs can be repeated over the same key space
+			n := uint64(cfg.Keys) + w.nextInsert.Add(1) - 1
+			if err = w.c.Upsert(w.ctx, w.key(n), w.value()); err == nil {
+				inserted.Add(1)
+			}
+		case OpScan:
+			// Dropping the last digit of a key leaves the prefix of it and up to nine neighbours
+			key := w.key(keys.next(w.rng))
+			err = w.c.Export(w.ctx, io.Discard, transfer.NDJSON, key[:len(key)-1])
+		case OpReadModifyWrite:
+			key := w.key(keys.next(w.rng))
+			if _, err = w.c.Get(w.ctx, key); err == nil {
+				err = w.c.Update(w.ctx, key, w.value())
+			}
+		}
+		return op, err
+	})
+}
+
+// operation does the operation numbered n of a run and returns its kind.
+type operation func(w *worker, n uint64) (Op, error)
+
+// worker is a client issuing operations one at a time.
+type worker struct {
+	cfg        *Config
+	ctx        context.Context
+	c          *client.Client
+	rng        *rand.Rand
+	sizes      *sizer
+	letters    []byte
+	width      int // Digits of key numbers, so that keys sort in number order
+	nextInsert *atomic.Uint64
+	ops        map[Op]*OpResult
+}
+
+// key returns the name of key number n.
+func (w *worker) key(n uint64) string {
+	s := strconv.FormatUint(n, 10)
+	if pad := w.width - len(s); pad > 0 {
+		s = strings.Repeat("0", pad) + s
+	}
+	return w.cfg.KeyPrefix + s
+}
+
+// value returns a JSON string of random letters with a size drawn from the value size distribution.
+func (w *worker) value() json.RawMessage {
+	size := w.sizes.next(w.rng)
+	start := w.rng.IntN(len(w.letters) - size + 1)
+	b := make([]byte, 0, size+2)
+	b = append(b, '"')
+	b = append(b, w.letters[start:start+size]...)
+	return append(b, '"')
+}
+
+func (w *worker) record(op Op, latency time.Duration, err error) {
+	r := w.ops[op]
+	if r == nil {
+		r = &OpResult{ErrorKinds: map[string]uint64{}, hist: new(Histogram)}
+		w.ops[op] = r
+	}
+	r.Operations++
+	r.hist.Record(latency)
+	if err != nil {
+		r.Errors++
+		r.ErrorKinds[errorKind(err)]++
+	}
+}
+
+// errorKind names the kind of err for the report: the HTTP status, or the cause of a failure to get one.
+func errorKind(err error) string {
+	var ce *client.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &ce):
+		return fmt.Sprintf("%d %s", ce.StatusCode, http.StatusText(ce.StatusCode))
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network error"
+	}
+	return "other"
+}
+
+func run(ctx context.Context, cfg Config, do operation) (*Result, error) {
+	if cfg.Rate < 0 {
+		return nil, fmt.Errorf("invalid rate %g", cfg.Rate)
+	}
+	// A client of its own, so that every worker can keep a connection, and without retries, which
+	// would hide errors and count several requests as one operation
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.Workers
+	c := client.New(cfg.Server)
+	c.HTTP = &http.Client{Transport: transport}
+	c.Retries = 0
+	defer transport.CloseIdleConnections()
+
+	if _, err := c.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("server not reachable: %w", err)
+	}
+
+	runCtx := ctx
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	sizes := newSizer(cfg.ValueSize)
+	letters := make([]byte, max(cfg.ValueSize.Max, 1)*2)
+	for i := range letters {
+		letters[i] = byte('a' + rand.IntN(26))
+	}
+	width := len(strconv.Itoa(max(cfg.Keys-1, 0)))
+	var tickets, inserts atomic.Uint64
+	var interval time.Duration
+	if cfg.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.Rate)
+	}
+
+	start := time.Now()
+	workers := make([]*worker, cfg.Workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		w := &worker{
+			cfg: &cfg, ctx: runCtx, c: c, rng: rand.New(rand.NewPCG(rand.Uint64(), uint64(i))),
+			sizes: sizes, letters: letters, width: width, nextInsert: &inserts, ops: map[Op]*OpResult{},
+		}
+		workers[i] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for runCtx.Err() == nil {
+				n := tickets.Add(1) - 1
+				if cfg.Operations > 0 && n >= uint64(cfg.Operations) {
+					return
+				}
+
+				// Paced operations are timed from when they were due
+				began := time.Now()
+				if interval > 0 {
+					began = start.Add(time.Duration(n) * interval)
+					if wait := time.Until(began); wait > 0 {
+						select {
+						case <-time.After(wait):
+						case <-runCtx.Done():
+							return
+						}
+					}
+				}
+
+				op, err := do(w, n)
+				if err != nil && runCtx.Err() != nil {
+					// Cut short by the end of the run rather than failed
+					return
+				}
+				w.record(op, time.Since(began), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := &Result{
+		Workload:   cfg.Workload.Name,
+		Mix:        cfg.Workload.String(),
+		Server:     cfg.Server,
+		Workers:    cfg.Workers,
+		Keys:       cfg.Keys,
+		ValueSize:  cfg.ValueSize.String(),
+		TargetRate: cfg.Rate,
+		Started:    start,
+		Elapsed:    time.Since(start),
+		Ops:        map[Op]*OpResult{},
+		all:        new(Histogram),
+	}
+	for _, w := range workers {
+		for op, r := range w.ops {
+			total := res.Ops[op]
+			if total == nil {
+				total = &OpResult{ErrorKinds: map[string]uint64{}, hist: new(Histogram)}
+				res.Ops[op] = total
+			}
+			total.Operations += r.Operations
+			total.Errors += r.Errors
+			for kind, n := range r.ErrorKinds {
+				total.ErrorKinds[kind] += n
+			}
+			total.hist.Merge(r.hist)
+		}
+	}
+	for _, r := range res.Ops {
+		r.Latency = summarize(r.hist)
+		res.all.Merge(r.hist)
+		res.Operations += r.Operations
+		res.Errors += r.Errors
+	}
+	res.Latency = summarize(res.all)
+	res.Seconds = res.Elapsed.Seconds()
+	if res.Seconds > 0 {
+		res.Throughput = float64(res.Operations) / res.Seconds
+	}
+	return res, ctx.Err()
+}
+
+// WriteText writes the result as a report for people.
+func (r *Result) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Workload %s (%s) against %s\n", r.Workload, r.Mix, r.Server)
+	fmt.Fprintf(w, "%d workers, %d keys, values of %s bytes", r.Workers, r.Keys, r.ValueSize)
+	if r.TargetRate > 0 {
+		fmt.Fprintf(w, ", target %g ops/s", r.TargetRate)
+	}
+	fmt.Fprintf(w, "\n%d operations in %s: %.1f ops/s, %d errors\n\nLatencies in milliseconds:\n",
+		r.Operations, r.Elapsed.Round(time.Millisecond), r.Throughput, r.Errors)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OP\tCOUNT\tERRORS\tMEAN\tP50\tP95\tP99\tP99.9\tMAX\t")
+	row := func(name string, count, errs uint64, l Latency) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t\n",
+			name, count, errs, l.Mean, l.P50, l.P95, l.P99, l.P999, l.Max)
+	}
+	for _, op := range sortedOps(r.Ops) {
+		o := r.Ops[op]
+		row(string(op), o.Operations, o.Errors, o.Latency)
+	}
+	row("all", r.Operations, r.Errors, r.Latency)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, op := range sortedOps(r.Ops) {
+		for kind, n := range r.Ops[op].ErrorKinds {
+			fmt.Fprintf(w, "%s errors: %d %s\n", op, n, kind)
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the result as JSON, for tools to read.
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
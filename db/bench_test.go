@@ -0,0 +1,199 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"kvstore/channels"
+	kvhttp "kvstore/http"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var server *httptest.Server
+
+// TestMain serves the real handlers, backed by the global store and request loop.
+func TestMain(m *testing.M) {
+	go channels.Requests()
+
+	mux := http.NewServeMux()
+	for path, h := range map[string]http.HandlerFunc{
+		"/ping": kvhttp.Ping, "/get": kvhttp.Get, "/update": kvhttp.Update,
+		"/upsert": kvhttp.Upsert, "/export": kvhttp.Export,
+	} {
+		mux.HandleFunc("/kvs"+path, h)
+	}
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	os.Exit(m.Run())
+}
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	for q, want := range map[float64]time.Duration{0.5: 5 * time.Millisecond, 0.99: 9900 * time.Microsecond, 1: 10 * time.Millisecond} {
+		got := h.Quantile(q)
+		if diff := got - want; diff < -want/64 || diff > want/64 {
+			t.Errorf("Quantile(%v) = %v, want %v within 1/64", q, got, want)
+		}
+	}
+	if h.Max() != 10*time.Millisecond || h.Count() != 10000 {
+		t.Errorf("Max() = %v, Count() = %d", h.Max(), h.Count())
+	}
+
+	var merged Histogram
+	merged.Record(time.Second)
+	merged.Merge(&h)
+	if merged.Count() != 10001 || merged.Max() != time.Second {
+		t.Errorf("after Merge, Count() = %d, Max() = %v", merged.Count(), merged.Max())
+	}
+
+	for v := uint64(0); v < 1<<20; v += 7 {
+		if i := bucket(v); bucketMax(i) < v || (i > 0 && bucketMax(i-1) >= v) {
+			t.Fatalf("%d falls in bucket %d, which ends at %d", v, i, bucketMax(i))
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	w, err := ParseWorkload("read=3,insert=1,dist=latest")
+	if err != nil || w.Mix[OpRead] != 0.75 || w.Mix[OpInsert] != 0.25 || w.Distribution != Latest {
+		t.Errorf("ParseWorkload() = %+v, %v", w, err)
+	}
+	if w.String() != "read 75%, insert 25%, latest" {
+		t.Errorf("String() = %q", w.String())
+	}
+	for _, bad := range []string{"g", "read=x", "delete=1", "read=0", "dist=normal"} {
+		if _, err := ParseWorkload(bad); err == nil {
+			t.Errorf("ParseWorkload(%q) succeeded", bad)
+		}
+	}
+
+	for s, want := range map[string]SizeDist{
+		"100":            {Min: 100, Max: 100},
+		"uniform:10-20":  {Uniform, 10, 20},
+		"zipfian:1-1000": {Zipfian, 1, 1000},
+	} {
+		if got, err := ParseSizeDist(s); err != nil || got != want {
+			t.Errorf("ParseSizeDist(%q) = %+v, %v, want %+v", s, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "x", "uniform:20-10", "normal:1-2"} {
+		if _, err := ParseSizeDist(bad); err == nil {
+			t.Errorf("ParseSizeDist(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	const n, draws = 1000, 100000
+
+	counts := make([]int, n)
+	z := newZipfian(n)
+	for range draws {
+		counts[z.next(rng)]++
+	}
+	// With a skew of 0.99 the first 1% of the numbers get about 40% of the draws
+	top := 0
+	for _, c := range counts[:n/100] {
+		top += c
+	}
+	if top < draws/3 || counts[0] < counts[n/2]*50 {
+		t.Errorf("zipfian: first 1%% drawn %d of %d times, 0 drawn %d times", top, draws, counts[0])
+	}
+
+	var inserted atomic.Uint64
+	inserted.Store(50)
+	latest := newKeyChooser(Latest, n, &inserted)
+	recent := 0
+	for range draws {
+		k := latest.next(rng)
+		if k >= n+50 {
+			t.Fatalf("latest drew %d, past the last key", k)
+		}
+		if k >= n+40 {
+			recent++
+		}
+	}
+	if recent < draws/3 {
+		t.Errorf("latest: the last 10 keys drawn %d of %d times", recent, draws)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{
+		Server:    server.URL,
+		Workers:   4,
+		Keys:      100,
+		KeyPrefix: "bench-test:",
+		ValueSize: SizeDist{Zipfian, 10, 100},
+	}
+	res, err := Seed(ctx, cfg)
+	if err != nil || res.Operations != 100 || res.Errors != 0 {
+		t.Fatalf("Seed() = %+v, %v, want 100 inserts", res, err)
+	}
+
+	for name, w := range Workloads {
+		cfg.Workload, cfg.Operations = w, 200
+		res, err := Run(ctx, cfg)
+		if err != nil {
+			t.Fatalf("Run(%s) = %v", name, err)
+		}
+		if res.Operations != 200 || res.Errors != 0 {
+			t.Errorf("Run(%s) did %d operations with %d errors: %+v", name, res.Operations, res.Errors, res.Ops)
+		}
+		for op := range w.Mix {
+			if res.Ops[op] == nil || res.Ops[op].Operations == 0 || res.Ops[op].Latency.Max <= 0 {
+				t.Errorf("Run(%s) has no %s latencies", name, op)
+			}
+		}
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	cfg := Config{Server: server.URL, Workers: 2, Keys: 10, KeyPrefix: "bench-report:", Workload: Workloads["c"]}
+	Seed(context.Background(), cfg)
+
+	// Paced at 200 ops/s, a quarter of a second holds about 50 operations
+	cfg.Rate, cfg.Duration = 200, 250*time.Millisecond
+	res, err := Run(context.Background(), cfg)
+	if err != nil || res.Operations < 40 || res.Operations > 52 {
+		t.Errorf("Run() at 200 ops/s for 250ms = %d operations, %v", res.Operations, err)
+	}
+
+	var text bytes.Buffer
+	res.WriteText(&text)
+	if !strings.Contains(text.String(), "P99") || !strings.Contains(text.String(), "read") {
+		t.Errorf("text report lacks the latency table:\n%s", text.String())
+	}
+
+	var js bytes.Buffer
+	res.WriteJSON(&js)
+	var decoded struct {
+		Operations uint64 `json:"operations"`
+		Ops        map[string]struct {
+			Latency struct {
+				P99 float64 `json:"p99_ms"`
+			} `json:"latency"`
+		} `json:"ops"`
+	}
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil || decoded.Operations != res.Operations || decoded.Ops["read"].Latency.P99 <= 0 {
+		t.Errorf("JSON report = %s, %v", js.String(), err)
+	}
+
+	cfg.Server = "http://127.0.0.1:1"
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Error("Run() against no server succeeded")
+	}
+}
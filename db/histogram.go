@@ -0,0 +1,93 @@
+package db
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBucketBits sets the precision of a Histogram: each power of two is split into
+// 2^(subBucketBits-1) buckets, so a recorded latency is off by less than 1/64 of itself.
+const subBucketBits = 7
+
+const halfSubBuckets = 1 << (subBucketBits - 1)
+
+// Histogram counts latencies in buckets whose width grows with the latency, which keeps its size
+// fixed however many latencies are recorded. The zero value is an empty histogram.
+type Histogram struct {
+	counts [64 * halfSubBuckets]uint64
+	n      uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// bucket returns the bucket of a latency of v nanoseconds. Values below 2^subBucketBits have a bucket
+// each; above that, v is kept to its top subBucketBits bits.
+func bucket(v uint64) int {
+	shift := max(bits.Len64(v)-subBucketBits, 0)
+	return shift*halfSubBuckets + int(v>>shift)
+}
+
+// bucketMax returns the largest value that falls in bucket i.
+func bucketMax(i int) uint64 {
+	if i < 2*halfSubBuckets {
+		return uint64(i)
+	}
+	shift := i/halfSubBuckets - 1
+	mantissa := uint64(i - shift*halfSubBuckets)
+	return (mantissa+1)<<shift - 1
+}
+
+// Record adds a latency.
+func (h *Histogram) Record(d time.Duration) {
+	d = max(d, 0)
+	h.counts[bucket(uint64(d))]++
+	h.n++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+// Merge adds the latencies recorded in o.
+func (h *Histogram) Merge(o *Histogram) {
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.n += o.n
+	h.sum += o.sum
+	h.max = max(h.max, o.max)
+}
+
+// Count returns the number of latencies recorded.
+func (h *Histogram) Count() uint64 {
+	return h.n
+}
+
+// Mean returns the mean latency.
+func (h *Histogram) Mean() time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.n)
+}
+
+// Max returns the largest latency recorded.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Quantile returns the latency that q of the recorded latencies are at or below, e.g. the median for
+// q 0.5, to the precision of the buckets.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.n)))
+	rank = min(max(rank, 1), h.n)
+	var seen uint64
+	for i, c := range h.counts {
+		if seen += c; seen >= rank {
+			return min(time.Duration(bucketMax(i)), h.max)
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Op is a kind of benchmark operation.
+type Op string
+
+const (
+	OpRead            Op = "read"              // Get a key
+	OpUpdate          Op = "update"            // Update a key with a new value
+	OpInsert          Op = "insert"            // Write a key past the end of the key space
+	OpScan            Op = "scan"              // Export the keys sharing a prefix, up to ten of them
+	OpReadModifyWrite Op = "read-modify-write" // Get a key, then update it
+)
+
+// ops lists every Op, in report order.
+var ops = []Op{OpRead, OpUpdate, OpInsert, OpScan, OpReadModifyWrite}
+
+// Distribution picks which keys operations go to.
+type Distribution string
+
+const (
+	Uniform Distribution = "uniform" // Every key alike
+	Zipfian Distribution = "zipfian" // A few popular keys get most of the operations
+	Latest  Distribution = "latest"  // The most recently inserted keys are the most popular
+)
+
+// Workload is a mix of operations: each is picked with its proportion of the total.
+type Workload struct {
+	Name         string
+	Mix          map[Op]float64
+	Distribution Distribution
+}
+
+// Workloads are the core workloads of the Yahoo! Cloud Serving Benchmark (YCSB).
+var Workloads = map[string]Workload{
+	"a": {"a", map[Op]float64{OpRead: 0.5, OpUpdate: 0.5}, Zipfian},          // Update heavy, e.g. a session store
+	"b": {"b", map[Op]float64{OpRead: 0.95, OpUpdate: 0.05}, Zipfian},        // Read mostly, e.g. photo tagging
+	"c": {"c", map[Op]float64{OpRead: 1}, Zipfian},                           // Read only, e.g. a profile cache
+	"d": {"d", map[Op]float64{OpRead: 0.95, OpInsert: 0.05}, Latest},         // Read latest, e.g. status updates
+	"e": {"e", map[Op]float64{OpScan: 0.95, OpInsert: 0.05}, Zipfian},        // Short ranges, e.g. threaded conversations
+	"f": {"f", map[Op]float64{OpRead: 0.5, OpReadModifyWrite: 0.5}, Zipfian}, // Read-modify-write, e.g. a user database
+}
+
+// ParseWorkload returns the YCSB workload named s, a to f, or a custom mix such as
+// "read=0.7,update=0.2,insert=0.1" with an optional "dist=uniform|zipfian|latest" (zipfian by default).
+func ParseWorkload(s string) (Workload, error) {
+	if w, ok := Workloads[strings.ToLower(s)]; ok {
+		return w, nil
+	}
+
+	w := Workload{Name: s, Mix: map[Op]float64{}, Distribution: Zipfian}
+	total := 0.0
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return Workload{}, fmt.Errorf("invalid workload %q: want a to f or op=proportion pairs", s)
+		}
+		if name == "dist" {
+			switch d := Distribution(value); d {
+			case Uniform, Zipfian, Latest:
+				w.Distribution = d
+			default:
+				return Workload{}, fmt.Errorf("invalid distribution %q: want uniform, zipfian or latest", value)
+			}
+			continue
+		}
+		op := Op(name)
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 || !slices.Contains(ops, op) {
+			return Workload{}, fmt.Errorf("invalid workload entry %q: want %s=proportion", part, opNames())
+		}
+		w.Mix[op] += p
+		total += p
+	}
+	if total == 0 {
+		return Workload{}, fmt.Errorf("invalid workload %q: no operations", s)
+	}
+	for op := range w.Mix {
+		w.Mix[op] /= total
+	}
+	return w, nil
+}
+
+func opNames() string {
+	names := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = string(op)
+	}
+	return strings.Join(names, "|")
+}
+
+// String returns the mix, e.g. "read 95%, update 5%, zipfian".
+func (w Workload) String() string {
+	var parts []string
+	for _, op := range ops {
+		if p := w.Mix[op]; p > 0 {
+			parts = append(parts, fmt.Sprintf("%s %g%%", op, math.Round(p*1000)/10))
+		}
+	}
+	return strings.Join(append(parts, string(w.Distribution)), ", ")
+}
+
+// pick returns the operation for u, uniform in [0, 1).
+func (w Workload) pick(u float64) Op {
+	var last Op
+	for _, op := range ops {
+		p := w.Mix[op]
+		if p == 0 {
+			continue
+		}
+		if u < p {
+			return op
+		}
+		u -= p
+		last = op
+	}
+	// Rounding can leave u just above the last proportion
+	return last
+}
+
+// SizeDist is a distribution of value sizes in bytes.
+type SizeDist struct {
+	Distribution Distribution // Uniform or Zipfian; a constant size if empty
+	Min, Max     int
+}
+
+// ParseSizeDist parses a constant size such as "100", or a distribution of sizes such as
+// "uniform:10-1000" or "zipfian:10-1000", where small sizes are the most common.
+func ParseSizeDist(s string) (SizeDist, error) {
+	kind, bounds, ok := strings.Cut(s, ":")
+	if !ok {
+		kind, bounds = "constant", s
+	}
+	lo, hi, ok := strings.Cut(bounds, "-")
+	if !ok {
+		hi = lo
+	}
+	smallest, err1 := strconv.Atoi(lo)
+	largest, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil || smallest < 0 || largest < smallest {
+		return SizeDist{}, fmt.Errorf("invalid value size %q: want n, uniform:min-max or zipfian:min-max", s)
+	}
+
+	switch kind {
+	case "constant":
+		return SizeDist{Min: smallest, Max: smallest}, nil
+	case string(Uniform), string(Zipfian):
+		return SizeDist{Distribution: Distribution(kind), Min: smallest, Max: largest}, nil
+	}
+	return SizeDist{}, fmt.Errorf("invalid value size distribution %q: want uniform or zipfian", kind)
+}
+
+func (d SizeDist) String() string {
+	if d.Distribution == "" || d.Min == d.Max {
+		return strconv.Itoa(d.Min)
+	}
+	return fmt.Sprintf("%s:%d-%d", d.Distribution, d.Min, d.Max)
+}
+
+// sizer draws value sizes from a SizeDist.
+type sizer struct {
+	dist SizeDist
+	zipf *zipfian
+}
+
+func newSizer(d SizeDist) *sizer {
+	s := &sizer{dist: d}
+	if d.Distribution == Zipfian {
+		s.zipf = newZipfian(uint64(d.Max - d.Min + 1))
+	}
+	return s
+}
+
+func (s *sizer) next(rng *rand.Rand) int {
+	switch {
+	case s.dist.Max == s.dist.Min:
+		return s.dist.Min
+	case s.zipf != nil:
+		return s.dist.Min + int(s.zipf.next(rng))
+	}
+	return s.dist.Min + rng.IntN(s.dist.Max-s.dist.Min+1)
+}
+
+// zipfianConstant is the skew of the Zipfian distribution YCSB uses.
+const zipfianConstant = 0.99
+
+// zipfian draws numbers in [0, n) from a Zipfian distribution, with 0 the most likely, using the
+// algorithm of Gray et al., "Quickly Generating Billion-Record Synthetic Databases", as YCSB does.
+// It is read-only once built, so workers share it with their own sources of randomness.
+type zipfian struct {
+	n                 uint64
+	alpha, zetan, eta float64
+	half              float64 // 1 + 0.5^theta, the bound under which 1 is drawn
+}
+
+func newZipfian(n uint64) *zipfian {
+	theta := zipfianConstant
+	zetan := zeta(n, theta)
+	return &zipfian{
+		n:     n,
+		alpha: 1 / (1 - theta),
+		zetan: zetan,
+		eta:   (1 - math.Pow(2/float64(n), 1-theta)) / (1 - zeta(2, theta)/zetan),
+		half:  1 + math.Pow(0.5, theta),
+	}
+}
+
+func zeta(n uint64, theta float64) float64 {
+	sum := 0.0
+	for i := uint64(1); i <= n; i++ {
+		sum += 1 / math.Pow(float64(i), theta)
+	}
+	return sum
+}
+
+func (z *zipfian) next(rng *rand.Rand) uint64 {
+	if z.n <= 1 {
+		return 0
+	}
+	u := rng.Float64()
+	uz := u * z.zetan
+	switch {
+	case uz < 1:
+		return 0
+	case uz < z.half:
+		return 1
+	}
+	return min(uint64(float64(z.n)*math.Pow(z.eta*u-z.eta+1, z.alpha)), z.n-1)
+}
+
+// keyChooser picks the keys operations go to, by number, according to a Distribution.
+type keyChooser struct {
+	dist     Distribution
+	keys     uint64         // Keys loaded before the run
+	inserted *atomic.Uint64 // Keys inserted since, by every worker
+	zipf     *zipfian
+}
+
+func newKeyChooser(dist Distribution, keys int, inserted *atomic.Uint64) *keyChooser {
+	k := &keyChooser{dist: dist, keys: uint64(keys), inserted: inserted}
+	if dist != Uniform {
+		k.zipf = newZipfian(uint64(keys))
+	}
+	return k
+}
+
+func (k *keyChooser) next(rng *rand.Rand) uint64 {
+	switch k.dist {
+	case Uniform:
+		return rng.Uint64N(k.keys)
+	case Latest:
+		last := k.keys + k.inserted.Load() - 1
+		return last - min(k.zipf.next(rng), last)
+	}
+	// Scrambled, so the popular keys are spread over the key space rather than all at its start
+	return scramble(k.zipf.next(rng)) % k.keys
+}
+
+func scramble(n uint64) uint64 {
+	h := fnv.New64a()
+	var b [8]byte
+	for i := range b {
+		b[i] = byte(n >> (8 * i))
+	}
+	h.Write(b[:])
+	return h.Sum64()
+}
+
+// sortedOps returns the operations of m in report order.
+func sortedOps[V any](m map[Op]V) []Op {
+	var out []Op
+	for _, op := range ops {
+		if _, ok := m[op]; ok {
+			out = append(out, op)
+		}
+	}
+	return out
+}
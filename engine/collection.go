@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"kvstore/store"
+)
+
+// Collection is a view of the keys of a DB under a prefix as values of type T, e.g. every "user:" key
+// as a User. Keys are given without the prefix. Values are decoded without holding the store, and a
+// value that does not decode into T fails with a *store.DecodeError.
+type Collection[T any] struct {
+	db     *DB
+	prefix string
+	codec  store.Codec[T]
+}
+
+// NewCollection returns the view of the keys of db under prefix, encoded with codec, or as JSON if nil.
+func NewCollection[T any](db *DB, prefix string, codec store.Codec[T]) *Collection[T] {
+	if codec == nil {
+		codec = store.JSONCodec[T]{}
+	}
+	return &Collection[T]{db: db, prefix: prefix, codec: codec}
+}
+
+// Get returns the value of id.
+func (c *Collection[T]) Get(ctx context.Context, id string) (T, error) {
+	value, err := c.db.Get(ctx, c.prefix+id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return store.Decode(c.codec, c.prefix+id, value)
+}
+
+// Add stores v under a new id. It fails with helpers.DuplicateKeyError if id exists.
+func (c *Collection[T]) Add(ctx context.Context, id string, v T) error {
+	return c.write(ctx, id, v, c.db.Add)
+}
+
+// Update replaces the value of an existing id. It fails with helpers.NotExistError if there is none.
+func (c *Collection[T]) Update(ctx context.Context, id string, v T) error {
+	return c.write(ctx, id, v, c.db.Update)
+}
+
+// Put stores v under id, whether it exists or not.
+func (c *Collection[T]) Put(ctx context.Context, id string, v T) error {
+	return c.write(ctx, id, v, c.db.Upsert)
+}
+
+func (c *Collection[T]) write(ctx context.Context, id string, v T, fn func(context.Context, string, []byte) error) error {
+	data, err := c.codec.Encode(v)
+	if err != nil {
+		return fmt.Errorf("engine: encoding %q: %w", c.prefix+id, err)
+	}
+	return fn(ctx, c.prefix+id, data)
+}
+
+// Delete removes id.
+func (c *Collection[T]) Delete(ctx context.Context, id string) error {
+	return c.db.Delete(ctx, c.prefix+id)
+}
+
+// Scan yields every id with its value, in order, from a snapshot taken now, so writes made during the
+// scan are not seen and do not wait for it. The scan stops at the first value that does not decode or
+// if the snapshot cannot be taken; the returned function then reports the error.
+//
+//	users, errf := c.Scan(ctx)
+//	for id, u := range users {
+//		...
+//	}
+//	if err := errf(); err != nil {
+//		...
+//	}
+func (c *Collection[T]) Scan(ctx context.Context) (iter.Seq2[string, T], func() error) {
+	snap, err := c.db.Snapshot(ctx)
+	if err != nil {
+		return func(func(string, T) bool) {}, func() error { return err }
+	}
+	return store.ScanAs(snap, c.prefix, c.codec)
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"kvstore/store"
+	"slices"
+	"testing"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+	db := start(t)
+	users := NewCollection[user](db, "user:", nil)
+
+	for id, u := range map[string]user{"2": {"bob", 30}, "1": {"ada", 36}} {
+		if err := users.Add(ctx, id, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Upsert(ctx, "order:1", []byte(`[1,2]`))
+
+	if u, err := users.Get(ctx, "1"); err != nil || u != (user{"ada", 36}) {
+		t.Errorf("Get(1) = %+v, %v", u, err)
+	}
+
+	seq, errf := users.Scan(ctx)
+	var names []string
+	for id, u := range seq {
+		names = append(names, id+"="+u.Name)
+		// Writes during a scan neither block on it nor show up in it
+		users.Put(ctx, "3", user{"cy", 1})
+	}
+	if err := errf(); err != nil || !slices.Equal(names, []string{"1=ada", "2=bob"}) {
+		t.Errorf("Scan() = %v, %v", names, err)
+	}
+
+	db.Upsert(ctx, "user:4", []byte(`{"age":"old"}`))
+	var de *store.DecodeError
+	if _, err := users.Get(ctx, "4"); !errors.As(err, &de) || de.Key != "user:4" {
+		t.Errorf("Get() of a bad value = %v, want a DecodeError", err)
+	}
+
+	db.Close()
+	seq, errf = users.Scan(ctx)
+	for range seq {
+		t.Error("Scan() of a closed DB yielded a value")
+	}
+	if !errors.Is(errf(), ErrClosed) {
+		t.Errorf("Scan() of a closed DB = %v, want ErrClosed", errf())
+	}
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"kvstore/helpers"
+	"kvstore/store"
+	"time"
+)
+
+// AcquireLease takes the lease name for owner for ttl. If another owner holds it, it keeps trying for
+// up to wait, or until ctx is done, before failing with helpers.LeaseHeldError.
+func (db *DB) AcquireLease(ctx context.Context, name, owner string, ttl, wait time.Duration) (store.Lease, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		l, err := do(ctx, db, func(s *store.KVStore) (store.Lease, error) {
+			return s.AcquireLease(name, owner, ttl)
+		})
+		remaining := time.Until(deadline)
+		if !errors.Is(err, helpers.LeaseHeldError) || remaining <= 0 {
+			return l, err
+		}
+
+		// Sleep until the lease expires, but poll in case it is released early
+		pause := min(remaining, PollInterval, max(time.Until(l.ExpiresAt), time.Millisecond))
+		if err := sleep(ctx, pause); err != nil {
+			return l, err
+		}
+	}
+}
+
+// RenewLease extends the lease name held by owner by ttl.
+func (db *DB) RenewLease(ctx context.Context, name, owner string, ttl time.Duration) (store.Lease, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.Lease, error) {
+		return s.RenewLease(name, owner, ttl)
+	})
+}
+
+// ReleaseLease frees the lease name held by owner.
+func (db *DB) ReleaseLease(ctx context.Context, name, owner string) error {
+	return db.Do(ctx, func(s *store.KVStore) error {
+		return s.ReleaseLease(name, owner)
+	})
+}
+
+// GetLease returns the current holder of the lease name.
+func (db *DB) GetLease(ctx context.Context, name string) (store.Lease, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.Lease, error) {
+		return s.GetLease(name)
+	})
+}
+
+// Enqueue appends the JSON value to the queue name.
+func (db *DB) Enqueue(ctx context.Context, name string, value []byte) (store.Message, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.Message, error) {
+		return s.Enqueue(name, value)
+	})
+}
+
+// Dequeue takes the oldest message from the queue name and hides it from other consumers for visibility.
+func (db *DB) Dequeue(ctx context.Context, name string, visibility time.Duration) (store.Message, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.Message, error) {
+		return s.Dequeue(name, visibility)
+	})
+}
+
+// Ack confirms the message dequeued with receipt has been processed.
+func (db *DB) Ack(ctx context.Context, name, receipt string) error {
+	return db.Do(ctx, func(s *store.KVStore) error {
+		return s.Ack(name, receipt)
+	})
+}
+
+// Nack returns the message dequeued with receipt to the queue for another delivery.
+func (db *DB) Nack(ctx context.Context, name, receipt string) error {
+	return db.Do(ctx, func(s *store.KVStore) error {
+		return s.Nack(name, receipt)
+	})
+}
+
+// QueueStats returns the counters of the queue name.
+func (db *DB) QueueStats(ctx context.Context, name string) (store.QueueStats, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.QueueStats, error) {
+		return s.QueueStats(name)
+	})
+}
+
+// AllQueueStats returns the counters of every queue.
+func (db *DB) AllQueueStats(ctx context.Context) ([]store.QueueStats, error) {
+	return do(ctx, db, func(s *store.KVStore) ([]store.QueueStats, error) {
+		return s.AllQueueStats()
+	})
+}
+
+// StreamAdd appends the JSON value to the stream name, trimming it to maxLen entries if positive.
+func (db *DB) StreamAdd(ctx context.Context, name string, value []byte, maxLen int) (store.StreamEntry, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.StreamEntry, error) {
+		return s.StreamAdd(name, value, maxLen)
+	})
+}
+
+// StreamRange returns up to count entries of the stream name with IDs from start to end, both inclusive.
+func (db *DB) StreamRange(ctx context.Context, name, start, end string, count int) ([]store.StreamEntry, error) {
+	return do(ctx, db, func(s *store.KVStore) ([]store.StreamEntry, error) {
+		return s.StreamRange(name, start, end, count)
+	})
+}
+
+// StreamRead returns up to count entries of the stream name added after the ID after, "$" for the
+// current end. If there are none it keeps trying for up to wait, or until ctx is done.
+func (db *DB) StreamRead(ctx context.Context, name, after string, count int, wait time.Duration) ([]store.StreamEntry, error) {
+	if after == "$" && wait > 0 {
+		// Pin the end of the stream now, or entries added between polls would be skipped
+		info, err := db.StreamInfo(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		after = "0"
+		if info.LastID != "" {
+			after = info.LastID
+		}
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		entries, err := do(ctx, db, func(s *store.KVStore) ([]store.StreamEntry, error) {
+			return s.StreamRead(name, after, count)
+		})
+		remaining := time.Until(deadline)
+		if err != nil || len(entries) > 0 || remaining <= 0 {
+			return entries, err
+		}
+		if err := sleep(ctx, min(remaining, PollInterval)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// StreamTail returns the last count entries of the stream name, or all of them if count is zero.
+func (db *DB) StreamTail(ctx context.Context, name string, count int) ([]store.StreamEntry, error) {
+	return do(ctx, db, func(s *store.KVStore) ([]store.StreamEntry, error) {
+		return s.StreamTail(name, count)
+	})
+}
+
+// StreamTrim drops the oldest entries of the stream name beyond maxLen.
+func (db *DB) StreamTrim(ctx context.Context, name string, maxLen int) (int, error) {
+	return do(ctx, db, func(s *store.KVStore) (int, error) {
+		return s.StreamTrim(name, maxLen)
+	})
+}
+
+// StreamInfo returns the length, ID range and consumer groups of the stream name.
+func (db *DB) StreamInfo(ctx context.Context, name string) (store.StreamInfo, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.StreamInfo, error) {
+		return s.StreamInfo(name)
+	})
+}
+
+// CreateGroup adds the consumer group to the stream name, starting after the ID start.
+func (db *DB) CreateGroup(ctx context.Context, name, group, start string) error {
+	return db.Do(ctx, func(s *store.KVStore) error {
+		return s.CreateGroup(name, group, start)
+	})
+}
+
+// ReadGroup delivers up to count new entries of the stream name to consumer of group.
+func (db *DB) ReadGroup(ctx context.Context, name, group, consumer string, count int) ([]store.StreamEntry, error) {
+	return do(ctx, db, func(s *store.KVStore) ([]store.StreamEntry, error) {
+		return s.ReadGroup(name, group, consumer, count)
+	})
+}
+
+// StreamAck acknowledges the entries ids delivered to group.
+func (db *DB) StreamAck(ctx context.Context, name, group string, ids ...string) (int, error) {
+	return do(ctx, db, func(s *store.KVStore) (int, error) {
+		return s.StreamAck(name, group, ids)
+	})
+}
+
+// Pending lists the entries delivered to group that have not been acknowledged.
+func (db *DB) Pending(ctx context.Context, name, group string) ([]store.PendingEntry, error) {
+	return do(ctx, db, func(s *store.KVStore) ([]store.PendingEntry, error) {
+		return s.Pending(name, group)
+	})
+}
+
+// Claim hands the pending entries ids of group (all of them if none are given) that have been idle
+// for minIdle over to consumer.
+func (db *DB) Claim(ctx context.Context, name, group, consumer string, minIdle time.Duration, ids ...string) ([]store.StreamEntry, error) {
+	return do(ctx, db, func(s *store.KVStore) ([]store.StreamEntry, error) {
+		return s.Claim(name, group, consumer, minIdle, ids)
+	})
+}
+
+// AllowRate takes limit.Cost tokens from the rate limit of key.
+func (db *DB) AllowRate(ctx context.Context, key string, limit store.RateLimit) (store.RateLimitResult, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.RateLimitResult, error) {
+		return s.AllowRate(key, limit)
+	})
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
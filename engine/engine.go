@@ -0,0 +1,371 @@
+// Package engine embeds the key/value store in a Go program, without the HTTP server. Every DB owns
+// its store and serializes access to it with a lock of its own, so any number of them can run side by
+// side in one process.
+//
+// The package is for embedding only. The servers in this module do not run on a DB: they serve the
+// process-wide store.Store through the channels request loop, which also carries Raft, replication and
+// the audit log, none of which a DB offers.
+//
+//	db := engine.New(engine.WithPersistence("data.json", time.Minute), engine.WithLimits(engine.Limits{MaxKeys: 1e6}))
+//	if err := db.Start(); err != nil {
+//		...
+//	}
+//	defer db.Close()
+//	err := db.Add(ctx, "user:1", []byte(`{"name":"ada"}`))
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kvstore/store"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultSweepInterval is how often expired leases, trash, rate limits and queue deliveries are
+	// removed, unless set with WithSweepInterval.
+	DefaultSweepInterval = time.Minute
+
+	// PollInterval caps how long a blocking lease acquisition or stream read waits before trying again.
+	PollInterval = 50 * time.Millisecond
+)
+
+var (
+	ErrNotStarted    = errors.New("engine: not started")
+	ErrClosed        = errors.New("engine: closed")
+	ErrTooManyKeys   = errors.New("engine: key limit reached")
+	ErrValueTooLarge = errors.New("engine: value too large")
+)
+
+// Limits caps what a DB holds. They apply to writes of values; keys restored from the trash are let
+// back in regardless. Zero values are unlimited.
+type Limits struct {
+	MaxKeys      int // Keys in the key space, trash and other state excluded
+	MaxValueSize int // Size of a JSON encoded value in bytes
+}
+
+// Option configures a DB.
+type Option func(*config)
+
+type config struct {
+	path           string        // File the store is saved to, empty if not persisted
+	saveInterval   time.Duration // Time between saves, zero saves on Close only
+	sweepInterval  time.Duration
+	limits         Limits
+	maxDeliveries  int
+	trashRetention time.Duration
+}
+
+// WithPersistence loads the store from the file at path on Start, if there is one, and saves it there
+// every interval and on Close. Everything in store.State is saved: keys with their metadata, the trash,
+// leases, queues, streams and rate limits. Replicated keys live in memory only.
+func WithPersistence(path string, interval time.Duration) Option {
+	return func(c *config) {
+		c.path, c.saveInterval = path, interval
+	}
+}
+
+// WithSweepInterval sets how often state whose time is up is removed: expired leases and rate limits,
+// trash older than the retention and queue messages whose visibility timeout has passed. Zero leaves
+// expired state in place until it is next touched.
+func WithSweepInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.sweepInterval = d
+	}
+}
+
+// WithLimits caps the number of keys and the size of values. Writes that would exceed them fail with
+// ErrTooManyKeys or ErrValueTooLarge.
+func WithLimits(l Limits) Option {
+	return func(c *config) {
+		c.limits = l
+	}
+}
+
+// WithMaxDeliveries sets how many failed deliveries move a queue message to the dead-letter queue, zero
+// retrying forever.
+func WithMaxDeliveries(n int) Option {
+	return func(c *config) {
+		c.maxDeliveries = n
+	}
+}
+
+// WithTrashRetention sets how long soft deleted keys are kept, zero keeping them until purged.
+func WithTrashRetention(d time.Duration) Option {
+	return func(c *config) {
+		c.trashRetention = d
+	}
+}
+
+// DB is an embedded store. Its methods are safe for concurrent use once it is started.
+type DB struct {
+	cfg   config
+	store *store.KVStore
+
+	lock    chan struct{} // Sent to while the store is in use; a channel, so that waiting can be cancelled
+	quit    chan struct{}
+	stopped chan struct{} // Closed when the sweeper and the saver have returned
+
+	mu      sync.Mutex // Guards closed and the start
+	started atomic.Bool
+	closed  bool
+
+	saveMu sync.Mutex        // Orders saves, so an older state never replaces a newer one
+	saved  [sha256.Size]byte // Hash of the state last saved
+}
+
+// New returns a DB configured by opts. It serves nothing until it is started.
+func New(opts ...Option) *DB {
+	cfg := config{
+		sweepInterval:  DefaultSweepInterval,
+		maxDeliveries:  store.DefaultMaxDeliveries,
+		trashRetention: store.DefaultTrashRetention,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	s := store.NewKeyValueStore()
+	s.MaxDeliveries = cfg.maxDeliveries
+	s.TrashRetention = cfg.trashRetention
+
+	return &DB{
+		cfg:     cfg,
+		store:   s,
+		lock:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Start loads the persisted key space, if any, and starts serving requests.
+func (db *DB) Start() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if db.started.Load() {
+		return nil
+	}
+
+	if db.cfg.path != "" {
+		if err := db.load(); err != nil {
+			return err
+		}
+	}
+
+	var wg sync.WaitGroup
+	if db.cfg.sweepInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.sweepEvery(db.cfg.sweepInterval)
+		}()
+	}
+	if db.cfg.path != "" && db.cfg.saveInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.saveEvery(db.cfg.saveInterval)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(db.stopped)
+	}()
+
+	db.started.Store(true)
+	return nil
+}
+
+// Close stops the DB and saves the key space, if persisted. Requests made after Close fail with ErrClosed.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	if db.closed || !db.started.Load() {
+		db.closed = true
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	db.mu.Unlock()
+
+	close(db.quit)
+	// Wait for the request in progress, if any, and keep the lock so no other one starts. Every write
+	// acknowledged so far is then in the state saved below.
+	db.lock <- struct{}{}
+	<-db.stopped
+
+	if db.cfg.path == "" {
+		return nil
+	}
+	db.saveMu.Lock()
+	defer db.saveMu.Unlock()
+	return db.writeState(db.store.State())
+}
+
+// sweepEvery removes expired state from the store every interval until the DB is closed.
+func (db *DB) sweepEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			db.Do(context.Background(), func(s *store.KVStore) error {
+				s.Sweep()
+				return nil
+			})
+		case <-db.quit:
+			return
+		}
+	}
+}
+
+// do runs fn on the store once it has the store to itself. The context bounds the wait for the store;
+// once fn has started, it runs to completion so that a write is never reported as cancelled when it
+// was applied.
+func do[T any](ctx context.Context, db *DB, fn func(*store.KVStore) (T, error)) (T, error) {
+	var zero T
+	if !db.started.Load() {
+		return zero, ErrNotStarted
+	}
+
+	select {
+	case db.lock <- struct{}{}:
+	case <-db.quit:
+		return zero, ErrClosed
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+	defer func() { <-db.lock }()
+
+	return fn(db.store)
+}
+
+// Do runs fn with exclusive access to the store, for operations this package does not wrap or several
+// that must happen together. fn must not keep the store beyond its return.
+func (db *DB) Do(ctx context.Context, fn func(s *store.KVStore) error) error {
+	_, err := do(ctx, db, func(s *store.KVStore) (struct{}, error) {
+		return struct{}{}, fn(s)
+	})
+	return err
+}
+
+// checkWrite enforces the limits on writing value under key.
+func (db *DB) checkWrite(s *store.KVStore, key string, value []byte) error {
+	l := db.cfg.limits
+	if l.MaxValueSize > 0 && len(value) > l.MaxValueSize {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrValueTooLarge, len(value), l.MaxValueSize)
+	}
+	if l.MaxKeys > 0 {
+		if ok, _ := s.Exists(key); !ok {
+			if n, _ := s.Count(); n >= l.MaxKeys {
+				return fmt.Errorf("%w: limit is %d", ErrTooManyKeys, l.MaxKeys)
+			}
+		}
+	}
+	return nil
+}
+
+// load replaces the key space with the one saved at the configured path, if the file exists. It runs
+// before any request is served.
+func (db *DB) load() error {
+	b, err := os.ReadFile(db.cfg.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var st store.State
+	if err := json.Unmarshal(b, &st); err != nil {
+		return fmt.Errorf("engine: loading %s: %w", db.cfg.path, err)
+	}
+	db.store.LoadState(st)
+	db.saved = sha256.Sum256(b)
+	return nil
+}
+
+// saveEvery saves the key space every interval until the DB is closed.
+func (db *DB) saveEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := db.save(context.Background()); err != nil && !errors.Is(err, ErrClosed) {
+				log.Printf("Engine Error: saving %s: %s", db.cfg.path, err)
+			}
+		case <-db.quit:
+			return
+		}
+	}
+}
+
+// save writes the store to the configured path if it changed since the last save. The state is
+// copied while holding the store, and encoded and written after letting it go.
+func (db *DB) save(ctx context.Context) error {
+	db.saveMu.Lock()
+	defer db.saveMu.Unlock()
+
+	st, err := do(ctx, db, func(s *store.KVStore) (store.State, error) {
+		return s.State(), nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.writeState(st)
+}
+
+// writeState saves st to the configured path if it differs from the state last saved. The caller holds saveMu.
+func (db *DB) writeState(st store.State) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	if sum == db.saved {
+		return nil
+	}
+
+	if err := writeFile(db.cfg.path, b); err != nil {
+		return err
+	}
+	db.saved = sum
+	return nil
+}
+
+// writeFile replaces the file at path with b, so a crash leaves either the old or the new version in
+// place.
+func writeFile(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
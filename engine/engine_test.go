@@ -0,0 +1,253 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kvstore/helpers"
+	"kvstore/store"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func start(t *testing.T, opts ...Option) *DB {
+	t.Helper()
+	db := New(opts...)
+	if err := db.Start(); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestInstancesAreIndependent(t *testing.T) {
+	ctx := context.Background()
+	a, b := start(t), start(t)
+
+	if err := a.Add(ctx, "k", []byte(`"a"`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(ctx, "k", []byte(`"b"`)); err != nil {
+		t.Fatalf("Add() to a second DB = %v", err)
+	}
+	if v, _ := a.Get(ctx, "k"); v != "a" {
+		t.Errorf("a.Get() = %v, want a", v)
+	}
+	if err := b.Clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := a.Count(ctx); n != 1 {
+		t.Errorf("a.Count() after clearing b = %d, want 1", n)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	if _, err := db.Get(ctx, "k"); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("Get() before Start() = %v, want ErrNotStarted", err)
+	}
+	if err := db.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get(ctx, "k"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Get() of a missing key = %v, want NotExistError", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get(ctx, "k"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get() after Close() = %v, want ErrClosed", err)
+	}
+	if err := db.Start(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Start() after Close() = %v, want ErrClosed", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	db := start(t, WithLimits(Limits{MaxKeys: 2, MaxValueSize: 8}))
+
+	if err := db.Add(ctx, "a", []byte(`"too long"`)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Add() of a large value = %v, want ErrValueTooLarge", err)
+	}
+	db.Add(ctx, "a", []byte(`1`))
+	db.Add(ctx, "b", []byte(`2`))
+	if err := db.Upsert(ctx, "c", []byte(`3`)); !errors.Is(err, ErrTooManyKeys) {
+		t.Errorf("Upsert() of a third key = %v, want ErrTooManyKeys", err)
+	}
+	if err := db.Upsert(ctx, "b", []byte(`20`)); err != nil {
+		t.Errorf("Upsert() of an existing key at the limit = %v", err)
+	}
+
+	// An expired key no longer exists, nor counts towards the limit
+	db.Do(ctx, func(s *store.KVStore) error { return s.Expire("a", time.Millisecond) })
+	time.Sleep(5 * time.Millisecond)
+	if ok, err := db.Exists(ctx, "a"); ok || err != nil {
+		t.Errorf("Exists() of an expired key = %v, %v, want false", ok, err)
+	}
+	if err := db.Upsert(ctx, "c", []byte(`3`)); err != nil {
+		t.Errorf("Upsert() of a third key once one expired = %v", err)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	db := New(WithPersistence(path, 0))
+	if err := db.Start(); err != nil {
+		t.Fatal(err)
+	}
+	db.Add(ctx, "kept", []byte(`{"n":1}`))
+	db.SetTags(ctx, "kept", map[string]string{"env": "prod"})
+	db.Add(ctx, "binned", []byte(`2`))
+	db.SoftDelete(ctx, "binned")
+	db.Enqueue(ctx, "jobs", []byte(`"job"`))
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	db = start(t, WithPersistence(path, 0))
+	item, err := db.GetWithMeta(ctx, "kept")
+	if err != nil || item.Metadata.Tags["env"] != "prod" {
+		t.Errorf("GetWithMeta() after reopening = %+v, %v", item, err)
+	}
+	if v, err := db.Restore(ctx, "binned"); err != nil || v != float64(2) {
+		t.Errorf("Restore() after reopening = %v, %v", v, err)
+	}
+	if msg, err := db.Dequeue(ctx, "jobs", time.Minute); err != nil || msg.Body != "job" {
+		t.Errorf("Dequeue() after reopening = %+v, %v", msg, err)
+	}
+}
+
+func TestCloseKeepsAcknowledgedWrites(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.json")
+	db := New(WithPersistence(path, 0))
+	if err := db.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writers race Close, every write that succeeded must be saved
+	var wg sync.WaitGroup
+	acked := make([][]string, 4)
+	for w := range acked {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				key := fmt.Sprintf("w%d-%d", w, i)
+				if err := db.Add(ctx, key, []byte(`1`)); err != nil {
+					return
+				}
+				acked[w] = append(acked[w], key)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	wg.Wait()
+
+	db = start(t, WithPersistence(path, 0))
+	for _, keys := range acked {
+		for _, key := range keys {
+			if ok, _ := db.Exists(ctx, key); !ok {
+				t.Fatalf("acknowledged write %s missing after reopening", key)
+			}
+		}
+	}
+}
+
+func TestPeriodicSave(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.json")
+	db := start(t, WithPersistence(path, 10*time.Millisecond))
+	db.Add(ctx, "k", []byte(`1`))
+
+	// A second DB reading the file sees the write without the first being closed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		other := New(WithPersistence(path, 0))
+		other.Start()
+		ok, _ := other.Exists(ctx, "k")
+		other.Close()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("write was never saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	ctx := context.Background()
+	db := start(t, WithSweepInterval(10*time.Millisecond), WithTrashRetention(time.Millisecond))
+	db.Add(ctx, "k", []byte(`1`))
+	db.SoftDelete(ctx, "k")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		trash, _ := db.Trash(ctx)
+		if len(trash) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired trash was never swept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestContextCancelsWait(t *testing.T) {
+	db := start(t)
+	if _, err := db.AcquireLease(context.Background(), "l", "a", time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := db.AcquireLease(ctx, "l", "b", time.Hour, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AcquireLease() = %v, want DeadlineExceeded", err)
+	}
+
+	db.StreamAdd(context.Background(), "s", []byte(`1`), 0)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := db.StreamRead(ctx, "s", "$", 1, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("StreamRead() = %v, want DeadlineExceeded", err)
+	}
+}
+
+// Requests from many goroutines are serialized. Run with -race.
+func TestConcurrentRequests(t *testing.T) {
+	ctx := context.Background()
+	db := start(t)
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				key := fmt.Sprintf("%d:%d", w, i)
+				db.Upsert(ctx, key, []byte(`1`))
+				db.Get(ctx, key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, _ := db.Count(ctx); n != 400 {
+		t.Errorf("Count() = %d, want 400", n)
+	}
+}
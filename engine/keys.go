@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"context"
+	"kvstore/store"
+)
+
+// Values returned by the methods below are shared with the store and must not be modified.
+
+// Get returns the value stored under key.
+func (db *DB) Get(ctx context.Context, key string) (any, error) {
+	return do(ctx, db, func(s *store.KVStore) (any, error) {
+		return s.Get(key)
+	})
+}
+
+// GetWithMeta returns the value stored under key together with its metadata.
+func (db *DB) GetWithMeta(ctx context.Context, key string) (store.Item, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.Item, error) {
+		return s.GetWithMeta(key)
+	})
+}
+
+// Meta returns the metadata of key.
+func (db *DB) Meta(ctx context.Context, key string) (store.Metadata, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.Metadata, error) {
+		return s.Meta(key)
+	})
+}
+
+// Add stores the JSON value under a new key. It fails with helpers.DuplicateKeyError if the key exists.
+func (db *DB) Add(ctx context.Context, key string, value []byte) error {
+	return db.write(ctx, key, value, (*store.KVStore).Add)
+}
+
+// Update replaces the value of an existing key. It fails with helpers.NotExistError if there is none.
+func (db *DB) Update(ctx context.Context, key string, value []byte) error {
+	return db.write(ctx, key, value, (*store.KVStore).Update)
+}
+
+// Upsert stores the JSON value under key, whether the key exists or not.
+func (db *DB) Upsert(ctx context.Context, key string, value []byte) error {
+	return db.write(ctx, key, value, (*store.KVStore).Upsert)
+}
+
+func (db *DB) write(ctx context.Context, key string, value []byte, fn func(*store.KVStore, string, []byte) (any, error)) error {
+	return db.Do(ctx, func(s *store.KVStore) error {
+		if err := db.checkWrite(s, key, value); err != nil {
+			return err
+		}
+		_, err := fn(s, key, value)
+		return err
+	})
+}
+
+// Import stores a JSON value under key, resolving a conflict with an existing key as opts say.
+func (db *DB) Import(ctx context.Context, key string, value []byte, opts store.ImportOptions) (store.ImportResult, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.ImportResult, error) {
+		if err := db.checkWrite(s, key, value); err != nil {
+			return "", err
+		}
+		return s.Import(key, value, opts)
+	})
+}
+
+// Delete removes key.
+func (db *DB) Delete(ctx context.Context, key string) error {
+	return db.Do(ctx, func(s *store.KVStore) error {
+		return s.Delete(key)
+	})
+}
+
+// Exists reports whether key is in the store.
+func (db *DB) Exists(ctx context.Context, key string) (bool, error) {
+	return do(ctx, db, func(s *store.KVStore) (bool, error) {
+		ok, _ := s.Exists(key)
+		return ok, nil
+	})
+}
+
+// Count returns the number of keys.
+func (db *DB) Count(ctx context.Context) (int, error) {
+	return do(ctx, db, func(s *store.KVStore) (int, error) {
+		return s.Count()
+	})
+}
+
+// Clear removes every key.
+func (db *DB) Clear(ctx context.Context) error {
+	return db.Do(ctx, func(s *store.KVStore) error {
+		_, err := s.Clear()
+		return err
+	})
+}
+
+// Snapshot returns an immutable view of the key space, which can be read for as long as needed
+// without holding up other requests. GetAll, Scan and Export are all served by snapshots.
+func (db *DB) Snapshot(ctx context.Context) (*store.Snapshot, error) {
+	return do(ctx, db, func(s *store.KVStore) (*store.Snapshot, error) {
+		return s.Snapshot(), nil
+	})
+}
+
+// GetAll returns every value by key.
+func (db *DB) GetAll(ctx context.Context) (map[string]any, error) {
+	snap, err := db.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snap.Values(), nil
+}
+
+// SoftDelete moves key to the trash.
+func (db *DB) SoftDelete(ctx context.Context, key string) error {
+	return db.Do(ctx, func(s *store.KVStore) error {
+		return s.SoftDelete(key)
+	})
+}
+
+// SoftClear moves every key to the trash and returns the ID that restores them all.
+func (db *DB) SoftClear(ctx context.Context) (string, error) {
+	return do(ctx, db, func(s *store.KVStore) (string, error) {
+		return s.SoftClear()
+	})
+}
+
+// Trash lists the soft deleted keys.
+func (db *DB) Trash(ctx context.Context) ([]store.TrashEntry, error) {
+	return do(ctx, db, func(s *store.KVStore) ([]store.TrashEntry, error) {
+		return s.Trash()
+	})
+}
+
+// Restore moves key out of the trash and returns its value.
+func (db *DB) Restore(ctx context.Context, key string) (any, error) {
+	return do(ctx, db, func(s *store.KVStore) (any, error) {
+		return s.Restore(key)
+	})
+}
+
+// RestoreClear moves every key removed by the soft clear with id out of the trash.
+func (db *DB) RestoreClear(ctx context.Context, id string) (store.RestoreResult, error) {
+	return do(ctx, db, func(s *store.KVStore) (store.RestoreResult, error) {
+		return s.RestoreClear(id)
+	})
+}
+
+// Purge permanently removes key from the trash, or the whole trash if key is empty.
+func (db *DB) Purge(ctx context.Context, key string) (int, error) {
+	return do(ctx, db, func(s *store.KVStore) (int, error) {
+		return s.Purge(key)
+	})
+}
+
+// Tags returns the tags of key.
+func (db *DB) Tags(ctx context.Context, key string) (map[string]string, error) {
+	return do(ctx, db, func(s *store.KVStore) (map[string]string, error) {
+		return s.Tags(key)
+	})
+}
+
+// SetTags adds tags to key and returns all its tags.
+func (db *DB) SetTags(ctx context.Context, key string, tags map[string]string) (map[string]string, error) {
+	return do(ctx, db, func(s *store.KVStore) (map[string]string, error) {
+		return s.SetTags(key, tags)
+	})
+}
+
+// RemoveTags removes the tags called names from key and returns the tags left.
+func (db *DB) RemoveTags(ctx context.Context, key string, names ...string) (map[string]string, error) {
+	return do(ctx, db, func(s *store.KVStore) (map[string]string, error) {
+		return s.RemoveTags(key, names)
+	})
+}
+
+// FindByTags lists the keys matching sel.
+func (db *DB) FindByTags(ctx context.Context, sel store.TagSelector) ([]string, error) {
+	return do(ctx, db, func(s *store.KVStore) ([]string, error) {
+		return s.FindByTags(sel)
+	})
+}
+
+// CountByTags counts the keys matching sel.
+func (db *DB) CountByTags(ctx context.Context, sel store.TagSelector) (int, error) {
+	return do(ctx, db, func(s *store.KVStore) (int, error) {
+		return s.CountByTags(sel)
+	})
+}
+
+// DeleteByTags deletes every key matching sel, moving them to the trash if soft is set.
+func (db *DB) DeleteByTags(ctx context.Context, sel store.TagSelector, soft bool) ([]store.Entry, error) {
+	return do(ctx, db, func(s *store.KVStore) ([]store.Entry, error) {
+		return s.DeleteByTags(sel, soft)
+	})
+}
@@ -9,12 +9,21 @@ import (
 
 // Error types
 var (
-	MissingKeyError   = errors.New("key not provided")
-	EmptyValueError   = errors.New("value not found")
-	MissingValueError = errors.New("value not provided")
-	NotExistError     = errors.New("key not found")
-	DuplicateKeyError = errors.New("duplicate key")
-	MethodNotAllowed  = errors.New("method not allowed")
+	MissingKeyError       = errors.New("key not provided")
+	EmptyValueError       = errors.New("value not found")
+	MissingValueError     = errors.New("value not provided")
+	NotExistError         = errors.New("key not found")
+	DuplicateKeyError     = errors.New("duplicate key")
+	MethodNotAllowed      = errors.New("method not allowed")
+	InvalidParamError     = errors.New("invalid parameter")
+	LeaseHeldError        = errors.New("lease held by another owner")
+	NotLeaseOwnerError    = errors.New("lease not held by owner")
+	QueueEmptyError       = errors.New("queue empty")
+	InvalidReceiptError   = errors.New("receipt not valid")
+	NotLeaderError        = errors.New("not the cluster leader")
+	NoOwnerError          = errors.New("no server owns the key")
+	RevisionMismatchError = errors.New("key changed since it was read")
+	ReadOnlyError         = errors.New("read only replica")
 )
 
 // ParseJSON takes in a byte array and parses into an any
@@ -66,6 +75,54 @@ func HandleError(w http.ResponseWriter, err error) {
 		return
 	}
 
+	if errors.Is(err, InvalidParamError) {
+		log.Printf("Param Error: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, LeaseHeldError) {
+		log.Printf("Lease Error: %s", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if errors.Is(err, NotLeaseOwnerError) {
+		log.Printf("Lease Error: %s", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if errors.Is(err, QueueEmptyError) {
+		log.Printf("Queue Error: %s", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if errors.Is(err, InvalidReceiptError) {
+		log.Printf("Queue Error: %s", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if errors.Is(err, RevisionMismatchError) {
+		log.Printf("Key Error: %s", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if errors.Is(err, NotLeaderError) {
+		log.Printf("Cluster Error: %s", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if errors.Is(err, NoOwnerError) {
+		log.Printf("Partition Error: %s", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
 	// Handle other errors if necessary
 	log.Printf("Unexpected Error: %s", err)
 	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
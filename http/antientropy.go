@@ -0,0 +1,122 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kvstore/antientropy"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"log"
+	"net/http"
+)
+
+// AntiEntropyStatus returns the repair schedule of this server and the report of its last repair.
+func AntiEntropyStatus(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	writeJSON(w, channels.AntiEntropy.Status())
+}
+
+// AntiEntropyRepair repairs this server against the peer given by ?peer= (the scheduled peer if unset)
+// in ?mode= and returns the report once it is done.
+func AntiEntropyRepair(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	var mode antientropy.Mode
+	if q.Get("mode") != "" {
+		m, err := antientropy.ParseMode(q.Get("mode"))
+		if err != nil {
+			helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+			return
+		}
+		mode = m
+	}
+
+	rep, err := channels.AntiEntropy.Repair(r.Context(), q.Get("peer"), mode)
+	if errors.Is(err, antientropy.ErrNoPeer) {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	log.Printf("Successfully repaired %d keys against %s", rep.Repaired, rep.Peer)
+	writeJSON(w, rep)
+}
+
+// AntiEntropyTree returns the hashes of a level of the Merkle tree, for a peer comparing its own.
+func AntiEntropyTree(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var req antientropy.TreeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	resp := channels.MerkleHashesRequest(req.Level, req.Nodes)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	writeJSON(w, antientropy.TreeResponse{Hashes: resp.Value.([]uint64)})
+}
+
+// AntiEntropyKeys returns the keys in a set of leaves of the Merkle tree with their metadata.
+func AntiEntropyKeys(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var req antientropy.KeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	resp := channels.MerkleItemsRequest(req.Leaves)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	writeJSON(w, resp.Value)
+}
+
+// AntiEntropyApply stores the keys a peer repaired on this server.
+func AntiEntropyApply(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var req antientropy.ApplyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	resp := channels.AntiEntropyApplyRequest(req.Changes)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully applied %d repaired keys from %s", resp.Value, r.RemoteAddr)
+	writeJSON(w, antientropy.ApplyResponse{Applied: resp.Value.(int)})
+}
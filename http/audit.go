@@ -0,0 +1,51 @@
+package http
+
+import (
+	"fmt"
+	"kvstore/audit"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Audit queries the audit log by ?key=, ?op=, a ?since= / ?until= RFC 3339 time range and ?limit=.
+func Audit(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	query := audit.Query{Key: q.Get("key"), Op: q.Get("op")}
+
+	var err error
+	if v := q.Get("since"); v != "" {
+		if query.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			helpers.HandleError(w, fmt.Errorf("%w: since: %s", helpers.InvalidParamError, err))
+			return
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if query.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			helpers.HandleError(w, fmt.Errorf("%w: until: %s", helpers.InvalidParamError, err))
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil {
+			helpers.HandleError(w, fmt.Errorf("%w: limit: %s", helpers.InvalidParamError, err))
+			return
+		}
+	}
+
+	records := []audit.Record{}
+	if channels.Audit != nil {
+		records = channels.Audit.Query(query)
+	}
+
+	log.Printf("Successfully queried %d audit records", len(records))
+	writeJSON(w, records)
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"log"
+	"net/http"
+)
+
+// ClusterStatus returns the Raft state of this server: its role, term, leader, log indexes and members.
+func ClusterStatus(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	writeJSON(w, channels.Raft.Status())
+}
+
+// ClusterMembers adds (POST) or removes (DELETE) the member given by the id parameter. Adding a member
+// also needs its base URL in the addr parameter.
+func ClusterMembers(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		helpers.HandleError(w, fmt.Errorf("%w: id not provided", helpers.InvalidParamError))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		addr := r.URL.Query().Get("addr")
+		if addr == "" {
+			helpers.HandleError(w, fmt.Errorf("%w: addr not provided", helpers.InvalidParamError))
+			return
+		}
+		if err := channels.AddMember(id, addr); err != nil {
+			helpers.HandleError(w, err)
+			return
+		}
+		log.Printf("Successfully added cluster member: %s at %s", id, addr)
+	case http.MethodDelete:
+		if err := channels.RemoveMember(id); err != nil {
+			helpers.HandleError(w, err)
+			return
+		}
+		log.Printf("Successfully removed cluster member: %s", id)
+	default:
+		helpers.HandleError(w, helpers.MethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, channels.Raft.Status().Members)
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"kvstore/channels"
+	"kvstore/crdt"
+	"kvstore/helpers"
+	"kvstore/store"
+	"log"
+	"net/http"
+)
+
+// CRDTGet returns the type and value of the replicated key ?key=, or of every replicated key if unset.
+func CRDTGet(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.CRDTGetRequest(r.URL.Query().Get("key"))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	writeJSON(w, resp.Value)
+}
+
+// CRDTDeclare makes ?key= a replicated value of ?type=: lww, gcounter, pncounter, orset or ormap.
+func CRDTDeclare(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	t, err := crdt.ParseType(q.Get("type"))
+	if err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	resp := channels.CRDTDeclareRequest(q.Get("key"), t, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully declared %s as %s", q.Get("key"), t)
+	writeJSON(w, resp.Value)
+}
+
+// CRDTSet writes the JSON body to the lww register ?key=.
+func CRDTSet(w http.ResponseWriter, r *http.Request) {
+	crdtUpdate(w, r, http.MethodPut, store.CRDTSet)
+}
+
+// CRDTIncrement adds ?by= (1 if unset) to the counter ?key=. A negative amount decrements a pncounter.
+func CRDTIncrement(w http.ResponseWriter, r *http.Request) {
+	crdtUpdate(w, r, http.MethodPost, store.CRDTIncrement)
+}
+
+// CRDTAdd adds the JSON body to the orset ?key=.
+func CRDTAdd(w http.ResponseWriter, r *http.Request) {
+	crdtUpdate(w, r, http.MethodPost, store.CRDTAdd)
+}
+
+// CRDTRemove removes the JSON body from the orset ?key=.
+func CRDTRemove(w http.ResponseWriter, r *http.Request) {
+	crdtUpdate(w, r, http.MethodPost, store.CRDTRemove)
+}
+
+// CRDTPut writes the JSON body to the field ?field= of the ormap ?key=.
+func CRDTPut(w http.ResponseWriter, r *http.Request) {
+	crdtUpdate(w, r, http.MethodPut, store.CRDTPut)
+}
+
+// CRDTDelete removes the field ?field= from the ormap ?key=.
+func CRDTDelete(w http.ResponseWriter, r *http.Request) {
+	crdtUpdate(w, r, http.MethodDelete, store.CRDTDelete)
+}
+
+// crdtUpdate applies an update of kind op, built from the request, to a replicated key.
+func crdtUpdate(w http.ResponseWriter, r *http.Request, method string, op store.CRDTOp) {
+	if err := helpers.CheckMethod(r.Method, method); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	u := store.CRDTUpdate{Op: op, Field: q.Get("field"), By: 1}
+	switch op {
+	case store.CRDTSet, store.CRDTAdd, store.CRDTRemove, store.CRDTPut:
+		v, err := GetBody(r)
+		if err != nil {
+			helpers.HandleError(w, err)
+			return
+		}
+		u.Value = v
+	case store.CRDTIncrement:
+		if q.Get("by") != "" {
+			by, err := GetIntParam(r, "by")
+			if err != nil {
+				helpers.HandleError(w, err)
+				return
+			}
+			u.By = int64(by)
+		}
+	}
+
+	resp := channels.CRDTUpdateRequest(q.Get("key"), u, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully applied %s to %s", op, q.Get("key"))
+	writeJSON(w, resp.Value)
+}
+
+// CRDTMerge merges a batch of states sent by another site, answering with the states of this site if
+// the batch asks for them.
+func CRDTMerge(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var b crdt.Batch
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	resp := channels.CRDTMergeRequest(b.States)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully merged %d replicated keys from %s", resp.Value, b.From)
+	if !b.Reply {
+		writeJSON(w, map[string]int{"merged": resp.Value.(int)})
+		return
+	}
+
+	states, err := channels.CRDTStates()
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+	writeJSON(w, crdt.Batch{From: channels.Sites.Self, States: states})
+}
+
+// CRDTSites returns the state of replication to every other site.
+func CRDTSites(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]any{"site": channels.Sites.Self, "peers": channels.Sites.Status()})
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -55,7 +56,18 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := channels.GetRequest(k)
+	includeMeta, err := GetBoolParam(r, "include_meta")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var resp channels.Response
+	if includeMeta {
+		resp = channels.GetWithMetaRequest(k)
+	} else {
+		resp = channels.GetRequest(k)
+	}
 	if resp.Error != nil {
 		helpers.HandleError(w, resp.Error)
 		return
@@ -67,6 +79,29 @@ func Get(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp.Value)
 }
 
+// Meta returns the created/updated timestamps, access statistics and size of a key.
+func Meta(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	k, _, err := GetParam(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.MetaRequest(k)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully Received Metadata for Key: %s", k)
+	writeJSON(w, resp.Value)
+}
+
 // Add Calls store.Add to Add a key to the map
 func Add(w http.ResponseWriter, r *http.Request) {
 	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
@@ -80,7 +115,7 @@ func Add(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := channels.AddRequest(k, v)
+	resp := channels.AddRequest(k, v, GetCaller(r))
 
 	if resp.Error != nil {
 		helpers.HandleError(w, resp.Error)
@@ -101,7 +136,19 @@ func GetAll(w http.ResponseWriter, r *http.Request) {
 		helpers.HandleError(w, err)
 		return
 	}
-	resp := channels.GetAllRequest()
+
+	includeMeta, err := GetBoolParam(r, "include_meta")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var resp channels.Response
+	if includeMeta {
+		resp = channels.GetAllWithMetaRequest()
+	} else {
+		resp = channels.GetAllRequest()
+	}
 
 	if resp.Error != nil {
 		helpers.HandleError(w, resp.Error)
@@ -165,7 +212,28 @@ func Clear(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := channels.ClearRequest()
+	soft, err := GetBoolParam(r, "soft")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	if soft {
+		resp := channels.SoftClearRequest(GetCaller(r))
+		if resp.Error != nil {
+			helpers.HandleError(w, resp.Error)
+			return
+		}
+		log.Printf("Successfully moved All keys to trash")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(struct {
+			ClearID string `json:"clear_id"`
+		}{resp.Value.(string)})
+		return
+	}
+
+	resp := channels.ClearRequest(GetCaller(r))
 
 	if resp.Error != nil {
 		helpers.HandleError(w, resp.Error)
@@ -190,7 +258,18 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := channels.DeleteRequest(k)
+	soft, err := GetBoolParam(r, "soft")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var resp channels.Response
+	if soft {
+		resp = channels.SoftDeleteRequest(k, GetCaller(r))
+	} else {
+		resp = channels.DeleteRequest(k, GetCaller(r))
+	}
 
 	if resp.Error != nil {
 		helpers.HandleError(w, resp.Error)
@@ -215,7 +294,7 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := channels.UpdateRequest(k, v)
+	resp := channels.UpdateRequest(k, v, GetCaller(r))
 
 	if resp.Error != nil {
 		helpers.HandleError(w, resp.Error)
@@ -240,7 +319,7 @@ func Upsert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := channels.UpsertRequest(k, v)
+	resp := channels.UpsertRequest(k, v, GetCaller(r))
 
 	if resp.Error != nil {
 		helpers.HandleError(w, resp.Error)
@@ -295,3 +374,84 @@ func GetParam(r *http.Request) (string, []byte, error) {
 	// Return the key, value, and nil error
 	return key, value, nil
 }
+
+// GetBody reads the whole request body, for endpoints that take a value but no ?key=.
+func GetBody(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("error decoding body: %v", err)
+		return nil, fmt.Errorf("failed to decode body: %w", err)
+	}
+
+	if len(value) == 0 {
+		return nil, helpers.MissingValueError
+	}
+	return value, nil
+}
+
+// GetBoolParam returns the boolean query parameter name, or false if it is not set.
+func GetBoolParam(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s: %s", helpers.InvalidParamError, name, err)
+	}
+	return b, nil
+}
+
+// IdentityHeader is the header an authenticating proxy in front of the server sets to the user it
+// authenticated. The server has no authentication of its own, so only set it when every request comes
+// through such a proxy, which must also replace the header on requests that carry one. Empty records
+// no identity.
+var IdentityHeader string
+
+// GetCaller identifies the client behind a request for the audit log, by its address and the identity
+// in IdentityHeader, if configured.
+func GetCaller(r *http.Request) channels.Caller {
+	c := channels.Caller{Addr: r.RemoteAddr}
+	if IdentityHeader != "" {
+		c.Identity = r.Header.Get(IdentityHeader)
+	}
+	return c
+}
+
+// GetDurationParam returns the duration query parameter name, such as "30s", or zero if it is not set.
+func GetDurationParam(r *http.Request, name string) (time.Duration, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %s", helpers.InvalidParamError, name, err)
+	}
+	return d, nil
+}
+
+// GetIntParam returns the integer query parameter name, or zero if it is not set.
+func GetIntParam(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %s", helpers.InvalidParamError, name, err)
+	}
+	return n, nil
+}
+
+// writeJSON writes a 200 response with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,6 +1,8 @@
 package http
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -8,3 +10,20 @@ func TestGetHandler(t *testing.T) {
 
 }
 
+
+func TestGetCaller(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.SetBasicAuth("mallory", "wrong")
+	r.Header.Set("X-Forwarded-User", "ada")
+
+	// Basic auth is never checked, so it is never trusted
+	if c := GetCaller(r); c.Identity != "" || c.Addr != r.RemoteAddr {
+		t.Errorf("GetCaller() without an identity header = %+v, want the address only", c)
+	}
+
+	IdentityHeader = "X-Forwarded-User"
+	defer func() { IdentityHeader = "" }()
+	if c := GetCaller(r); c.Identity != "ada" {
+		t.Errorf("GetCaller() = %+v, want the identity set by the proxy", c)
+	}
+}
@@ -0,0 +1,268 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KeysPath is where the REST API serves the keys of the store, each under KeysPath/<key>.
+const KeysPath = "/v1/keys"
+
+const (
+	// DefaultListLimit and MaxListLimit bound the keys returned by one call to ListKeys.
+	DefaultListLimit = 1000
+	MaxListLimit     = 10000
+)
+
+// RegisterKeys adds the routes of the REST API to mux. A key is the rest of the path after KeysPath,
+// slashes included.
+func RegisterKeys(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+KeysPath, ListKeys)
+	mux.HandleFunc("POST "+KeysPath, CreateKey)
+	mux.HandleFunc("GET "+KeysPath+"/{key...}", GetKey)
+	mux.HandleFunc("HEAD "+KeysPath+"/{key...}", HeadKey)
+	mux.HandleFunc("PUT "+KeysPath+"/{key...}", PutKey)
+	mux.HandleFunc("PATCH "+KeysPath+"/{key...}", PatchKey)
+	mux.HandleFunc("DELETE "+KeysPath+"/{key...}", DeleteKey)
+}
+
+// pathKey returns the key a request is for, from its path.
+func pathKey(r *http.Request) (string, error) {
+	key := r.PathValue("key")
+	if key == "" {
+		return "", helpers.MissingKeyError
+	}
+	return key, nil
+}
+
+// GetKey returns the value of a key, with its metadata if include_meta is set, as Get does.
+func GetKey(w http.ResponseWriter, r *http.Request) {
+	k, err := pathKey(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	includeMeta, err := GetBoolParam(r, "include_meta")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var resp channels.Response
+	if includeMeta {
+		resp = channels.GetWithMetaRequest(k)
+	} else {
+		resp = channels.GetRequest(k)
+	}
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully Received Key: %s", k)
+	writeJSON(w, resp.Value)
+}
+
+// HeadKey answers 200 if a key exists and 404 if not, without a body.
+func HeadKey(w http.ResponseWriter, r *http.Request) {
+	k, err := pathKey(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	if resp := channels.ExistsRequest(k); resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// PutKey stores the body under a key, whether the key exists or not, as Upsert does.
+func PutKey(w http.ResponseWriter, r *http.Request) {
+	writeKey(w, r, "Upserted", channels.UpsertRequest)
+}
+
+// PatchKey replaces the value of an existing key with the body, as Update does.
+func PatchKey(w http.ResponseWriter, r *http.Request) {
+	writeKey(w, r, "Updated", channels.UpdateRequest)
+}
+
+func writeKey(w http.ResponseWriter, r *http.Request, done string, send func(string, []byte, channels.Caller) channels.Response) {
+	k, err := pathKey(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+	v, err := GetBody(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := send(k, v, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully %s key: %s", done, k)
+	writeJSON(w, resp.Value)
+}
+
+// DeleteKey deletes a key, or moves it to the trash if soft is set, and answers 204.
+func DeleteKey(w http.ResponseWriter, r *http.Request) {
+	k, err := pathKey(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	soft, err := GetBoolParam(r, "soft")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var resp channels.Response
+	if soft {
+		resp = channels.SoftDeleteRequest(k, GetCaller(r))
+	} else {
+		resp = channels.DeleteRequest(k, GetCaller(r))
+	}
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully deleted key: %s", k)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newKey is the body of CreateKey.
+type newKey struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// CreateKey adds the key and value given by a body such as {"key": "user:1", "value": {...}}, as Add
+// does, and answers 201 with the URL of the key.
+func CreateKey(w http.ResponseWriter, r *http.Request) {
+	body, err := GetBody(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var nk newKey
+	if err := json.Unmarshal(body, &nk); err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+	switch {
+	case nk.Key == "":
+		helpers.HandleError(w, helpers.MissingKeyError)
+		return
+	case len(nk.Value) == 0:
+		helpers.HandleError(w, helpers.MissingValueError)
+		return
+	}
+
+	resp := channels.AddRequest(nk.Key, nk.Value, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully Added value under key: %s", nk.Key)
+	w.Header().Set("Location", KeysPath+"/"+escapeKey(nk.Key))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp.Value)
+}
+
+// escapeKey escapes a key for the path of its URL. Slashes are kept, as RegisterKeys takes them, but
+// the segments between them are escaped. ServeMux cleans empty, . and .. segments out of a path, so the
+// slashes next to them are escaped too, as are the dots of a key that is only . or ..
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	var b strings.Builder
+	for i, s := range segments {
+		if i > 0 {
+			if cleaned(segments[i-1]) || cleaned(s) {
+				b.WriteString("%2F")
+			} else {
+				b.WriteByte('/')
+			}
+		}
+		b.WriteString(url.PathEscape(s))
+	}
+	if escaped := b.String(); escaped != "." && escaped != ".." {
+		return escaped
+	}
+	return strings.ReplaceAll(key, ".", "%2E")
+}
+
+// cleaned reports whether ServeMux would clean a path segment away.
+func cleaned(segment string) bool {
+	return segment == "" || segment == "." || segment == ".."
+}
+
+// keyPage is a page of ListKeys.
+type keyPage struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"` // Pass as after for the next page; empty on the last one
+}
+
+// ListKeys lists keys in order, as of a snapshot. prefix keeps only the keys starting with it, and
+// after only those after it; at most limit keys are listed.
+func ListKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, after := q.Get("prefix"), q.Get("after")
+	limit, err := GetIntParam(r, "limit")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+	switch {
+	case limit == 0:
+		limit = DefaultListLimit
+	case limit < 0 || limit > MaxListLimit:
+		helpers.HandleError(w, fmt.Errorf("%w: limit must be between 1 and %d", helpers.InvalidParamError, MaxListLimit))
+		return
+	}
+
+	page := keyPage{Keys: []string{}}
+	for k := range channels.SnapshotRequest().ScanAfter(prefix, after) {
+		if len(page.Keys) == limit {
+			page.Next = page.Keys[limit-1]
+			break
+		}
+		page.Keys = append(page.Keys, k)
+	}
+
+	log.Printf("Successfully listed %d keys", len(page.Keys))
+	writeJSON(w, page)
+}
+
+// createKey returns the key in the body of a CreateKey request, putting the body back to be read again.
+func createKey(r *http.Request) string {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var nk newKey
+	json.Unmarshal(body, &nk)
+	return nk.Key
+}
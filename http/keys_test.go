@@ -0,0 +1,175 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"kvstore/channels"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var server *httptest.Server
+
+// TestMain serves the REST API and the routes it sits alongside, backed by the global store and
+// request loop.
+func TestMain(m *testing.M) {
+	go channels.Requests()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(BASE_PATH+"/get", Get)
+	mux.HandleFunc(BASE_PATH+"/upsert", Upsert)
+	RegisterKeys(mux)
+	server = httptest.NewServer(mux)
+
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
+// reset clears the store, so that a test sees only the keys it writes however many times it runs.
+func reset(t *testing.T) {
+	t.Helper()
+	if resp := channels.ClearRequest(channels.Caller{}); resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+}
+
+// send makes a request to the test server and returns its status and body.
+func send(t *testing.T, method, path, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func TestKeys(t *testing.T) {
+	reset(t)
+	tests := []struct {
+		method, path, body string
+		wantStatus         int
+		wantBody           string
+	}{
+		{"POST", KeysPath, `{"key": "rest/a/b", "value": {"n": 1}}`, http.StatusCreated, `{"n":1}`},
+		{"POST", KeysPath, `{"key": "rest/a/b", "value": 2}`, http.StatusBadRequest, "duplicate key"},
+		{"POST", KeysPath, `{"value": 2}`, http.StatusNotFound, "key not provided"},
+		{"POST", KeysPath, `{"key": "rest/x"}`, http.StatusBadRequest, "value not provided"},
+		{"GET", KeysPath + "/rest/a/b", "", http.StatusOK, `{"n":1}`},
+		{"HEAD", KeysPath + "/rest/a/b", "", http.StatusOK, ""},
+		{"HEAD", KeysPath + "/rest/missing", "", http.StatusNotFound, ""},
+		{"PATCH", KeysPath + "/rest/missing", `1`, http.StatusNotFound, "key not found"},
+		{"PATCH", KeysPath + "/rest/a/b", `{"n": 2}`, http.StatusOK, `{"n":2}`},
+		{"PUT", KeysPath + "/rest/c", `"c"`, http.StatusOK, `"c"`},
+		{"PUT", KeysPath + "/rest/c", ``, http.StatusBadRequest, "value not provided"},
+		{"GET", KeysPath + "/rest%2Fd", "", http.StatusNotFound, "key not found"},
+		{"PUT", KeysPath + "/rest/e%20f", `1`, http.StatusOK, `1`},
+		{"GET", KeysPath + "/rest/e%20f?include_meta=true", "", http.StatusOK, `{"value":1,`},
+		{"DELETE", KeysPath + "/rest/c", "", http.StatusNoContent, ""},
+		{"DELETE", KeysPath + "/rest/c", "", http.StatusNotFound, "key not found"},
+		{"POST", KeysPath + "/rest/c", `1`, http.StatusMethodNotAllowed, "Method Not Allowed"},
+
+		// The old routes read and write the same keys
+		{"GET", BASE_PATH + "/get?key=rest/a/b", "", http.StatusOK, `{"n":2}`},
+		{"PUT", BASE_PATH + "/upsert?key=rest/g", `"g"`, http.StatusOK, `"g"`},
+		{"GET", KeysPath + "/rest/g", "", http.StatusOK, `"g"`},
+	}
+	for _, tt := range tests {
+		status, body := send(t, tt.method, tt.path, tt.body)
+		if status != tt.wantStatus || !strings.HasPrefix(body, tt.wantBody) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, status, body, tt.wantStatus, tt.wantBody)
+		}
+	}
+}
+
+func TestCreateLocation(t *testing.T) {
+	reset(t)
+	resp, err := http.Post(server.URL+KeysPath, "application/json", strings.NewReader(`{"key": "loc/a b?", "value": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc := resp.Header.Get("Location")
+	if loc != KeysPath+"/loc/a%20b%3F" {
+		t.Fatalf("Location = %q", loc)
+	}
+	if status, body := send(t, "GET", loc, ""); status != http.StatusOK || body != "1" {
+		t.Errorf("GET Location = %d %q, want the value", status, body)
+	}
+}
+
+// TestCreateLocationCleaned checks that keys whose paths ServeMux would clean get a Location that
+// reaches them.
+func TestCreateLocationCleaned(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"a//b", "a%2F%2Fb"},
+		{"a/../b", "a%2F..%2Fb"},
+		{"a/./b/c", "a%2F.%2Fb/c"},
+		{"/a/", "%2Fa%2F"},
+		{"..", "%2E%2E"},
+		{".", "%2E"},
+	}
+	for _, tt := range tests {
+		reset(t)
+		resp, err := http.Post(server.URL+KeysPath, "application/json", strings.NewReader(`{"key": "`+tt.key+`", "value": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		loc := resp.Header.Get("Location")
+		if loc != KeysPath+"/"+tt.want {
+			t.Errorf("Location of %s = %q, want %q", tt.key, loc, KeysPath+"/"+tt.want)
+			continue
+		}
+		if status, body := send(t, "GET", loc, ""); status != http.StatusOK || body != "1" {
+			t.Errorf("GET Location of %s = %d %q, want the value", tt.key, status, body)
+		}
+	}
+}
+
+func TestListKeys(t *testing.T) {
+	reset(t)
+	var want []string
+	for _, k := range []string{"list/c", "list/a", "list/b/1", "list/b/2", "other"} {
+		send(t, "PUT", KeysPath+"/"+k, `1`)
+		if strings.HasPrefix(k, "list/") {
+			want = append(want, k)
+		}
+	}
+	slices.Sort(want)
+
+	var got []string
+	path := KeysPath + "?prefix=list/&limit=3"
+	for range 3 {
+		status, body := send(t, "GET", path, "")
+		var page keyPage
+		if err := json.Unmarshal([]byte(body), &page); status != http.StatusOK || err != nil {
+			t.Fatalf("GET %s = %d %q", path, status, body)
+		}
+		got = append(got, page.Keys...)
+		if page.Next == "" {
+			break
+		}
+		path = KeysPath + "?prefix=list/&limit=3&after=" + page.Next
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("listed keys = %q, want %q", got, want)
+	}
+
+	if status, _ := send(t, "GET", KeysPath+"?limit=-1", ""); status != http.StatusBadRequest {
+		t.Errorf("GET with a negative limit = %d, want 400", status)
+	}
+}
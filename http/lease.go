@@ -0,0 +1,100 @@
+package http
+
+import (
+	"kvstore/channels"
+	"kvstore/helpers"
+	"log"
+	"net/http"
+)
+
+// AcquireLease takes the lease ?name= for ?owner= with a ?ttl= such as 30s. With ?wait= it blocks
+// up to that long for the current holder to release the lease or let it expire.
+func AcquireLease(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	ttl, err := GetDurationParam(r, "ttl")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	wait, err := GetDurationParam(r, "wait")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	resp := channels.AcquireLeaseRequest(q.Get("name"), q.Get("owner"), ttl, wait, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully acquired lease: %s", q.Get("name"))
+	writeJSON(w, resp.Value)
+}
+
+// RenewLease extends the lease ?name= held by ?owner= by a new ?ttl=.
+func RenewLease(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	ttl, err := GetDurationParam(r, "ttl")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	resp := channels.RenewLeaseRequest(q.Get("name"), q.Get("owner"), ttl, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully renewed lease: %s", q.Get("name"))
+	writeJSON(w, resp.Value)
+}
+
+// ReleaseLease frees the lease ?name= if it is held by ?owner=.
+func ReleaseLease(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	resp := channels.ReleaseLeaseRequest(q.Get("name"), q.Get("owner"), GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully released lease: %s", q.Get("name"))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Lease Released\n"))
+}
+
+// GetLease returns the current holder of the lease ?name=.
+func GetLease(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	resp := channels.GetLeaseRequest(name)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully Received lease: %s", name)
+	writeJSON(w, resp.Value)
+}
@@ -1,8 +1,14 @@
 package http
 
 import (
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/partition"
+	"kvstore/store"
 	"log"
 	"net/http"
+	"strings"
 )
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -12,3 +18,173 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// followerMiddleware sends writes made to a follower on to its leader. Anything but GET and HEAD may
+// change the store, so only those are served locally, along with the replication and anti-entropy
+// endpoints.
+func followerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := channels.Follower
+		if f == nil || r.Method == http.MethodGet || r.Method == http.MethodHead ||
+			strings.HasPrefix(r.URL.Path, BASE_PATH+"/replication") ||
+			strings.HasPrefix(r.URL.Path, BASE_PATH+"/antientropy") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 307 keeps the method and body, so clients that follow redirects simply retry on the leader
+		http.Redirect(w, r, f.Leader+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	})
+}
+
+// clusterMiddleware serves requests on the cluster leader only. Other members redirect them to the
+// leader, or answer 503 while there is none. Reads on the leader wait for a read barrier so they see
+// every write committed before them. Raft RPCs, replication and the status of this member stay local.
+func clusterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		node := channels.Raft
+		if node == nil || strings.HasPrefix(r.URL.Path, RaftPath+"/") ||
+			strings.HasPrefix(r.URL.Path, BASE_PATH+"/replication") ||
+			(r.URL.Path == BASE_PATH+"/cluster" && r.Method == http.MethodGet) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		st := node.Status()
+		if st.Leader != st.ID {
+			if st.LeaderAddr == "" {
+				helpers.HandleError(w, fmt.Errorf("%w: no leader elected", helpers.NotLeaderError))
+				return
+			}
+			http.Redirect(w, r, strings.TrimSuffix(st.LeaderAddr, "/")+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if err := channels.ReadBarrier(r.Context()); err != nil {
+				helpers.HandleError(w, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// partitionMiddleware routes requests in a partitioned cluster. A request for a key, given as a parameter
+// or by the path of the REST API, or for a named queue, stream or lease, is forwarded to the server
+// owning it. A dead-letter queue is owned along with its queue. Requests spanning the key space are
+// gathered from every server. Forwarded requests and the partitioning endpoints are served locally, and
+// a read that finds nothing there is sent on to the previous owner, which may still be handing it over.
+func partitionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := channels.Partitions
+		if p == nil || strings.HasPrefix(r.URL.Path, BASE_PATH+"/partition") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		q := r.URL.Query()
+		key := q.Get("key")
+		if key == "" {
+			key = q.Get("name")
+			if strings.HasPrefix(r.URL.Path, BASE_PATH+"/queue/") {
+				key = strings.TrimSuffix(key, store.DeadLetterSuffix)
+			}
+		}
+		if k, ok := strings.CutPrefix(r.URL.Path, KeysPath+"/"); ok && key == "" {
+			key = k
+		}
+
+		if r.Header.Get(partition.ForwardedHeader) != "" {
+			serveWithFallback(w, r, next, key)
+			return
+		}
+
+		if r.URL.Path == KeysPath && r.Method == http.MethodPost {
+			// A create without a key fails the same on any server
+			if key = createKey(r); key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if key == "" {
+			if r.URL.Path == BASE_PATH+"/export" {
+				gatherExport(w, r)
+				return
+			}
+			if merge, ok := gathered[r.URL.Path]; ok {
+				gather(w, r, merge)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		owner, err := p.Owner(key)
+		if err != nil {
+			helpers.HandleError(w, partitionError(err))
+			return
+		}
+		if owner.ID != p.Self {
+			p.Forward(w, r, owner)
+			return
+		}
+		serveWithFallback(w, r, next, key)
+	})
+}
+
+// serveWithFallback serves a request locally. A read of key that is not found here is sent on to the
+// previous owner of key, unless it came from there already.
+func serveWithFallback(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	p := channels.Partitions
+	prev, ok := p.PreviousOwner(key)
+	if key == "" || !ok || r.Header.Get(partition.FallbackHeader) != "" ||
+		(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	fw := &fallbackWriter{ResponseWriter: w}
+	next.ServeHTTP(fw, r)
+	if !fw.notFound {
+		return
+	}
+
+	// Drop the headers of the 404, the previous owner sends its own
+	clear(w.Header())
+	r.Header.Set(partition.FallbackHeader, p.Self)
+	p.Forward(w, r, prev)
+}
+
+// fallbackWriter passes a response through unless its status is 404, which it holds back.
+type fallbackWriter struct {
+	http.ResponseWriter
+	notFound bool
+	written  bool
+}
+
+func (fw *fallbackWriter) WriteHeader(code int) {
+	if !fw.written && code == http.StatusNotFound {
+		fw.notFound = true
+	}
+	fw.written = true
+	if !fw.notFound {
+		fw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (fw *fallbackWriter) Write(b []byte) (int, error) {
+	if !fw.written {
+		fw.WriteHeader(http.StatusOK)
+	}
+	if fw.notFound {
+		return len(b), nil
+	}
+	return fw.ResponseWriter.Write(b)
+}
+
+func (fw *fallbackWriter) Flush() {
+	if f, ok := fw.ResponseWriter.(http.Flusher); ok && !fw.notFound {
+		f.Flush()
+	}
+}
@@ -0,0 +1,405 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/partition"
+	"kvstore/store"
+	"kvstore/transfer"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// gatherTimeout caps how long a request spanning the key space waits for every server.
+const gatherTimeout = 30 * time.Second
+
+// gathered lists the endpoints that span the key space. In a partitioned cluster they are sent to every
+// server and the responses merged. Exports are streamed instead, see gatherExport.
+var gathered = map[string]func(r *http.Request, bodies [][]byte) ([]byte, error){
+	BASE_PATH + "/get_all":     unionJSON,
+	BASE_PATH + "/queue/stats": listJSON(byName),
+	BASE_PATH + "/trash":       listJSON(byDeletedAt),
+	BASE_PATH + "/count":       sumJSON,
+	BASE_PATH + "/tags/find":   sumJSON,
+	BASE_PATH + "/tags/count":  sumJSON,
+	BASE_PATH + "/tags/delete": sumJSON,
+	BASE_PATH + "/trash/purge": sumJSON,
+	BASE_PATH + "/clear":       firstBody,
+	KeysPath:                   mergeKeyPages,
+}
+
+// PartitionStatus returns the membership as seen by this server and the progress of rebalancing.
+func PartitionStatus(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	writeJSON(w, channels.Partitions.Status())
+}
+
+// PartitionNodes adds (POST) or removes (DELETE) the server given by the id parameter. Adding a server
+// also needs its base URL in the addr parameter. The new membership is announced to every server.
+func PartitionNodes(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		helpers.HandleError(w, fmt.Errorf("%w: id not provided", helpers.InvalidParamError))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), gatherTimeout)
+	defer cancel()
+
+	var m partition.Membership
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		addr := r.URL.Query().Get("addr")
+		if addr == "" {
+			helpers.HandleError(w, fmt.Errorf("%w: addr not provided", helpers.InvalidParamError))
+			return
+		}
+		m, err = channels.Partitions.Join(ctx, partition.Node{ID: id, Addr: addr})
+	case http.MethodDelete:
+		m, err = channels.Partitions.Leave(ctx, id)
+	default:
+		err = helpers.MethodNotAllowed
+	}
+	if err != nil {
+		helpers.HandleError(w, partitionError(err))
+		return
+	}
+
+	log.Printf("Successfully changed partition membership to version %d", m.Version)
+	writeJSON(w, m)
+}
+
+// PartitionRing takes a new membership announced by another server.
+func PartitionRing(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPut); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var m partition.Membership
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	channels.Partitions.SetMembership(m)
+	writeJSON(w, channels.Partitions.Membership())
+}
+
+// PartitionReceive takes keys and other state handed over by another server while rebalancing.
+func PartitionReceive(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var b partition.Batch
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	resp := channels.PartitionReceiveRequest(b)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully received %d of %d keys and other state from %s", resp.Value, len(b.Items)+len(b.Changes), b.From)
+	writeJSON(w, struct {
+		Received int `json:"received"`
+	}{resp.Value.(int)})
+}
+
+// gather serves a request spanning the key space by sending it to every server and merging the results.
+func gather(w http.ResponseWriter, r *http.Request, merge func(*http.Request, [][]byte) ([]byte, error)) {
+	// Every server numbers its soft clears on its own, so there is no single ID to restore them by
+	if soft, _ := GetBoolParam(r, "soft"); soft && r.URL.Path == BASE_PATH+"/clear" {
+		helpers.HandleError(w, fmt.Errorf("%w: soft clear is not supported in a partitioned cluster", helpers.InvalidParamError))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), gatherTimeout)
+	defer cancel()
+
+	bodies, err := channels.Partitions.Gather(ctx, r)
+	var se *partition.StatusError
+	if errors.As(err, &se) {
+		// Every server answers alike, so the first error stands for all of them
+		log.Printf("Partition Error: %s", err)
+		http.Error(w, se.Message, se.Code)
+		return
+	}
+	if err != nil {
+		helpers.HandleError(w, partitionError(err))
+		return
+	}
+
+	body, err := merge(r, bodies)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// gatherExport serves an export in a partitioned cluster. The export of every server is streamed in
+// turn, keeping only the first CSV header, so no more than a buffer of it is held in memory.
+func gatherExport(w http.ResponseWriter, r *http.Request) {
+	format, err := transfer.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	resps, err := channels.Partitions.Open(r.Context(), r)
+	var se *partition.StatusError
+	if errors.As(err, &se) {
+		log.Printf("Partition Error: %s", err)
+		http.Error(w, se.Message, se.Code)
+		return
+	}
+	if err != nil {
+		helpers.HandleError(w, partitionError(err))
+		return
+	}
+	defer func() {
+		for _, resp := range resps {
+			resp.Body.Close()
+		}
+	}()
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.WriteHeader(http.StatusOK)
+	for i, resp := range resps {
+		body := bufio.NewReader(resp.Body)
+		if format == transfer.CSV && i > 0 {
+			body.ReadString('\n')
+		}
+		if _, err := io.Copy(w, body); err != nil {
+			// The status is sent, so cut the response short rather than end it as if it were complete
+			log.Printf("Partition Error: streaming the export: %s", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// unionJSON merges JSON objects whose keys do not overlap.
+func unionJSON(_ *http.Request, bodies [][]byte) ([]byte, error) {
+	union := make(map[string]json.RawMessage)
+	for _, b := range bodies {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			if _, ok := union[k]; !ok {
+				union[k] = v
+			}
+		}
+	}
+	return marshalLine(union)
+}
+
+// sumJSON adds up numbers and concatenates lists, recursing into objects, so counts are summed and
+// lists of keys combined.
+func sumJSON(_ *http.Request, bodies [][]byte) ([]byte, error) {
+	var total any
+	for _, b := range bodies {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		var v any
+		if err := d.Decode(&v); err != nil {
+			return nil, err
+		}
+		total = sum(total, v)
+	}
+	return marshalLine(total)
+}
+
+func sum(a, b any) any {
+	switch b := b.(type) {
+	case json.Number:
+		a, ok := a.(json.Number)
+		if !ok {
+			break
+		}
+		if x, err := a.Int64(); err == nil {
+			if y, err := b.Int64(); err == nil {
+				return json.Number(fmt.Sprint(x + y))
+			}
+		}
+		x, _ := a.Float64()
+		y, _ := b.Float64()
+		return json.Number(fmt.Sprint(x + y))
+	case []any:
+		a, ok := a.([]any)
+		if !ok {
+			break
+		}
+		list := append(a, b...)
+		// Lists of keys are sorted on every server, and stay sorted when combined
+		if !slices.ContainsFunc(list, func(v any) bool { _, ok := v.(string); return !ok }) {
+			slices.SortFunc(list, func(x, y any) int { return strings.Compare(x.(string), y.(string)) })
+		}
+		return list
+	case map[string]any:
+		a, ok := a.(map[string]any)
+		if !ok {
+			break
+		}
+		for k, v := range b {
+			a[k] = sum(a[k], v)
+		}
+		return a
+	}
+	if a == nil {
+		return b
+	}
+	return a
+}
+
+// listJSON joins JSON lists of objects and sorts the result the way a single server would.
+func listJSON(compare func(a, b map[string]any) int) func(*http.Request, [][]byte) ([]byte, error) {
+	return func(_ *http.Request, bodies [][]byte) ([]byte, error) {
+		list := []map[string]any{}
+		for _, b := range bodies {
+			var l []map[string]any
+			if err := json.Unmarshal(b, &l); err != nil {
+				return nil, err
+			}
+			list = append(list, l...)
+		}
+		slices.SortStableFunc(list, compare)
+		return marshalLine(list)
+	}
+}
+
+// byName orders queue stats by queue name.
+func byName(a, b map[string]any) int {
+	return strings.Compare(fmt.Sprint(a["name"]), fmt.Sprint(b["name"]))
+}
+
+// byDeletedAt orders trash entries newest first, then by key.
+func byDeletedAt(a, b map[string]any) int {
+	x, _ := time.Parse(time.RFC3339Nano, fmt.Sprint(a["deleted_at"]))
+	y, _ := time.Parse(time.RFC3339Nano, fmt.Sprint(b["deleted_at"]))
+	if c := y.Compare(x); c != 0 {
+		return c
+	}
+	return strings.Compare(fmt.Sprint(a["key"]), fmt.Sprint(b["key"]))
+}
+
+// mergeKeyPages joins the pages of ListKeys from every server into one page of the limit asked for.
+// Each server lists the keys after the same one, so the first limit keys of their union are the page.
+func mergeKeyPages(r *http.Request, bodies [][]byte) ([]byte, error) {
+	limit, _ := GetIntParam(r, "limit")
+	if limit == 0 {
+		limit = DefaultListLimit
+	}
+
+	merged := keyPage{Keys: []string{}}
+	var more bool
+	for _, b := range bodies {
+		var page keyPage
+		if err := json.Unmarshal(b, &page); err != nil {
+			return nil, err
+		}
+		merged.Keys = append(merged.Keys, page.Keys...)
+		more = more || page.Next != ""
+	}
+	slices.Sort(merged.Keys)
+	merged.Keys = slices.Compact(merged.Keys)
+	if len(merged.Keys) > limit {
+		merged.Keys, more = merged.Keys[:limit], true
+	}
+	if more && len(merged.Keys) > 0 {
+		merged.Next = merged.Keys[len(merged.Keys)-1]
+	}
+	return marshalLine(merged)
+}
+
+// firstBody answers with the response of the first server, for requests every server answers alike.
+func firstBody(_ *http.Request, bodies [][]byte) ([]byte, error) {
+	return bodies[0], nil
+}
+
+func marshalLine(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	return append(b, '\n'), err
+}
+
+// partitionImport wraps the function storing imported records so records owned by another server
+// are sent there, one record per request.
+func partitionImport(r *http.Request, local transfer.ApplyFunc) transfer.ApplyFunc {
+	return func(rec transfer.Record, opts store.ImportOptions) (store.ImportResult, error) {
+		p := channels.Partitions
+		owner, err := p.Owner(rec.Key)
+		if err != nil {
+			return "", partitionError(err)
+		}
+		if owner.ID == p.Self {
+			return local(rec, opts)
+		}
+
+		var body bytes.Buffer
+		out := transfer.NewWriter(&body, transfer.NDJSON)
+		if err := out.Write(rec); err != nil {
+			return "", err
+		}
+		out.Flush()
+
+		q := url.Values{"mode": {string(opts.Mode)}, "dry_run": {fmt.Sprint(opts.DryRun)}}
+		resp, err := p.Do(r.Context(), owner, http.MethodPost, BASE_PATH+"/import?"+q.Encode(), body.Bytes())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		var summary transfer.Summary
+		if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+			return "", fmt.Errorf("importing on %s: %s", owner.ID, resp.Status)
+		}
+		switch {
+		case summary.Added > 0:
+			return store.ImportAdded, nil
+		case summary.Overwritten > 0:
+			return store.ImportOverwritten, nil
+		case summary.Skipped > 0:
+			return store.ImportSkipped, nil
+		case len(summary.Errors) > 0:
+			return "", errors.New(strings.TrimPrefix(summary.Errors[0], rec.Key+": "))
+		}
+		return "", fmt.Errorf("importing on %s: %s", owner.ID, resp.Status)
+	}
+}
+
+// partitionError maps errors from the partitioned cluster onto the errors the handlers know how to report.
+func partitionError(err error) error {
+	switch {
+	case errors.Is(err, partition.ErrNoOwner):
+		return fmt.Errorf("%w: %v", helpers.NoOwnerError, err)
+	case errors.Is(err, partition.ErrInvalidMember):
+		return fmt.Errorf("%w: %v", helpers.InvalidParamError, err)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"kvstore/channels"
+	"kvstore/partition"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPartitionReadFallback(t *testing.T) {
+	reset(t)
+
+	// The previous owner of every key still holds "moving"
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(partition.FallbackHeader) != "n1" || r.URL.Query().Get("key") != "moving" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `"from n0"`)
+	}))
+	defer old.Close()
+
+	c := partition.NewCluster("n1", partition.Membership{Version: 1, Nodes: []partition.Node{{ID: "n0", Addr: old.URL}}})
+	c.SetMembership(partition.Membership{Version: 2, Nodes: []partition.Node{{ID: "n1", Addr: "http://n1"}}})
+	channels.Partitions = c
+	defer func() { channels.Partitions = nil }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(BASE_PATH+"/get", Get)
+	srv := httptest.NewServer(partitionMiddleware(mux))
+	defer srv.Close()
+	channels.UpsertRequest("here", []byte(`"local"`), channels.Caller{})
+
+	tests := []struct {
+		key    string
+		status int
+		body   string
+	}{
+		{"here", http.StatusOK, `"local"`},
+		{"moving", http.StatusOK, `"from n0"`},
+		{"missing", http.StatusNotFound, "404 page not found"},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + BASE_PATH + "/get?key=" + tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if body := strings.TrimSpace(string(b)); resp.StatusCode != tt.status || body != tt.body {
+			t.Errorf("GET %s = %d %s, want %d %s", tt.key, resp.StatusCode, body, tt.status, tt.body)
+		}
+	}
+}
+
+func TestPartitionExportStreamsEveryServer(t *testing.T) {
+	reset(t)
+	channels.UpsertRequest("a", []byte(`1`), channels.Caller{})
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "key,value,metadata\nb,2,\n")
+	}))
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(BASE_PATH+"/export", Export)
+	srv := httptest.NewServer(partitionMiddleware(mux))
+	defer srv.Close()
+
+	nodes := []partition.Node{{ID: "n1", Addr: srv.URL}, {ID: "n2", Addr: other.URL}}
+	channels.Partitions = partition.NewCluster("n1", partition.Membership{Version: 1, Nodes: nodes})
+	defer func() { channels.Partitions = nil }()
+
+	resp, err := http.Get(srv.URL + BASE_PATH + "/export?format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "key,") || !strings.HasPrefix(lines[1], "a,1,") || lines[2] != "b,2," {
+		t.Errorf("export = %q, want one header and a row from each server", b)
+	}
+}
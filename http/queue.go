@@ -0,0 +1,118 @@
+package http
+
+import (
+	"kvstore/channels"
+	"kvstore/helpers"
+	"log"
+	"kvstore/store"
+	"net/http"
+)
+
+// DefaultVisibility is how long a dequeued message stays hidden when ?visibility= is not given.
+const DefaultVisibility = store.DefaultVisibility
+
+// Enqueue appends the JSON body to the queue ?name=.
+func Enqueue(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	v, err := GetBody(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.EnqueueRequest(name, v, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully enqueued to queue: %s", name)
+	writeJSON(w, resp.Value)
+}
+
+// Dequeue takes the oldest message from the queue ?name= and hides it for ?visibility=.
+func Dequeue(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	visibility, err := GetDurationParam(r, "visibility")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+	if visibility == 0 {
+		visibility = DefaultVisibility
+	}
+
+	name := r.URL.Query().Get("name")
+	resp := channels.DequeueRequest(name, visibility, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully dequeued from queue: %s", name)
+	writeJSON(w, resp.Value)
+}
+
+// Ack confirms the message with ?receipt= from the queue ?name= has been processed.
+func Ack(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	resp := channels.AckRequest(q.Get("name"), q.Get("receipt"), GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully acked message in queue: %s", q.Get("name"))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Message Acked\n"))
+}
+
+// Nack returns the message with ?receipt= to the queue ?name= for another delivery.
+func Nack(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	resp := channels.NackRequest(q.Get("name"), q.Get("receipt"), GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully nacked message in queue: %s", q.Get("name"))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Message Nacked\n"))
+}
+
+// QueueStats returns the depth, in-flight and dead-letter counts of the queue ?name=, or of every queue.
+func QueueStats(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.QueueStatsRequest(r.URL.Query().Get("name"))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully retrieved queue stats")
+	writeJSON(w, resp.Value)
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/store"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// RateLimit takes ?cost= tokens (default 1) from the rate limit of ?key=, which holds ?capacity= tokens
+// refilled at ?rate= per second using ?algorithm=. Allowed requests get a 200, denied ones a 429 with a
+// Retry-After header; both carry the decision as JSON.
+func RateLimit(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	algorithm, err := store.ParseRateAlgorithm(q.Get("algorithm"))
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	capacity, err := GetIntParam(r, "capacity")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	cost, err := GetIntParam(r, "cost")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	rate, err := strconv.ParseFloat(q.Get("rate"), 64)
+	if err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: rate: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	limit := store.RateLimit{Algorithm: algorithm, Capacity: capacity, Rate: rate, Cost: cost}
+	resp := channels.RateLimitRequest(q.Get("key"), limit)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	res := resp.Value.(store.RateLimitResult)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	if res.Allowed {
+		writeJSON(w, res)
+		return
+	}
+
+	log.Printf("Rate limit exceeded for key: %s", q.Get("key"))
+	w.Header().Set("Retry-After", strconv.FormatInt((res.RetryAfterMs+999)/1000, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(res)
+}
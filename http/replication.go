@@ -0,0 +1,52 @@
+package http
+
+import (
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/replication"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ReplicationStatus returns the role of this server and, on a follower, how far it lags its leader.
+func ReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	if channels.Follower != nil {
+		writeJSON(w, channels.Follower.Status())
+		return
+	}
+	writeJSON(w, channels.Leader.Status())
+}
+
+// ReplicationStream sends a follower a snapshot of the store followed by every change made to it,
+// as newline delimited JSON, until the follower disconnects.
+func ReplicationStream(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.SubscribeRequest(GetCaller(r))
+	sub := resp.Value.(*replication.Subscription)
+	log.Printf("Follower connected: %s", sub.Addr)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	err := channels.Leader.Serve(r.Context(), w, func() { rc.Flush() }, sub)
+	if err != nil {
+		log.Printf("Replication Error: %s", err)
+	}
+	log.Printf("Follower disconnected: %s", sub.Addr)
+}
@@ -3,6 +3,11 @@ package http
 
 import (
 	"context"
+	"kvstore/antientropy"
+	"kvstore/channels"
+	"kvstore/crdt"
+	"kvstore/partition"
+	"kvstore/replication"
 	"log"
 	"net/http"
 	_ "net/http/pprof" // Import pprof for profiling
@@ -17,11 +22,18 @@ const (
 	// Main server port
 )
 
+// RaftPath is where the Raft RPCs of a cluster member are served.
+const RaftPath = BASE_PATH + "/raft"
+
+// Addr is the address the server listens on, PORT unless set otherwise before StartServer.
+var Addr = PORT
+
 // StartServer creates an HTTP server that prints path and exposes pprof.
 func StartServer(serverStarted chan struct{}, done chan bool) {
 	// Handlers
 	http.HandleFunc(BASE_PATH+"/ping", Ping)
 	http.HandleFunc(BASE_PATH+"/get", Get)
+	http.HandleFunc(BASE_PATH+"/meta", Meta)
 	http.HandleFunc(BASE_PATH+"/add", Add)
 	http.HandleFunc(BASE_PATH+"/get_all", GetAll)
 	http.HandleFunc(BASE_PATH+"/exists", Exists)
@@ -30,17 +42,86 @@ func StartServer(serverStarted chan struct{}, done chan bool) {
 	http.HandleFunc(BASE_PATH+"/delete", Delete)
 	http.HandleFunc(BASE_PATH+"/update", Update)
 	http.HandleFunc(BASE_PATH+"/upsert", Upsert)
+	http.HandleFunc(BASE_PATH+"/export", Export)
+	http.HandleFunc(BASE_PATH+"/import", Import)
+	http.HandleFunc(BASE_PATH+"/trash", Trash)
+	http.HandleFunc(BASE_PATH+"/trash/restore", Restore)
+	http.HandleFunc(BASE_PATH+"/trash/purge", Purge)
+	http.HandleFunc(BASE_PATH+"/audit", Audit)
+	http.HandleFunc(BASE_PATH+"/tags", Tags)
+	http.HandleFunc(BASE_PATH+"/tags/set", SetTags)
+	http.HandleFunc(BASE_PATH+"/tags/remove", RemoveTags)
+	http.HandleFunc(BASE_PATH+"/tags/find", FindByTags)
+	http.HandleFunc(BASE_PATH+"/tags/count", CountByTags)
+	http.HandleFunc(BASE_PATH+"/tags/delete", DeleteByTags)
+	http.HandleFunc(BASE_PATH+"/lease", GetLease)
+	http.HandleFunc(BASE_PATH+"/lease/acquire", AcquireLease)
+	http.HandleFunc(BASE_PATH+"/lease/renew", RenewLease)
+	http.HandleFunc(BASE_PATH+"/lease/release", ReleaseLease)
+	http.HandleFunc(BASE_PATH+"/queue/enqueue", Enqueue)
+	http.HandleFunc(BASE_PATH+"/queue/dequeue", Dequeue)
+	http.HandleFunc(BASE_PATH+"/queue/ack", Ack)
+	http.HandleFunc(BASE_PATH+"/queue/nack", Nack)
+	http.HandleFunc(BASE_PATH+"/queue/stats", QueueStats)
+	http.HandleFunc(BASE_PATH+"/stream", StreamInfo)
+	http.HandleFunc(BASE_PATH+"/stream/add", StreamAdd)
+	http.HandleFunc(BASE_PATH+"/stream/range", StreamRange)
+	http.HandleFunc(BASE_PATH+"/stream/read", StreamRead)
+	http.HandleFunc(BASE_PATH+"/stream/tail", StreamTail)
+	http.HandleFunc(BASE_PATH+"/stream/trim", StreamTrim)
+	http.HandleFunc(BASE_PATH+"/stream/group/create", CreateGroup)
+	http.HandleFunc(BASE_PATH+"/stream/group/read", ReadGroup)
+	http.HandleFunc(BASE_PATH+"/stream/group/ack", StreamAck)
+	http.HandleFunc(BASE_PATH+"/stream/group/pending", Pending)
+	http.HandleFunc(BASE_PATH+"/stream/group/claim", Claim)
+	http.HandleFunc(BASE_PATH+"/ratelimit", RateLimit)
+	http.HandleFunc(BASE_PATH+"/replication", ReplicationStatus)
+	http.HandleFunc(replication.StreamPath, ReplicationStream)
+
+	// REST API, served alongside the routes above while clients move over to it
+	RegisterKeys(http.DefaultServeMux)
+
+	if channels.AntiEntropy != nil {
+		http.HandleFunc(BASE_PATH+"/antientropy", AntiEntropyStatus)
+		http.HandleFunc(BASE_PATH+"/antientropy/repair", AntiEntropyRepair)
+		http.HandleFunc(antientropy.TreePath, AntiEntropyTree)
+		http.HandleFunc(antientropy.KeysPath, AntiEntropyKeys)
+		http.HandleFunc(antientropy.ApplyPath, AntiEntropyApply)
+	}
+	if channels.Raft != nil {
+		http.HandleFunc(BASE_PATH+"/cluster", ClusterStatus)
+		http.HandleFunc(BASE_PATH+"/cluster/members", ClusterMembers)
+		http.Handle(RaftPath+"/", http.StripPrefix(RaftPath, channels.Raft.Handler()))
+	}
+	if channels.Partitions != nil {
+		http.HandleFunc(BASE_PATH+"/partition", PartitionStatus)
+		http.HandleFunc(BASE_PATH+"/partition/nodes", PartitionNodes)
+		http.HandleFunc(partition.RingPath, PartitionRing)
+		http.HandleFunc(partition.ReceivePath, PartitionReceive)
+	}
+	if channels.Sites != nil {
+		http.HandleFunc(BASE_PATH+"/crdt", CRDTGet)
+		http.HandleFunc(BASE_PATH+"/crdt/declare", CRDTDeclare)
+		http.HandleFunc(BASE_PATH+"/crdt/set", CRDTSet)
+		http.HandleFunc(BASE_PATH+"/crdt/increment", CRDTIncrement)
+		http.HandleFunc(BASE_PATH+"/crdt/add", CRDTAdd)
+		http.HandleFunc(BASE_PATH+"/crdt/remove", CRDTRemove)
+		http.HandleFunc(BASE_PATH+"/crdt/put", CRDTPut)
+		http.HandleFunc(BASE_PATH+"/crdt/delete", CRDTDelete)
+		http.HandleFunc(BASE_PATH+"/crdt/sites", CRDTSites)
+		http.HandleFunc(crdt.MergePath, CRDTMerge)
+	}
 
 	// Main server
 	s := http.Server{
-		Addr:         PORT,
-		Handler:      http.DefaultServeMux, // Use DefaultServeMux
+		Addr:         Addr,
+		Handler:      partitionMiddleware(clusterMiddleware(followerMiddleware(http.DefaultServeMux))), // Use DefaultServeMux
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
 
 	go func() {
-		log.Printf("KV Store Listening at %s", PORT)
+		log.Printf("KV Store Listening at %s", Addr)
 		close(serverStarted) // Signal that the server has started
 		log.Fatal(s.ListenAndServe())
 	}()
@@ -0,0 +1,284 @@
+package http
+
+import (
+	"kvstore/channels"
+	"kvstore/helpers"
+	"log"
+	"net/http"
+)
+
+// StreamAdd appends the JSON body to the stream ?name=. With ?maxlen= the stream is trimmed to that
+// many entries afterwards.
+func StreamAdd(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	maxLen, err := GetIntParam(r, "maxlen")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	v, err := GetBody(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.StreamAddRequest(name, v, maxLen, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully added to stream: %s", name)
+	writeJSON(w, resp.Value)
+}
+
+// StreamRange returns up to ?count= entries of the stream ?name= with IDs from ?start= to ?end=, both
+// inclusive. They default to "-" and "+", the whole stream.
+func StreamRange(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	count, err := GetIntParam(r, "count")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	start, end := q.Get("start"), q.Get("end")
+	if start == "" {
+		start = "-"
+	}
+	if end == "" {
+		end = "+"
+	}
+
+	resp := channels.StreamRangeRequest(q.Get("name"), start, end, count)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully read range of stream: %s", q.Get("name"))
+	writeJSON(w, resp.Value)
+}
+
+// StreamRead returns up to ?count= entries of the stream ?name= added after the ID ?after=, which
+// defaults to "$", the current end. With ?wait= it blocks up to that long for new entries.
+func StreamRead(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	count, err := GetIntParam(r, "count")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	wait, err := GetDurationParam(r, "wait")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	after := q.Get("after")
+	if after == "" {
+		after = "$"
+	}
+
+	resp := channels.StreamReadRequest(q.Get("name"), after, count, wait)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully read stream: %s", q.Get("name"))
+	writeJSON(w, resp.Value)
+}
+
+// StreamTail returns the last ?count= entries of the stream ?name=, or all of them.
+func StreamTail(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	count, err := GetIntParam(r, "count")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	resp := channels.StreamTailRequest(name, count)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully read tail of stream: %s", name)
+	writeJSON(w, resp.Value)
+}
+
+// StreamTrim drops the oldest entries of the stream ?name= beyond ?maxlen=.
+func StreamTrim(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	maxLen, err := GetIntParam(r, "maxlen")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	resp := channels.StreamTrimRequest(name, maxLen, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully trimmed stream: %s", name)
+	writeJSON(w, map[string]any{"trimmed": resp.Value})
+}
+
+// StreamInfo returns the length, ID range and consumer groups of the stream ?name=.
+func StreamInfo(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	resp := channels.StreamInfoRequest(name)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully retrieved info of stream: %s", name)
+	writeJSON(w, resp.Value)
+}
+
+// CreateGroup adds the consumer group ?group= to the stream ?name=. It starts after the ID ?start=,
+// "0" for the whole stream or "$" (the default) for new entries only.
+func CreateGroup(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	start := q.Get("start")
+	if start == "" {
+		start = "$"
+	}
+
+	resp := channels.CreateGroupRequest(q.Get("name"), q.Get("group"), start, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully created group %s on stream: %s", q.Get("group"), q.Get("name"))
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("Group Created\n"))
+}
+
+// ReadGroup delivers up to ?count= new entries of the stream ?name= to ?consumer= of ?group=.
+func ReadGroup(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	count, err := GetIntParam(r, "count")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	resp := channels.ReadGroupRequest(q.Get("name"), q.Get("group"), q.Get("consumer"), count, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully read group %s of stream: %s", q.Get("group"), q.Get("name"))
+	writeJSON(w, resp.Value)
+}
+
+// StreamAck acknowledges the entries ?id= (repeatable) delivered to ?group= of the stream ?name=.
+func StreamAck(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	resp := channels.StreamAckRequest(q.Get("name"), q.Get("group"), q["id"], GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully acked entries of stream: %s", q.Get("name"))
+	writeJSON(w, map[string]any{"acked": resp.Value})
+}
+
+// Pending lists the entries delivered to ?group= of the stream ?name= that have not been acknowledged.
+func Pending(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	resp := channels.PendingRequest(q.Get("name"), q.Get("group"))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully retrieved pending entries of stream: %s", q.Get("name"))
+	writeJSON(w, resp.Value)
+}
+
+// Claim hands the pending entries ?id= (repeatable, all if omitted) of ?group= that have been idle for
+// ?min_idle= over to ?consumer=.
+func Claim(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	minIdle, err := GetDurationParam(r, "min_idle")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	resp := channels.ClaimRequest(q.Get("name"), q.Get("group"), q.Get("consumer"), minIdle, q["id"], GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully claimed entries of stream: %s", q.Get("name"))
+	writeJSON(w, resp.Value)
+}
@@ -0,0 +1,176 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/store"
+	"log"
+	"net/http"
+)
+
+// Tags returns the tags of a key.
+func Tags(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	k, _, err := GetParam(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.TagsRequest(k)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully Received Tags for Key: %s", k)
+	writeJSON(w, resp.Value)
+}
+
+// SetTags adds the tags in the JSON object body to a key.
+func SetTags(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	k, v, err := GetParam(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	var tags map[string]string
+	if err := json.Unmarshal(v, &tags); err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: tags must be a JSON object of strings: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	resp := channels.SetTagsRequest(k, tags, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully Set Tags for Key: %s", k)
+	writeJSON(w, resp.Value)
+}
+
+// RemoveTags removes the tags named by ?name= from a key.
+func RemoveTags(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodDelete); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	k, _, err := GetParam(r)
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	names := r.Form["name"]
+	if len(names) == 0 {
+		helpers.HandleError(w, fmt.Errorf("%w: no tag names provided", helpers.InvalidParamError))
+		return
+	}
+
+	resp := channels.RemoveTagsRequest(k, names, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully Removed Tags for Key: %s", k)
+	writeJSON(w, resp.Value)
+}
+
+// FindByTags lists the keys matching ?selector=.
+func FindByTags(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	sel, err := store.ParseTagSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.FindByTagsRequest(sel)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully Found keys by tag")
+	writeJSON(w, resp.Value)
+}
+
+// CountByTags counts the keys matching ?selector=.
+func CountByTags(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	sel, err := store.ParseTagSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.CountByTagsRequest(sel)
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully Counted keys by tag")
+	writeJSON(w, resp.Value)
+}
+
+// DeleteByTags deletes every key matching ?selector=, moving them to the trash with ?soft=true.
+func DeleteByTags(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodDelete); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	sel, err := store.ParseTagSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	soft, err := GetBoolParam(r, "soft")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.DeleteByTagsRequest(sel, soft, GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	entries := resp.Value.([]store.Entry)
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+	}
+
+	log.Printf("Successfully Deleted %d keys by tag", len(keys))
+	writeJSON(w, struct {
+		Deleted int      `json:"deleted"`
+		Keys    []string `json:"keys"`
+	}{len(keys), keys})
+}
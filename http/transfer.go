@@ -0,0 +1,92 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/store"
+	"kvstore/transfer"
+	"log"
+	"net/http"
+)
+
+// Export streams every key (or every key under ?prefix=) as NDJSON or CSV.
+func Export(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	format, err := transfer.ParseFormat(q.Get("format"))
+	if err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	items := channels.ExportRequest(q.Get("prefix"))
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.WriteHeader(http.StatusOK)
+
+	n, err := transfer.Export(transfer.NewWriter(w, format), items)
+	if err != nil {
+		log.Printf("Export Error: %s", err)
+		return
+	}
+	log.Printf("Successfully exported %d keys", n)
+}
+
+// Import streams NDJSON or CSV records from the body into the store and returns a summary.
+func Import(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	q := r.URL.Query()
+	format, err := transfer.ParseFormat(q.Get("format"))
+	if err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	mode, err := store.ParseConflictMode(q.Get("mode"))
+	if err != nil {
+		helpers.HandleError(w, fmt.Errorf("%w: %s", helpers.InvalidParamError, err))
+		return
+	}
+
+	dryRun, err := GetBoolParam(r, "dry_run")
+	if err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	opts := store.ImportOptions{Mode: mode, DryRun: dryRun}
+	var apply transfer.ApplyFunc = func(rec transfer.Record, opts store.ImportOptions) (store.ImportResult, error) {
+		resp := channels.ImportRequest(rec.Key, rec.Value, opts, GetCaller(r))
+		if resp.Error != nil {
+			return "", resp.Error
+		}
+		return resp.Value.(store.ImportResult), nil
+	}
+	if channels.Partitions != nil {
+		apply = partitionImport(r, apply)
+	}
+	summary, err := transfer.Import(transfer.NewReader(r.Body, format), opts, apply)
+
+	status := http.StatusOK
+	if err != nil {
+		log.Printf("Import Error: %s", err)
+		status = http.StatusBadRequest
+	} else {
+		log.Printf("Successfully imported %d records", summary.Total)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(summary)
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"kvstore/channels"
+	"kvstore/helpers"
+	"log"
+	"net/http"
+)
+
+// Trash lists the soft deleted keys.
+func Trash(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodGet); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.TrashRequest()
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully listed trash")
+	writeJSON(w, resp.Value)
+}
+
+// Restore moves a key (?key=) or every key removed by a soft clear (?clear_id=) out of the trash.
+func Restore(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodPost); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	var resp channels.Response
+	switch {
+	case q.Get("clear_id") != "":
+		resp = channels.RestoreClearRequest(q.Get("clear_id"), GetCaller(r))
+	case q.Get("key") != "":
+		resp = channels.RestoreRequest(q.Get("key"), GetCaller(r))
+	default:
+		helpers.HandleError(w, helpers.MissingKeyError)
+		return
+	}
+
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully restored from trash")
+	writeJSON(w, resp.Value)
+}
+
+// Purge permanently removes a key (?key=) from the trash, or the whole trash when no key is given.
+func Purge(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.CheckMethod(r.Method, http.MethodDelete); err != nil {
+		helpers.HandleError(w, err)
+		return
+	}
+
+	resp := channels.PurgeRequest(r.URL.Query().Get("key"), GetCaller(r))
+	if resp.Error != nil {
+		helpers.HandleError(w, resp.Error)
+		return
+	}
+
+	log.Printf("Successfully purged %d keys from trash", resp.Value)
+	writeJSON(w, struct {
+		Purged int `json:"purged"`
+	}{resp.Value.(int)})
+}
@@ -1,23 +1,278 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"kvstore/antientropy"
+	"kvstore/audit"
 	"kvstore/channels"
+	"kvstore/crdt"
 	"kvstore/http"
+	"kvstore/memcached"
+	"kvstore/partition"
+	"kvstore/raft"
+	"kvstore/replication"
+	"kvstore/resp"
 	"kvstore/store"
+	wireserver "kvstore/wire/server"
+	"log"
 	_ "net/http/pprof" // Import pprof for profiling
+	"os"
+	"slices"
+	"strings"
 )
 
 func main() {
 
+	// Subcommands (import, export) talk to a running server and exit
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	flag.StringVar(&http.Addr, "addr", http.PORT, "address the server listens on")
+	follow := flag.String("follow", "", "base URL of a leader to replicate from, e.g. http://localhost:8080; writes are redirected there")
+	clusterID := flag.String("cluster-id", "", "ID of this server in a Raft cluster; enables cluster mode")
+	clusterPeers := flag.String("cluster-peers", "", "initial cluster members as id=url pairs, e.g. n1=http://localhost:8081,n2=http://localhost:8082; leave empty to join an existing cluster")
+	clusterDir := flag.String("cluster-dir", "", "directory the Raft log and snapshots are kept in (empty keeps them in memory only)")
+	partitionID := flag.String("partition-id", "", "ID of this server in a partitioned cluster; enables partitioned mode")
+	partitionPeers := flag.String("partition-peers", "", "initial partition members as id=url pairs, e.g. p1=http://localhost:8081,p2=http://localhost:8082; leave empty to be added to an existing cluster")
+	repairPeer := flag.String("repair-peer", "", "base URL of a replica to repair against on a schedule, e.g. http://localhost:8081")
+	repairInterval := flag.Duration("repair-interval", antientropy.DefaultInterval, "time between scheduled repairs against -repair-peer (0 repairs on demand only)")
+	repairMode := flag.String("repair-mode", string(antientropy.ModeSync), "direction of scheduled repairs: sync, pull or push")
+	siteID := flag.String("site-id", "", "name of this site among sites that all accept writes to CRDT keys; enables multi-master mode")
+	sitePeers := flag.String("site-peers", "", "base URLs of the other sites, e.g. http://eu.example.com:8080,http://us.example.com:8080")
+	flag.IntVar(&store.Store.MaxDeliveries, "queue-max-deliveries", store.DefaultMaxDeliveries, "failed deliveries before a queue message is moved to the dead-letter queue (0 retries forever)")
+	flag.DurationVar(&store.Store.TrashRetention, "trash-retention", store.DefaultTrashRetention, "how long soft deleted keys are kept (0 keeps them until purged)")
+	flag.DurationVar(&store.Store.TombstoneRetention, "tombstone-retention", store.DefaultTombstoneRetention, "how long deletions are remembered so that sync repairs do not bring deleted keys back (0 remembers them forever)")
+	respAddr := flag.String("resp-addr", "", "address to serve the Redis protocol (RESP) on as well, e.g. :6379 (empty disables it)")
+	wireAddr := flag.String("wire-addr", "", "address to serve the binary protocol on as well, e.g. :7070 (empty disables it)")
+	memcachedAddr := flag.String("memcached-addr", "", "address to serve the memcached text protocol on as well, e.g. :11211 (empty disables it)")
+	flag.StringVar(&http.IdentityHeader, "audit-identity-header", "", "header an authenticating proxy in front of the server sets to the authenticated user, e.g. X-Forwarded-User, recorded in the audit log (empty records no identity)")
+	auditFile := flag.String("audit-file", "kvstore-audit.log", "file the audit log is written to, rotated as <file>.1 ... (empty keeps it in memory only)")
+	auditMaxSize := flag.Int64("audit-max-size", audit.DefaultMaxSize, "size in bytes at which the audit log file is rotated")
+	flag.Parse()
+
+	auditLog, err := audit.Open(*auditFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer auditLog.Close()
+	auditLog.MaxSize = *auditMaxSize
+	channels.Audit = auditLog
+
+	// Every server can be followed, followers included
+	channels.Leader = replication.NewLeader()
+	store.Store.OnChange = channels.Leader.Publish
+	if *follow != "" {
+		channels.Follower = replication.NewFollower(*follow, channels.ReplicateRequest)
+	}
+
+	if *siteID != "" {
+		if *follow != "" || *clusterID != "" || *partitionID != "" {
+			log.Fatal("-site-id cannot be used with -follow, -cluster-id or -partition-id")
+		}
+		channels.Sites = newSites(*siteID, *sitePeers)
+	} else if *sitePeers != "" {
+		log.Fatal("-site-peers requires -site-id")
+	}
+
+	go channels.Requests()
+
+	if channels.Sites != nil {
+		go channels.Sites.Run(context.Background())
+	}
+
+	if *clusterID != "" {
+		if *follow != "" {
+			log.Fatal("-follow and -cluster-id cannot be used together")
+		}
+		node, err := newClusterNode(*clusterID, *clusterPeers, *clusterDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		channels.Raft = node
+		defer node.Stop()
+	}
+
+	if *partitionID != "" {
+		if *follow != "" || *clusterID != "" {
+			log.Fatal("-partition-id cannot be used with -follow or -cluster-id")
+		}
+		cluster, err := newPartitionCluster(*partitionID, *partitionPeers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		channels.Partitions = cluster
+	}
+
+	// Cluster members and partitions each hold their own data, which no other server replicates
+	if channels.Raft == nil && channels.Partitions == nil {
+		mode, err := antientropy.ParseMode(*repairMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		channels.AntiEntropy = &antientropy.Repairer{
+			Local:    channels.LocalReplica{},
+			Peer:     *repairPeer,
+			Mode:     mode,
+			Interval: *repairInterval,
+		}
+		go channels.AntiEntropy.Run(context.Background())
+	} else if *repairPeer != "" {
+		log.Fatal("-repair-peer cannot be used with -cluster-id or -partition-id")
+	}
+
+	if *respAddr != "" {
+		// Keys are not routed to their owners over RESP, so a partition would only serve its own share
+		if channels.Partitions != nil {
+			log.Fatal("-resp-addr cannot be used with -partition-id")
+		}
+		go func() {
+			log.Printf("RESP Listening at %s", *respAddr)
+			log.Fatal((&resp.Server{Addr: *respAddr}).ListenAndServe())
+		}()
+	}
+	if *wireAddr != "" {
+		if channels.Partitions != nil {
+			log.Fatal("-wire-addr cannot be used with -partition-id")
+		}
+		go func() {
+			log.Printf("Wire Listening at %s", *wireAddr)
+			log.Fatal((&wireserver.Server{Addr: *wireAddr}).ListenAndServe())
+		}()
+	}
+	if *memcachedAddr != "" {
+		if channels.Partitions != nil {
+			log.Fatal("-memcached-addr cannot be used with -partition-id")
+		}
+		go func() {
+			log.Printf("Memcached Listening at %s", *memcachedAddr)
+			log.Fatal((&memcached.Server{Addr: *memcachedAddr}).ListenAndServe())
+		}()
+	}
+
 	serverStarted := make(chan struct{}) // Channel to signal when the server is ready
 	done := make(chan bool, 1)           // Block the main goroutine until the server has shutdown
 
-	go channels.Requests()
 	go http.StartServer(serverStarted, done)
 
 	<-serverStarted
 
-	store.Store.InitData()
+	if channels.Raft != nil {
+		// The store is whatever the cluster has committed
+		channels.Raft.Start()
+	} else if channels.Partitions != nil {
+		// Seeding every server would leave each with keys it does not own
+		go channels.Partitions.Run(context.Background())
+	} else if channels.Follower != nil {
+		// The leader's snapshot replaces the store, so there is no point seeding it
+		go channels.Follower.Run(context.Background())
+	} else {
+		store.Store.InitData()
+	}
 
 	<-done
 }
+
+// newClusterNode creates the Raft node of this server, with the store as its state machine.
+func newClusterNode(id, peers, dir string) (*raft.Node, error) {
+	var boot raft.Configuration
+	if peers != "" {
+		for _, peer := range strings.Split(peers, ",") {
+			peerID, addr, ok := strings.Cut(strings.TrimSpace(peer), "=")
+			if !ok || peerID == "" || addr == "" {
+				return nil, fmt.Errorf("invalid cluster peer %q, want id=url", peer)
+			}
+			boot.Members = append(boot.Members, raft.Member{ID: peerID, Addr: addr})
+		}
+		if !boot.Has(id) {
+			return nil, fmt.Errorf("cluster peers do not include this server (%s)", id)
+		}
+	}
+
+	var storage raft.Storage = raft.NewMemoryStorage()
+	if dir != "" {
+		fs, err := raft.OpenFileStorage(dir)
+		if err != nil {
+			return nil, err
+		}
+		storage = fs
+	}
+
+	return raft.NewNode(raft.Config{
+		ID:           id,
+		Bootstrap:    boot,
+		Storage:      storage,
+		Transport:    &raft.HTTPTransport{Path: http.RaftPath},
+		StateMachine: channels.StateMachine{},
+	})
+}
+
+// newSites names this site in the store and sends every update to a CRDT key to the other sites.
+func newSites(id, peers string) *crdt.Replicator {
+	var urls []string
+	if peers != "" {
+		for _, peer := range strings.Split(peers, ",") {
+			urls = append(urls, strings.TrimSpace(peer))
+		}
+	}
+
+	r := crdt.NewReplicator(id, urls)
+	r.States = channels.CRDTStates
+	r.Merge = func(states map[string]crdt.State) error {
+		return channels.CRDTMergeRequest(states).Error
+	}
+	store.Store.SetCRDTNode(id)
+	store.Store.OnCRDTChange = r.Publish
+	return r
+}
+
+// newPartitionCluster creates the partitioned cluster as seen by this server, handing keys over
+// through the request loop.
+func newPartitionCluster(id, peers string) (*partition.Cluster, error) {
+	var m partition.Membership
+	if peers != "" {
+		for _, peer := range strings.Split(peers, ",") {
+			peerID, addr, ok := strings.Cut(strings.TrimSpace(peer), "=")
+			if !ok || peerID == "" || addr == "" {
+				return nil, fmt.Errorf("invalid partition peer %q, want id=url", peer)
+			}
+			m.Nodes = append(m.Nodes, partition.Node{ID: peerID, Addr: addr})
+		}
+		if !slices.ContainsFunc(m.Nodes, func(n partition.Node) bool { return n.ID == id }) {
+			return nil, fmt.Errorf("partition peers do not include this server (%s)", id)
+		}
+		m.Version = 1
+	}
+
+	c := partition.NewCluster(id, m)
+	c.Local = func() (map[string]store.Item, error) {
+		resp := channels.GetAllWithMetaRequest()
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Value.(map[string]store.Item), nil
+	}
+	c.Drop = func(items map[string]store.Item) (int, error) {
+		resp := channels.PartitionDropRequest(items)
+		if resp.Error != nil {
+			return 0, resp.Error
+		}
+		return resp.Value.(int), nil
+	}
+	c.Take = func(move func(string) bool) ([]store.Change, error) {
+		resp := channels.PartitionTakeRequest(move)
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Value.([]store.Change), nil
+	}
+	c.Merge = func(changes []store.Change) error {
+		return channels.PartitionReceiveRequest(partition.Batch{From: id, Changes: changes}).Error
+	}
+	return c, nil
+}
@@ -0,0 +1,415 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"io"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/store"
+	"log"
+	"strconv"
+	"time"
+)
+
+// command is a command the server understands.
+type command struct {
+	data    bool // Followed by a data block, whose length is the fourth argument
+	noreply bool // Takes noreply as its last argument
+	write   bool // Changes the store, so followers refuse it
+	read    bool // Reads the store, so cluster members wait for a read barrier first
+	run     func(c *conn, args []string)
+}
+
+// commands are the commands by name, which memcached takes in lower case only.
+var commands = map[string]command{
+	"get":       {read: true, run: (*conn).get},
+	"gets":      {read: true, run: (*conn).get},
+	"set":       {data: true, noreply: true, write: true, run: (*conn).store},
+	"add":       {data: true, noreply: true, write: true, run: (*conn).store},
+	"replace":   {data: true, noreply: true, write: true, run: (*conn).store},
+	"cas":       {data: true, noreply: true, write: true, run: (*conn).store},
+	"delete":    {noreply: true, write: true, run: (*conn).delete},
+	"incr":      {noreply: true, write: true, run: (*conn).incr},
+	"decr":      {noreply: true, write: true, run: (*conn).incr},
+	"touch":     {noreply: true, write: true, run: (*conn).touch},
+	"flush_all": {noreply: true, write: true, run: (*conn).flushAll},
+	"stats":     {run: (*conn).stats},
+	"version":   {run: (*conn).version},
+	"verbosity": {noreply: true, run: (*conn).verbosity},
+	"quit":      {run: (*conn).quitConn},
+}
+
+const errFormat = "CLIENT_ERROR bad command line format"
+
+// exec runs a command line and writes its reply.
+func (c *conn) exec(fields []string) {
+	c.name = fields[0]
+	args := fields[1:]
+	cmd, ok := commands[c.name]
+	if !ok {
+		c.reply("ERROR")
+		return
+	}
+	if cmd.noreply && len(args) > 0 && args[len(args)-1] == "noreply" {
+		args = args[:len(args)-1]
+		c.noreply = true
+		defer func() { c.noreply = false }()
+	}
+
+	// The data block is read before anything is checked, so that a refused command does not leave it
+	// to be read as the next command
+	if cmd.data {
+		if !c.readData(args) {
+			return
+		}
+	}
+
+	if err := channels.Admit(context.Background(), cmd.write, cmd.read); err != nil {
+		c.serverError(err)
+		return
+	}
+	cmd.run(c, args)
+}
+
+// readData reads the data block of a storage command into c.data, reporting whether the command should
+// go on. A block that is too large is read and dropped.
+func (c *conn) readData(args []string) bool {
+	if len(args) < 4 {
+		c.reply(errFormat)
+		return false
+	}
+	n, err := strconv.Atoi(args[3])
+	if err != nil || n < 0 {
+		c.reply(errFormat)
+		return false
+	}
+	if n > MaxItemSize {
+		if _, err := c.r.Discard(n + 2); err != nil {
+			c.quit = true
+			return false
+		}
+		c.reply("SERVER_ERROR object too large for cache")
+		return false
+	}
+
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		c.quit = true
+		return false
+	}
+	if string(data[n:]) != "\r\n" {
+		c.reply("CLIENT_ERROR bad data chunk")
+		return false
+	}
+	c.data = data[:n]
+	return true
+}
+
+// validKey reports whether key is one memcached takes: at most 250 bytes, with no control characters.
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// ttl converts an exptime to a time to live: zero never expires, up to 30 days is seconds from now,
+// beyond that a Unix time, and a negative exptime or a time already passed expires the key at once.
+func ttl(exptime int64) time.Duration {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return -1
+	case exptime <= maxRelative:
+		return time.Duration(exptime) * time.Second
+	}
+	if d := time.Until(time.Unix(exptime, 0)); d > 0 {
+		return d
+	}
+	return -1
+}
+
+// get runs get and gets, which also replies with the CAS unique of each key.
+func (c *conn) get(args []string) {
+	if len(args) == 0 {
+		c.reply("ERROR")
+		return
+	}
+	for _, key := range args {
+		if !validKey(key) {
+			c.reply(errFormat)
+			return
+		}
+	}
+
+	for _, key := range args {
+		c.srv.stats.cmdGet.Add(1)
+		resp := channels.GetWithMetaRequest(key)
+		switch {
+		case errors.Is(resp.Error, helpers.NotExistError):
+			c.srv.stats.getMisses.Add(1)
+			continue
+		case resp.Error != nil:
+			c.serverError(resp.Error)
+			return
+		}
+		c.srv.stats.getHits.Add(1)
+
+		item := resp.Value.(store.Item)
+		data := store.DecodeBytes(item.Value)
+		line := "VALUE " + key + " " + strconv.FormatUint(uint64(item.Metadata.Flags), 10) + " " + strconv.Itoa(len(data))
+		if c.name == "gets" {
+			line += " " + strconv.FormatUint(item.Metadata.Revision(), 10)
+		}
+		c.reply(line)
+		c.w.Write(data)
+		c.reply("")
+	}
+	c.reply("END")
+}
+
+// store runs set, add, replace and cas <key> <flags> <exptime> <bytes> [<cas unique>]. add has the
+// semantics of Add and replace those of Update, and cas writes the key only if it is at the revision
+// given by its CAS unique.
+func (c *conn) store(args []string) {
+	c.srv.stats.cmdSet.Add(1)
+	want := 4
+	if c.name == "cas" {
+		want = 5
+	}
+	if len(args) != want || !validKey(args[0]) {
+		c.reply(errFormat)
+		return
+	}
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		c.reply(errFormat)
+		return
+	}
+	opts := store.WriteOptions{TTL: ttl(exptime), Flags: uint32(flags)}
+	switch c.name {
+	case "add":
+		opts.IfMissing = true
+	case "replace":
+		opts.IfExists = true
+	case "cas":
+		unique, err := strconv.ParseUint(args[4], 10, 64)
+		if err != nil {
+			c.reply(errFormat)
+			return
+		}
+		if unique == 0 {
+			// No revision is zero, so the key exists under another or not at all
+			if resp := channels.ExistsRequest(args[0]); resp.Error == nil {
+				c.srv.stats.casBadval.Add(1)
+				c.reply("EXISTS")
+			} else {
+				c.srv.stats.casMisses.Add(1)
+				c.reply("NOT_FOUND")
+			}
+			return
+		}
+		opts.Revision = unique
+	}
+
+	resp := channels.WriteRequest(args[0], store.EncodeBytes(c.data), opts, c.caller)
+	switch {
+	case resp.Error == nil:
+		if c.name == "cas" {
+			c.srv.stats.casHits.Add(1)
+		}
+		c.reply("STORED")
+	case errors.Is(resp.Error, helpers.DuplicateKeyError):
+		c.reply("NOT_STORED")
+	case errors.Is(resp.Error, helpers.NotExistError) && c.name == "cas":
+		c.srv.stats.casMisses.Add(1)
+		c.reply("NOT_FOUND")
+	case errors.Is(resp.Error, helpers.NotExistError):
+		c.reply("NOT_STORED")
+	case errors.Is(resp.Error, helpers.RevisionMismatchError):
+		c.srv.stats.casBadval.Add(1)
+		c.reply("EXISTS")
+	default:
+		c.serverError(resp.Error)
+	}
+}
+
+// delete runs delete <key>. The zero time memcached once took after the key is still accepted.
+func (c *conn) delete(args []string) {
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[1] != "0") || !validKey(args[0]) {
+		c.reply(errFormat)
+		return
+	}
+	resp := channels.DeleteRequest(args[0], c.caller)
+	switch {
+	case resp.Error == nil:
+		c.srv.stats.deleteHits.Add(1)
+		c.reply("DELETED")
+	case errors.Is(resp.Error, helpers.NotExistError):
+		c.srv.stats.deleteMisses.Add(1)
+		c.reply("NOT_FOUND")
+	default:
+		c.serverError(resp.Error)
+	}
+}
+
+// incr runs incr and decr <key> <delta>. Values are unsigned 64-bit integers: incr wraps around and
+// decr stops at zero, as in memcached. The new value is written only if the key is still at the
+// revision it was read at, and read again otherwise, so that concurrent writers are not lost.
+func (c *conn) incr(args []string) {
+	if len(args) != 2 || !validKey(args[0]) {
+		c.reply(errFormat)
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+	hits, misses := &c.srv.stats.incrHits, &c.srv.stats.incrMisses
+	if c.name == "decr" {
+		hits, misses = &c.srv.stats.decrHits, &c.srv.stats.decrMisses
+	}
+
+	for {
+		resp := channels.GetWithMetaRequest(args[0])
+		switch {
+		case errors.Is(resp.Error, helpers.NotExistError):
+			misses.Add(1)
+			c.reply("NOT_FOUND")
+			return
+		case resp.Error != nil:
+			c.serverError(resp.Error)
+			return
+		}
+		item := resp.Value.(store.Item)
+		n, err := strconv.ParseUint(string(store.DecodeBytes(item.Value)), 10, 64)
+		if err != nil {
+			c.reply("CLIENT_ERROR cannot increment or decrement non-numeric value")
+			return
+		}
+		if c.name == "incr" {
+			n += delta
+		} else {
+			n -= min(n, delta)
+		}
+
+		// A JSON number, such as one written over HTTP, stays one while it is exact
+		digits := []byte(strconv.FormatUint(n, 10))
+		value := store.EncodeBytes(digits)
+		if _, ok := item.Value.(float64); ok && n <= 1<<53 {
+			value = digits
+		}
+		opts := store.WriteOptions{Revision: item.Metadata.Revision(), KeepTTL: true, Flags: item.Metadata.Flags}
+		resp = channels.WriteRequest(args[0], value, opts, c.caller)
+		switch {
+		case resp.Error == nil:
+			hits.Add(1)
+			c.reply(string(digits))
+			return
+		case errors.Is(resp.Error, helpers.RevisionMismatchError), errors.Is(resp.Error, helpers.NotExistError):
+			continue
+		default:
+			c.serverError(resp.Error)
+			return
+		}
+	}
+}
+
+// touch runs touch <key> <exptime>, changing the expiry of a key without writing it.
+func (c *conn) touch(args []string) {
+	c.srv.stats.cmdTouch.Add(1)
+	if len(args) != 2 || !validKey(args[0]) {
+		c.reply(errFormat)
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+
+	var resp channels.Response
+	if d := ttl(exptime); d == 0 {
+		resp = channels.PersistRequest(args[0], c.caller)
+	} else {
+		resp = channels.ExpireRequest(args[0], d, c.caller)
+	}
+	switch {
+	case resp.Error == nil:
+		c.srv.stats.touchHits.Add(1)
+		c.reply("TOUCHED")
+	case errors.Is(resp.Error, helpers.NotExistError):
+		c.srv.stats.touchMisses.Add(1)
+		c.reply("NOT_FOUND")
+	default:
+		c.serverError(resp.Error)
+	}
+}
+
+// flushAll runs flush_all [delay], clearing the store now or once delay seconds have passed. A later
+// flush_all replaces a delayed one.
+func (c *conn) flushAll(args []string) {
+	c.srv.stats.cmdFlush.Add(1)
+	var delay int64
+	if len(args) > 1 {
+		c.reply(errFormat)
+		return
+	}
+	if len(args) == 1 {
+		var err error
+		if delay, err = strconv.ParseInt(args[0], 10, 64); err != nil || delay < 0 {
+			c.reply(errFormat)
+			return
+		}
+	}
+
+	s := c.srv
+	s.mu.Lock()
+	if s.flush != nil {
+		s.flush.Stop()
+		s.flush = nil
+	}
+	if delay > 0 {
+		caller := c.caller
+		s.flush = time.AfterFunc(time.Duration(delay)*time.Second, func() {
+			if resp := channels.ClearRequest(caller); resp.Error != nil {
+				log.Printf("Memcached Error: %s", resp.Error)
+			}
+		})
+	}
+	s.mu.Unlock()
+
+	if delay == 0 {
+		if resp := channels.ClearRequest(c.caller); resp.Error != nil {
+			c.serverError(resp.Error)
+			return
+		}
+	}
+	c.reply("OK")
+}
+
+func (c *conn) version(args []string) {
+	c.reply("VERSION " + Version)
+}
+
+// verbosity runs verbosity <level>, which has nothing to set here.
+func (c *conn) verbosity(args []string) {
+	if len(args) != 1 {
+		c.reply("ERROR")
+		return
+	}
+	c.reply("OK")
+}
+
+func (c *conn) quitConn(args []string) {
+	c.quit = true
+}
@@ -0,0 +1,245 @@
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"kvstore/channels"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+var addr string
+
+// TestMain serves the global store, through the request loop, on a free port.
+func TestMain(m *testing.M) {
+	go channels.Requests()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	srv := &Server{}
+	go srv.Serve(l)
+	addr = l.Addr().String()
+
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
+
+func TestCommands(t *testing.T) {
+	mc := memcache.New(addr)
+
+	if err := mc.Set(&memcache.Item{Key: "mc:a", Value: []byte("hello"), Flags: 42}); err != nil {
+		t.Fatalf("set = %v", err)
+	}
+	item, err := mc.Get("mc:a")
+	if err != nil || string(item.Value) != "hello" || item.Flags != 42 {
+		t.Fatalf("get = %+v, %v, want hello with flags 42", item, err)
+	}
+	if _, err := mc.Get("mc:missing"); !errors.Is(err, memcache.ErrCacheMiss) {
+		t.Errorf("get missing = %v, want a miss", err)
+	}
+
+	// add fails on a key that exists, and replace on one that does not
+	if err := mc.Add(&memcache.Item{Key: "mc:a", Value: []byte("x")}); !errors.Is(err, memcache.ErrNotStored) {
+		t.Errorf("add existing = %v, want not stored", err)
+	}
+	if err := mc.Add(&memcache.Item{Key: "mc:b", Value: []byte("b")}); err != nil {
+		t.Errorf("add = %v", err)
+	}
+	if err := mc.Replace(&memcache.Item{Key: "mc:missing", Value: []byte("x")}); !errors.Is(err, memcache.ErrNotStored) {
+		t.Errorf("replace missing = %v, want not stored", err)
+	}
+	if err := mc.Replace(&memcache.Item{Key: "mc:b", Value: []byte("\xff\x00\xfe")}); err != nil {
+		t.Errorf("replace = %v", err)
+	}
+	if item, _ := mc.Get("mc:b"); item == nil || string(item.Value) != "\xff\x00\xfe" {
+		t.Errorf("get binary = %+v, want the bytes written", item)
+	}
+
+	// cas writes only over the revision read
+	item, _ = mc.Get("mc:a")
+	stale := *item
+	item.Value = []byte("swapped")
+	if err := mc.CompareAndSwap(item); err != nil {
+		t.Errorf("cas = %v", err)
+	}
+	if err := mc.CompareAndSwap(&stale); !errors.Is(err, memcache.ErrCASConflict) {
+		t.Errorf("cas stale = %v, want a conflict", err)
+	}
+	if item, _ := mc.Get("mc:a"); item == nil || string(item.Value) != "swapped" || item.Flags != 42 {
+		t.Errorf("get after cas = %+v, want swapped", item)
+	}
+
+	got, err := mc.GetMulti([]string{"mc:a", "mc:missing", "mc:b"})
+	if err != nil || len(got) != 2 {
+		t.Errorf("get multi = %v, %v, want 2 items", got, err)
+	}
+
+	if err := mc.Delete("mc:b"); err != nil {
+		t.Errorf("delete = %v", err)
+	}
+	if err := mc.Delete("mc:b"); !errors.Is(err, memcache.ErrCacheMiss) {
+		t.Errorf("delete again = %v, want a miss", err)
+	}
+}
+
+func TestIncrDecr(t *testing.T) {
+	mc := memcache.New(addr)
+
+	if _, err := mc.Increment("mc:n", 1); !errors.Is(err, memcache.ErrCacheMiss) {
+		t.Errorf("incr missing = %v, want a miss", err)
+	}
+	mc.Set(&memcache.Item{Key: "mc:n", Value: []byte("10"), Flags: 7, Expiration: 100})
+	if n, err := mc.Increment("mc:n", 5); err != nil || n != 15 {
+		t.Errorf("incr = %d, %v, want 15", n, err)
+	}
+	if n, err := mc.Decrement("mc:n", 20); err != nil || n != 0 {
+		t.Errorf("decr = %d, %v, want 0", n, err)
+	}
+	mc.Set(&memcache.Item{Key: "mc:n", Value: []byte("18446744073709551615")})
+	if n, err := mc.Increment("mc:n", 2); err != nil || n != 1 {
+		t.Errorf("incr past the maximum = %d, %v, want 1", n, err)
+	}
+
+	mc.Set(&memcache.Item{Key: "mc:text", Value: []byte("abc")})
+	if _, err := mc.Increment("mc:text", 1); err == nil {
+		t.Error("incr of text succeeded")
+	}
+
+	// Increments from many clients at once are none of them lost
+	mc.Set(&memcache.Item{Key: "mc:count", Value: []byte("0"), Flags: 3})
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mc := memcache.New(addr)
+			for range 25 {
+				mc.Increment("mc:count", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if item, _ := mc.Get("mc:count"); item == nil || string(item.Value) != "200" || item.Flags != 3 {
+		t.Errorf("get count = %+v, want 200 with flags kept", item)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	mc := memcache.New(addr)
+
+	mc.Set(&memcache.Item{Key: "mc:gone", Value: []byte("x"), Expiration: -1})
+	if _, err := mc.Get("mc:gone"); !errors.Is(err, memcache.ErrCacheMiss) {
+		t.Errorf("get expired = %v, want a miss", err)
+	}
+	mc.Set(&memcache.Item{Key: "mc:past", Value: []byte("x"), Expiration: int32(time.Now().Add(-time.Hour).Unix())})
+	if _, err := mc.Get("mc:past"); !errors.Is(err, memcache.ErrCacheMiss) {
+		t.Errorf("get with a passed Unix time = %v, want a miss", err)
+	}
+
+	mc.Set(&memcache.Item{Key: "mc:t", Value: []byte("x"), Expiration: 100})
+	if err := mc.Touch("mc:t", 0); err != nil {
+		t.Errorf("touch = %v", err)
+	}
+	if err := mc.Touch("mc:missing", 10); !errors.Is(err, memcache.ErrCacheMiss) {
+		t.Errorf("touch missing = %v, want a miss", err)
+	}
+	if err := mc.Touch("mc:t", -1); err != nil {
+		t.Errorf("touch expiring = %v", err)
+	}
+	if _, err := mc.Get("mc:t"); !errors.Is(err, memcache.ErrCacheMiss) {
+		t.Errorf("get touched = %v, want a miss", err)
+	}
+}
+
+// TestRaw speaks the protocol directly: pipelining in a single write, noreply, bad commands and data
+// blocks, and an oversized value whose data block is skipped.
+func TestRaw(t *testing.T) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	big := strings.Repeat("x", MaxItemSize+1)
+	fmt.Fprintf(nc, "set raw:k 5 0 2 noreply\r\nhi\r\nget raw:k raw:x\r\nbogus\r\nset raw:big 0 0 %d\r\n%s\r\n"+
+		"set raw:k 0 0 2\r\nabc\r\nincr raw:k x\r\nverbosity 1\r\nversion\r\nquit\r\n", len(big), big)
+	replies, err := io.ReadAll(bufio.NewReader(nc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "VALUE raw:k 5 2\r\nhi\r\nEND\r\nERROR\r\nSERVER_ERROR object too large for cache\r\n" +
+		"CLIENT_ERROR bad data chunk\r\nCLIENT_ERROR invalid numeric delta argument\r\nOK\r\nVERSION " + Version + "\r\n"
+	if got := string(replies); got != want {
+		t.Errorf("replies = %q, want %q", got, want)
+	}
+}
+
+func TestGets(t *testing.T) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+
+	fmt.Fprint(nc, "set gets:k 0 0 1\r\na\r\ngets gets:k\r\n")
+	var line string
+	for range 2 {
+		line, _ = r.ReadString('\n')
+	}
+	var cas uint64
+	if _, err := fmt.Sscanf(line, "VALUE gets:k 0 1 %d", &cas); err != nil || cas == 0 {
+		t.Fatalf("gets = %q, %v, want a CAS unique", line, err)
+	}
+	r.ReadString('\n')
+	r.ReadString('\n')
+
+	fmt.Fprintf(nc, "cas gets:k 0 0 1 %d\r\nb\r\ncas gets:k 0 0 1 %d\r\nc\r\ncas gets:x 0 0 1 %d\r\nd\r\n", cas, cas, cas)
+	for _, want := range []string{"STORED", "EXISTS", "NOT_FOUND"} {
+		if got, _ := r.ReadString('\n'); got != want+"\r\n" {
+			t.Errorf("cas reply = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	fmt.Fprint(nc, "stats\r\nquit\r\n")
+	replies, _ := io.ReadAll(nc)
+	for _, want := range []string{"STAT version " + Version + "\r\n", "STAT curr_items ", "STAT cmd_get "} {
+		if !strings.Contains(string(replies), want) {
+			t.Errorf("stats = %q, want %q", replies, want)
+		}
+	}
+	if !strings.HasSuffix(string(replies), "END\r\n") {
+		t.Errorf("stats = %q, want END last", replies)
+	}
+}
+
+// TestFlush runs last, as it clears the store the other tests share.
+func TestFlush(t *testing.T) {
+	mc := memcache.New(addr)
+	mc.Set(&memcache.Item{Key: "mc:f", Value: []byte("x")})
+	if err := mc.FlushAll(); err != nil {
+		t.Fatalf("flush_all = %v", err)
+	}
+	if _, err := mc.Get("mc:f"); !errors.Is(err, memcache.ErrCacheMiss) {
+		t.Errorf("get after flush_all = %v, want a miss", err)
+	}
+}
@@ -0,0 +1,221 @@
+// Package memcached serves the key/value store over the text protocol of memcached, so that services
+// with only a memcached client can use it. It speaks get, gets, set, add, replace, cas, delete, incr,
+// decr, touch, flush_all, stats, version, verbosity and quit, and commands may be pipelined.
+//
+// Commands go through the request loop like the HTTP handlers, so both see the same keys. add has the
+// semantics of Add, failing on a key that exists, and replace those of Update. Values are raw bytes,
+// kept as store.EncodeBytes keeps them, and their flags and expiry are kept in the metadata of the key.
+// The CAS unique of gets and cas is the revision of the key.
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"kvstore/channels"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAddr is the address memcached listens on.
+	DefaultAddr = ":11211"
+
+	// MaxItemSize caps the size of a value, as the -I option of memcached does.
+	MaxItemSize = 1 << 20
+
+	// maxKeyLen is the longest key memcached takes.
+	maxKeyLen = 250
+
+	// maxLine caps the length of a command line.
+	maxLine = 2048
+
+	// maxRelative is the largest exptime read as seconds from now; larger ones are Unix times.
+	maxRelative = 60 * 60 * 24 * 30
+)
+
+// Version is the version of memcached whose protocol the server speaks, reported by version and stats.
+const Version = "1.6.0-kvstore"
+
+// ErrServerClosed is returned by Serve and ListenAndServe once Close has been called.
+var ErrServerClosed = errors.New("memcached: server closed")
+
+// errLineTooLong is returned for a command line longer than maxLine.
+var errLineTooLong = errors.New("line too long")
+
+// Server accepts memcached connections, serving each on its own goroutine.
+type Server struct {
+	Addr string // Address to listen on, DefaultAddr if empty
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	flush    *time.Timer // Pending flush_all with a delay
+
+	stats stats
+}
+
+// ListenAndServe listens on s.Addr and serves connections until Close is called.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the connections accepted by l until Close is called. It closes l when it returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.stats.started = time.Now()
+	s.mu.Unlock()
+	defer l.Close()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nc, true) {
+			nc.Close()
+			continue
+		}
+		go s.serve(nc)
+	}
+}
+
+// Close stops the server, closing its listener and every connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.flush != nil {
+		s.flush.Stop()
+	}
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for nc := range s.conns {
+		nc.Close()
+	}
+	clear(s.conns)
+	return err
+}
+
+// track adds or removes a connection, reporting false if one is added after Close.
+func (s *Server) track(nc net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.conns, nc)
+		s.stats.currConns.Add(-1)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[nc] = struct{}{}
+	s.stats.currConns.Add(1)
+	s.stats.totalConns.Add(1)
+	return true
+}
+
+// conn is a client connection.
+type conn struct {
+	srv    *Server
+	r      *bufio.Reader
+	w      *bufio.Writer
+	caller channels.Caller
+	quit   bool // Close the connection once the reply is written
+
+	name    string // Name of the command being run
+	data    []byte // Data block of the storage command being run
+	noreply bool   // Write no reply to the command being run
+}
+
+func (s *Server) serve(nc net.Conn) {
+	defer s.track(nc, false)
+	defer nc.Close()
+
+	c := &conn{
+		srv:    s,
+		r:      bufio.NewReader(nc),
+		w:      bufio.NewWriter(nc),
+		caller: channels.Caller{Addr: nc.RemoteAddr().String()},
+	}
+	for !c.quit {
+		line, err := c.readLine()
+		if errors.Is(err, errLineTooLong) {
+			// The rest of the line cannot be told from the next command, as in memcached
+			c.reply("CLIENT_ERROR line too long")
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			c.exec(fields)
+		}
+
+		// Replies to pipelined commands are sent together, once every command read has been run
+		if c.r.Buffered() == 0 || c.quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLine reads a command line, ended by CRLF or a bare LF, without the line ending.
+func (c *conn) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > maxLine {
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// reply writes a reply line, unless the command asked for none.
+func (c *conn) reply(line string) {
+	if c.noreply {
+		return
+	}
+	c.w.WriteString(line)
+	c.w.WriteString("\r\n")
+}
+
+// serverError writes err as a SERVER_ERROR.
+func (c *conn) serverError(err error) {
+	log.Printf("Memcached Error: %s", err)
+	c.reply(fmt.Sprintf("SERVER_ERROR %s", err))
+}
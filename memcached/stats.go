@@ -0,0 +1,95 @@
+package memcached
+
+import (
+	"fmt"
+	"kvstore/channels"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// stats are the counters reported by the stats command, named as memcached names them.
+type stats struct {
+	started time.Time
+
+	currConns  atomic.Int64
+	totalConns atomic.Uint64
+
+	cmdGet, getHits, getMisses       atomic.Uint64
+	cmdSet                           atomic.Uint64
+	cmdTouch, touchHits, touchMisses atomic.Uint64
+	cmdFlush                         atomic.Uint64
+	deleteHits, deleteMisses         atomic.Uint64
+	incrHits, incrMisses             atomic.Uint64
+	decrHits, decrMisses             atomic.Uint64
+	casHits, casMisses, casBadval    atomic.Uint64
+}
+
+// stats runs stats, replying with the general statistics. The settings, items and slabs groups of
+// memcached have no counterpart here and reply with no statistics.
+func (c *conn) stats(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "settings", "items", "slabs", "sizes", "conns":
+			c.reply("END")
+		case "reset":
+			c.srv.stats.reset()
+			c.reply("RESET")
+		default:
+			c.reply("ERROR")
+		}
+		return
+	}
+
+	s := &c.srv.stats
+	var items int
+	if resp := channels.CountRequest(); resp.Error == nil {
+		items = resp.Value.(int)
+	}
+	now := time.Now()
+	for _, stat := range []struct {
+		name  string
+		value any
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(s.started).Seconds())},
+		{"time", now.Unix()},
+		{"version", Version},
+		{"pointer_size", strconv.IntSize},
+		{"curr_connections", s.currConns.Load()},
+		{"total_connections", s.totalConns.Load()},
+		{"cmd_get", s.cmdGet.Load()},
+		{"cmd_set", s.cmdSet.Load()},
+		{"cmd_flush", s.cmdFlush.Load()},
+		{"cmd_touch", s.cmdTouch.Load()},
+		{"get_hits", s.getHits.Load()},
+		{"get_misses", s.getMisses.Load()},
+		{"delete_misses", s.deleteMisses.Load()},
+		{"delete_hits", s.deleteHits.Load()},
+		{"incr_misses", s.incrMisses.Load()},
+		{"incr_hits", s.incrHits.Load()},
+		{"decr_misses", s.decrMisses.Load()},
+		{"decr_hits", s.decrHits.Load()},
+		{"cas_misses", s.casMisses.Load()},
+		{"cas_hits", s.casHits.Load()},
+		{"cas_badval", s.casBadval.Load()},
+		{"touch_hits", s.touchHits.Load()},
+		{"touch_misses", s.touchMisses.Load()},
+		{"curr_items", items},
+	} {
+		c.reply(fmt.Sprintf("STAT %s %v", stat.name, stat.value))
+	}
+	c.reply("END")
+}
+
+// reset zeroes the command counters, as stats reset does; the connection counts are kept.
+func (s *stats) reset() {
+	for _, n := range []*atomic.Uint64{
+		&s.cmdGet, &s.getHits, &s.getMisses, &s.cmdSet, &s.cmdTouch, &s.touchHits, &s.touchMisses,
+		&s.cmdFlush, &s.deleteHits, &s.deleteMisses, &s.incrHits, &s.incrMisses, &s.decrHits,
+		&s.decrMisses, &s.casHits, &s.casMisses, &s.casBadval,
+	} {
+		n.Store(0)
+	}
+}
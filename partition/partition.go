@@ -0,0 +1,531 @@
+// Package partition spreads the key space over several servers. A consistent-hash ring with virtual
+// nodes assigns every key to one server, so adding or removing a server only moves the keys between it
+// and its neighbours on the ring.
+//
+// Every server knows the whole ring. A request for a key owned by another server is forwarded there,
+// and requests that span the key space are sent to every server and their results merged. When the
+// membership changes, each server streams the keys it no longer owns to their new owners, along with
+// the leases, queues, streams, rate limits and trash they route to. Until the next change, a read that
+// misses on the owner of a key falls back to its previous owner, which may not have handed it over yet.
+package partition
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kvstore/store"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ForwardedHeader marks a request forwarded by another server, which is always served locally.
+	ForwardedHeader = "X-Kvs-Forwarded-By"
+
+	// FallbackHeader marks a read sent on to the previous owner of a key, which does not fall back again.
+	FallbackHeader = "X-Kvs-Fallback-From"
+
+	// RingPath is where a server takes a new membership, relative to the server root.
+	RingPath = "/kvs/partition/ring"
+
+	// ReceivePath is where a server takes keys handed over by other servers.
+	ReceivePath = "/kvs/partition/receive"
+
+	// BatchSize is how many keys are handed over per request while rebalancing.
+	BatchSize = 256
+
+	// RetryInterval is how long a failed rebalance waits before trying again.
+	RetryInterval = time.Second
+)
+
+// ErrNoOwner is returned while a server does not know of any server to own a key.
+var ErrNoOwner = errors.New("partition: no server owns the key")
+
+// ErrInvalidMember is returned for a membership change that cannot be made.
+var ErrInvalidMember = errors.New("partition: invalid membership change")
+
+// Membership is a versioned list of servers. Servers only replace their membership with a newer one.
+type Membership struct {
+	Version uint64 `json:"version"`
+	Nodes   []Node `json:"nodes"`
+}
+
+// Batch is a set of keys, or of the state outside the key space, handed over to their new owner.
+type Batch struct {
+	From    string                `json:"from"`
+	Items   map[string]store.Item `json:"items,omitempty"`
+	Changes []store.Change        `json:"changes,omitempty"`
+}
+
+// Cluster is the partitioned cluster as seen by one server.
+type Cluster struct {
+	Self   string
+	Client *http.Client // Used to talk to other servers, http.DefaultClient if nil
+
+	// Local returns every key held by this server, and Drop removes the given keys once their new owner
+	// has them, unless they have been written since. Both are needed to rebalance.
+	Local func() (map[string]store.Item, error)
+	Drop  func(map[string]store.Item) (int, error)
+
+	// Take removes the leases, queues, streams, rate limits and trash whose name move reports true and
+	// returns them as changes, and Merge adds such changes back if they cannot be handed over. Without
+	// them only keys are handed over.
+	Take  func(move func(name string) bool) ([]store.Change, error)
+	Merge func([]store.Change) error
+
+	mu         sync.Mutex
+	membership Membership
+	ring       *Ring
+	previous   *Ring // The ring before the last membership change, nil if there was none
+	kick       chan struct{}
+
+	rebalancing   bool
+	moved         int
+	lastRebalance time.Time
+	lastError     string
+}
+
+// Status describes the cluster as seen by a server.
+type Status struct {
+	Self          string    `json:"self"`
+	Version       uint64    `json:"version"`
+	Nodes         []Node    `json:"nodes"`
+	Rebalancing   bool      `json:"rebalancing"`
+	Moved         int       `json:"moved"` // Keys and other state handed over to other servers so far
+	LastRebalance time.Time `json:"last_rebalance,omitzero"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// NewCluster returns the cluster as seen by the server self, starting with membership m.
+func NewCluster(self string, m Membership) *Cluster {
+	c := &Cluster{Self: self, kick: make(chan struct{}, 1)}
+	c.membership = Membership{Version: m.Version, Nodes: slices.Clone(m.Nodes)}
+	c.ring = NewRing(m.Nodes, DefaultVirtualNodes)
+	return c
+}
+
+// Owner returns the server that owns key.
+func (c *Cluster) Owner(key string) (Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.ring.Owner(key)
+	if !ok {
+		return Node{}, ErrNoOwner
+	}
+	return n, nil
+}
+
+// PreviousOwner returns the server that owned key before the last membership change, if that is not
+// its owner now.
+func (c *Cluster) PreviousOwner(key string) (Node, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.previous == nil {
+		return Node{}, false
+	}
+	prev, ok := c.previous.Owner(key)
+	if !ok {
+		return Node{}, false
+	}
+	if n, ok := c.ring.Owner(key); ok && n.ID == prev.ID {
+		return Node{}, false
+	}
+	return prev, true
+}
+
+// Membership returns the current membership.
+func (c *Cluster) Membership() Membership {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Membership{Version: c.membership.Version, Nodes: slices.Clone(c.membership.Nodes)}
+}
+
+// SetMembership replaces the membership if m is newer, and starts handing over the keys this server no
+// longer owns. It reports whether m was taken.
+func (c *Cluster) SetMembership(m Membership) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m.Version <= c.membership.Version {
+		return false
+	}
+	c.membership = Membership{Version: m.Version, Nodes: slices.Clone(m.Nodes)}
+	c.previous = c.ring
+	c.ring = NewRing(m.Nodes, DefaultVirtualNodes)
+	log.Printf("Partition: membership version %d with %d servers", m.Version, len(m.Nodes))
+
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Join adds a server and announces the new membership to every server, old and new.
+func (c *Cluster) Join(ctx context.Context, n Node) (Membership, error) {
+	return c.change(ctx, func(nodes []Node) ([]Node, error) {
+		if n.ID == "" || n.Addr == "" {
+			return nil, fmt.Errorf("%w: a server needs an id and an address", ErrInvalidMember)
+		}
+		if slices.ContainsFunc(nodes, func(m Node) bool { return m.ID == n.ID }) {
+			return nil, fmt.Errorf("%w: %s is already a member", ErrInvalidMember, n.ID)
+		}
+		return append(nodes, n), nil
+	})
+}
+
+// Leave removes a server and announces the new membership to every server, including the one leaving
+// so it hands over all of its keys.
+func (c *Cluster) Leave(ctx context.Context, id string) (Membership, error) {
+	return c.change(ctx, func(nodes []Node) ([]Node, error) {
+		i := slices.IndexFunc(nodes, func(m Node) bool { return m.ID == id })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s is not a member", ErrInvalidMember, id)
+		}
+		return slices.Delete(nodes, i, i+1), nil
+	})
+}
+
+func (c *Cluster) change(ctx context.Context, f func([]Node) ([]Node, error)) (Membership, error) {
+	old := c.Membership()
+	nodes, err := f(slices.Clone(old.Nodes))
+	if err != nil {
+		return old, err
+	}
+	m := Membership{Version: old.Version + 1, Nodes: nodes}
+
+	// Servers leaving need the news as much as those staying
+	targets := slices.Clone(m.Nodes)
+	for _, n := range old.Nodes {
+		if !slices.ContainsFunc(targets, func(t Node) bool { return t.ID == n.ID }) {
+			targets = append(targets, n)
+		}
+	}
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		return old, err
+	}
+	var errs []error
+	for _, n := range targets {
+		if n.ID == c.Self {
+			c.SetMembership(m)
+			continue
+		}
+		resp, err := c.send(ctx, n, http.MethodPut, RingPath, body)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("announcing to %s: %w", n.ID, err))
+			continue
+		}
+		resp.Body.Close()
+	}
+	return m, errors.Join(errs...)
+}
+
+// Do sends a request to a server, marked as forwarded so the server handles it itself.
+func (c *Cluster) Do(ctx context.Context, n Node, method, pathQuery string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(n.Addr, "/")+pathQuery, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ForwardedHeader, c.Self)
+	return c.client().Do(req)
+}
+
+// send is Do for requests that have to succeed, turning an error status into a StatusError.
+func (c *Cluster) send(ctx context.Context, n Node, method, pathQuery string, body []byte) (*http.Response, error) {
+	resp, err := c.Do(ctx, n, method, pathQuery, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &StatusError{Node: n.ID, Code: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// StatusError is a request to another server that was answered with an error status.
+type StatusError struct {
+	Node    string
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s answered %d: %s", e.Node, e.Code, e.Message)
+}
+
+// Forward proxies a request to the server that owns it and copies back the response.
+func (c *Cluster) Forward(w http.ResponseWriter, r *http.Request, n Node) {
+	target, err := url.Parse(n.Addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Header.Set(ForwardedHeader, c.Self)
+		},
+		Transport: c.client().Transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Partition Error: forwarding to %s: %s", n.ID, err)
+			http.Error(w, fmt.Sprintf("forwarding to %s: %s", n.ID, err), http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// Gather sends a request to every server, this one included, and returns their response bodies in
+// the order of the membership. It fails if any server fails.
+func (c *Cluster) Gather(ctx context.Context, r *http.Request) ([][]byte, error) {
+	resps, err := c.Open(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	bodies := make([][]byte, len(resps))
+	errs := make([]error, len(resps))
+	var wg sync.WaitGroup
+	for i, resp := range resps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer resp.Body.Close()
+			bodies[i], errs[i] = io.ReadAll(resp.Body)
+		}()
+	}
+	wg.Wait()
+
+	return bodies, errors.Join(errs...)
+}
+
+// Open sends a request to every server, this one included, and returns their responses in the order
+// of the membership, for bodies too large to hold in memory. It fails if any server fails, otherwise
+// the caller closes the bodies.
+func (c *Cluster) Open(ctx context.Context, r *http.Request) ([]*http.Response, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	nodes := c.Membership().Nodes
+	if len(nodes) == 0 {
+		return nil, ErrNoOwner
+	}
+
+	resps := make([]*http.Response, len(nodes))
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resps[i], errs[i] = c.send(ctx, n, r.Method, r.URL.RequestURI(), body)
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		for _, resp := range resps {
+			if resp != nil {
+				resp.Body.Close()
+			}
+		}
+		return nil, err
+	}
+	return resps, nil
+}
+
+// Run hands over keys this server does not own whenever the membership changes, until ctx ends.
+func (c *Cluster) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.kick:
+		}
+
+		for {
+			err := c.rebalance(ctx)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+			log.Printf("Partition Error: rebalancing: %s", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(RetryInterval):
+			}
+		}
+	}
+}
+
+// rebalance hands every local key owned by another server over to that server, in batches, dropping
+// each batch locally once its new owner has it. The state outside the key space goes first.
+func (c *Cluster) rebalance(ctx context.Context) (err error) {
+	c.mu.Lock()
+	c.rebalancing = true
+	ring := c.ring
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.rebalancing = false
+		c.lastRebalance = time.Now()
+		c.lastError = ""
+		if err != nil {
+			c.lastError = err.Error()
+		}
+		c.mu.Unlock()
+	}()
+
+	if err := c.handOverState(ctx, ring); err != nil {
+		return err
+	}
+
+	items, err := c.Local()
+	if err != nil {
+		return err
+	}
+
+	moving := make(map[string][]string) // Owner ID to the keys it should have
+	owners := make(map[string]Node)
+	for k := range items {
+		n, ok := ring.Owner(k)
+		if !ok || n.ID == c.Self {
+			continue
+		}
+		moving[n.ID] = append(moving[n.ID], k)
+		owners[n.ID] = n
+	}
+
+	for id, keys := range moving {
+		slices.Sort(keys)
+		for batch := range slices.Chunk(keys, BatchSize) {
+			b := Batch{From: c.Self, Items: make(map[string]store.Item, len(batch))}
+			for _, k := range batch {
+				b.Items[k] = items[k]
+			}
+			if err := c.handOver(ctx, owners[id], b); err != nil {
+				return err
+			}
+
+			dropped, err := c.Drop(b.Items)
+			if err != nil {
+				return err
+			}
+			c.mu.Lock()
+			c.moved += dropped
+			c.mu.Unlock()
+			log.Printf("Partition: handed %d keys over to %s", dropped, id)
+			if dropped < len(b.Items) {
+				return fmt.Errorf("%d keys written while handing them over to %s", len(b.Items)-dropped, id)
+			}
+		}
+	}
+	return nil
+}
+
+// handOverState takes the state outside the key space that another server owns out of the store and
+// hands it over, putting back whatever could not be handed over.
+func (c *Cluster) handOverState(ctx context.Context, ring *Ring) (err error) {
+	if c.Take == nil || c.Merge == nil {
+		return nil
+	}
+	changes, err := c.Take(func(name string) bool {
+		n, ok := ring.Owner(name)
+		return ok && n.ID != c.Self
+	})
+	if err != nil {
+		return err
+	}
+
+	moving := make(map[string][]store.Change) // Owner ID to the state it should have
+	owners := make(map[string]Node)
+	for _, ch := range changes {
+		name := ch.Key
+		if ch.Op == store.ChangeQueue {
+			name = strings.TrimSuffix(name, store.DeadLetterSuffix)
+		}
+		n, _ := ring.Owner(name)
+		moving[n.ID] = append(moving[n.ID], ch)
+		owners[n.ID] = n
+	}
+
+	var unsent []store.Change
+	defer func() {
+		if len(unsent) > 0 {
+			if merr := c.Merge(unsent); merr != nil {
+				err = errors.Join(err, fmt.Errorf("putting back state not handed over: %w", merr))
+			}
+		}
+	}()
+
+	for id, changes := range moving {
+		if err != nil {
+			unsent = append(unsent, changes...)
+			continue
+		}
+		for i := 0; i < len(changes); i += BatchSize {
+			batch := changes[i:min(i+BatchSize, len(changes))]
+			if err = c.handOver(ctx, owners[id], Batch{From: c.Self, Changes: batch}); err != nil {
+				unsent = append(unsent, changes[i:]...)
+				break
+			}
+			c.mu.Lock()
+			c.moved += len(batch)
+			c.mu.Unlock()
+			log.Printf("Partition: handed %d leases, queues, streams, rate limits and trash entries over to %s", len(batch), id)
+		}
+	}
+	return err
+}
+
+func (c *Cluster) handOver(ctx context.Context, n Node, b Batch) error {
+	body, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(ctx, n, http.MethodPost, ReceivePath, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Status returns the membership and the progress of rebalancing.
+func (c *Cluster) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Status{
+		Self:          c.Self,
+		Version:       c.membership.Version,
+		Nodes:         slices.Clone(c.membership.Nodes),
+		Rebalancing:   c.rebalancing,
+		Moved:         c.moved,
+		LastRebalance: c.lastRebalance,
+		LastError:     c.lastError,
+	}
+}
+
+func (c *Cluster) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,324 @@
+package partition
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kvstore/store"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server is a partition member whose keys are kept in a map, standing in for the store.
+type server struct {
+	mu      sync.Mutex
+	items   map[string]store.Item
+	state   map[string]store.Change // Leases and the like, by name
+	cluster *Cluster
+	node    Node
+}
+
+func (s *server) local() (map[string]store.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.items), nil
+}
+
+func (s *server) drop(items map[string]store.Item) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for k, item := range items {
+		if cur, ok := s.items[k]; ok && cur.Metadata.WriteCount == item.Metadata.WriteCount {
+			delete(s.items, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *server) take(move func(string) bool) ([]store.Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changes []store.Change
+	for name, c := range s.state {
+		if move(name) {
+			changes = append(changes, c)
+			delete(s.state, name)
+		}
+	}
+	return changes, nil
+}
+
+func (s *server) merge(changes []store.Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range changes {
+		s.state[c.Key] = c
+	}
+	return nil
+}
+
+func (s *server) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Collect(maps.Keys(s.state))
+}
+
+func (s *server) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return keysOf(s.items)
+}
+
+func keysOf(m map[string]store.Item) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startServers starts a server for each id, with a membership holding the first members of them.
+func startServers(t *testing.T, members int, ids ...string) []*server {
+	var servers []*server
+	var m Membership
+	for _, id := range ids {
+		s := &server{items: make(map[string]store.Item), state: make(map[string]store.Change)}
+		mux := http.NewServeMux()
+		mux.HandleFunc(RingPath, func(w http.ResponseWriter, r *http.Request) {
+			var m Membership
+			if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.cluster.SetMembership(m)
+		})
+		mux.HandleFunc(ReceivePath, func(w http.ResponseWriter, r *http.Request) {
+			var b Batch
+			if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.mu.Lock()
+			maps.Copy(s.items, b.Items)
+			s.mu.Unlock()
+			s.merge(b.Changes)
+		})
+		mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(ForwardedHeader) == "" {
+				http.Error(w, "not marked as forwarded", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, id)
+		})
+		mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "broken", http.StatusTeapot)
+		})
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+
+		s.node = Node{ID: id, Addr: srv.URL}
+		if len(servers) < members {
+			m.Nodes = append(m.Nodes, s.node)
+		}
+		servers = append(servers, s)
+	}
+
+	m.Version = 1
+	for _, s := range servers {
+		if !slicesHas(m.Nodes, s.node.ID) {
+			s.cluster = NewCluster(s.node.ID, Membership{})
+		} else {
+			s.cluster = NewCluster(s.node.ID, m)
+		}
+		s.cluster.Local = s.local
+		s.cluster.Drop = s.drop
+		s.cluster.Take = s.take
+		s.cluster.Merge = s.merge
+	}
+	return servers
+}
+
+func slicesHas(nodes []Node, id string) bool {
+	for _, n := range nodes {
+		if n.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// waitFor polls cond until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSetMembership(t *testing.T) {
+	c := NewCluster("n1", Membership{Version: 2, Nodes: nodes("n1")})
+
+	tests := []struct {
+		name    string
+		m       Membership
+		taken   bool
+		version uint64
+	}{
+		{"older", Membership{Version: 1, Nodes: nodes("n1", "n2")}, false, 2},
+		{"same", Membership{Version: 2, Nodes: nodes("n1", "n2")}, false, 2},
+		{"newer", Membership{Version: 3, Nodes: nodes("n1", "n2")}, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if taken := c.SetMembership(tt.m); taken != tt.taken {
+				t.Errorf("SetMembership = %v, want %v", taken, tt.taken)
+			}
+			if v := c.Membership().Version; v != tt.version {
+				t.Errorf("version = %d, want %d", v, tt.version)
+			}
+		})
+	}
+}
+
+func TestOwnerWithoutMembers(t *testing.T) {
+	c := NewCluster("n1", Membership{})
+	if _, err := c.Owner("a"); !errors.Is(err, ErrNoOwner) {
+		t.Errorf("Owner = %v, want ErrNoOwner", err)
+	}
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	servers := startServers(t, 2, "n1", "n2", "n3")
+	ctx := context.Background()
+
+	if _, err := servers[0].cluster.Join(ctx, servers[2].node); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range servers {
+		if m := s.cluster.Membership(); m.Version != 2 || len(m.Nodes) != 3 {
+			t.Errorf("%s has membership %+v, want version 2 with 3 servers", s.node.ID, m)
+		}
+	}
+
+	if _, err := servers[0].cluster.Join(ctx, servers[2].node); !errors.Is(err, ErrInvalidMember) {
+		t.Errorf("joining twice = %v, want ErrInvalidMember", err)
+	}
+
+	// The leaving server hears about it too
+	if _, err := servers[1].cluster.Leave(ctx, "n3"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range servers {
+		if m := s.cluster.Membership(); m.Version != 3 || slicesHas(m.Nodes, "n3") {
+			t.Errorf("%s has membership %+v, want version 3 without n3", s.node.ID, m)
+		}
+	}
+
+	if _, err := servers[1].cluster.Leave(ctx, "n3"); !errors.Is(err, ErrInvalidMember) {
+		t.Errorf("leaving twice = %v, want ErrInvalidMember", err)
+	}
+}
+
+func TestGather(t *testing.T) {
+	servers := startServers(t, 3, "n1", "n2", "n3")
+	c := servers[0].cluster
+
+	r := httptest.NewRequest(http.MethodGet, "/echo", nil)
+	bodies, err := c.Gather(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{"n1", "n2", "n3"} {
+		if string(bodies[i]) != id {
+			t.Errorf("body %d = %q, want %q", i, bodies[i], id)
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/fail", nil)
+	var se *StatusError
+	if _, err := c.Gather(context.Background(), r); !errors.As(err, &se) || se.Code != http.StatusTeapot {
+		t.Errorf("Gather = %v, want a StatusError with code %d", err, http.StatusTeapot)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	servers := startServers(t, 1, "n1", "n2")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	for _, s := range servers {
+		go s.cluster.Run(ctx)
+	}
+
+	const keys, leases = 1000, 100
+	for i := range keys {
+		servers[0].items[fmt.Sprintf("key-%d", i)] = store.Item{Value: i, Metadata: store.Metadata{WriteCount: 1}}
+	}
+	for i := range leases {
+		name := fmt.Sprintf("lease-%d", i)
+		servers[0].state[name] = store.Change{Op: store.ChangeLease, Key: name, Lease: &store.Lease{Name: name}}
+	}
+
+	if _, err := servers[0].cluster.Join(ctx, servers[1].node); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every key and lease ends up on its owner, and only there
+	owned := func(s *server) bool {
+		for _, k := range slices.Concat(s.keys(), s.names()) {
+			if n, _ := s.cluster.Owner(k); n.ID != s.node.ID {
+				return false
+			}
+		}
+		return true
+	}
+	waitFor(t, "keys to move to n2", func() bool {
+		return owned(servers[0]) && len(servers[0].keys())+len(servers[1].keys()) == keys &&
+			len(servers[0].names())+len(servers[1].names()) == leases
+	})
+	if n := len(servers[1].keys()); n < keys/4 || n > keys*3/4 {
+		t.Errorf("n2 has %d of %d keys, want about half", n, keys)
+	}
+	if n := len(servers[1].names()); n == 0 {
+		t.Errorf("n2 has no leases, want about half")
+	}
+	if st := servers[0].cluster.Status(); st.Moved != len(servers[1].keys())+len(servers[1].names()) || st.LastError != "" {
+		t.Errorf("n1 status = %+v", st)
+	}
+
+	// Leaving hands every key back
+	if _, err := servers[0].cluster.Leave(ctx, "n2"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "keys to move back to n1", func() bool {
+		return len(servers[0].keys()) == keys && len(servers[1].keys()) == 0 && len(servers[1].names()) == 0
+	})
+}
+
+func TestPreviousOwner(t *testing.T) {
+	c := NewCluster("n1", Membership{Version: 1, Nodes: nodes("n1")})
+	if _, ok := c.PreviousOwner("a"); ok {
+		t.Errorf("PreviousOwner() before any change reports an owner")
+	}
+
+	c.SetMembership(Membership{Version: 2, Nodes: nodes("n1", "n2")})
+	for i := range 100 {
+		key := fmt.Sprintf("key-%d", i)
+		owner, _ := c.Owner(key)
+		prev, ok := c.PreviousOwner(key)
+		if ok != (owner.ID == "n2") || (ok && prev.ID != "n1") {
+			t.Errorf("PreviousOwner(%s) = %s, %v with owner %s, want n1 for keys moved to n2", key, prev.ID, ok, owner.ID)
+		}
+	}
+}
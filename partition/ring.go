@@ -0,0 +1,100 @@
+package partition
+
+import (
+	"cmp"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is how many points each server gets on the ring. More points spread the keys
+// more evenly at the cost of a larger ring.
+const DefaultVirtualNodes = 128
+
+// Node is a server in a partitioned cluster.
+type Node struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"` // Base URL, e.g. http://localhost:8081
+}
+
+// Ring maps keys to nodes. It is immutable; a change of membership builds a new one.
+type Ring struct {
+	nodes  []Node   // Sorted by ID
+	points []uint64 // Sorted positions of the virtual nodes
+	owners []int    // Index into nodes of the owner of each point
+}
+
+type point struct {
+	hash  uint64
+	owner int
+}
+
+// NewRing builds a ring with vnodes points per node.
+func NewRing(nodes []Node, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+
+	r := &Ring{nodes: slices.Clone(nodes)}
+	slices.SortFunc(r.nodes, func(a, b Node) int { return cmp.Compare(a.ID, b.ID) })
+
+	points := make([]point, 0, len(r.nodes)*vnodes)
+	for i, n := range r.nodes {
+		for v := range vnodes {
+			points = append(points, point{hash(n.ID + "#" + strconv.Itoa(v)), i})
+		}
+	}
+	// Ties are broken by node ID so every server builds the same ring
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+
+	r.points = make([]uint64, len(points))
+	r.owners = make([]int, len(points))
+	for i, p := range points {
+		r.points[i], r.owners[i] = p.hash, p.owner
+	}
+	return r
+}
+
+// Owner returns the node that owns key: the one with the first point at or after the key's hash.
+func (r *Ring) Owner(key string) (Node, bool) {
+	if len(r.points) == 0 {
+		return Node{}, false
+	}
+
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0 // Wrap around
+	}
+	return r.nodes[r.owners[i]], true
+}
+
+// Nodes returns the members of the ring sorted by ID.
+func (r *Ring) Nodes() []Node {
+	return slices.Clone(r.nodes)
+}
+
+// Has reports whether id is a member of the ring.
+func (r *Ring) Has(id string) bool {
+	return slices.ContainsFunc(r.nodes, func(n Node) bool { return n.ID == id })
+}
+
+// hash places a string on the ring. FNV-1a is finalised with the SplitMix64 mixer, as on its own it
+// spreads similar strings such as "n1#1" and "n1#2" poorly.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
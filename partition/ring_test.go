@@ -0,0 +1,86 @@
+package partition
+
+import (
+	"fmt"
+	"testing"
+)
+
+func nodes(ids ...string) []Node {
+	var ns []Node
+	for _, id := range ids {
+		ns = append(ns, Node{ID: id, Addr: "http://" + id})
+	}
+	return ns
+}
+
+func TestRingEmpty(t *testing.T) {
+	if _, ok := NewRing(nil, 0).Owner("a"); ok {
+		t.Fatal("empty ring owns a key")
+	}
+}
+
+func TestRingDeterministic(t *testing.T) {
+	a := NewRing(nodes("n1", "n2", "n3"), 0)
+	b := NewRing(nodes("n3", "n1", "n2"), 0)
+
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i)
+		x, _ := a.Owner(key)
+		y, _ := b.Owner(key)
+		if x != y {
+			t.Fatalf("%s owned by %s and %s depending on member order", key, x.ID, y.ID)
+		}
+	}
+}
+
+func TestRingBalance(t *testing.T) {
+	const keys = 30000
+	r := NewRing(nodes("n1", "n2", "n3"), DefaultVirtualNodes)
+
+	counts := make(map[string]int)
+	for i := range keys {
+		n, _ := r.Owner(fmt.Sprintf("key-%d", i))
+		counts[n.ID]++
+	}
+
+	// With 128 points per server every share stays well within a fifth of a third
+	for _, id := range []string{"n1", "n2", "n3"} {
+		if share := float64(counts[id]) / keys; share < 0.8/3 || share > 1.2/3 {
+			t.Errorf("%s owns %.3f of the keys, want about 1/3 (%v)", id, share, counts)
+		}
+	}
+}
+
+func TestRingMinimalMovement(t *testing.T) {
+	const keys = 30000
+	before := NewRing(nodes("n1", "n2", "n3"), 0)
+	after := NewRing(nodes("n1", "n2", "n3", "n4"), 0)
+
+	moved := 0
+	for i := range keys {
+		key := fmt.Sprintf("key-%d", i)
+		x, _ := before.Owner(key)
+		y, _ := after.Owner(key)
+		if x == y {
+			continue
+		}
+		if y.ID != "n4" {
+			t.Fatalf("%s moved from %s to %s, want only moves to the new server", key, x.ID, y.ID)
+		}
+		moved++
+	}
+
+	if share := float64(moved) / keys; share < 0.15 || share > 0.35 {
+		t.Errorf("%.3f of the keys moved, want about 1/4", share)
+	}
+}
+
+func TestRingHas(t *testing.T) {
+	r := NewRing(nodes("n1", "n2"), 0)
+	if !r.Has("n1") || r.Has("n3") {
+		t.Errorf("Has: n1 %v, n3 %v", r.Has("n1"), r.Has("n3"))
+	}
+	if got := r.Nodes(); len(got) != 2 || got[0].ID != "n1" {
+		t.Errorf("Nodes = %v", got)
+	}
+}
@@ -0,0 +1,982 @@
+// Package raft implements the Raft consensus algorithm: leader election, log replication, log
+// compaction with snapshots and single-server membership changes.
+//
+// A Node replicates opaque commands to its peers and hands each one to a StateMachine once a quorum
+// has stored it. Reads can be made linearizable with ReadIndex, which confirms the node is still the
+// leader and waits until everything committed before the read has been applied.
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotLeader              = errors.New("raft: not the leader")
+	ErrLeadershipLost         = errors.New("raft: leadership lost before the entry was committed")
+	ErrConfigChangeInProgress = errors.New("raft: a membership change is already in progress")
+	ErrInvalidMember          = errors.New("raft: invalid membership change")
+	ErrStopped                = errors.New("raft: node stopped")
+)
+
+// State is the role a node plays in the cluster.
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return "follower"
+}
+
+// EntryType is the kind of a log entry.
+type EntryType int
+
+const (
+	EntryCommand EntryType = iota // Handed to the state machine
+	EntryNoop                     // Appended by a new leader to commit entries of earlier terms
+	EntryConfig                   // A new Configuration, in effect as soon as it is appended
+)
+
+// Entry is a single entry of the replicated log.
+type Entry struct {
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Type  EntryType `json:"type"`
+	Data  []byte    `json:"data,omitempty"`
+}
+
+// Member is a voting member of the cluster. Addr is where its Transport reaches it.
+type Member struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+// Configuration is the set of voting members.
+type Configuration struct {
+	Members []Member `json:"members"`
+}
+
+// Has reports whether id is a member.
+func (c Configuration) Has(id string) bool {
+	return slices.ContainsFunc(c.Members, func(m Member) bool { return m.ID == id })
+}
+
+// Addr returns the address of member id, or "" if it is not a member.
+func (c Configuration) Addr(id string) string {
+	for _, m := range c.Members {
+		if m.ID == id {
+			return m.Addr
+		}
+	}
+	return ""
+}
+
+func (c Configuration) quorum() int {
+	return len(c.Members)/2 + 1
+}
+
+// HardState is the state a node must persist before answering any RPC.
+type HardState struct {
+	Term uint64 `json:"term"`
+	Vote string `json:"vote"`
+}
+
+// Snapshot is the state machine as of Index, replacing every log entry up to it.
+type Snapshot struct {
+	Index  uint64        `json:"index"`
+	Term   uint64        `json:"term"`
+	Config Configuration `json:"config"`
+	Data   []byte        `json:"data"`
+}
+
+// StateMachine is the state replicated by the cluster. Apply is called with committed commands in log
+// order and its result is returned by Propose on the node that proposed the command. Apply, Snapshot
+// and Restore are never called concurrently.
+type StateMachine interface {
+	Apply(command []byte) any
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// Config configures a Node.
+type Config struct {
+	ID           string
+	Bootstrap    Configuration // Initial members, only used if Storage is empty
+	Storage      Storage
+	Transport    Transport
+	StateMachine StateMachine
+
+	HeartbeatInterval time.Duration // Default 50ms
+	ElectionTimeout   time.Duration // Minimum, randomised up to twice as long. Default 10 heartbeats
+	SnapshotThreshold uint64        // Applied entries between snapshots, default 1024
+	MaxAppendEntries  int           // Entries per AppendEntries RPC, default 64
+}
+
+// Status describes a node.
+type Status struct {
+	ID            string   `json:"id"`
+	State         string   `json:"state"`
+	Term          uint64   `json:"term"`
+	Leader        string   `json:"leader"`
+	LeaderAddr    string   `json:"leader_addr"`
+	CommitIndex   uint64   `json:"commit_index"`
+	LastApplied   uint64   `json:"last_applied"`
+	LastIndex     uint64   `json:"last_index"`
+	SnapshotIndex uint64   `json:"snapshot_index"`
+	Members       []Member `json:"members"`
+}
+
+// Node is a member of a Raft cluster.
+type Node struct {
+	id        string
+	cfg       Config
+	storage   Storage
+	transport Transport
+	sm        StateMachine
+
+	mu               sync.Mutex
+	state            State
+	term             uint64
+	vote             string
+	leader           string
+	log              []Entry // log[0] holds the index and term of the last snapshot
+	snapshot         Snapshot
+	config           Configuration // Latest configuration in the log
+	configIndex      uint64
+	commitIndex      uint64
+	lastApplied      uint64
+	electionDeadline time.Time
+	pending          map[uint64]proposal
+	changed          chan struct{} // Closed and replaced whenever state, commitIndex or lastApplied moves
+
+	// Leader only
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastContact map[string]time.Time // Last reply from each follower in this term
+	replicators map[string]*replicator
+	leaderStop  chan struct{} // Closed when leadership ends
+
+	applyMu sync.Mutex // Held while the state machine is used
+	applyCh chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type proposal struct {
+	term uint64
+	done chan result
+}
+
+type result struct {
+	value any
+	err   error
+}
+
+type replicator struct {
+	wake chan struct{}
+	stop chan struct{}
+}
+
+// NewNode loads a node from its storage, bootstrapping it with cfg.Bootstrap if the storage is empty.
+// A node without a configuration waits to be added to a cluster by its leader.
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 50 * time.Millisecond
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = 10 * cfg.HeartbeatInterval
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = 1024
+	}
+	if cfg.MaxAppendEntries <= 0 {
+		cfg.MaxAppendEntries = 64
+	}
+	if cfg.Storage == nil {
+		cfg.Storage = NewMemoryStorage()
+	}
+
+	n := &Node{
+		id:        cfg.ID,
+		cfg:       cfg,
+		storage:   cfg.Storage,
+		transport: cfg.Transport,
+		sm:        cfg.StateMachine,
+		pending:   make(map[uint64]proposal),
+		changed:   make(chan struct{}),
+		applyCh:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+
+	hs, snap, entries, err := n.storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	if hs.Term == 0 && snap.Index == 0 && len(entries) == 0 && len(cfg.Bootstrap.Members) > 0 {
+		// Every node bootstraps with the same entry, so their logs agree from the start
+		data, _ := json.Marshal(cfg.Bootstrap)
+		entries = []Entry{{Index: 1, Term: 1, Type: EntryConfig, Data: data}}
+		hs = HardState{Term: 1}
+		if err := n.storage.SetHardState(hs); err != nil {
+			return nil, err
+		}
+		if err := n.storage.Append(entries); err != nil {
+			return nil, err
+		}
+	}
+
+	n.term, n.vote = hs.Term, hs.Vote
+	n.snapshot = snap
+	n.log = append([]Entry{{Index: snap.Index, Term: snap.Term}}, entries...)
+	if snap.Data != nil {
+		if err := n.sm.Restore(snap.Data); err != nil {
+			return nil, fmt.Errorf("raft: restoring snapshot: %w", err)
+		}
+	}
+	n.commitIndex, n.lastApplied = snap.Index, snap.Index
+	n.recomputeConfig()
+
+	return n, nil
+}
+
+// Start runs the node until Stop is called.
+func (n *Node) Start() {
+	n.mu.Lock()
+	n.resetElection()
+	n.mu.Unlock()
+
+	n.wg.Add(2)
+	go n.run()
+	go n.applier()
+}
+
+// Stop shuts the node down. Pending proposals fail with ErrStopped.
+func (n *Node) Stop() {
+	close(n.stop)
+	n.mu.Lock()
+	if n.state == Leader {
+		close(n.leaderStop)
+		n.replicators = nil
+	}
+	n.state = Follower
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+// Status returns the current state of the node.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return Status{
+		ID:            n.id,
+		State:         n.state.String(),
+		Term:          n.term,
+		Leader:        n.leader,
+		LeaderAddr:    n.config.Addr(n.leader),
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		LastIndex:     n.lastIndex(),
+		SnapshotIndex: n.log[0].Index,
+		Members:       slices.Clone(n.config.Members),
+	}
+}
+
+// Leader returns the ID and address of the leader this node knows of, empty if there is none.
+func (n *Node) Leader() (id, addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader, n.config.Addr(n.leader)
+}
+
+// Propose replicates a command and returns the result of applying it once it has been committed.
+// If the context ends first the command may still be applied later.
+func (n *Node) Propose(ctx context.Context, command []byte) (any, error) {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	p := n.propose(Entry{Type: EntryCommand, Data: command})
+	n.mu.Unlock()
+
+	return n.wait(ctx, p)
+}
+
+// AddMember adds a voting member to the cluster. Only one membership change may be in flight at a time.
+func (n *Node) AddMember(ctx context.Context, m Member) error {
+	return n.changeConfig(ctx, func(c Configuration) (Configuration, error) {
+		if m.ID == "" || m.Addr == "" {
+			return c, fmt.Errorf("%w: a member needs an id and an address", ErrInvalidMember)
+		}
+		if c.Has(m.ID) {
+			return c, fmt.Errorf("%w: %s is already a member", ErrInvalidMember, m.ID)
+		}
+		c.Members = append(slices.Clone(c.Members), m)
+		return c, nil
+	})
+}
+
+// RemoveMember removes a member from the cluster. A leader that removes itself steps down once the
+// change has been committed.
+func (n *Node) RemoveMember(ctx context.Context, id string) error {
+	return n.changeConfig(ctx, func(c Configuration) (Configuration, error) {
+		if !c.Has(id) {
+			return c, fmt.Errorf("%w: %s is not a member", ErrInvalidMember, id)
+		}
+		c.Members = slices.DeleteFunc(slices.Clone(c.Members), func(m Member) bool { return m.ID == id })
+		return c, nil
+	})
+}
+
+func (n *Node) changeConfig(ctx context.Context, change func(Configuration) (Configuration, error)) error {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	if n.configIndex > n.commitIndex {
+		n.mu.Unlock()
+		return ErrConfigChangeInProgress
+	}
+	c, err := change(n.config)
+	if err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	data, _ := json.Marshal(c)
+	p := n.propose(Entry{Type: EntryConfig, Data: data})
+	n.mu.Unlock()
+
+	_, err = n.wait(ctx, p)
+	return err
+}
+
+// ReadIndex returns once the state machine reflects every write committed before it was called, after
+// confirming with a quorum that this node is still the leader. Reads made afterwards are linearizable.
+func (n *Node) ReadIndex(ctx context.Context) error {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	term := n.term
+	n.mu.Unlock()
+
+	// The commit index is only known to be current once an entry of this term has committed
+	err := n.waitUntil(ctx, func() bool {
+		t, _ := n.termAt(n.commitIndex)
+		return n.state != Leader || n.term != term || t == term
+	})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	if n.state != Leader || n.term != term {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	readIndex := n.commitIndex
+	n.mu.Unlock()
+
+	if err := n.confirmLeadership(ctx, term); err != nil {
+		return err
+	}
+	return n.waitUntil(ctx, func() bool { return n.lastApplied >= readIndex })
+}
+
+// confirmLeadership sends a round of heartbeats and waits for a quorum to accept them.
+func (n *Node) confirmLeadership(ctx context.Context, term uint64) error {
+	n.mu.Lock()
+	quorum := n.config.quorum()
+	acks := 0
+	if n.config.Has(n.id) {
+		acks++
+	}
+	type call struct {
+		addr string
+		args AppendEntriesArgs
+	}
+	var calls []call
+	for _, m := range n.config.Members {
+		if m.ID != n.id {
+			calls = append(calls, call{m.Addr, n.heartbeatArgs(m.ID)})
+		}
+	}
+	n.mu.Unlock()
+
+	replies := make(chan bool, len(calls))
+	for _, c := range calls {
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, n.cfg.ElectionTimeout)
+			defer cancel()
+			reply, err := n.transport.AppendEntries(ctx, c.addr, c.args)
+			if err == nil && reply.Term > term {
+				n.mu.Lock()
+				n.observeTerm(reply.Term)
+				n.mu.Unlock()
+			}
+			replies <- err == nil && reply.Term == term
+		}()
+	}
+
+	for range calls {
+		if acks >= quorum {
+			break
+		}
+		select {
+		case ok := <-replies:
+			if ok {
+				acks++
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if acks < quorum {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// propose appends an entry as leader and registers a proposal waiting for it.
+func (n *Node) propose(e Entry) proposal {
+	index := n.appendLocal(e)
+	p := proposal{term: n.term, done: make(chan result, 1)}
+	n.pending[index] = p
+	n.wakeReplicators()
+	n.advanceCommit()
+	return p
+}
+
+func (n *Node) wait(ctx context.Context, p proposal) (any, error) {
+	select {
+	case r := <-p.done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.stop:
+		return nil, ErrStopped
+	}
+}
+
+// waitUntil blocks until cond, evaluated with the lock held, is true.
+func (n *Node) waitUntil(ctx context.Context, cond func() bool) error {
+	for {
+		n.mu.Lock()
+		ok, changed := cond(), n.changed
+		n.mu.Unlock()
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.stop:
+			return ErrStopped
+		}
+	}
+}
+
+// run drives elections.
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.state != Leader && n.config.Has(n.id) && time.Now().After(n.electionDeadline) {
+			n.startElection()
+		}
+		if n.state == Leader && !n.quorumContact() {
+			// A leader cut off from the majority steps down instead of taking writes it cannot commit
+			log.Printf("Raft: %s lost contact with a quorum in term %d, stepping down", n.id, n.term)
+			n.becomeFollower(n.term)
+			n.leader = ""
+			n.resetElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *Node) startElection() {
+	n.state = Candidate
+	n.term++
+	n.vote = n.id
+	n.leader = ""
+	n.persistState()
+	n.resetElection()
+	n.notify()
+
+	term := n.term
+	args := RequestVoteArgs{Term: term, CandidateID: n.id, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()}
+	votes := 1
+	if votes >= n.config.quorum() {
+		n.becomeLeader()
+		return
+	}
+
+	for _, m := range n.config.Members {
+		if m.ID == n.id {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+			defer cancel()
+			reply, err := n.transport.RequestVote(ctx, m.Addr, args)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.observeTerm(reply.Term) || n.state != Candidate || n.term != term || !reply.VoteGranted {
+				return
+			}
+			votes++
+			if votes >= n.config.quorum() {
+				n.becomeLeader()
+			}
+		}()
+	}
+}
+
+func (n *Node) becomeLeader() {
+	log.Printf("Raft: %s elected leader for term %d", n.id, n.term)
+
+	n.state = Leader
+	n.leader = n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastContact = make(map[string]time.Time)
+	n.replicators = make(map[string]*replicator)
+	n.leaderStop = make(chan struct{})
+	n.syncReplicators()
+
+	// Entries of earlier terms can only be committed along with one of this term
+	n.appendLocal(Entry{Type: EntryNoop})
+	n.wakeReplicators()
+	n.advanceCommit()
+	n.notify()
+}
+
+// becomeFollower steps down, moving to term if it is newer.
+func (n *Node) becomeFollower(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.vote = ""
+		n.persistState()
+	}
+	if n.state == Leader {
+		close(n.leaderStop)
+		n.replicators = nil
+	}
+	n.state = Follower
+	n.notify()
+}
+
+// observeTerm steps down if term is newer than ours and reports whether it did.
+func (n *Node) observeTerm(term uint64) bool {
+	if term <= n.term {
+		return false
+	}
+	n.becomeFollower(term)
+	n.leader = ""
+	n.resetElection()
+	return true
+}
+
+// syncReplicators starts a replicator for every new member and stops those of removed members.
+func (n *Node) syncReplicators() {
+	if n.state != Leader {
+		return
+	}
+
+	for _, m := range n.config.Members {
+		if _, ok := n.replicators[m.ID]; ok || m.ID == n.id {
+			continue
+		}
+		r := &replicator{wake: make(chan struct{}, 1), stop: make(chan struct{})}
+		n.replicators[m.ID] = r
+		n.nextIndex[m.ID] = n.lastIndex() + 1
+		n.matchIndex[m.ID] = 0
+		n.lastContact[m.ID] = time.Now() // Grace period before it counts as unreachable
+		go n.replicate(m, n.term, r, n.leaderStop)
+	}
+
+	for id, r := range n.replicators {
+		if !n.config.Has(id) {
+			close(r.stop)
+			delete(n.replicators, id)
+		}
+	}
+}
+
+func (n *Node) wakeReplicators() {
+	for _, r := range n.replicators {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// replicate keeps a follower up to date for as long as this node leads term.
+func (n *Node) replicate(m Member, term uint64, r *replicator, leaderStop chan struct{}) {
+	heartbeat := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		if n.sendAppend(m, term) {
+			continue // More to send
+		}
+
+		select {
+		case <-n.stop:
+			return
+		case <-leaderStop:
+			return
+		case <-r.stop:
+			return
+		case <-r.wake:
+		case <-heartbeat.C:
+		}
+	}
+}
+
+// sendAppend sends the next batch of entries, or a snapshot, to a follower and reports whether there
+// is more to send straight away.
+func (n *Node) sendAppend(m Member, term uint64) bool {
+	n.mu.Lock()
+	if n.state != Leader || n.term != term || !n.config.Has(m.ID) {
+		n.mu.Unlock()
+		return false
+	}
+
+	next := n.nextIndex[m.ID]
+	if next <= n.log[0].Index {
+		return n.sendSnapshot(m, term)
+	}
+
+	args := n.heartbeatArgs(m.ID)
+	last := min(n.lastIndex(), next+uint64(n.cfg.MaxAppendEntries)-1)
+	if next <= last {
+		args.Entries = slices.Clone(n.log[next-n.log[0].Index : last-n.log[0].Index+1])
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	defer cancel()
+	reply, err := n.transport.AppendEntries(ctx, m.Addr, args)
+	if err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.observeTerm(reply.Term) || n.state != Leader || n.term != term {
+		return false
+	}
+	n.lastContact[m.ID] = time.Now()
+
+	if !reply.Success {
+		n.nextIndex[m.ID] = max(1, min(reply.ConflictIndex, next-1))
+		return true
+	}
+
+	match := args.PrevLogIndex + uint64(len(args.Entries))
+	if match > n.matchIndex[m.ID] {
+		n.matchIndex[m.ID] = match
+		n.advanceCommit()
+	}
+	n.nextIndex[m.ID] = max(n.nextIndex[m.ID], match+1)
+	return n.nextIndex[m.ID] <= n.lastIndex()
+}
+
+// sendSnapshot sends the latest snapshot to a follower that needs entries already compacted away.
+// It is called with the lock held and releases it.
+func (n *Node) sendSnapshot(m Member, term uint64) bool {
+	args := InstallSnapshotArgs{Term: term, LeaderID: n.id, Snapshot: n.snapshot}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*n.cfg.ElectionTimeout)
+	defer cancel()
+	reply, err := n.transport.InstallSnapshot(ctx, m.Addr, args)
+	if err != nil {
+		log.Printf("Raft: sending snapshot to %s: %v", m.ID, err)
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.observeTerm(reply.Term) || n.state != Leader || n.term != term {
+		return false
+	}
+	n.lastContact[m.ID] = time.Now()
+
+	n.matchIndex[m.ID] = max(n.matchIndex[m.ID], args.Snapshot.Index)
+	n.nextIndex[m.ID] = n.matchIndex[m.ID] + 1
+	n.advanceCommit()
+	return n.nextIndex[m.ID] <= n.lastIndex()
+}
+
+// heartbeatArgs returns AppendEntries arguments without entries for a follower.
+func (n *Node) heartbeatArgs(id string) AppendEntriesArgs {
+	prev := max(n.nextIndex[id], n.log[0].Index+1) - 1
+	prevTerm, _ := n.termAt(prev)
+	return AppendEntriesArgs{
+		Term:         n.term,
+		LeaderID:     n.id,
+		PrevLogIndex: prev,
+		PrevLogTerm:  prevTerm,
+		LeaderCommit: n.commitIndex,
+	}
+}
+
+// quorumContact reports whether a quorum of members, this node included, has been heard from within
+// the election timeout.
+func (n *Node) quorumContact() bool {
+	cutoff := time.Now().Add(-n.cfg.ElectionTimeout)
+	count := 0
+	for _, m := range n.config.Members {
+		if m.ID == n.id || n.lastContact[m.ID].After(cutoff) {
+			count++
+		}
+	}
+	return count >= n.config.quorum()
+}
+
+// advanceCommit commits the latest entry of the current term stored on a quorum.
+func (n *Node) advanceCommit() {
+	if n.state != Leader {
+		return
+	}
+
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if t, _ := n.termAt(index); t != n.term {
+			break // Earlier terms are only committed through an entry of this one
+		}
+
+		count := 0
+		for _, m := range n.config.Members {
+			if m.ID == n.id || n.matchIndex[m.ID] >= index {
+				count++
+			}
+		}
+		if count >= n.config.quorum() {
+			n.commitIndex = index
+			n.notify()
+			n.wakeApplier()
+			break
+		}
+	}
+
+	// A leader that removed itself leads until the change commits, then steps down
+	if !n.config.Has(n.id) && n.configIndex <= n.commitIndex {
+		n.becomeFollower(n.term)
+		n.leader = ""
+	}
+}
+
+// appendLocal appends an entry of the current term to the log and returns its index.
+func (n *Node) appendLocal(e Entry) uint64 {
+	e.Index = n.lastIndex() + 1
+	e.Term = n.term
+	n.log = append(n.log, e)
+	if err := n.storage.Append([]Entry{e}); err != nil {
+		panic(fmt.Sprintf("raft: storing entry %d: %v", e.Index, err))
+	}
+	if e.Type == EntryConfig {
+		n.applyConfigEntry(e)
+	}
+	return e.Index
+}
+
+func (n *Node) applyConfigEntry(e Entry) {
+	var c Configuration
+	if err := json.Unmarshal(e.Data, &c); err != nil {
+		log.Printf("Raft: ignoring invalid configuration at %d: %v", e.Index, err)
+		return
+	}
+	n.config, n.configIndex = c, e.Index
+	n.syncReplicators()
+}
+
+// recomputeConfig finds the latest configuration after the log has been truncated or replaced.
+func (n *Node) recomputeConfig() {
+	for i := len(n.log) - 1; i > 0; i-- {
+		if n.log[i].Type == EntryConfig {
+			n.applyConfigEntry(n.log[i])
+			return
+		}
+	}
+	n.config, n.configIndex = n.snapshot.Config, n.snapshot.Index
+	n.syncReplicators()
+}
+
+// configAt returns the configuration in effect at index.
+func (n *Node) configAt(index uint64) Configuration {
+	for i := int(index - n.log[0].Index); i > 0; i-- {
+		if n.log[i].Type == EntryConfig {
+			var c Configuration
+			if json.Unmarshal(n.log[i].Data, &c) == nil {
+				return c
+			}
+		}
+	}
+	return n.snapshot.Config
+}
+
+// applier hands committed entries to the state machine.
+func (n *Node) applier() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.applyCh:
+		}
+
+		for n.applyBatch() {
+		}
+	}
+}
+
+// applyBatch applies the next committed entries and reports whether any were applied.
+func (n *Node) applyBatch() bool {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	if n.lastApplied >= n.commitIndex {
+		n.mu.Unlock()
+		return false
+	}
+	first := n.log[0].Index
+	last := min(n.commitIndex, n.lastApplied+uint64(n.cfg.MaxAppendEntries))
+	entries := slices.Clone(n.log[n.lastApplied+1-first : last-first+1])
+	n.mu.Unlock()
+
+	results := make([]any, len(entries))
+	for i, e := range entries {
+		if e.Type == EntryCommand {
+			results[i] = n.sm.Apply(e.Data)
+		}
+	}
+
+	n.mu.Lock()
+	for i, e := range entries {
+		p, ok := n.pending[e.Index]
+		if !ok {
+			continue
+		}
+		delete(n.pending, e.Index)
+		if p.term == e.Term {
+			p.done <- result{value: results[i]}
+		} else {
+			p.done <- result{err: ErrLeadershipLost}
+		}
+	}
+	n.lastApplied = last
+	n.notify()
+	compact := n.lastApplied-n.log[0].Index >= n.cfg.SnapshotThreshold
+	n.mu.Unlock()
+
+	if compact {
+		n.takeSnapshot()
+	}
+	return true
+}
+
+// takeSnapshot replaces the applied part of the log with a snapshot. applyMu must be held.
+func (n *Node) takeSnapshot() {
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		log.Printf("Raft: taking snapshot: %v", err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	index := n.lastApplied
+	term, _ := n.termAt(index)
+	snap := Snapshot{Index: index, Term: term, Config: n.configAt(index), Data: data}
+	rest := slices.Clone(n.log[index-n.log[0].Index+1:])
+	if err := n.storage.SaveSnapshot(snap, rest); err != nil {
+		panic(fmt.Sprintf("raft: storing snapshot: %v", err))
+	}
+
+	n.snapshot = snap
+	n.log = append([]Entry{{Index: index, Term: term}}, rest...)
+}
+
+func (n *Node) wakeApplier() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// notify wakes everything waiting in waitUntil.
+func (n *Node) notify() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+func (n *Node) persistState() {
+	if err := n.storage.SetHardState(HardState{Term: n.term, Vote: n.vote}); err != nil {
+		panic(fmt.Sprintf("raft: storing state: %v", err))
+	}
+}
+
+func (n *Node) resetElection() {
+	timeout := n.cfg.ElectionTimeout + rand.N(n.cfg.ElectionTimeout)
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// termAt returns the term of the entry at index, if it is in the log or is the last snapshotted entry.
+func (n *Node) termAt(index uint64) (uint64, bool) {
+	first := n.log[0].Index
+	if index < first || index > n.lastIndex() {
+		return 0, false
+	}
+	return n.log[index-first].Term, true
+}
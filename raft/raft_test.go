@@ -0,0 +1,358 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listMachine is a state machine that appends every command to a list.
+type listMachine struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (m *listMachine) Apply(command []byte) any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.list = append(m.list, string(command))
+	return len(m.list)
+}
+
+func (m *listMachine) Snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Marshal(m.list)
+}
+
+func (m *listMachine) Restore(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.list = nil
+	return json.Unmarshal(data, &m.list)
+}
+
+func (m *listMachine) get() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.list)
+}
+
+// network delivers RPCs between nodes in memory and can cut nodes off.
+type network struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	cut   map[string]bool
+}
+
+var errUnreachable = errors.New("unreachable")
+
+func (nw *network) node(from, to string) (*Node, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if nw.cut[from] || nw.cut[to] || nw.nodes[to] == nil {
+		return nil, errUnreachable
+	}
+	return nw.nodes[to], nil
+}
+
+func (nw *network) isolate(id string, cut bool) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.cut[id] = cut
+}
+
+// transport is the view of the network from one node.
+type transport struct {
+	nw   *network
+	from string
+}
+
+func (t transport) RequestVote(ctx context.Context, addr string, args RequestVoteArgs) (RequestVoteReply, error) {
+	n, err := t.nw.node(t.from, addr)
+	if err != nil {
+		return RequestVoteReply{}, err
+	}
+	return n.RequestVote(args), nil
+}
+
+func (t transport) AppendEntries(ctx context.Context, addr string, args AppendEntriesArgs) (AppendEntriesReply, error) {
+	n, err := t.nw.node(t.from, addr)
+	if err != nil {
+		return AppendEntriesReply{}, err
+	}
+	return n.AppendEntries(args), nil
+}
+
+func (t transport) InstallSnapshot(ctx context.Context, addr string, args InstallSnapshotArgs) (InstallSnapshotReply, error) {
+	n, err := t.nw.node(t.from, addr)
+	if err != nil {
+		return InstallSnapshotReply{}, err
+	}
+	return n.InstallSnapshot(args)
+}
+
+type cluster struct {
+	t        *testing.T
+	nw       *network
+	nodes    map[string]*Node
+	machines map[string]*listMachine
+}
+
+func newCluster(t *testing.T, size int, threshold uint64) *cluster {
+	c := &cluster{
+		t:        t,
+		nw:       &network{nodes: make(map[string]*Node), cut: make(map[string]bool)},
+		nodes:    make(map[string]*Node),
+		machines: make(map[string]*listMachine),
+	}
+
+	var boot Configuration
+	for i := range size {
+		id := fmt.Sprintf("n%d", i+1)
+		boot.Members = append(boot.Members, Member{ID: id, Addr: id})
+	}
+	for _, m := range boot.Members {
+		c.start(m.ID, boot, threshold)
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+	return c
+}
+
+func (c *cluster) start(id string, boot Configuration, threshold uint64) *Node {
+	c.t.Helper()
+	sm := &listMachine{}
+	n, err := NewNode(Config{
+		ID:                id,
+		Bootstrap:         boot,
+		Transport:         transport{c.nw, id},
+		StateMachine:      sm,
+		HeartbeatInterval: 10 * time.Millisecond,
+		ElectionTimeout:   50 * time.Millisecond,
+		SnapshotThreshold: threshold,
+	})
+	if err != nil {
+		c.t.Fatalf("NewNode() returned an error: %v", err)
+	}
+
+	c.nw.mu.Lock()
+	c.nw.nodes[id] = n
+	c.nw.mu.Unlock()
+	c.nodes[id] = n
+	c.machines[id] = sm
+	n.Start()
+	return n
+}
+
+// leader waits for exactly one leader among the nodes that are not cut off.
+func (c *cluster) leader() *Node {
+	c.t.Helper()
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var leaders []*Node
+		for id, n := range c.nodes {
+			if n.Status().State == "leader" && !c.nw.cut[id] {
+				leaders = append(leaders, n)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+	}
+	c.t.Fatalf("no single leader elected")
+	return nil
+}
+
+func (c *cluster) propose(cmd string) {
+	c.t.Helper()
+	for range 10 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := c.leader().Propose(ctx, []byte(cmd))
+		cancel()
+		if err == nil {
+			return
+		}
+	}
+	c.t.Fatalf("Propose(%q) kept failing", cmd)
+}
+
+// converged waits until every listed node has applied want.
+func (c *cluster) converged(want []string, ids ...string) {
+	c.t.Helper()
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		done := true
+		for _, id := range ids {
+			if !slices.Equal(c.machines[id].get(), want) {
+				done = false
+			}
+		}
+		if done {
+			return
+		}
+	}
+	for _, id := range ids {
+		c.t.Errorf("%s applied %v, want %v", id, c.machines[id].get(), want)
+	}
+	c.t.FailNow()
+}
+
+func TestElection(t *testing.T) {
+	c := newCluster(t, 3, 0)
+	leader := c.leader()
+
+	term := leader.Status().Term
+	for _, n := range c.nodes {
+		if st := n.Status(); st.Term != term || st.Leader != leader.id {
+			t.Errorf("%s Status() = %+v, want leader %s in term %d", n.id, st, leader.id, term)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	c := newCluster(t, 3, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i, cmd := range []string{"a", "b", "c"} {
+		got, err := c.leader().Propose(ctx, []byte(cmd))
+		if err != nil || got != i+1 {
+			t.Fatalf("Propose(%q) = %v, %v, want %d", cmd, got, err, i+1)
+		}
+	}
+	c.converged([]string{"a", "b", "c"}, "n1", "n2", "n3")
+
+	for _, n := range c.nodes {
+		if n != c.leader() {
+			if _, err := n.Propose(ctx, []byte("x")); !errors.Is(err, ErrNotLeader) {
+				t.Errorf("Propose() on a follower error = %v, want %v", err, ErrNotLeader)
+			}
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	c := newCluster(t, 3, 0)
+	c.propose("a")
+
+	old := c.leader()
+	c.nw.isolate(old.id, true)
+
+	// The rest elect a new leader and keep committing
+	c.propose("b")
+	if c.leader() == old {
+		t.Fatalf("isolated leader is still the leader")
+	}
+
+	// The old leader cannot commit on its own
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := old.Propose(ctx, []byte("lost")); err == nil {
+		t.Errorf("Propose() on an isolated leader succeeded")
+	}
+
+	// Without a quorum it steps down by itself
+	for deadline := time.Now().Add(time.Second); old.Status().State == "leader"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("isolated leader did not step down")
+		}
+	}
+
+	// Once it is back it drops its uncommitted entry and catches up
+	c.nw.isolate(old.id, false)
+	c.propose("c")
+	c.converged([]string{"a", "b", "c"}, "n1", "n2", "n3")
+}
+
+func TestSnapshots(t *testing.T) {
+	c := newCluster(t, 3, 5)
+	c.propose("first")
+
+	lagging := "n1"
+	if c.leader().id == lagging {
+		lagging = "n2"
+	}
+	c.nw.isolate(lagging, true)
+
+	var want []string
+	want = append(want, "first")
+	for i := range 20 {
+		cmd := fmt.Sprint(i)
+		c.propose(cmd)
+		want = append(want, cmd)
+	}
+	if st := c.leader().Status(); st.SnapshotIndex == 0 {
+		t.Fatalf("leader Status() = %+v, want a snapshot", st)
+	}
+
+	// The log the lagging node needs is gone, so it is sent the snapshot
+	c.nw.isolate(lagging, false)
+	c.converged(want, "n1", "n2", "n3")
+	if st := c.nodes[lagging].Status(); st.SnapshotIndex == 0 {
+		t.Errorf("lagging node Status() = %+v, want it to have installed a snapshot", st)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	c := newCluster(t, 3, 0)
+	c.propose("a")
+
+	// A node without a configuration waits to be added
+	c.start("n4", Configuration{}, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.leader().AddMember(ctx, Member{ID: "n4", Addr: "n4"}); err != nil {
+		t.Fatalf("AddMember() returned an error: %v", err)
+	}
+	c.propose("b")
+	c.converged([]string{"a", "b"}, "n1", "n2", "n3", "n4")
+
+	// Removing the leader hands leadership to the rest
+	old := c.leader()
+	if err := old.RemoveMember(ctx, old.id); err != nil {
+		t.Fatalf("RemoveMember() returned an error: %v", err)
+	}
+	old.Stop()
+	delete(c.nodes, old.id)
+	c.nw.isolate(old.id, true)
+
+	c.propose("c")
+	if st := c.leader().Status(); len(st.Members) != 3 || slices.ContainsFunc(st.Members, func(m Member) bool { return m.ID == old.id }) {
+		t.Errorf("Status() members = %v, want 3 without %s", st.Members, old.id)
+	}
+	var rest []string
+	for id := range c.nodes {
+		rest = append(rest, id)
+	}
+	c.converged([]string{"a", "b", "c"}, rest...)
+}
+
+func TestReadIndex(t *testing.T) {
+	c := newCluster(t, 3, 0)
+	c.propose("a")
+	leader := c.leader()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := leader.ReadIndex(ctx); err != nil {
+		t.Fatalf("ReadIndex() returned an error: %v", err)
+	}
+	if got := c.machines[leader.id].get(); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("state after ReadIndex() = %v, want [a]", got)
+	}
+
+	// A leader cut off from the others cannot confirm it still leads
+	c.nw.isolate(leader.id, true)
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := leader.ReadIndex(ctx); err == nil {
+		t.Errorf("ReadIndex() on an isolated leader succeeded")
+	}
+}
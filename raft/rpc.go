@@ -0,0 +1,301 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// RequestVoteArgs asks for a vote in an election.
+type RequestVoteArgs struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type RequestVoteReply struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+// AppendEntriesArgs replicates entries to a follower. Without entries it is a heartbeat.
+type AppendEntriesArgs struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leader_id"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+// AppendEntriesReply answers AppendEntries. When the follower's log does not match, ConflictIndex is
+// where the leader should try next, so it can skip a whole conflicting term at once.
+type AppendEntriesReply struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// InstallSnapshotArgs replaces a follower's state with a snapshot. Snapshots are sent whole.
+type InstallSnapshotArgs struct {
+	Term     uint64   `json:"term"`
+	LeaderID string   `json:"leader_id"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type InstallSnapshotReply struct {
+	Term uint64 `json:"term"`
+}
+
+// Transport carries RPCs to the member at addr.
+type Transport interface {
+	RequestVote(ctx context.Context, addr string, args RequestVoteArgs) (RequestVoteReply, error)
+	AppendEntries(ctx context.Context, addr string, args AppendEntriesArgs) (AppendEntriesReply, error)
+	InstallSnapshot(ctx context.Context, addr string, args InstallSnapshotArgs) (InstallSnapshotReply, error)
+}
+
+// RequestVote handles a RequestVote RPC.
+func (n *Node) RequestVote(args RequestVoteArgs) RequestVoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.observeTerm(args.Term)
+	reply := RequestVoteReply{Term: n.term}
+	if args.Term < n.term || (n.vote != "" && n.vote != args.CandidateID) {
+		return reply
+	}
+
+	// Only vote for candidates whose log holds at least everything ours does
+	if args.LastLogTerm < n.lastTerm() || (args.LastLogTerm == n.lastTerm() && args.LastLogIndex < n.lastIndex()) {
+		return reply
+	}
+
+	n.vote = args.CandidateID
+	n.persistState()
+	n.resetElection()
+	reply.VoteGranted = true
+	return reply
+}
+
+// AppendEntries handles an AppendEntries RPC.
+func (n *Node) AppendEntries(args AppendEntriesArgs) AppendEntriesReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if args.Term < n.term {
+		return AppendEntriesReply{Term: n.term}
+	}
+	if args.Term > n.term || n.state != Follower {
+		n.becomeFollower(args.Term)
+	}
+	n.leader = args.LeaderID
+	n.resetElection()
+	reply := AppendEntriesReply{Term: n.term}
+
+	// The entry before the new ones has to match, unless it is already covered by our snapshot
+	first := n.log[0].Index
+	if args.PrevLogIndex > n.lastIndex() {
+		reply.ConflictIndex = n.lastIndex() + 1
+		return reply
+	}
+	if args.PrevLogIndex >= first {
+		if t, _ := n.termAt(args.PrevLogIndex); t != args.PrevLogTerm {
+			index := args.PrevLogIndex
+			for index > first+1 {
+				if prev, _ := n.termAt(index - 1); prev != t {
+					break
+				}
+				index--
+			}
+			reply.ConflictIndex = index
+			return reply
+		}
+	}
+
+	for i, e := range args.Entries {
+		if e.Index <= first {
+			continue
+		}
+		if e.Index <= n.lastIndex() {
+			if t, _ := n.termAt(e.Index); t == e.Term {
+				continue
+			}
+		}
+		n.appendEntries(args.Entries[i:])
+		break
+	}
+
+	reply.Success = true
+	if last := args.PrevLogIndex + uint64(len(args.Entries)); args.LeaderCommit > n.commitIndex {
+		n.commitIndex = max(n.commitIndex, min(args.LeaderCommit, last))
+		n.notify()
+		n.wakeApplier()
+	}
+	return reply
+}
+
+// appendEntries writes entries from the leader, replacing any conflicting suffix of the log.
+func (n *Node) appendEntries(entries []Entry) {
+	first := n.log[0].Index
+	truncated := entries[0].Index <= n.lastIndex()
+
+	n.log = append(n.log[:entries[0].Index-first], entries...)
+	if err := n.storage.Append(entries); err != nil {
+		panic(fmt.Sprintf("raft: storing entries from %d: %v", entries[0].Index, err))
+	}
+
+	if truncated {
+		n.recomputeConfig()
+		return
+	}
+	for _, e := range entries {
+		if e.Type == EntryConfig {
+			n.applyConfigEntry(e)
+		}
+	}
+}
+
+// InstallSnapshot handles an InstallSnapshot RPC.
+func (n *Node) InstallSnapshot(args InstallSnapshotArgs) (InstallSnapshotReply, error) {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if args.Term < n.term {
+		return InstallSnapshotReply{Term: n.term}, nil
+	}
+	if args.Term > n.term || n.state != Follower {
+		n.becomeFollower(args.Term)
+	}
+	n.leader = args.LeaderID
+	n.resetElection()
+	reply := InstallSnapshotReply{Term: n.term}
+
+	snap := args.Snapshot
+	if snap.Index <= n.lastApplied {
+		return reply, nil
+	}
+
+	// Keep the entries after the snapshot if our log agrees with it there
+	var rest []Entry
+	if t, ok := n.termAt(snap.Index); ok && t == snap.Term {
+		rest = slices.Clone(n.log[snap.Index-n.log[0].Index+1:])
+	}
+
+	if err := n.sm.Restore(snap.Data); err != nil {
+		return reply, fmt.Errorf("raft: restoring snapshot %d: %w", snap.Index, err)
+	}
+	if err := n.storage.SaveSnapshot(snap, rest); err != nil {
+		panic(fmt.Sprintf("raft: storing snapshot: %v", err))
+	}
+
+	n.snapshot = snap
+	n.log = append([]Entry{{Index: snap.Index, Term: snap.Term}}, rest...)
+	n.commitIndex = max(n.commitIndex, snap.Index)
+	n.lastApplied = snap.Index
+	n.recomputeConfig()
+
+	// Entries proposed here and covered by the snapshot were never applied one by one
+	for index, p := range n.pending {
+		if index <= snap.Index {
+			delete(n.pending, index)
+			p.done <- result{err: ErrLeadershipLost}
+		}
+	}
+	n.notify()
+
+	return reply, nil
+}
+
+// Handler serves the RPCs of the node over HTTP, as sent by HTTPTransport.
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /vote", func(w http.ResponseWriter, r *http.Request) {
+		serveRPC(w, r, func(args RequestVoteArgs) (RequestVoteReply, error) { return n.RequestVote(args), nil })
+	})
+	mux.HandleFunc("POST /append", func(w http.ResponseWriter, r *http.Request) {
+		serveRPC(w, r, func(args AppendEntriesArgs) (AppendEntriesReply, error) { return n.AppendEntries(args), nil })
+	})
+	mux.HandleFunc("POST /snapshot", func(w http.ResponseWriter, r *http.Request) {
+		serveRPC(w, r, n.InstallSnapshot)
+	})
+	return mux
+}
+
+func serveRPC[Args, Reply any](w http.ResponseWriter, r *http.Request, handle func(Args) (Reply, error)) {
+	var args Args
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reply, err := handle(args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// HTTPTransport sends RPCs as JSON to the Handler of the member, mounted at Path under its address.
+type HTTPTransport struct {
+	Client *http.Client
+	Path   string // e.g. "/kvs/raft"
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, addr string, args RequestVoteArgs) (RequestVoteReply, error) {
+	var reply RequestVoteReply
+	err := t.call(ctx, addr, "/vote", args, &reply)
+	return reply, err
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, addr string, args AppendEntriesArgs) (AppendEntriesReply, error) {
+	var reply AppendEntriesReply
+	err := t.call(ctx, addr, "/append", args, &reply)
+	return reply, err
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, addr string, args InstallSnapshotArgs) (InstallSnapshotReply, error) {
+	var reply InstallSnapshotReply
+	err := t.call(ctx, addr, "/snapshot", args, &reply)
+	return reply, err
+}
+
+func (t *HTTPTransport) call(ctx context.Context, addr, rpc string, args, reply any) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(addr, "/") + t.Path + rpc
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("raft: %s %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
@@ -0,0 +1,305 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Storage persists the state of a node so it can restart without breaking the promises it made to the
+// rest of the cluster. Every method must have reached stable storage by the time it returns.
+type Storage interface {
+	// Load returns everything stored so far. The entries follow the snapshot without gaps.
+	Load() (HardState, Snapshot, []Entry, error)
+
+	SetHardState(HardState) error
+
+	// Append stores entries, first dropping any stored entries from entries[0].Index on.
+	Append(entries []Entry) error
+
+	// SaveSnapshot replaces the snapshot and sets the log to the entries following it.
+	SaveSnapshot(snap Snapshot, entries []Entry) error
+}
+
+// MemoryStorage keeps everything in memory. A node using it forgets everything when it restarts, so it
+// may only rejoin its cluster as a new member.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	state   HardState
+	snap    Snapshot
+	entries []Entry
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load() (HardState, Snapshot, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.snap, slices.Clone(s.entries), nil
+}
+
+func (s *MemoryStorage) SetHardState(hs HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = hs
+	return nil
+}
+
+func (s *MemoryStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries[:s.offset(entries[0].Index)], entries...)
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snap Snapshot, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snap = snap
+	s.entries = slices.Clone(entries)
+	return nil
+}
+
+// offset returns the position of index in entries.
+func (s *MemoryStorage) offset(index uint64) int {
+	return min(len(s.entries), int(index-s.snap.Index-1))
+}
+
+// FileStorage keeps the state of a node in a directory: the hard state and snapshot as JSON files that
+// are replaced atomically, and the log as a file of JSON lines that is appended to and truncated.
+type FileStorage struct {
+	dir string
+
+	mu      sync.Mutex
+	log     *os.File
+	first   uint64  // Index of the first entry in the log file
+	offsets []int64 // Offset of each entry in the log file
+}
+
+const (
+	stateFile    = "state.json"
+	snapshotFile = "snapshot.json"
+	logFile      = "log.ndjson"
+)
+
+// OpenFileStorage opens the storage in dir, creating the directory if needed.
+func OpenFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+func (s *FileStorage) Load() (HardState, Snapshot, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var hs HardState
+	var snap Snapshot
+	if err := readJSON(filepath.Join(s.dir, stateFile), &hs); err != nil {
+		return hs, snap, nil, err
+	}
+	if err := readJSON(filepath.Join(s.dir, snapshotFile), &snap); err != nil {
+		return hs, snap, nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, logFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return hs, snap, nil, err
+	}
+
+	var entries []Entry
+	var offsets []int64
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A partial line is an append that never completed, and was never acknowledged
+			break
+		}
+		if err != nil {
+			f.Close()
+			return hs, snap, nil, err
+		}
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			f.Close()
+			return hs, snap, nil, fmt.Errorf("raft: reading %s at offset %d: %w", logFile, offset, err)
+		}
+		if e.Index > snap.Index {
+			entries = append(entries, e)
+			offsets = append(offsets, offset)
+		}
+		offset += int64(len(line))
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return hs, snap, nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return hs, snap, nil, err
+	}
+
+	s.log, s.first, s.offsets = f, snap.Index+1, offsets
+	if len(entries) > 0 {
+		s.first = entries[0].Index
+	}
+	return hs, snap, entries, nil
+}
+
+func (s *FileStorage) SetHardState(hs HardState) error {
+	return writeJSON(filepath.Join(s.dir, stateFile), hs)
+}
+
+func (s *FileStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.log == nil {
+		return errors.New("raft: storage not loaded")
+	}
+
+	// Drop the entries being replaced
+	i := max(0, int(entries[0].Index)-int(s.first))
+	if i < len(s.offsets) {
+		end := s.offsets[i]
+		if err := s.log.Truncate(end); err != nil {
+			return err
+		}
+		if _, err := s.log.Seek(end, io.SeekStart); err != nil {
+			return err
+		}
+		s.offsets = s.offsets[:i]
+	}
+	if len(s.offsets) == 0 {
+		s.first = entries[0].Index
+	}
+
+	offset := s.end()
+	var buf []byte
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		s.offsets = append(s.offsets, offset+int64(len(buf)))
+		buf = append(append(buf, line...), '\n')
+	}
+	if _, err := s.log.Write(buf); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+func (s *FileStorage) SaveSnapshot(snap Snapshot, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeJSON(filepath.Join(s.dir, snapshotFile), snap); err != nil {
+		return err
+	}
+
+	// Rewrite the log with only the entries after the snapshot
+	path := filepath.Join(s.dir, logFile)
+	tmp, err := os.CreateTemp(s.dir, logFile+".tmp*")
+	if err != nil {
+		return err
+	}
+	var offsets []int64
+	w := bufio.NewWriter(tmp)
+	var offset int64
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		offsets = append(offsets, offset)
+		w.Write(line)
+		w.WriteByte('\n')
+		offset += int64(len(line)) + 1
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.log, s.first, s.offsets = tmp, snap.Index+1, offsets
+	return nil
+}
+
+// Close closes the log file.
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	return s.log.Close()
+}
+
+// end returns the size of the log file.
+func (s *FileStorage) end() int64 {
+	off, _ := s.log.Seek(0, io.SeekEnd)
+	return off
+}
+
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// writeJSON replaces the file at path with v encoded as JSON, so a crash leaves either the old or the
+// new version in place.
+func writeJSON(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
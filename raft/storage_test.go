@@ -0,0 +1,94 @@
+package raft
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func entries(from, to, term uint64) []Entry {
+	var es []Entry
+	for i := from; i <= to; i++ {
+		es = append(es, Entry{Index: i, Term: term, Data: []byte{byte(i)}})
+	}
+	return es
+}
+
+func indexes(es []Entry) []uint64 {
+	var is []uint64
+	for _, e := range es {
+		is = append(is, e.Index)
+	}
+	return is
+}
+
+func TestFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	reopen := func() (*FileStorage, HardState, Snapshot, []Entry) {
+		t.Helper()
+		s, err := OpenFileStorage(dir)
+		if err != nil {
+			t.Fatalf("OpenFileStorage() returned an error: %v", err)
+		}
+		hs, snap, es, err := s.Load()
+		if err != nil {
+			t.Fatalf("Load() returned an error: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s, hs, snap, es
+	}
+
+	s, hs, snap, es := reopen()
+	if hs != (HardState{}) || snap.Index != 0 || len(es) != 0 {
+		t.Fatalf("Load() of an empty directory = %v, %v, %v", hs, snap, es)
+	}
+
+	// Appending over a suffix replaces it
+	s.SetHardState(HardState{Term: 2, Vote: "n1"})
+	s.Append(entries(1, 5, 1))
+	s.Append(entries(4, 6, 2))
+	s.Close()
+
+	s, hs, _, es = reopen()
+	if hs != (HardState{Term: 2, Vote: "n1"}) {
+		t.Errorf("Load() hard state = %+v", hs)
+	}
+	if got, want := indexes(es), []uint64{1, 2, 3, 4, 5, 6}; !slices.Equal(got, want) || es[3].Term != 2 {
+		t.Errorf("Load() entries = %v, want %v with entry 4 from term 2", es, want)
+	}
+
+	// A snapshot drops the log before it
+	s.SaveSnapshot(Snapshot{Index: 4, Term: 2, Data: []byte(`"state"`)}, entries(5, 6, 2))
+	s.Append(entries(7, 7, 2))
+	s.Close()
+
+	s, _, snap, es = reopen()
+	if snap.Index != 4 || string(snap.Data) != `"state"` {
+		t.Errorf("Load() snapshot = %+v", snap)
+	}
+	if got, want := indexes(es), []uint64{5, 6, 7}; !slices.Equal(got, want) {
+		t.Errorf("Load() entries = %v, want %v", got, want)
+	}
+	s.Close()
+
+	// A write torn by a crash is dropped
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"index":8,"te`)
+	f.Close()
+
+	s, _, _, es = reopen()
+	if got, want := indexes(es), []uint64{5, 6, 7}; !slices.Equal(got, want) {
+		t.Errorf("Load() after a torn write entries = %v, want %v", got, want)
+	}
+	s.Append(entries(8, 8, 3))
+	s.Close()
+
+	_, _, _, es = reopen()
+	if got, want := indexes(es), []uint64{5, 6, 7, 8}; !slices.Equal(got, want) {
+		t.Errorf("Load() entries = %v, want %v", got, want)
+	}
+}
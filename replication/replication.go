@@ -0,0 +1,361 @@
+// Package replication streams the changes a leader applies to its store to warm standby followers.
+//
+// A follower connects to the leader's stream endpoint and first receives a snapshot of the whole store
+// state: keys, trash, leases, queues, streams and rate limits. Then it receives each change as the leader
+// applies it, interleaved with heartbeats. Messages are newline delimited JSON.
+// A follower that falls too far behind is disconnected and starts again from a fresh snapshot.
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kvstore/store"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StreamPath is where a leader serves its replication stream, relative to the server root.
+	StreamPath = "/kvs/replication/stream"
+
+	// HeartbeatInterval is how often the leader tells idle followers its latest sequence number.
+	HeartbeatInterval = time.Second
+
+	// HeartbeatTimeout is how long a follower waits for any message before reconnecting.
+	HeartbeatTimeout = 5 * HeartbeatInterval
+
+	// RetryInterval is how long a follower waits before reconnecting after the stream breaks.
+	RetryInterval = time.Second
+
+	// DefaultBuffer is how many changes may queue up for a follower before it is disconnected.
+	DefaultBuffer = 4096
+)
+
+// MessageType is the kind of a Message.
+type MessageType string
+
+const (
+	MessageSnapshot  MessageType = "snapshot"
+	MessageChange    MessageType = "change"
+	MessageHeartbeat MessageType = "heartbeat"
+)
+
+// Message is a single line of the replication stream. Seq is the sequence number of the last change
+// included, so a snapshot at Seq is followed by the change at Seq+1.
+type Message struct {
+	Type    MessageType    `json:"type"`
+	Seq     uint64         `json:"seq"`
+	Time    time.Time      `json:"time"`
+	State   *store.State   `json:"state,omitempty"` // Set on a snapshot
+	Changes []store.Change `json:"changes,omitempty"`
+}
+
+// Leader fans the changes of its store out to subscribed followers. Publish is meant to be set as the
+// store's OnChange hook, so it runs in the request loop in the order the changes were applied.
+type Leader struct {
+	Buffer int // Messages queued per follower before it is dropped, DefaultBuffer if zero
+
+	mu   sync.Mutex
+	seq  uint64
+	subs map[*Subscription]struct{}
+}
+
+// Subscription is a follower's view of the leader's changes.
+type Subscription struct {
+	Addr        string
+	ConnectedAt time.Time
+	Snapshot    Message      // Sent first, everything the follower needs to catch up to Snapshot.Seq
+	C           chan Message // Changes after the snapshot, closed if the follower falls behind
+
+	sent uint64 // Sequence number last queued, guarded by Leader.mu
+}
+
+// NewLeader returns a leader with no followers.
+func NewLeader() *Leader {
+	return &Leader{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish hands a change to every follower.
+func (l *Leader) Publish(c store.Change) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	msg := Message{Type: MessageChange, Seq: l.seq, Time: time.Now(), Changes: []store.Change{c}}
+	for sub := range l.subs {
+		select {
+		case sub.C <- msg:
+			sub.sent = l.seq
+		default:
+			// Never block the request loop on a slow follower, it will resync from a snapshot
+			log.Printf("Replication: dropping follower %s, %d changes behind", sub.Addr, cap(sub.C))
+			delete(l.subs, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Subscribe registers a follower. snapshot must be the state of the store as of the last change
+// published, so Subscribe has to run in the request loop alongside taking it.
+func (l *Leader) Subscribe(addr string, snapshot store.State) *Subscription {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buffer := l.Buffer
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+
+	sub := &Subscription{
+		Addr:        addr,
+		ConnectedAt: time.Now(),
+		Snapshot:    Message{Type: MessageSnapshot, Seq: l.seq, Time: time.Now(), State: &snapshot},
+		C:           make(chan Message, buffer),
+		sent:        l.seq,
+	}
+	l.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes a follower, e.g. once it has disconnected.
+func (l *Leader) Unsubscribe(sub *Subscription) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.subs[sub]; ok {
+		delete(l.subs, sub)
+		close(sub.C)
+	}
+}
+
+// Serve writes the subscription to w until ctx is done or the follower is dropped for falling behind.
+// flush is called after every message so it is not held back by buffering.
+func (l *Leader) Serve(ctx context.Context, w io.Writer, flush func(), sub *Subscription) error {
+	defer l.Unsubscribe(sub)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(sub.Snapshot); err != nil {
+		return err
+	}
+	flush()
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var msg Message
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-sub.C:
+			if !ok {
+				return fmt.Errorf("follower %s fell behind", sub.Addr)
+			}
+			msg = m
+		case <-heartbeat.C:
+			msg = Message{Type: MessageHeartbeat, Seq: l.Seq(), Time: time.Now()}
+		}
+
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+		flush()
+	}
+}
+
+// Seq returns the sequence number of the last change published.
+func (l *Leader) Seq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// FollowerInfo describes a connected follower as seen by the leader.
+type FollowerInfo struct {
+	Addr        string    `json:"addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Sent        uint64    `json:"sent"` // Sequence number last queued for the follower
+}
+
+// LeaderStatus is the replication state of a leader.
+type LeaderStatus struct {
+	Role      string         `json:"role"`
+	Seq       uint64         `json:"seq"` // Sequence number of the last change
+	Followers []FollowerInfo `json:"followers"`
+}
+
+// FollowerStatus is the replication state of a follower.
+type FollowerStatus struct {
+	Role        string    `json:"role"`
+	Leader      string    `json:"leader"`
+	Connected   bool      `json:"connected"`
+	Seq         uint64    `json:"seq"`        // Sequence number of the last change applied
+	LeaderSeq   uint64    `json:"leader_seq"` // Latest sequence number heard from the leader
+	Behind      uint64    `json:"behind"`     // Changes the leader has applied and this follower has not
+	LagMs       int64     `json:"lag_ms"`
+	LastContact time.Time `json:"last_contact,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Status returns the leader's sequence number and followers.
+func (l *Leader) Status() LeaderStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := LeaderStatus{Role: "leader", Seq: l.seq, Followers: []FollowerInfo{}}
+	for sub := range l.subs {
+		st.Followers = append(st.Followers, FollowerInfo{Addr: sub.Addr, ConnectedAt: sub.ConnectedAt, Sent: sub.sent})
+	}
+	return st
+}
+
+// Follower keeps a store in sync with a leader.
+type Follower struct {
+	Leader string              // Base URL of the leader, e.g. http://localhost:8080
+	Apply  func(Message) error // Applies a snapshot or change to the local store
+	Client *http.Client
+
+	mu          sync.Mutex
+	connected   bool
+	seq         uint64 // Sequence number last applied
+	leaderSeq   uint64 // Latest sequence number heard from the leader
+	lag         time.Duration
+	lastContact time.Time
+	lastErr     error
+}
+
+// NewFollower returns a follower of the leader at base URL leader.
+func NewFollower(leader string, apply func(Message) error) *Follower {
+	return &Follower{Leader: strings.TrimSuffix(leader, "/"), Apply: apply, Client: &http.Client{}}
+}
+
+// Run follows the leader until ctx is done, reconnecting whenever the stream breaks.
+func (f *Follower) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := f.follow(ctx)
+
+		f.mu.Lock()
+		f.connected = false
+		f.lastErr = err
+		f.mu.Unlock()
+
+		if ctx.Err() == nil {
+			log.Printf("Replication: lost leader %s: %v", f.Leader, err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(RetryInterval):
+		}
+	}
+}
+
+// follow reads the stream of one connection to the leader.
+func (f *Follower) follow(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Give up on a leader that has gone quiet, the connection may be dead without us hearing about it
+	watchdog := time.AfterFunc(HeartbeatTimeout, cancel)
+	defer watchdog.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.Leader+StreamPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("leader returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var msg Message
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil && !watchdog.Stop() {
+				return fmt.Errorf("no message from the leader for %s", HeartbeatTimeout)
+			}
+			return err
+		}
+		watchdog.Reset(HeartbeatTimeout)
+
+		if err := f.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle applies a message from the leader and updates the follower's status.
+func (f *Follower) handle(msg Message) error {
+	f.mu.Lock()
+	seq := f.seq
+	f.mu.Unlock()
+
+	switch msg.Type {
+	case MessageChange:
+		if msg.Seq != seq+1 {
+			return fmt.Errorf("expected change %d, got %d", seq+1, msg.Seq)
+		}
+		fallthrough
+	case MessageSnapshot:
+		if err := f.Apply(msg); err != nil {
+			return fmt.Errorf("applying %s %d: %w", msg.Type, msg.Seq, err)
+		}
+		seq = msg.Seq
+	}
+
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if msg.Type == MessageSnapshot && msg.State != nil {
+		log.Printf("Replication: loaded snapshot of %d keys at %d from %s", len(msg.State.Items), msg.Seq, f.Leader)
+	}
+	f.connected = true
+	f.seq = seq
+	f.leaderSeq = max(msg.Seq, seq)
+	f.lag = max(0, now.Sub(msg.Time))
+	f.lastContact = now
+	f.lastErr = nil
+	return nil
+}
+
+// Status returns how far the follower is behind its leader. While connected, the lag is the delay
+// between the leader sending the latest message and the follower applying it. Once the connection is
+// lost it is the time since the leader was last heard from.
+func (f *Follower) Status() FollowerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lag := f.lag
+	if !f.connected && !f.lastContact.IsZero() {
+		lag = time.Since(f.lastContact)
+	}
+
+	st := FollowerStatus{
+		Role:        "follower",
+		Leader:      f.Leader,
+		Connected:   f.connected,
+		Seq:         f.seq,
+		LeaderSeq:   f.leaderSeq,
+		Behind:      f.leaderSeq - f.seq,
+		LagMs:       lag.Milliseconds(),
+		LastContact: f.lastContact,
+	}
+	if f.lastErr != nil {
+		st.LastError = f.lastErr.Error()
+	}
+	return st
+}
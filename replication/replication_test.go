@@ -0,0 +1,195 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"kvstore/store"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// node is a store with a lock standing in for the request loop.
+type node struct {
+	mu    sync.Mutex
+	store *store.KVStore
+}
+
+func (n *node) do(f func(s *store.KVStore)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f(n.store)
+}
+
+func (n *node) dump() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	b, _ := json.Marshal(n.store.State())
+	return string(b)
+}
+
+// startLeader serves the replication stream of a new store.
+func startLeader(t *testing.T) (*node, *Leader, *httptest.Server) {
+	n := &node{store: store.NewKeyValueStore()}
+	l := NewLeader()
+	n.store.OnChange = l.Publish
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sub *Subscription
+		n.do(func(s *store.KVStore) { sub = l.Subscribe(r.RemoteAddr, s.State()) })
+		l.Serve(r.Context(), w, w.(http.Flusher).Flush, sub)
+	}))
+	t.Cleanup(srv.Close)
+	return n, l, srv
+}
+
+// startFollower follows the leader at url into a new store.
+func startFollower(t *testing.T, url string) (*node, *Follower) {
+	n := &node{store: store.NewKeyValueStore()}
+	f := NewFollower(url, func(msg Message) (err error) {
+		n.do(func(s *store.KVStore) {
+			if msg.Type == MessageSnapshot {
+				s.LoadState(*msg.State)
+			}
+			for _, c := range msg.Changes {
+				if err = s.ApplyChange(c); err != nil {
+					return
+				}
+			}
+		})
+		return err
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go f.Run(ctx)
+	return n, f
+}
+
+// waitFor polls cond for up to two seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestFollowerReplicates(t *testing.T) {
+	leader, l, srv := startLeader(t)
+
+	// Written before the follower connects, arrives in the snapshot
+	leader.do(func(s *store.KVStore) {
+		s.InitData()
+		s.SetTags("TestString", map[string]string{"env": "prod"})
+	})
+
+	follower, f := startFollower(t, srv.URL)
+	waitFor(t, "the snapshot", func() bool { return f.Status().Connected })
+
+	// Written afterwards, arrives as changes
+	leader.do(func(s *store.KVStore) {
+		s.Add("new", []byte(`{"a": 1}`))
+		s.Delete("TestNumber")
+		s.Update("TestString", []byte(`"changed"`))
+	})
+
+	waitFor(t, "the follower to catch up", func() bool { return follower.dump() == leader.dump() })
+
+	st := f.Status()
+	if st.Seq != l.Seq() || st.Behind != 0 {
+		t.Errorf("Status() = %+v, want caught up at %d", st, l.Seq())
+	}
+	if ls := l.Status(); len(ls.Followers) != 1 {
+		t.Errorf("leader Status() = %+v, want 1 follower", ls)
+	}
+}
+
+func TestFollowerReplicatesState(t *testing.T) {
+	leader, _, srv := startLeader(t)
+
+	// In the snapshot
+	leader.do(func(s *store.KVStore) {
+		s.InitData()
+		s.AcquireLease("lock", "a", time.Minute)
+		s.Enqueue("jobs", []byte(`1`))
+		s.StreamAdd("events", []byte(`"first"`), 0)
+		s.CreateGroup("events", "workers", "0")
+	})
+
+	follower, _ := startFollower(t, srv.URL)
+	waitFor(t, "the snapshot", func() bool { return follower.dump() == leader.dump() })
+
+	// As changes
+	leader.do(func(s *store.KVStore) {
+		s.SoftDelete("TestString")
+		s.ReleaseLease("lock", "a")
+		s.AcquireLease("lock", "b", time.Minute)
+		s.Enqueue("jobs", []byte(`2`))
+		s.Dequeue("jobs", time.Minute)
+		s.StreamAdd("events", []byte(`"second"`), 0)
+		s.ReadGroup("events", "workers", "w1", 1)
+		s.AllowRate("api", store.RateLimit{Capacity: 10, Rate: 1})
+	})
+	waitFor(t, "the follower to catch up", func() bool { return follower.dump() == leader.dump() })
+
+	follower.do(func(s *store.KVStore) {
+		if l, err := s.GetLease("lock"); err != nil || l.Owner != "b" || l.Token != 2 {
+			t.Errorf("GetLease() on the follower = %+v, %v, want held by b with token 2", l, err)
+		}
+	})
+}
+
+func TestFollowerResyncsAfterClear(t *testing.T) {
+	leader, _, srv := startLeader(t)
+	leader.do(func(s *store.KVStore) { s.InitData() })
+
+	follower, _ := startFollower(t, srv.URL)
+	waitFor(t, "the snapshot", func() bool { return follower.dump() == leader.dump() })
+
+	leader.do(func(s *store.KVStore) {
+		s.Clear()
+		s.Add("only", []byte(`1`))
+	})
+	waitFor(t, "the follower to clear", func() bool { return follower.dump() == leader.dump() })
+}
+
+func TestSlowFollowerIsDropped(t *testing.T) {
+	l := NewLeader()
+	l.Buffer = 1
+
+	sub := l.Subscribe("slow", store.State{})
+	l.Publish(store.Change{Op: store.ChangeDelete, Key: "a"})
+	l.Publish(store.Change{Op: store.ChangeDelete, Key: "b"})
+
+	if msg := <-sub.C; msg.Seq != 1 {
+		t.Errorf("first message seq = %d, want 1", msg.Seq)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Errorf("subscription still open after overflowing its buffer")
+	}
+	if st := l.Status(); len(st.Followers) != 0 {
+		t.Errorf("Status() = %+v, want no followers", st)
+	}
+}
+
+func TestFollowerDetectsGaps(t *testing.T) {
+	f := NewFollower("http://leader", func(Message) error { return nil })
+
+	if err := f.handle(Message{Type: MessageSnapshot, Seq: 5}); err != nil {
+		t.Fatalf("handle() of a snapshot returned an error: %v", err)
+	}
+	if err := f.handle(Message{Type: MessageChange, Seq: 6}); err != nil {
+		t.Fatalf("handle() of the next change returned an error: %v", err)
+	}
+	if err := f.handle(Message{Type: MessageChange, Seq: 8}); err == nil {
+		t.Errorf("handle() of a change after a gap returned no error")
+	}
+	if err := f.handle(Message{Type: MessageHeartbeat, Seq: 9}); err != nil || f.Status().Behind != 3 {
+		t.Errorf("handle() of a heartbeat = %v, status %+v, want 3 behind", err, f.Status())
+	}
+}
@@ -0,0 +1,376 @@
+package resp
+
+import (
+	"errors"
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/store"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	serverName = "kvstore"
+	version    = "0"
+)
+
+// command is a command the server understands.
+type command struct {
+	arity int  // Number of arguments, the name included, or -n for at least n
+	write bool // Changes the store, so followers refuse it
+	read  bool // Reads the store, so cluster members wait for a read barrier first
+	run   func(c *conn, args [][]byte)
+}
+
+// commands are the commands by upper case name.
+var commands = map[string]command{
+	"GET":      {arity: 2, read: true, run: (*conn).get},
+	"SET":      {arity: -3, write: true, run: (*conn).set},
+	"SETNX":    {arity: 3, write: true, run: (*conn).setnx},
+	"SETEX":    {arity: 4, write: true, run: (*conn).setex},
+	"DEL":      {arity: -2, write: true, run: (*conn).del},
+	"EXISTS":   {arity: -2, read: true, run: (*conn).exists},
+	"DBSIZE":   {arity: 1, read: true, run: (*conn).dbsize},
+	"FLUSHDB":  {arity: -1, write: true, run: (*conn).flush},
+	"FLUSHALL": {arity: -1, write: true, run: (*conn).flush},
+	"KEYS":     {arity: 2, read: true, run: (*conn).keys},
+	"SCAN":     {arity: -2, read: true, run: (*conn).scan},
+	"INCR":     {arity: 2, write: true, run: (*conn).incr},
+	"DECR":     {arity: 2, write: true, run: (*conn).incr},
+	"INCRBY":   {arity: 3, write: true, run: (*conn).incr},
+	"DECRBY":   {arity: 3, write: true, run: (*conn).incr},
+	"EXPIRE":   {arity: 3, write: true, run: (*conn).expire},
+	"PEXPIRE":  {arity: 3, write: true, run: (*conn).expire},
+	"TTL":      {arity: 2, read: true, run: (*conn).ttl},
+	"PTTL":     {arity: 2, read: true, run: (*conn).ttl},
+
+	"PING":    {arity: -1, run: (*conn).ping},
+	"ECHO":    {arity: 2, run: (*conn).echo},
+	"HELLO":   {arity: -1, run: (*conn).hello},
+	"CLIENT":  {arity: -2, run: (*conn).client},
+	"SELECT":  {arity: 2, run: (*conn).selectDB},
+	"COMMAND": {arity: -1, run: (*conn).command},
+	"QUIT":    {arity: -1, run: (*conn).quitConn},
+}
+
+const (
+	errSyntax     = "ERR syntax error"
+	errNotInteger = "ERR value is not an integer or out of range"
+)
+
+func (c *conn) get(args [][]byte) {
+	resp := channels.GetRequest(string(args[1]))
+	switch {
+	case errors.Is(resp.Error, helpers.NotExistError):
+		c.w.null()
+	case resp.Error != nil:
+		c.replyError(resp.Error)
+	default:
+		c.w.bulk(store.DecodeBytes(resp.Value))
+	}
+}
+
+// set runs SET key value [NX | XX] [EX seconds | PX milliseconds | KEEPTTL]. NX adds the key and XX
+// updates it, with the semantics of Add and Update; without either the key is upserted.
+func (c *conn) set(args [][]byte) {
+	var opts store.WriteOptions
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); {
+		case opt == "NX" && !opts.IfExists:
+			opts.IfMissing = true
+		case opt == "XX" && !opts.IfMissing:
+			opts.IfExists = true
+		case (opt == "EX" || opt == "PX") && opts.TTL == 0 && !opts.KeepTTL && i+1 < len(args):
+			i++
+			var ok bool
+			if opts.TTL, ok = duration(args[i], opt == "EX"); !ok || opts.TTL <= 0 {
+				c.w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+		case opt == "KEEPTTL" && opts.TTL == 0:
+			opts.KeepTTL = true
+		default:
+			c.w.error(errSyntax)
+			return
+		}
+	}
+
+	resp := channels.WriteRequest(string(args[1]), store.EncodeBytes(args[2]), opts, c.caller)
+	switch {
+	case errors.Is(resp.Error, helpers.DuplicateKeyError), errors.Is(resp.Error, helpers.NotExistError):
+		c.w.null()
+	case resp.Error != nil:
+		c.replyError(resp.Error)
+	default:
+		c.w.simple("OK")
+	}
+}
+
+// setnx runs SETNX key value, the older form of SET NX that clients still send, replying 1 if the key
+// was set and 0 if it already existed.
+func (c *conn) setnx(args [][]byte) {
+	resp := channels.AddRequest(string(args[1]), store.EncodeBytes(args[2]), c.caller)
+	switch {
+	case errors.Is(resp.Error, helpers.DuplicateKeyError):
+		c.w.integer(0)
+	case resp.Error != nil:
+		c.replyError(resp.Error)
+	default:
+		c.w.integer(1)
+	}
+}
+
+// setex runs SETEX key seconds value, the older form of SET EX.
+func (c *conn) setex(args [][]byte) {
+	c.set([][]byte{args[0], args[1], args[3], []byte("EX"), args[2]})
+}
+
+func (c *conn) del(args [][]byte) {
+	var n int64
+	for _, key := range args[1:] {
+		resp := channels.DeleteRequest(string(key), c.caller)
+		switch {
+		case resp.Error == nil:
+			n++
+		case !errors.Is(resp.Error, helpers.NotExistError):
+			c.replyError(resp.Error)
+			return
+		}
+	}
+	c.w.integer(n)
+}
+
+// exists counts the keys that exist, a key named twice counting twice as in Redis.
+func (c *conn) exists(args [][]byte) {
+	var n int64
+	for _, key := range args[1:] {
+		if resp := channels.ExistsRequest(string(key)); resp.Error == nil {
+			n++
+		}
+	}
+	c.w.integer(n)
+}
+
+func (c *conn) dbsize(args [][]byte) {
+	resp := channels.CountRequest()
+	if resp.Error != nil {
+		c.replyError(resp.Error)
+		return
+	}
+	c.w.integer(int64(resp.Value.(int)))
+}
+
+// flush runs FLUSHDB and FLUSHALL, which are the same with a single database. Both take ASYNC or SYNC,
+// and either clears the store before replying.
+func (c *conn) flush(args [][]byte) {
+	if len(args) > 2 || (len(args) == 2 && !strings.EqualFold(string(args[1]), "ASYNC") &&
+		!strings.EqualFold(string(args[1]), "SYNC")) {
+		c.w.error(errSyntax)
+		return
+	}
+	if resp := channels.ClearRequest(c.caller); resp.Error != nil {
+		c.replyError(resp.Error)
+		return
+	}
+	c.w.simple("OK")
+}
+
+// incr runs INCR, DECR, INCRBY and DECRBY.
+func (c *conn) incr(args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	delta := int64(1)
+	if len(args) == 3 {
+		n, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil || (name == "DECRBY" && n == math.MinInt64) {
+			c.w.error(errNotInteger)
+			return
+		}
+		delta = n
+	}
+	if strings.HasPrefix(name, "DECR") {
+		delta = -delta
+	}
+
+	resp := channels.IncrRequest(string(args[1]), delta, c.caller)
+	switch {
+	case errors.Is(resp.Error, helpers.InvalidParamError):
+		c.w.error(errNotInteger)
+	case resp.Error != nil:
+		c.replyError(resp.Error)
+	default:
+		c.w.integer(resp.Value.(int64))
+	}
+}
+
+// expire runs EXPIRE and PEXPIRE, replying 1 if the key exists and 0 if not. A time to live that is not
+// positive deletes the key, as in Redis.
+func (c *conn) expire(args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	ttl, ok := duration(args[2], name == "expire")
+	if !ok {
+		c.w.error(fmt.Sprintf("ERR invalid expire time in '%s' command", name))
+		return
+	}
+	resp := channels.ExpireRequest(string(args[1]), ttl, c.caller)
+	switch {
+	case errors.Is(resp.Error, helpers.NotExistError):
+		c.w.integer(0)
+	case resp.Error != nil:
+		c.replyError(resp.Error)
+	default:
+		c.w.integer(1)
+	}
+}
+
+// ttl runs TTL and PTTL, replying -2 if the key does not exist and -1 if it does not expire.
+func (c *conn) ttl(args [][]byte) {
+	resp := channels.MetaRequest(string(args[1]))
+	switch {
+	case errors.Is(resp.Error, helpers.NotExistError):
+		c.w.integer(-2)
+		return
+	case resp.Error != nil:
+		c.replyError(resp.Error)
+		return
+	}
+
+	md := resp.Value.(store.Metadata)
+	if md.ExpiresAt.IsZero() {
+		c.w.integer(-1)
+		return
+	}
+	left := max(time.Until(md.ExpiresAt), 0)
+	if strings.EqualFold(string(args[0]), "PTTL") {
+		c.w.integer(left.Milliseconds())
+		return
+	}
+	c.w.integer(int64((left + 500*time.Millisecond) / time.Second))
+}
+
+func (c *conn) ping(args [][]byte) {
+	switch len(args) {
+	case 1:
+		c.w.simple("PONG")
+	case 2:
+		c.w.bulk(args[1])
+	default:
+		c.w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func (c *conn) echo(args [][]byte) {
+	c.w.bulk(args[1])
+}
+
+// hello runs HELLO [protover [AUTH username password] [SETNAME clientname]], which switches the
+// connection to RESP3 when asked for version 3 and describes the server.
+func (c *conn) hello(args [][]byte) {
+	proto := c.w.proto
+	if len(args) > 1 {
+		n, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			c.w.error("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if n != 2 && n != 3 {
+			c.w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = n
+	}
+
+	name := c.name
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); {
+		case opt == "AUTH" && i+2 < len(args):
+			// The store has no users, and Redis without a password refuses AUTH the same way
+			c.w.error("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+			return
+		case opt == "SETNAME" && i+1 < len(args):
+			i++
+			name = string(args[i])
+		default:
+			c.w.error(errSyntax)
+			return
+		}
+	}
+
+	c.w.proto, c.name = proto, name
+	role := "master"
+	if channels.Follower != nil {
+		role = "replica"
+	}
+	c.w.mapOf(7)
+	c.w.bulkString("server")
+	c.w.bulkString(serverName)
+	c.w.bulkString("version")
+	c.w.bulkString(version)
+	c.w.bulkString("proto")
+	c.w.integer(int64(proto))
+	c.w.bulkString("id")
+	c.w.integer(c.id)
+	c.w.bulkString("mode")
+	c.w.bulkString("standalone")
+	c.w.bulkString("role")
+	c.w.bulkString(role)
+	c.w.bulkString("modules")
+	c.w.array(0)
+}
+
+// client runs the CLIENT subcommands that client libraries send when they connect.
+func (c *conn) client(args [][]byte) {
+	sub := strings.ToUpper(string(args[1]))
+	switch {
+	case sub == "SETNAME" && len(args) == 3:
+		c.name = string(args[2])
+		c.w.simple("OK")
+	case sub == "GETNAME" && len(args) == 2:
+		if c.name == "" {
+			c.w.null()
+			return
+		}
+		c.w.bulkString(c.name)
+	case sub == "ID" && len(args) == 2:
+		c.w.integer(c.id)
+	case sub == "SETINFO" && len(args) == 4:
+		c.w.simple("OK")
+	default:
+		c.w.error(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP.", args[1]))
+	}
+}
+
+// selectDB runs SELECT. There is a single database, 0.
+func (c *conn) selectDB(args [][]byte) {
+	if string(args[1]) != "0" {
+		c.w.error("ERR DB index is out of range")
+		return
+	}
+	c.w.simple("OK")
+}
+
+// command runs COMMAND, which redis-cli sends to learn the commands of the server. The server describes
+// none, which clients take as having nothing to go on rather than as an error.
+func (c *conn) command(args [][]byte) {
+	c.w.array(0)
+}
+
+func (c *conn) quitConn(args [][]byte) {
+	c.w.simple("OK")
+	c.quit = true
+}
+
+// duration parses a time to live in seconds or milliseconds, which must fit a time.Duration.
+func duration(b []byte, seconds bool) (time.Duration, bool) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	unit := time.Millisecond
+	if seconds {
+		unit = time.Second
+	}
+	if err != nil || n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
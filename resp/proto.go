@@ -0,0 +1,185 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// MaxBulkLen caps the size of a single argument, as proto-max-bulk-len does in Redis.
+	MaxBulkLen = 512 << 20
+
+	// maxArgs caps the number of arguments of a command.
+	maxArgs = 1 << 20
+
+	// maxInline caps the length of an inline command, one typed in as a line of words.
+	maxInline = 64 << 10
+)
+
+// errProtocol is returned for input that is not RESP. The connection cannot be read further, as the
+// start of the next command is unknown.
+var errProtocol = errors.New("protocol error")
+
+// reader reads commands sent by clients.
+type reader struct {
+	r *bufio.Reader
+}
+
+// readCommand reads the next command, sent either as an array of bulk strings, as clients send them, or
+// inline, as a line of words typed into telnet.
+func (r *reader) readCommand() ([][]byte, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '*' {
+			return bytes.Fields(line), nil
+		}
+
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > maxArgs {
+			return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+		}
+		if n <= 0 {
+			continue
+		}
+		args := make([][]byte, n)
+		for i := range args {
+			if args[i], err = r.readBulk(); err != nil {
+				return nil, err
+			}
+		}
+		return args, nil
+	}
+}
+
+func (r *reader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, fmt.Errorf("%w: expected '$', got %q", errProtocol, line[:min(len(line), 16)])
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > MaxBulkLen {
+		return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+	}
+
+	b := make([]byte, n+2)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	if b[n] != '\r' || b[n+1] != '\n' {
+		return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", errProtocol)
+	}
+	return b[:n], nil
+}
+
+// readLine reads a line ended by CRLF, or by a bare LF as telnet may send, without the line ending.
+func (r *reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		part, err := r.r.ReadSlice('\n')
+		line = append(line, part...)
+		if len(line) > maxInline {
+			return nil, fmt.Errorf("%w: too big inline request", errProtocol)
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+// writer writes replies in the protocol version the client asked for with HELLO, 2 until it does.
+type writer struct {
+	w     *bufio.Writer
+	proto int
+}
+
+// simple writes a simple string, such as OK.
+func (w *writer) simple(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// error writes an error. msg starts with an error code, such as ERR.
+func (w *writer) error(msg string) {
+	w.w.WriteByte('-')
+	w.w.WriteString(msg)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.header(':', n)
+}
+
+func (w *writer) bulk(b []byte) {
+	w.header('$', int64(len(b)))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) bulkString(s string) {
+	w.header('$', int64(len(s)))
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// null writes the absence of a value: a null bulk string in RESP2, which clients read as nil.
+func (w *writer) null() {
+	if w.proto >= 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+// array starts an array of n elements, which must follow.
+func (w *writer) array(n int) {
+	w.header('*', int64(n))
+}
+
+// mapOf starts a map of n pairs, which must follow as key, value, key, value... RESP2 has no maps, so
+// it gets them as flat arrays.
+func (w *writer) mapOf(n int) {
+	if w.proto >= 3 {
+		w.header('%', int64(n))
+		return
+	}
+	w.header('*', int64(2*n))
+}
+
+func (w *writer) strings(ss []string) {
+	w.array(len(ss))
+	for _, s := range ss {
+		w.bulkString(s)
+	}
+}
+
+func (w *writer) header(prefix byte, n int64) {
+	var buf [24]byte
+	b := append(buf[:0], prefix)
+	b = strconv.AppendInt(b, n, 10)
+	w.w.Write(append(b, '\r', '\n'))
+}
@@ -0,0 +1,268 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"kvstore/channels"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var addr string
+
+// TestMain serves the global store, through the request loop, on a free port.
+func TestMain(m *testing.M) {
+	go channels.Requests()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	srv := &Server{}
+	go srv.Serve(l)
+	addr = l.Addr().String()
+
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
+
+// client returns a go-redis client speaking protocol version proto.
+func client(t *testing.T, proto int) *redis.Client {
+	rdb := redis.NewClient(&redis.Options{Addr: addr, Protocol: proto})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func TestCommands(t *testing.T) {
+	for _, proto := range []int{2, 3} {
+		t.Run(fmt.Sprintf("RESP%d", proto), func(t *testing.T) {
+			ctx := context.Background()
+			rdb := client(t, proto)
+			k := func(name string) string { return fmt.Sprintf("cmd%d:%s", proto, name) }
+
+			if got, err := rdb.Ping(ctx).Result(); err != nil || got != "PONG" {
+				t.Fatalf("PING = %q, %v", got, err)
+			}
+			if err := rdb.Set(ctx, k("a"), "hello world", 0).Err(); err != nil {
+				t.Fatalf("SET = %v", err)
+			}
+			if got, err := rdb.Get(ctx, k("a")).Result(); err != nil || got != "hello world" {
+				t.Errorf("GET = %q, %v, want %q", got, err, "hello world")
+			}
+			if _, err := rdb.Get(ctx, k("missing")).Result(); !errors.Is(err, redis.Nil) {
+				t.Errorf("GET of a missing key error = %v, want redis.Nil", err)
+			}
+
+			if ok, err := rdb.SetNX(ctx, k("a"), "again", 0).Result(); err != nil || ok {
+				t.Errorf("SET NX of an existing key = %v, %v, want false", ok, err)
+			}
+			if ok, err := rdb.SetXX(ctx, k("b"), "new", 0).Result(); err != nil || ok {
+				t.Errorf("SET XX of a missing key = %v, %v, want false", ok, err)
+			}
+			if ok, err := rdb.SetNX(ctx, k("b"), "new", time.Minute).Result(); err != nil || !ok {
+				t.Errorf("SET NX EX of a missing key = %v, %v, want true", ok, err)
+			}
+			if got, err := rdb.TTL(ctx, k("b")).Result(); err != nil || got != time.Minute {
+				t.Errorf("TTL = %v, %v, want 1m", got, err)
+			}
+			if got, err := rdb.TTL(ctx, k("a")).Result(); err != nil || got != -1 {
+				t.Errorf("TTL without expiry = %v, %v, want -1", got, err)
+			}
+			if got, err := rdb.TTL(ctx, k("missing")).Result(); err != nil || got != -2 {
+				t.Errorf("TTL of a missing key = %v, %v, want -2", got, err)
+			}
+
+			if ok, err := rdb.PExpire(ctx, k("a"), 50*time.Millisecond).Result(); err != nil || !ok {
+				t.Errorf("PEXPIRE = %v, %v, want true", ok, err)
+			}
+			if ok, err := rdb.Expire(ctx, k("missing"), time.Minute).Result(); err != nil || ok {
+				t.Errorf("EXPIRE of a missing key = %v, %v, want false", ok, err)
+			}
+			time.Sleep(60 * time.Millisecond)
+			if n, err := rdb.Exists(ctx, k("a"), k("b"), k("b")).Result(); err != nil || n != 2 {
+				t.Errorf("EXISTS after a key expired = %d, %v, want 2", n, err)
+			}
+
+			if n, err := rdb.Incr(ctx, k("n")).Result(); err != nil || n != 1 {
+				t.Errorf("INCR of a missing key = %d, %v, want 1", n, err)
+			}
+			if n, err := rdb.DecrBy(ctx, k("n"), 5).Result(); err != nil || n != -4 {
+				t.Errorf("DECRBY = %d, %v, want -4", n, err)
+			}
+			if err := rdb.Incr(ctx, k("b")).Err(); err == nil || err.Error() != "ERR value is not an integer or out of range" {
+				t.Errorf("INCR of text error = %v", err)
+			}
+
+			if n, err := rdb.Del(ctx, k("b"), k("n"), k("missing")).Result(); err != nil || n != 2 {
+				t.Errorf("DEL = %d, %v, want 2", n, err)
+			}
+			if err := rdb.Do(ctx, "FROB").Err(); err == nil {
+				t.Error("an unknown command succeeded")
+			}
+			rdb.Set(ctx, k("bin"), "\xff\x00\xfe", 0)
+			if got, err := rdb.Get(ctx, k("bin")).Result(); err != nil || got != "\xff\x00\xfe" {
+				t.Errorf("GET of binary = %q, %v, want it as set", got, err)
+			}
+		})
+	}
+}
+
+// TestSharedStore checks that keys set over RESP are the keys of the HTTP handlers, and the other way round.
+func TestSharedStore(t *testing.T) {
+	ctx := context.Background()
+	rdb := client(t, 3)
+
+	rdb.Set(ctx, "shared:text", `say "hi"`, 0)
+	if resp := channels.GetRequest("shared:text"); resp.Value != `say "hi"` {
+		t.Errorf("GetRequest() = %#v, want the string set over RESP", resp.Value)
+	}
+
+	channels.UpsertRequest("shared:doc", []byte(`{"n": 41}`), channels.Caller{})
+	if got, _ := rdb.Get(ctx, "shared:doc").Result(); got != `{"n":41}` {
+		t.Errorf("GET of a JSON object = %q, want its JSON encoding", got)
+	}
+	channels.UpsertRequest("shared:count", []byte(`41`), channels.Caller{})
+	if n, err := rdb.Incr(ctx, "shared:count").Result(); err != nil || n != 42 {
+		t.Errorf("INCR of a JSON number = %d, %v, want 42", n, err)
+	}
+	if resp := channels.GetRequest("shared:count"); resp.Value != float64(42) {
+		t.Errorf("GetRequest() after INCR = %#v, want the number 42", resp.Value)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	rdb := client(t, 2)
+
+	cmds, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for range 100 {
+			p.Incr(ctx, "pipeline:n")
+		}
+		p.Get(ctx, "pipeline:n")
+		return nil
+	})
+	if err != nil || len(cmds) != 101 {
+		t.Fatalf("Pipelined() = %d replies, %v", len(cmds), err)
+	}
+	for i, cmd := range cmds[:100] {
+		if n := cmd.(*redis.IntCmd).Val(); n != int64(i+1) {
+			t.Fatalf("INCR %d of the pipeline = %d", i+1, n)
+		}
+	}
+	if got := cmds[100].(*redis.StringCmd).Val(); got != "100" {
+		t.Errorf("GET at the end of the pipeline = %q, want 100", got)
+	}
+}
+
+// TestRaw speaks the protocol directly: inline commands, pipelining in a single write, and a
+// protocol error that ends the connection.
+func TestRaw(t *testing.T) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	fmt.Fprint(nc, "SET raw:k v\r\nGET raw:k\n*2\r\n$3\r\nGET\r\n$5\r\nraw:x\r\nHELLO 3\r\nGET raw:x\r\nPING\r\n*1\r\n$x\r\n")
+	replies, err := io.ReadAll(bufio.NewReader(nc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := "%7\r\n$6\r\nserver\r\n$7\r\nkvstore\r\n$7\r\nversion\r\n$1\r\n0\r\n$5\r\nproto\r\n:3\r\n"
+	want := "+OK\r\n$1\r\nv\r\n$-1\r\n"
+	if got := string(replies); !strings.HasPrefix(got, want+hello) ||
+		!strings.HasSuffix(got, "*0\r\n_\r\n+PONG\r\n-ERR protocol error: invalid bulk length\r\n") {
+		t.Errorf("replies = %q", got)
+	}
+}
+
+func TestKeysAndScan(t *testing.T) {
+	ctx := context.Background()
+	rdb := client(t, 3)
+
+	want := make([]string, 0, 250)
+	for i := range 250 {
+		key := fmt.Sprintf("scan:%03d", i)
+		rdb.Set(ctx, key, "x", 0)
+		want = append(want, key)
+	}
+	rdb.Set(ctx, "other:1", "x", 0)
+
+	keys, err := rdb.Keys(ctx, "scan:*").Result()
+	if err != nil || !slices.Equal(keys, want) {
+		t.Errorf("KEYS scan:* = %d keys, %v, want %d in order", len(keys), err, len(want))
+	}
+	if keys, _ := rdb.Keys(ctx, "scan:1[0-2]?").Result(); len(keys) != 30 {
+		t.Errorf("KEYS scan:1[0-2]? = %q, want 30 keys", keys)
+	}
+
+	// Keys deleted and added while the scan goes on do not stop it returning every other key once
+	seen := map[string]int{}
+	iter := rdb.Scan(ctx, 0, "scan:*", 20).Iterator()
+	for i := 0; iter.Next(ctx); i++ {
+		seen[iter.Val()]++
+		if i == 50 {
+			rdb.Del(ctx, "other:1")
+			rdb.Set(ctx, "other:2", "x", 0)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("SCAN = %v", err)
+	}
+	for _, key := range want {
+		if seen[key] != 1 {
+			t.Errorf("SCAN returned %s %d times, want once", key, seen[key])
+		}
+	}
+	if len(seen) != len(want) {
+		t.Errorf("SCAN MATCH scan:* returned %d keys, want %d", len(seen), len(want))
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h*o*d", "hello world", true},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"a/*", "a/b/c", true},
+	} {
+		if got := match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+// TestFlush runs last, as it clears the store the other tests share.
+func TestFlush(t *testing.T) {
+	ctx := context.Background()
+	rdb := client(t, 3)
+
+	rdb.Set(ctx, "flush:k", "v", 0)
+	if err := rdb.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("FLUSHDB = %v", err)
+	}
+	if n, err := rdb.DBSize(ctx).Result(); err != nil || n != 0 {
+		t.Errorf("DBSIZE after FLUSHDB = %d, %v, want 0", n, err)
+	}
+}
@@ -0,0 +1,215 @@
+package resp
+
+import (
+	"cmp"
+	"hash/fnv"
+	"kvstore/channels"
+	"kvstore/store"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultScanCount is how many keys SCAN looks at when not given a COUNT, as in Redis.
+const defaultScanCount = 10
+
+// keys runs KEYS pattern, replying with the matching keys in key order.
+func (c *conn) keys(args [][]byte) {
+	pattern := string(args[1])
+
+	keys := []string{}
+	for key := range channels.SnapshotRequest().Scan(literalPrefix(pattern)) {
+		if match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	c.w.strings(keys)
+}
+
+// scan runs SCAN cursor [MATCH pattern] [COUNT count] [TYPE type].
+//
+// Keys are scanned in the order of a hash of their names, and the cursor is the hash to carry on from.
+// Cursors therefore hold no state on the server and stay valid across connections, and a key present
+// from the first call to the last is returned exactly once, whatever else changes in between.
+func (c *conn) scan(args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		c.w.error("ERR invalid cursor")
+		return
+	}
+
+	pattern, count, kind := "*", defaultScanCount, ""
+	for i := 2; i < len(args); i++ {
+		if i+1 == len(args) {
+			c.w.error(errSyntax)
+			return
+		}
+		switch opt := strings.ToUpper(string(args[i])); opt {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil || count < 1 {
+				c.w.error(errSyntax)
+				return
+			}
+		case "TYPE":
+			kind = strings.ToLower(string(args[i+1]))
+		default:
+			c.w.error(errSyntax)
+			return
+		}
+		i++
+	}
+
+	snap := channels.SnapshotRequest()
+	index := c.srv.scan.index(snap)
+	start, _ := slices.BinarySearchFunc(index, cursor, func(k scanKey, h uint64) int {
+		return cmp.Compare(k.hash, h)
+	})
+	end := min(start+count, len(index))
+	// Keys sharing a hash go out together, as a cursor cannot point between them
+	for end < len(index) && end > start && index[end].hash == index[end-1].hash {
+		end++
+	}
+
+	next := uint64(0)
+	if end < len(index) && index[end-1].hash < math.MaxUint64 {
+		next = index[end-1].hash + 1
+	}
+
+	// Every value of the store is a string as far as Redis clients can tell
+	// The index is kept across calls, so keys that have expired since are left out here
+	keys := []string{}
+	for _, k := range index[start:end] {
+		if _, ok := snap.Get(k.key); ok && (kind == "" || kind == "string") && match(pattern, k.key) {
+			keys = append(keys, k.key)
+		}
+	}
+
+	c.w.array(2)
+	c.w.bulkString(strconv.FormatUint(next, 10))
+	c.w.strings(keys)
+}
+
+// scanKey is a key in SCAN order.
+type scanKey struct {
+	hash uint64
+	key  string
+}
+
+// scanCache holds the keys of the latest snapshot in SCAN order, so that a scan of many calls sorts
+// them once unless the store changes in between.
+type scanCache struct {
+	mu   sync.Mutex
+	snap *store.Snapshot
+	keys []scanKey
+}
+
+// index returns the keys of snap in SCAN order. The slice must not be modified.
+func (sc *scanCache) index(snap *store.Snapshot) []scanKey {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.snap == snap {
+		return sc.keys
+	}
+	keys := make([]scanKey, 0, snap.Len())
+	for key := range snap.Scan("") {
+		keys = append(keys, scanKey{hashKey(key), key})
+	}
+	slices.SortFunc(keys, func(a, b scanKey) int {
+		if c := cmp.Compare(a.hash, b.hash); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key, b.key)
+	})
+	sc.snap, sc.keys = snap, keys
+	return keys
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// match reports whether s matches a glob-style pattern as Redis reads it: * matches any run of bytes,
+// ? any one, [abc], [a-z] and [^abc] sets of them, and \ escapes the byte after it.
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := range len(s) + 1 {
+				if match(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		case '[':
+			if s == "" {
+				return false
+			}
+			var ok bool
+			if pattern, ok = matchSet(pattern[1:], s[0]); !ok {
+				return false
+			}
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// matchSet matches b against the set at the start of pattern, just past its [, and returns the rest of
+// the pattern after the closing ].
+func matchSet(pattern string, b byte) (string, bool) {
+	negate := strings.HasPrefix(pattern, "^")
+	if negate {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == b
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := min(pattern[0], pattern[2]), max(pattern[0], pattern[2])
+			matched = matched || (lo <= b && b <= hi)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == b
+			pattern = pattern[1:]
+		}
+	}
+	pattern = strings.TrimPrefix(pattern, "]")
+	return pattern, matched != negate
+}
+
+// literalPrefix returns the start of pattern that only matches itself, which every match begins with.
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
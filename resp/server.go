@@ -0,0 +1,200 @@
+// Package resp serves the key/value store over RESP, the protocol of Redis, so that Redis clients and
+// tools can be pointed at it. Both RESP2 and RESP3, which clients ask for with HELLO 3, are spoken, and
+// commands may be pipelined.
+//
+// The commands cover the key space: GET, SET, DEL, EXISTS, DBSIZE, FLUSHDB, KEYS, SCAN, INCR, EXPIRE
+// and TTL, with a few relatives of each. They go through the request loop like the HTTP handlers, so
+// both see the same keys. Values are kept as store.EncodeBytes keeps them, and values written over HTTP
+// read as their JSON encoding, strings aside.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultAddr is the address Redis listens on.
+const DefaultAddr = ":6379"
+
+// ErrServerClosed is returned by Serve and ListenAndServe once Close has been called.
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Server accepts RESP connections, serving each on its own goroutine.
+type Server struct {
+	Addr string // Address to listen on, DefaultAddr if empty
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+
+	clientID atomic.Int64 // Last ID handed to a connection, reported by HELLO and CLIENT ID
+	scan     scanCache    // Keys in SCAN order, see scan.go
+}
+
+// ListenAndServe listens on s.Addr and serves connections until Close is called.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the connections accepted by l until Close is called. It closes l when it returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+	defer l.Close()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nc, true) {
+			nc.Close()
+			continue
+		}
+		go s.serve(nc)
+	}
+}
+
+// Close stops the server, closing its listener and every connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for nc := range s.conns {
+		nc.Close()
+	}
+	clear(s.conns)
+	return err
+}
+
+// track adds or removes a connection, reporting false if one is added after Close.
+func (s *Server) track(nc net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.conns, nc)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[nc] = struct{}{}
+	return true
+}
+
+// conn is a client connection.
+type conn struct {
+	srv    *Server
+	r      reader
+	w      writer
+	id     int64
+	name   string // Set with CLIENT SETNAME or HELLO SETNAME
+	caller channels.Caller
+	quit   bool // Close the connection once the reply is written
+}
+
+func (s *Server) serve(nc net.Conn) {
+	defer s.track(nc, false)
+	defer nc.Close()
+
+	c := &conn{
+		srv:    s,
+		r:      reader{bufio.NewReader(nc)},
+		w:      writer{w: bufio.NewWriter(nc), proto: 2},
+		id:     s.clientID.Add(1),
+		caller: channels.Caller{Addr: nc.RemoteAddr().String()},
+	}
+	for !c.quit {
+		args, err := c.r.readCommand()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.w.error("ERR " + err.Error())
+				c.w.w.Flush()
+			}
+			return
+		}
+		c.exec(args)
+
+		// Replies to pipelined commands are sent together, once every command read has been run
+		if c.r.r.Buffered() == 0 || c.quit {
+			if err := c.w.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec runs a command and writes its reply.
+func (c *conn) exec(args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		var quoted []string
+		for _, arg := range args[1:] {
+			quoted = append(quoted, "'"+string(arg)+"'")
+		}
+		c.w.error(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", args[0], strings.Join(quoted, " ")))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		c.w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+
+	if err := channels.Admit(context.Background(), cmd.write, cmd.read); err != nil {
+		if errors.Is(err, helpers.ReadOnlyError) {
+			c.w.error("READONLY You can't write against a read only replica.")
+		} else {
+			c.replyError(err)
+		}
+		return
+	}
+	cmd.run(c, args)
+}
+
+// replyError writes err as an error reply.
+func (c *conn) replyError(err error) {
+	if !errors.Is(err, helpers.NotExistError) && !errors.Is(err, helpers.InvalidParamError) {
+		log.Printf("RESP Error: %s", err)
+	}
+	c.w.error("ERR " + err.Error())
+}
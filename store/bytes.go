@@ -0,0 +1,39 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// Values written through the Redis and memcached protocols are bytes, while the store holds JSON. Text
+// is kept as a JSON string, so it reads the same over HTTP, and other bytes as a document holding them
+// in base64 under this one field.
+const bytesField = "$base64"
+
+// EncodeBytes returns the JSON document the store keeps for b.
+func EncodeBytes(b []byte) []byte {
+	var doc any = string(b)
+	if !utf8.Valid(b) {
+		doc = map[string]string{bytesField: base64.StdEncoding.EncodeToString(b)}
+	}
+	encoded, _ := json.Marshal(doc)
+	return encoded
+}
+
+// DecodeBytes returns the bytes a value stands for: a string as it is, a document written by EncodeBytes
+// decoded, and any other value as its JSON encoding.
+func DecodeBytes(value any) []byte {
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case map[string]any:
+		if s, ok := v[bytesField].(string); ok && len(v) == 1 {
+			if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+				return b
+			}
+		}
+	}
+	encoded, _ := json.Marshal(value)
+	return encoded
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"kvstore/crdt"
+	"kvstore/helpers"
+	"maps"
+	"slices"
+	"time"
+)
+
+// CRDT is a replicated key and its current value.
+type CRDT struct {
+	Key   string    `json:"key"`
+	Type  crdt.Type `json:"type"`
+	Value any       `json:"value"`
+}
+
+// CRDTOp is an update to a replicated key.
+type CRDTOp string
+
+const (
+	CRDTSet       CRDTOp = "set"       // Write an lww register
+	CRDTIncrement CRDTOp = "increment" // Add By to a counter, negative to decrement a pncounter
+	CRDTAdd       CRDTOp = "add"       // Add Value to an orset
+	CRDTRemove    CRDTOp = "remove"    // Remove Value from an orset
+	CRDTPut       CRDTOp = "put"       // Write Field of an ormap
+	CRDTDelete    CRDTOp = "delete"    // Remove Field from an ormap
+)
+
+// CRDTUpdate describes one update to a replicated key.
+type CRDTUpdate struct {
+	Op    CRDTOp
+	Value json.RawMessage
+	Field string
+	By    int64
+}
+
+// SetCRDTNode names this site in the timestamps and counters of its updates. Every site replicating
+// the same keys must have a different name.
+func (s *KVStore) SetCRDTNode(node string) {
+	s.crdtClock = &crdt.Clock{Node: node, Now: func() time.Time { return s.now() }}
+}
+
+// DeclareCRDT makes key a replicated value of type t. Declaring a key again with the same type does
+// nothing, so every site can declare the keys it uses.
+func (s *KVStore) DeclareCRDT(key string, t crdt.Type) (CRDT, error) {
+
+	if key == "" {
+		return CRDT{}, helpers.MissingKeyError
+	}
+	if _, err := crdt.ParseType(string(t)); err != nil {
+		return CRDT{}, fmt.Errorf("%w: %s", helpers.InvalidParamError, err)
+	}
+
+	if st, ok := s.crdts[key]; ok {
+		if st.Type != t {
+			return CRDT{}, fmt.Errorf("%w: %s is already a %s", helpers.DuplicateKeyError, key, st.Type)
+		}
+		return CRDT{Key: key, Type: t, Value: st.Value()}, nil
+	}
+
+	st := crdt.New(t)
+	s.crdts[key] = &st
+	s.crdtChanged(key, st)
+	return CRDT{Key: key, Type: t, Value: st.Value()}, nil
+}
+
+// GetCRDT returns the current value of a replicated key.
+func (s *KVStore) GetCRDT(key string) (CRDT, error) {
+
+	if key == "" {
+		return CRDT{}, helpers.MissingKeyError
+	}
+	st, ok := s.crdts[key]
+	if !ok {
+		return CRDT{}, helpers.NotExistError
+	}
+	return CRDT{Key: key, Type: st.Type, Value: st.Value()}, nil
+}
+
+// AllCRDTs returns the current value of every replicated key, sorted by key.
+func (s *KVStore) AllCRDTs() []CRDT {
+	all := make([]CRDT, 0, len(s.crdts))
+	for _, key := range slices.Sorted(maps.Keys(s.crdts)) {
+		st := s.crdts[key]
+		all = append(all, CRDT{Key: key, Type: st.Type, Value: st.Value()})
+	}
+	return all
+}
+
+// UpdateCRDT applies an update to a replicated key and reports its delta to OnCRDTChange.
+func (s *KVStore) UpdateCRDT(key string, u CRDTUpdate) (CRDT, error) {
+
+	if key == "" {
+		return CRDT{}, helpers.MissingKeyError
+	}
+	st, ok := s.crdts[key]
+	if !ok {
+		return CRDT{}, helpers.NotExistError
+	}
+
+	var delta crdt.State
+	var err error
+	switch u.Op {
+	case CRDTSet:
+		delta, err = st.SetValue(u.Value, s.crdtClock.Tick())
+	case CRDTIncrement:
+		delta, err = st.Increment(s.crdtClock.Node, u.By)
+	case CRDTAdd:
+		delta, err = st.Add(u.Value, s.crdtClock.Tick())
+	case CRDTRemove:
+		delta, err = st.Remove(u.Value)
+	case CRDTPut:
+		delta, err = st.Put(u.Field, u.Value, s.crdtClock.Tick())
+	case CRDTDelete:
+		delta, err = st.Delete(u.Field)
+	default:
+		err = fmt.Errorf("unknown operation %q", u.Op)
+	}
+	if err != nil {
+		return CRDT{}, fmt.Errorf("%w: %s", helpers.InvalidParamError, err)
+	}
+
+	s.crdtChanged(key, delta)
+	return CRDT{Key: key, Type: st.Type, Value: st.Value()}, nil
+}
+
+// MergeCRDTs merges states received from another site and returns how many keys it merged. Keys not
+// declared here yet are declared by the merge. Merged states are not reported to OnCRDTChange: every
+// site sends its own updates to every other one.
+func (s *KVStore) MergeCRDTs(states map[string]crdt.State) (int, error) {
+
+	for key, st := range states {
+		if key == "" {
+			return 0, helpers.MissingKeyError
+		}
+		if _, err := crdt.ParseType(string(st.Type)); err != nil {
+			return 0, fmt.Errorf("%w: %s: %s", helpers.InvalidParamError, key, err)
+		}
+	}
+
+	for key, st := range states {
+		s.crdtClock.Observe(st.Latest())
+		var merged crdt.State
+		if cur, ok := s.crdts[key]; ok {
+			merged = crdt.Merge(*cur, st)
+		} else {
+			merged = crdt.Merge(crdt.State{}, st)
+		}
+		s.crdts[key] = &merged
+	}
+	return len(states), nil
+}
+
+// CRDTStates returns a copy of the state of every replicated key, for a full sync with another site.
+func (s *KVStore) CRDTStates() map[string]crdt.State {
+	states := make(map[string]crdt.State, len(s.crdts))
+	for key, st := range s.crdts {
+		states[key] = st.Clone()
+	}
+	return states
+}
+
+func (s *KVStore) crdtChanged(key string, delta crdt.State) {
+	if s.OnCRDTChange != nil {
+		s.OnCRDTChange(key, delta)
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"kvstore/crdt"
+	"kvstore/helpers"
+	"reflect"
+	"testing"
+)
+
+// site returns a store named node whose deltas are collected in the returned map.
+func site(node string) (*KVStore, map[string]crdt.State) {
+	s := NewKeyValueStore()
+	s.SetCRDTNode(node)
+	deltas := make(map[string]crdt.State)
+	s.OnCRDTChange = func(key string, delta crdt.State) {
+		deltas[key] = crdt.Merge(deltas[key], delta)
+	}
+	return s, deltas
+}
+
+func TestDeclareCRDT(t *testing.T) {
+	s, deltas := site("a")
+
+	if _, err := s.DeclareCRDT("visits", crdt.PNCounter); err != nil {
+		t.Fatalf("DeclareCRDT() returned an error: %v", err)
+	}
+	if _, err := s.DeclareCRDT("visits", crdt.PNCounter); err != nil {
+		t.Errorf("declaring a key again with the same type returned an error: %v", err)
+	}
+	if _, err := s.DeclareCRDT("visits", crdt.ORSet); !errors.Is(err, helpers.DuplicateKeyError) {
+		t.Errorf("declaring a key with another type = %v, want DuplicateKeyError", err)
+	}
+	if _, err := s.DeclareCRDT("tags", "list"); !errors.Is(err, helpers.InvalidParamError) {
+		t.Errorf("declaring an unknown type = %v, want InvalidParamError", err)
+	}
+	if _, ok := deltas["visits"]; !ok {
+		t.Error("declaring a key reported no delta")
+	}
+
+	// Replicated keys are kept apart from plain ones
+	if _, err := s.Get("visits"); err == nil {
+		t.Error("Get() found a replicated key")
+	}
+	if _, err := s.GetCRDT("missing"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("GetCRDT() of a missing key = %v, want NotExistError", err)
+	}
+}
+
+func TestUpdateCRDT(t *testing.T) {
+	s, _ := site("a")
+	s.DeclareCRDT("visits", crdt.PNCounter)
+	s.DeclareCRDT("user", crdt.ORMap)
+
+	s.UpdateCRDT("visits", CRDTUpdate{Op: CRDTIncrement, By: 5})
+	got, err := s.UpdateCRDT("visits", CRDTUpdate{Op: CRDTIncrement, By: -2})
+	if err != nil || got.Value != int64(3) {
+		t.Errorf("UpdateCRDT(increment) = %+v, %v, want 3", got, err)
+	}
+
+	s.UpdateCRDT("user", CRDTUpdate{Op: CRDTPut, Field: "name", Value: json.RawMessage(`"ada"`)})
+	got, _ = s.UpdateCRDT("user", CRDTUpdate{Op: CRDTPut, Field: "langs", Value: json.RawMessage(`["go"]`)})
+	want := map[string]any{"name": "ada", "langs": []any{"go"}}
+	if !reflect.DeepEqual(got.Value, want) {
+		t.Errorf("UpdateCRDT(put) = %v, want %v", got.Value, want)
+	}
+
+	for _, u := range []CRDTUpdate{
+		{Op: CRDTAdd, Value: json.RawMessage(`1`)},
+		{Op: CRDTIncrement},
+		{Op: "append"},
+	} {
+		if _, err := s.UpdateCRDT("visits", u); !errors.Is(err, helpers.InvalidParamError) {
+			t.Errorf("UpdateCRDT(%s) on a pncounter = %v, want InvalidParamError", u.Op, err)
+		}
+	}
+}
+
+// Two sites updating the same keys at once converge once they have exchanged their deltas.
+func TestMergeCRDTs(t *testing.T) {
+	a, fromA := site("a")
+	b, fromB := site("b")
+	for _, s := range []*KVStore{a, b} {
+		s.DeclareCRDT("visits", crdt.GCounter)
+		s.DeclareCRDT("tags", crdt.ORSet)
+	}
+
+	a.UpdateCRDT("visits", CRDTUpdate{Op: CRDTIncrement, By: 2})
+	b.UpdateCRDT("visits", CRDTUpdate{Op: CRDTIncrement, By: 3})
+	a.UpdateCRDT("tags", CRDTUpdate{Op: CRDTAdd, Value: json.RawMessage(`"x"`)})
+	b.UpdateCRDT("tags", CRDTUpdate{Op: CRDTAdd, Value: json.RawMessage(`"y"`)})
+	b.DeclareCRDT("only-b", crdt.LWW)
+
+	if _, err := a.MergeCRDTs(fromB); err != nil {
+		t.Fatalf("MergeCRDTs() returned an error: %v", err)
+	}
+	b.MergeCRDTs(fromA)
+
+	if !reflect.DeepEqual(a.AllCRDTs(), b.AllCRDTs()) {
+		t.Errorf("sites did not converge:\n%+v\n%+v", a.AllCRDTs(), b.AllCRDTs())
+	}
+	if got, _ := a.GetCRDT("visits"); got.Value != int64(5) {
+		t.Errorf("visits = %v, want 5", got.Value)
+	}
+	if got, _ := a.GetCRDT("tags"); !reflect.DeepEqual(got.Value, []any{"x", "y"}) {
+		t.Errorf("tags = %v, want [x y]", got.Value)
+	}
+
+	bad := map[string]crdt.State{"visits": {Type: "list"}}
+	if _, err := a.MergeCRDTs(bad); !errors.Is(err, helpers.InvalidParamError) {
+		t.Errorf("merging an unknown type = %v, want InvalidParamError", err)
+	}
+}
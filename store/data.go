@@ -1,5 +1,11 @@
 package store
 
+import (
+	"kvstore/crdt"
+	"sync"
+	"time"
+)
+
 type Storer interface {
 	Get(key string, value []byte) (any, error)
 	Add()
@@ -7,6 +13,47 @@ type Storer interface {
 
 type KVStore struct {
 	store map[string]any
+	meta  map[string]*meta        // Per key metadata, see meta.go
+	trash map[string][]TrashEntry // Soft deleted versions of each key, oldest first, see trash.go
+
+	tagIndex map[string]map[string]struct{} // "name=value" to the keys carrying it, see tags.go
+	tagNames map[string]map[string]struct{} // Tag name to the keys carrying it with any value
+
+	leases   map[string]*Lease // Named locks, see lease.go
+	leaseSeq uint64            // Last fencing token handed out
+
+	queues        map[string]*queue // Work queues, see queue.go
+	MaxDeliveries int               // Failed deliveries before a message is dead-lettered, zero retries forever
+	receiptKey    []byte            // Secret receipts are made with
+
+	streams map[string]*stream // Append-only logs, see stream.go
+
+	rateLimits map[string]*rateState // Rate limit state by key, see ratelimit.go
+
+	merkle *merkle // Hashes of the key space for anti-entropy, see merkle.go
+
+	version uint64    // Changes made to the key space, see snapshot.go
+	snap    *Snapshot // Latest snapshot taken
+	shared  bool      // Whether the latest snapshot shares store and meta, which must be copied before a change
+	epoch   uint64    // Snapshots taken, metadata from an earlier epoch may be held by one
+
+	// mu lets Read run alongside the request loop. The loop holds it to change the maps of the key space,
+	// the expiry of a key or the clock, and needs no lock to read them, as it is their only writer.
+	mu sync.RWMutex
+
+	crdts     map[string]*crdt.State // Replicated keys, kept apart from the key space, see crdt.go
+	crdtClock *crdt.Clock            // Timestamps updates to replicated keys
+
+	TrashRetention time.Duration // How long soft deleted keys are kept, zero keeps them until purged
+
+	tombstones         map[string]time.Time // When each deleted key was deleted, see tombstone.go
+	TombstoneRetention time.Duration        // How long deletions are remembered, zero remembers them forever
+
+	clearSeq uint64           // Sequence used to build soft clear IDs
+	now      func() time.Time // Clock, replaced in tests
+
+	OnChange     func(Change)                       // Called with every change to the key space, see replication.go
+	OnCRDTChange func(key string, delta crdt.State) // Called with the delta of every update to a replicated key
 }
 
 type Response struct {
@@ -15,9 +62,27 @@ type Response struct {
 }
 
 func NewKeyValueStore() *KVStore {
-	return &KVStore{
-		store: make(map[string]any), // Initialising the map with make
+	s := &KVStore{
+		store:          make(map[string]any), // Initialising the map with make
+		meta:           make(map[string]*meta),
+		trash:          make(map[string][]TrashEntry),
+		tagIndex:       make(map[string]map[string]struct{}),
+		tagNames:       make(map[string]map[string]struct{}),
+		leases:         make(map[string]*Lease),
+		queues:         make(map[string]*queue),
+		streams:        make(map[string]*stream),
+		rateLimits:     make(map[string]*rateState),
+		merkle:         newMerkle(),
+		crdts:          make(map[string]*crdt.State),
+		MaxDeliveries:  DefaultMaxDeliveries,
+		TrashRetention: DefaultTrashRetention,
+		now:            time.Now,
+
+		tombstones:         make(map[string]time.Time),
+		TombstoneRetention: DefaultTombstoneRetention,
 	}
+	s.SetCRDTNode("local")
+	return s
 }
 
 func (s *KVStore) InitData() {
@@ -34,3 +99,13 @@ func (s *KVStore) InitData() {
 		s.Add(k, v.([]byte))
 	}
 }
+
+// Sweep removes state that has outlived its retention. It is called periodically by the request loop.
+func (s *KVStore) Sweep() {
+	s.purgeExpiredKeys()
+	s.purgeExpiredTrash()
+	s.purgeExpiredTombstones()
+	s.purgeExpiredLeases()
+	s.purgeExpiredRateLimits()
+	s.requeueAllExpired()
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"kvstore/helpers"
+	"time"
+)
+
+// Keys can be given a time to live, after which they read as missing. An expired key is removed when it
+// is next used, or by Sweep, so until then it is still counted by Count and seen by snapshots, which
+// callers can tell from Metadata.ExpiresAt.
+
+// Expire sets key to expire once ttl has passed, replacing any expiry it had. A ttl of zero or less
+// deletes the key at once, as in Redis.
+func (s *KVStore) Expire(key string, ttl time.Duration) error {
+
+	if !s.live(key) {
+		return helpers.NotExistError
+	}
+	if ttl <= 0 {
+		s.remove(key)
+		return nil
+	}
+
+	m, _ := s.writable(key)
+	s.setExpiry(m, s.now().Add(ttl))
+	s.changed(key)
+	return nil
+}
+
+// Persist removes the expiry of key, reporting whether it had one.
+func (s *KVStore) Persist(key string) (bool, error) {
+
+	if !s.live(key) {
+		return false, helpers.NotExistError
+	}
+	if s.meta[key].expiresAt.IsZero() {
+		return false, nil
+	}
+
+	m, _ := s.writable(key)
+	s.setExpiry(m, time.Time{})
+	s.changed(key)
+	return true, nil
+}
+
+// live reports whether key exists, removing it first if it has expired.
+func (s *KVStore) live(key string) bool {
+	m, ok := s.meta[key]
+	if !ok {
+		return false
+	}
+	if m.expired(s.now()) {
+		s.remove(key)
+		return false
+	}
+	return true
+}
+
+// setExpiry sets when the key of m expires. Read checks expiries, so they change under the lock.
+func (s *KVStore) setExpiry(m *meta, expiresAt time.Time) {
+	s.mu.Lock()
+	m.expiresAt = expiresAt
+	s.mu.Unlock()
+}
+
+// expired reports whether the key has expired by now.
+func (m *meta) expired(now time.Time) bool {
+	return !m.expiresAt.IsZero() && !now.Before(m.expiresAt)
+}
+
+// purgeExpiredKeys removes the keys that have expired.
+func (s *KVStore) purgeExpiredKeys() {
+	now := s.now()
+	for key, m := range s.meta {
+		if m.expired(now) {
+			s.remove(key)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"errors"
+	"kvstore/helpers"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	store.Add("Key", []byte(`"Value"`))
+	store.Add("Swept", []byte(`1`))
+	if err := store.Expire("Key", time.Minute); err != nil {
+		t.Fatalf("Expire() = %v", err)
+	}
+	store.Expire("Swept", time.Minute)
+	if md, _ := store.Meta("Key"); !md.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Meta().ExpiresAt = %v, want %v", md.ExpiresAt, now.Add(time.Minute))
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := store.Get("Key"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Get() of an expired key error = %v, want %v", err, helpers.NotExistError)
+	}
+	if _, err := store.Add("Key", []byte(`"New"`)); err != nil {
+		t.Errorf("Add() over an expired key = %v", err)
+	}
+	if md, _ := store.Meta("Key"); !md.ExpiresAt.IsZero() {
+		t.Errorf("Meta().ExpiresAt of a new key = %v, want none", md.ExpiresAt)
+	}
+
+	store.Sweep()
+	if n, _ := store.Count(); n != 1 {
+		t.Errorf("Count() after Sweep() = %d, want 1", n)
+	}
+
+	store.Expire("Key", time.Hour)
+	store.Upsert("Key", []byte(`"Newer"`))
+	if md, _ := store.Meta("Key"); !md.ExpiresAt.IsZero() {
+		t.Errorf("Meta().ExpiresAt after Upsert() = %v, want the expiry cleared", md.ExpiresAt)
+	}
+	if err := store.Expire("Key", 0); err != nil {
+		t.Errorf("Expire(0) = %v", err)
+	}
+	if err := store.Expire("Key", time.Minute); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Expire() after Expire(0) error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	store := NewKeyValueStore()
+	store.Add("Number", []byte(`41`))
+	store.Add("String", []byte(`"-7"`))
+	store.Add("Float", []byte(`1.5`))
+	store.Add("Text", []byte(`"seven"`))
+	store.Expire("Number", time.Hour)
+
+	for _, tt := range []struct {
+		key   string
+		delta int64
+		want  int64
+		value any
+	}{
+		{"Number", 1, 42, float64(42)},
+		{"String", 10, 3, "3"},
+		{"Missing", -2, -2, float64(-2)},
+	} {
+		got, err := store.Incr(tt.key, tt.delta)
+		value, _ := store.Get(tt.key)
+		if err != nil || got != tt.want || value != tt.value {
+			t.Errorf("Incr(%q, %d) = %d, %v, stored %#v, want %d, %#v", tt.key, tt.delta, got, err, value, tt.want, tt.value)
+		}
+	}
+	if md, _ := store.Meta("Number"); md.ExpiresAt.IsZero() {
+		t.Error("Incr() cleared the expiry")
+	}
+
+	for _, key := range []string{"Float", "Text"} {
+		if _, err := store.Incr(key, 1); !errors.Is(err, helpers.InvalidParamError) {
+			t.Errorf("Incr(%q) error = %v, want %v", key, err, helpers.InvalidParamError)
+		}
+	}
+	store.Upsert("String", []byte(`"9223372036854775807"`))
+	if _, err := store.Incr("String", 1); !errors.Is(err, helpers.InvalidParamError) {
+		t.Errorf("Incr() past the largest integer error = %v, want %v", err, helpers.InvalidParamError)
+	}
+}
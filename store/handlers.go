@@ -11,17 +11,32 @@ var (
 
 func (s *KVStore) Get(key string) (any, error) {
 
-	value, ok := s.store[key]
-	if !ok {
+	if !s.live(key) {
 		return "", helpers.NotExistError
 	}
-	return value, nil
+	s.meta[key].touch(s.now())
+	return s.store[key], nil
+}
+
+// Read is Get for use outside the request loop, alongside it. An expired key reads as missing but is
+// left for the loop to remove.
+func (s *KVStore) Read(key string) (any, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.meta[key]
+	now := s.now()
+	if !ok || m.expired(now) {
+		return "", helpers.NotExistError
+	}
+	m.touch(now)
+	return s.store[key], nil
 }
 
 func (s *KVStore) Add(key string, v []byte) (any, error) {
 
 	// Check for duplicate keys
-	if _, ok := s.store[key]; ok {
+	if s.live(key) {
 		return "", helpers.DuplicateKeyError // Return early if the key already exists
 	}
 
@@ -31,19 +46,20 @@ func (s *KVStore) Add(key string, v []byte) (any, error) {
 		return "", err // Return early if parsing fails
 	}
 	// Add the key-value pair to the store
-	s.store[key] = value
+	s.put(key, value, len(v))
 
 	return value, nil
 }
 
+// GetAll returns every value by key, as of a snapshot. The map must not be modified.
 func (s *KVStore) GetAll() (any, error) {
 
-	return s.store, nil
+	return s.Snapshot().Values(), nil
 }
 
 func (s *KVStore) Exists(key string) (bool, error) {
 
-	if _, ok := s.store[key]; !ok {
+	if !s.live(key) {
 		return false, helpers.NotExistError
 	}
 
@@ -52,30 +68,52 @@ func (s *KVStore) Exists(key string) (bool, error) {
 
 func (s *KVStore) Count() (int, error) {
 
-	return len(s.store), nil
+	return s.Snapshot().Len(), nil
 }
 
 func (s *KVStore) Clear() (any, error) {
 
-	clear(s.store)
+	for key := range s.meta {
+		s.bury(key)
+	}
+
+	s.mu.Lock()
+	if s.shared {
+		// A snapshot holds the maps, so start afresh rather than clearing them
+		s.store = make(map[string]any)
+		s.meta = make(map[string]*meta)
+		s.shared = false
+	} else {
+		clear(s.store)
+		clear(s.meta)
+	}
+	s.mu.Unlock()
+	s.version++
+	clear(s.tagIndex)
+	clear(s.tagNames)
+	s.merkle.reset()
+
+	if s.OnChange != nil {
+		s.OnChange(Change{Op: ChangeClear})
+	}
 
-	return s.store, nil
+	return map[string]any{}, nil
 }
 
 func (s *KVStore) Delete(key string) error {
 
-	if _, ok := s.store[key]; !ok {
+	if !s.live(key) {
 		return helpers.NotExistError
 
 	}
 
-	delete(s.store, key)
+	s.remove(key)
 	return nil
 }
 
 func (s *KVStore) Update(key string, v []byte) (any, error) {
 
-	if _, ok := s.store[key]; !ok {
+	if !s.live(key) {
 		return "", helpers.NotExistError
 	}
 
@@ -85,7 +123,7 @@ func (s *KVStore) Update(key string, v []byte) (any, error) {
 		return "", err // Return early if parsing fails
 	}
 
-	s.store[key] = value
+	s.put(key, value, len(v))
 
 	return value, nil
 }
@@ -98,7 +136,13 @@ func (s *KVStore) Upsert(key string, v []byte) (any, error) {
 		return "", err // Return early if parsing fails
 	}
 
-	s.store[key] = value
+	s.put(key, value, len(v))
 
 	return value, nil
 }
+
+// Peek returns the value stored under key, if any. Used by the request loop to capture old values for auditing.
+func (s *KVStore) Peek(key string) (any, bool) {
+	value, ok := s.store[key]
+	return value, ok
+}
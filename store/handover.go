@@ -0,0 +1,151 @@
+package store
+
+import (
+	"fmt"
+	"kvstore/helpers"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// TakeState removes the leases, queues, streams, rate limits and trash that move reports belong
+// elsewhere and returns them as changes, so they can be handed over to another store with MergeChange.
+// move is asked about the name of a lease, queue or stream and about the key of a rate limit or trash
+// entry. A dead-letter queue goes wherever its queue goes.
+func (s *KVStore) TakeState(move func(name string) bool) []Change {
+
+	var changes []Change
+
+	for _, key := range slices.Sorted(maps.Keys(s.trash)) {
+		if move(key) {
+			changes = append(changes, Change{Op: ChangeTrash, Key: key, Trash: s.trashStates(key), ClearSeq: s.clearSeq})
+			delete(s.trash, key)
+			s.trashChanged(key)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.leases)) {
+		if move(name) {
+			l := *s.leases[name]
+			changes = append(changes, Change{Op: ChangeLease, Key: name, Lease: &l, LeaseSeq: s.leaseSeq})
+			delete(s.leases, name)
+			s.leaseChanged(name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.queues)) {
+		if move(strings.TrimSuffix(name, DeadLetterSuffix)) {
+			qs := queueState(name, s.queues[name])
+			changes = append(changes, Change{Op: ChangeQueue, Key: name, Queue: &qs})
+			delete(s.queues, name)
+			s.queueChanged(name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.streams)) {
+		if move(name) {
+			ss := streamState(name, s.streams[name])
+			changes = append(changes, Change{Op: ChangeStream, Key: name, Stream: &ss})
+			delete(s.streams, name)
+			s.streamChanged(name)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(s.rateLimits)) {
+		if move(key) {
+			rs := rateLimitState(key, s.rateLimits[key])
+			changes = append(changes, Change{Op: ChangeRateLimit, Key: key, RateLimit: &rs})
+			delete(s.rateLimits, key)
+			s.rateLimitChanged(key)
+		}
+	}
+
+	return changes
+}
+
+// MergeChange adds state taken from another store by TakeState to this one. Whatever this store already
+// holds under the same name is kept: deleted versions and queue messages are added to those here, stream
+// entries and consumer groups missing here are added, and a lease or rate limit here that has not run
+// out wins over the one handed over.
+func (s *KVStore) MergeChange(c Change) error {
+
+	if c.Key == "" {
+		return helpers.MissingKeyError
+	}
+
+	now := s.now()
+	switch {
+	case c.Op == ChangeTrash:
+		versions := slices.Concat(s.trashStates(c.Key), c.Trash)
+		slices.SortStableFunc(versions, func(a, b TrashState) int { return a.DeletedAt.Compare(b.DeletedAt) })
+		s.loadTrash(c.Key, versions)
+		s.trashChanged(c.Key)
+	case c.Op == ChangeLease && c.Lease != nil:
+		// Tokens handed out for the lease from now on stay above those of its previous owner
+		s.leaseSeq = max(s.leaseSeq, c.Lease.Token)
+		if l, ok := s.leases[c.Key]; !ok || !now.Before(l.ExpiresAt) {
+			s.loadLease(*c.Lease)
+		}
+		s.leaseChanged(c.Key)
+	case c.Op == ChangeQueue && c.Queue != nil:
+		if q, ok := s.queues[c.Key]; ok {
+			q.merge(newQueue(*c.Queue))
+		} else {
+			s.loadQueue(*c.Queue)
+		}
+		s.queueChanged(c.Key)
+	case c.Op == ChangeStream && c.Stream != nil:
+		if st, ok := s.streams[c.Key]; ok {
+			st.merge(newStream(*c.Stream))
+		} else {
+			s.loadStream(*c.Stream)
+		}
+		s.streamChanged(c.Key)
+	case c.Op == ChangeRateLimit && c.RateLimit != nil:
+		if st, ok := s.rateLimits[c.Key]; !ok || !now.Before(st.expiresAt) {
+			s.loadRateLimit(*c.RateLimit)
+		}
+		s.rateLimitChanged(c.Key)
+	default:
+		return fmt.Errorf("%w: cannot merge a %q change", helpers.InvalidParamError, c.Op)
+	}
+
+	return nil
+}
+
+// merge adds the messages of other to q. They were enqueued before the queue moved here, so the
+// messages waiting in other are delivered first.
+func (q *queue) merge(other *queue) {
+	q.ready = append(other.ready, q.ready...)
+	for receipt, msg := range other.inFlight {
+		if _, ok := q.inFlight[receipt]; !ok {
+			q.inFlight[receipt] = msg
+		}
+	}
+	q.seq = max(q.seq, other.seq)
+	q.enqueued += other.enqueued
+	q.acked += other.acked
+}
+
+// merge adds the entries and consumer groups of other that st does not have.
+func (st *stream) merge(other *stream) {
+	entries := slices.Concat(st.entries, other.entries)
+	slices.SortStableFunc(entries, func(a, b StreamEntry) int { return a.id.compare(b.id) })
+	st.entries = slices.CompactFunc(entries, func(a, b StreamEntry) bool { return a.id == b.id })
+	if other.lastID.compare(st.lastID) > 0 {
+		st.lastID = other.lastID
+	}
+
+	for name, og := range other.groups {
+		g, ok := st.groups[name]
+		if !ok {
+			st.groups[name] = og
+			continue
+		}
+		if og.lastDelivered.compare(g.lastDelivered) > 0 {
+			g.lastDelivered = og.lastDelivered
+		}
+		for id, p := range og.pending {
+			if _, ok := g.pending[id]; !ok {
+				g.pending[id] = p
+			}
+		}
+		maps.Copy(g.consumers, og.consumers)
+	}
+}
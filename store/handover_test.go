@@ -0,0 +1,74 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTakeAndMergeState(t *testing.T) {
+	from := NewKeyValueStore()
+	from.MaxDeliveries = 1
+	from.AcquireLease("lock", "a", time.Minute)
+	from.Enqueue("jobs", []byte(`1`))
+	from.Enqueue("jobs", []byte(`2`))
+	msg, _ := from.Dequeue("jobs", time.Minute)
+	from.Nack("jobs", msg.Receipt) // Dead-lettered
+	from.At(time.Now().Add(-time.Second), func() { from.StreamAdd("events", []byte(`1`), 0) })
+	from.CreateGroup("events", "workers", "0")
+	from.AllowRate("api", RateLimit{Capacity: 5, Rate: 1})
+	from.Add("gone", []byte(`1`))
+	from.SoftDelete("gone")
+	from.Enqueue("stays", []byte(`1`))
+
+	changes := from.TakeState(func(name string) bool { return name != "stays" })
+	if len(changes) != 6 {
+		t.Fatalf("TakeState() returned %d changes, want 6: %+v", len(changes), changes)
+	}
+	if _, err := from.GetLease("lock"); err == nil {
+		t.Errorf("lease still held after being taken")
+	}
+	if _, err := from.QueueStats("stays"); err != nil {
+		t.Errorf("QueueStats() of the queue left behind = %v", err)
+	}
+
+	// The receiving store already has some of it
+	to := NewKeyValueStore()
+	to.AcquireLease("lock", "b", time.Minute)
+	to.Enqueue("jobs", []byte(`3`))
+	to.StreamAdd("events", []byte(`2`), 0)
+	for _, c := range changes {
+		if err := to.MergeChange(c); err != nil {
+			t.Fatalf("MergeChange(%s %s) = %v", c.Op, c.Key, err)
+		}
+	}
+
+	if l, _ := to.GetLease("lock"); l.Owner != "b" {
+		t.Errorf("lease held by %s after merging, want b who held it here", l.Owner)
+	}
+	if l, _ := to.AcquireLease("other", "c", time.Minute); l.Token < 2 {
+		t.Errorf("token after merging = %d, want above the tokens of both stores", l.Token)
+	}
+	if m, _ := to.Dequeue("jobs", time.Minute); m.Body != float64(2) {
+		t.Errorf("first message after merging = %v, want 2, enqueued before the queue moved", m.Body)
+	}
+	if st, _ := to.QueueStats("jobs"); st.Dead != 1 {
+		t.Errorf("dead letters after merging = %d, want 1", st.Dead)
+	}
+	if entries, _ := to.StreamRange("events", "-", "+", 0); len(entries) != 2 {
+		t.Errorf("stream after merging has %d entries, want 2", len(entries))
+	}
+	if info, _ := to.StreamInfo("events"); len(info.Groups) != 1 {
+		t.Errorf("stream after merging has groups %+v, want workers", info.Groups)
+	}
+	if res, _ := to.AllowRate("api", RateLimit{Capacity: 5, Rate: 1}); res.Remaining != 3 {
+		t.Errorf("rate limit after merging has %d remaining, want 3", res.Remaining)
+	}
+	if v, err := to.Restore("gone"); err != nil || v != float64(1) {
+		t.Errorf("Restore() after merging = %v, %v", v, err)
+	}
+
+	if err := to.MergeChange(Change{Op: ChangeSet, Key: "k"}); err == nil || !strings.Contains(err.Error(), "merge") {
+		t.Errorf("MergeChange() of a key = %v, want an error", err)
+	}
+}
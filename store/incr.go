@@ -0,0 +1,57 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"kvstore/helpers"
+	"math"
+	"strconv"
+	"time"
+)
+
+// maxExactInt is the largest integer a JSON number, read as a float64, holds exactly.
+const maxExactInt = 1 << 53
+
+// Incr adds delta to the integer stored under key and returns the result, as one step. A missing key
+// counts as zero. The value may be a JSON number or a string holding a decimal integer, such as one set
+// through Redis, and keeps its kind; the key keeps its expiry and flags.
+func (s *KVStore) Incr(key string, delta int64) (int64, error) {
+
+	var n int64
+	var quoted bool
+	var expiresAt time.Time
+	var flags uint32
+	if s.live(key) {
+		expiresAt, flags = s.meta[key].expiresAt, s.meta[key].flags
+		switch v := s.store[key].(type) {
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) > maxExactInt {
+				return 0, fmt.Errorf("%w: value is not an integer", helpers.InvalidParamError)
+			}
+			n = int64(v)
+		case string:
+			var err error
+			if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return 0, fmt.Errorf("%w: value is not an integer", helpers.InvalidParamError)
+			}
+			quoted = true
+		default:
+			return 0, fmt.Errorf("%w: value is not an integer", helpers.InvalidParamError)
+		}
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, fmt.Errorf("%w: increment would overflow", helpers.InvalidParamError)
+	}
+	n += delta
+
+	var value any = float64(n)
+	if quoted {
+		value = strconv.FormatInt(n, 10)
+	} else if n > maxExactInt || n < -maxExactInt {
+		return 0, fmt.Errorf("%w: result does not fit a JSON number", helpers.InvalidParamError)
+	}
+	encoded, _ := json.Marshal(value)
+	s.putWith(key, value, len(encoded), expiresAt, flags)
+	return n, nil
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"fmt"
+	"kvstore/helpers"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Lease is a named lock held by an owner until it is released or its TTL runs out.
+// Leases live in their own namespace and do not appear among the keys of the store.
+type Lease struct {
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	Token     uint64    `json:"token"` // Fencing token, higher than the token of every earlier acquisition
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AcquireLease takes the lease for owner if it is free or expired. Acquiring a lease the owner already
+// holds extends it and keeps its token. If another owner holds it, the current lease is returned with a
+// LeaseHeldError so the caller knows when to try again.
+func (s *KVStore) AcquireLease(name, owner string, ttl time.Duration) (Lease, error) {
+
+	if err := validateLease(name, owner, ttl); err != nil {
+		return Lease{}, err
+	}
+
+	now := s.now()
+	if l, ok := s.leases[name]; ok && now.Before(l.ExpiresAt) {
+		if l.Owner != owner {
+			return *l, helpers.LeaseHeldError
+		}
+		l.ExpiresAt = now.Add(ttl)
+		s.leaseChanged(name)
+		return *l, nil
+	}
+
+	s.leaseSeq++
+	l := &Lease{Name: name, Owner: owner, Token: s.leaseSeq, ExpiresAt: now.Add(ttl)}
+	s.leases[name] = l
+	s.leaseChanged(name)
+
+	return *l, nil
+}
+
+// RenewLease extends a lease that owner still holds.
+func (s *KVStore) RenewLease(name, owner string, ttl time.Duration) (Lease, error) {
+
+	if err := validateLease(name, owner, ttl); err != nil {
+		return Lease{}, err
+	}
+
+	l, err := s.heldLease(name, owner)
+	if err != nil {
+		return Lease{}, err
+	}
+
+	l.ExpiresAt = s.now().Add(ttl)
+	s.leaseChanged(name)
+	return *l, nil
+}
+
+// ReleaseLease frees a lease that owner still holds.
+func (s *KVStore) ReleaseLease(name, owner string) error {
+
+	if _, err := s.heldLease(name, owner); err != nil {
+		return err
+	}
+
+	delete(s.leases, name)
+	s.leaseChanged(name)
+	return nil
+}
+
+// GetLease returns the current holder of a lease.
+func (s *KVStore) GetLease(name string) (Lease, error) {
+
+	if name == "" {
+		return Lease{}, helpers.MissingKeyError
+	}
+
+	l, ok := s.leases[name]
+	if !ok || !s.now().Before(l.ExpiresAt) {
+		return Lease{}, helpers.NotExistError
+	}
+	return *l, nil
+}
+
+// heldLease returns the lease if owner holds it and it has not expired.
+func (s *KVStore) heldLease(name, owner string) (*Lease, error) {
+	l, ok := s.leases[name]
+	if !ok || !s.now().Before(l.ExpiresAt) {
+		return nil, helpers.NotLeaseOwnerError
+	}
+	if l.Owner != owner {
+		return nil, helpers.NotLeaseOwnerError
+	}
+	return l, nil
+}
+
+// purgeExpiredLeases drops leases whose TTL has run out.
+func (s *KVStore) purgeExpiredLeases() {
+	now := s.now()
+	for name, l := range s.leases {
+		if !now.Before(l.ExpiresAt) {
+			delete(s.leases, name)
+			s.leaseChanged(name)
+		}
+	}
+}
+
+func validateLease(name, owner string, ttl time.Duration) error {
+	if name == "" {
+		return helpers.MissingKeyError
+	}
+	if owner == "" {
+		return fmt.Errorf("%w: owner not provided", helpers.InvalidParamError)
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("%w: ttl must be positive", helpers.InvalidParamError)
+	}
+	return nil
+}
+
+// leaseStates returns a copy of every lease, sorted by name.
+func (s *KVStore) leaseStates() []Lease {
+	leases := make([]Lease, 0, len(s.leases))
+	for _, name := range slices.Sorted(maps.Keys(s.leases)) {
+		leases = append(leases, *s.leases[name])
+	}
+	return leases
+}
+
+// loadLeases replaces the leases of the store with leases, handing out tokens after seq from then on.
+func (s *KVStore) loadLeases(leases []Lease, seq uint64) {
+	clear(s.leases)
+	for _, l := range leases {
+		s.loadLease(l)
+	}
+	s.leaseSeq = seq
+}
+
+// loadLease adds l to the leases of the store.
+func (s *KVStore) loadLease(l Lease) {
+	s.leases[l.Name] = &l
+}
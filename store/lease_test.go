@@ -0,0 +1,89 @@
+package store
+
+import (
+	"errors"
+	"kvstore/helpers"
+	"testing"
+	"time"
+)
+
+func TestAcquireLease(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	first, err := store.AcquireLease("cron", "worker-1", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease() returned an error: %v", err)
+	}
+
+	// A second owner is refused while the lease is live
+	held, err := store.AcquireLease("cron", "worker-2", time.Minute)
+	if !errors.Is(err, helpers.LeaseHeldError) || held.Owner != "worker-1" {
+		t.Errorf("AcquireLease() = %+v, %v, want %v held by worker-1", held, err, helpers.LeaseHeldError)
+	}
+
+	// Acquiring again as the holder extends the lease without a new token
+	now = now.Add(30 * time.Second)
+	again, err := store.AcquireLease("cron", "worker-1", time.Minute)
+	if err != nil || again.Token != first.Token || !again.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("AcquireLease() by holder = %+v, %v, want token %d extended", again, err, first.Token)
+	}
+
+	// Once the lease has expired, a new owner takes it with a higher fencing token
+	now = now.Add(2 * time.Minute)
+	next, err := store.AcquireLease("cron", "worker-2", time.Minute)
+	if err != nil || next.Token <= first.Token {
+		t.Errorf("AcquireLease() after expiry = %+v, %v, want a token above %d", next, err, first.Token)
+	}
+
+	if _, err := store.AcquireLease("cron", "", time.Minute); !errors.Is(err, helpers.InvalidParamError) {
+		t.Errorf("AcquireLease() without owner error = %v, want %v", err, helpers.InvalidParamError)
+	}
+	if _, err := store.AcquireLease("cron", "worker-1", 0); !errors.Is(err, helpers.InvalidParamError) {
+		t.Errorf("AcquireLease() without ttl error = %v, want %v", err, helpers.InvalidParamError)
+	}
+}
+
+func TestRenewLease(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	store.AcquireLease("cron", "worker-1", time.Minute)
+
+	if _, err := store.RenewLease("cron", "worker-2", time.Minute); !errors.Is(err, helpers.NotLeaseOwnerError) {
+		t.Errorf("RenewLease() by another owner error = %v, want %v", err, helpers.NotLeaseOwnerError)
+	}
+
+	now = now.Add(50 * time.Second)
+	got, err := store.RenewLease("cron", "worker-1", time.Minute)
+	if err != nil || !got.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("RenewLease() = %+v, %v, want expiry %v", got, err, now.Add(time.Minute))
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := store.RenewLease("cron", "worker-1", time.Minute); !errors.Is(err, helpers.NotLeaseOwnerError) {
+		t.Errorf("RenewLease() after expiry error = %v, want %v", err, helpers.NotLeaseOwnerError)
+	}
+}
+
+func TestReleaseLease(t *testing.T) {
+	store := NewKeyValueStore()
+	first, _ := store.AcquireLease("cron", "worker-1", time.Minute)
+
+	if err := store.ReleaseLease("cron", "worker-2"); !errors.Is(err, helpers.NotLeaseOwnerError) {
+		t.Errorf("ReleaseLease() by another owner error = %v, want %v", err, helpers.NotLeaseOwnerError)
+	}
+	if err := store.ReleaseLease("cron", "worker-1"); err != nil {
+		t.Errorf("ReleaseLease() returned an error: %v", err)
+	}
+	if _, err := store.GetLease("cron"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("GetLease() after ReleaseLease() error = %v, want %v", err, helpers.NotExistError)
+	}
+
+	next, err := store.AcquireLease("cron", "worker-2", time.Minute)
+	if err != nil || next.Token <= first.Token {
+		t.Errorf("AcquireLease() after release = %+v, %v, want a token above %d", next, err, first.Token)
+	}
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"kvstore/helpers"
+)
+
+// MerkleDepth is the depth of the Merkle tree over the key space. Keys are spread over 1 << MerkleDepth
+// leaves by the hash of the key, so each leaf covers a range of hashes.
+const MerkleDepth = 10
+
+// merkle is a Merkle tree over the key space. A leaf is the XOR of the digests of its keys, so a key can
+// be added or removed without touching the others, and every inner node hashes its two children.
+// Changed keys are only marked dirty on write and folded in when the tree is next read.
+type merkle struct {
+	digests map[string]uint64   // Digest of each key as last folded into its leaf
+	dirty   map[string]struct{} // Keys changed since the tree was last read
+	levels  [][]uint64          // levels[0] is the root, levels[MerkleDepth] the leaves
+}
+
+func newMerkle() *merkle {
+	t := &merkle{digests: make(map[string]uint64), dirty: make(map[string]struct{})}
+	t.levels = make([][]uint64, MerkleDepth+1)
+	for l := range t.levels {
+		t.levels[l] = make([]uint64, 1<<l)
+	}
+	return t
+}
+
+// reset empties the tree, for when the whole key space is cleared.
+func (t *merkle) reset() {
+	clear(t.digests)
+	clear(t.dirty)
+	for _, level := range t.levels {
+		clear(level)
+	}
+}
+
+// MerkleLeaf returns the leaf of the Merkle tree that key falls in.
+func MerkleLeaf(key string) int {
+	return int(sum64([]byte(key)) >> (64 - MerkleDepth))
+}
+
+// Digest identifies the content of a key: its value and tags. Two stores holding the same value and
+// tags under a key have the same digest for it, whatever the rest of their metadata.
+func Digest(key string, item Item) uint64 {
+	value, _ := json.Marshal(item.Value)
+	var tags []byte
+	if len(item.Metadata.Tags) > 0 {
+		tags, _ = json.Marshal(item.Metadata.Tags)
+	}
+
+	b := make([]byte, 0, len(key)+len(value)+len(tags)+2)
+	b = append(b, key...)
+	b = append(b, 0)
+	b = append(b, value...)
+	b = append(b, 0)
+	b = append(b, tags...)
+	return sum64(b)
+}
+
+func sum64(b []byte) uint64 {
+	h := sha256.Sum256(b)
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// refreshMerkle folds the keys changed since the last read into the tree and rehashes the path from
+// each changed leaf to the root.
+func (s *KVStore) refreshMerkle() {
+	t := s.merkle
+	if len(t.dirty) == 0 {
+		return
+	}
+
+	leaves := t.levels[MerkleDepth]
+	changed := make(map[int]struct{})
+	for key := range t.dirty {
+		leaf := MerkleLeaf(key)
+		leaves[leaf] ^= t.digests[key]
+		delete(t.digests, key)
+
+		if m, ok := s.meta[key]; ok {
+			d := Digest(key, Item{Value: s.store[key], Metadata: Metadata{Tags: m.tags}})
+			t.digests[key] = d
+			leaves[leaf] ^= d
+		}
+		changed[leaf] = struct{}{}
+	}
+	clear(t.dirty)
+
+	for l := MerkleDepth - 1; l >= 0; l-- {
+		parents := make(map[int]struct{}, len(changed))
+		for i := range changed {
+			p := i / 2
+			t.levels[l][p] = inner(t.levels[l+1][2*p], t.levels[l+1][2*p+1])
+			parents[p] = struct{}{}
+		}
+		changed = parents
+	}
+}
+
+// inner hashes two children into their parent. An empty subtree hashes to zero, so the tree depends
+// only on the keys it holds and not on those it held before.
+func inner(left, right uint64) uint64 {
+	if left == 0 && right == 0 {
+		return 0
+	}
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], left)
+	binary.BigEndian.PutUint64(b[8:], right)
+	return sum64(b[:])
+}
+
+// MerkleHashes returns the hashes of the given nodes at level of the Merkle tree, level 0 being the root.
+// Nodes at a level are numbered from 0, and the children of node i are 2i and 2i+1.
+func (s *KVStore) MerkleHashes(level int, nodes []int) ([]uint64, error) {
+	if level < 0 || level > MerkleDepth {
+		return nil, fmt.Errorf("%w: level %d outside 0 to %d", helpers.InvalidParamError, level, MerkleDepth)
+	}
+	s.refreshMerkle()
+
+	hashes := make([]uint64, len(nodes))
+	for i, n := range nodes {
+		if n < 0 || n >= 1<<level {
+			return nil, fmt.Errorf("%w: node %d outside level %d", helpers.InvalidParamError, n, level)
+		}
+		hashes[i] = s.merkle.levels[level][n]
+	}
+	return hashes, nil
+}
+
+// MerkleItems returns every key in the given leaves of the Merkle tree with its metadata. Reading them
+// does not count as an access.
+func (s *KVStore) MerkleItems(leaves []int) (map[string]Item, error) {
+	want := make(map[int]bool, len(leaves))
+	for _, l := range leaves {
+		if l < 0 || l >= 1<<MerkleDepth {
+			return nil, fmt.Errorf("%w: leaf %d outside the tree", helpers.InvalidParamError, l)
+		}
+		want[l] = true
+	}
+
+	items := make(map[string]Item)
+	for k, v := range s.store {
+		if want[MerkleLeaf(k)] {
+			items[k] = Item{Value: v, Metadata: s.meta[k].snapshot()}
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func root(t *testing.T, s *KVStore) uint64 {
+	t.Helper()
+	h, err := s.MerkleHashes(0, []int{0})
+	if err != nil {
+		t.Fatalf("MerkleHashes() returned an error: %v", err)
+	}
+	return h[0]
+}
+
+func TestMerkleOrderIndependent(t *testing.T) {
+	a, b := NewKeyValueStore(), NewKeyValueStore()
+	for i := range 100 {
+		a.Add(fmt.Sprintf("key-%d", i), []byte(fmt.Sprint(i)))
+	}
+	for i := 99; i >= 0; i-- {
+		b.Add(fmt.Sprintf("key-%d", i), []byte(fmt.Sprint(i)))
+	}
+
+	if root(t, a) != root(t, b) {
+		t.Error("stores with the same keys added in another order have different roots")
+	}
+}
+
+func TestMerkleTracksChanges(t *testing.T) {
+	s := NewKeyValueStore()
+	empty := root(t, s)
+
+	s.Add("a", []byte(`1`))
+	one := root(t, s)
+	if one == empty {
+		t.Fatal("root unchanged after Add()")
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+		same   bool // Whether the root is back to one key holding 1
+	}{
+		{"update", func() { s.Update("a", []byte(`2`)) }, false},
+		{"update back", func() { s.Update("a", []byte(`1`)) }, true},
+		{"tag", func() { s.SetTags("a", map[string]string{"env": "prod"}) }, false},
+		{"untag", func() { s.RemoveTags("a", []string{"env"}) }, true},
+		{"read", func() { s.Get("a") }, true},
+		{"add and delete", func() { s.Add("b", []byte(`1`)); s.Delete("b") }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			if got := root(t, s) == one; got != tt.same {
+				t.Errorf("root equal to one key holding 1 = %v, want %v", got, tt.same)
+			}
+		})
+	}
+
+	s.Clear()
+	if root(t, s) != empty {
+		t.Error("root after Clear() differs from an empty store")
+	}
+}
+
+func TestMerkleDescend(t *testing.T) {
+	a, b := NewKeyValueStore(), NewKeyValueStore()
+	for i := range 200 {
+		key := fmt.Sprintf("key-%d", i)
+		a.Add(key, []byte(`1`))
+		b.Add(key, []byte(`1`))
+	}
+	b.Update("key-42", []byte(`2`))
+
+	// Walking down the differing nodes ends at the leaf holding the changed key
+	nodes := []int{0}
+	for level := range MerkleDepth + 1 {
+		ha, _ := a.MerkleHashes(level, nodes)
+		hb, _ := b.MerkleHashes(level, nodes)
+		var differing []int
+		for i, n := range nodes {
+			if ha[i] != hb[i] {
+				differing = append(differing, n)
+			}
+		}
+		if len(differing) != 1 {
+			t.Fatalf("level %d: %d differing nodes, want 1", level, len(differing))
+		}
+		if level == MerkleDepth {
+			nodes = differing
+			break
+		}
+		nodes = []int{2 * differing[0], 2*differing[0] + 1}
+	}
+
+	if want := MerkleLeaf("key-42"); nodes[0] != want {
+		t.Errorf("differing leaf = %d, want %d", nodes[0], want)
+	}
+	items, err := b.MerkleItems(nodes)
+	if err != nil {
+		t.Fatalf("MerkleItems() returned an error: %v", err)
+	}
+	if _, ok := items["key-42"]; !ok {
+		t.Errorf("MerkleItems() = %v, want key-42 among them", slices.Collect(maps.Keys(items)))
+	}
+}
+
+func TestMerkleInvalid(t *testing.T) {
+	s := NewKeyValueStore()
+	if _, err := s.MerkleHashes(MerkleDepth+1, []int{0}); err == nil {
+		t.Error("MerkleHashes() below the leaves returned no error")
+	}
+	if _, err := s.MerkleHashes(1, []int{2}); err == nil {
+		t.Error("MerkleHashes() past the end of a level returned no error")
+	}
+	if _, err := s.MerkleItems([]int{-1}); err == nil {
+		t.Error("MerkleItems() with a negative leaf returned no error")
+	}
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"kvstore/helpers"
+	"maps"
+	"sync/atomic"
+	"time"
+)
+
+// Metadata describes the history of a single key.
+type Metadata struct {
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	LastAccessedAt time.Time         `json:"last_accessed_at,omitzero"`
+	ReadCount      int64             `json:"read_count"`
+	WriteCount     int64             `json:"write_count"`
+	Size           int               `json:"size"` // Size of the JSON encoded value in bytes
+	Tags           map[string]string `json:"tags,omitempty"`
+	ExpiresAt      time.Time         `json:"expires_at,omitzero"` // When the key expires, zero if it does not, see expire.go
+	Flags          uint32            `json:"flags,omitempty"`     // Opaque to the store, kept for memcached clients, see write.go
+}
+
+// Item is a value together with its metadata.
+type Item struct {
+	Value    any      `json:"value"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// meta is the bookkeeping kept alongside each key. Write fields are only touched by the request loop,
+// while reads are counted atomically so that Read can record an access without exclusive access to the
+// store.
+type meta struct {
+	createdAt time.Time
+	updatedAt time.Time
+	writes    int64
+	size      int
+	tags      map[string]string
+	expiresAt time.Time
+	flags     uint32
+	epoch     uint64 // Snapshot epoch the write fields belong to, see snapshot.go
+
+	reads        atomic.Int64
+	lastAccessed atomic.Int64 // Unix nanoseconds, zero if never read
+}
+
+func (m *meta) snapshot() Metadata {
+	md := Metadata{
+		CreatedAt:  m.createdAt,
+		UpdatedAt:  m.updatedAt,
+		ReadCount:  m.reads.Load(),
+		WriteCount: m.writes,
+		Size:       m.size,
+		Tags:       maps.Clone(m.tags),
+		ExpiresAt:  m.expiresAt,
+		Flags:      m.flags,
+	}
+	if ns := m.lastAccessed.Load(); ns != 0 {
+		md.LastAccessedAt = time.Unix(0, ns).UTC()
+	}
+	return md
+}
+
+// touch records a read of the key.
+func (m *meta) touch(now time.Time) {
+	m.reads.Add(1)
+	m.lastAccessed.Store(now.UnixNano())
+}
+
+// Meta returns the metadata for key.
+func (s *KVStore) Meta(key string) (Metadata, error) {
+
+	if !s.live(key) {
+		return Metadata{}, helpers.NotExistError
+	}
+	return s.meta[key].snapshot(), nil
+}
+
+// GetWithMeta returns the value for key together with its metadata, counting it as a read.
+func (s *KVStore) GetWithMeta(key string) (Item, error) {
+
+	value, err := s.Get(key)
+	if err != nil {
+		return Item{}, err
+	}
+	return Item{Value: value, Metadata: s.meta[key].snapshot()}, nil
+}
+
+// GetAllWithMeta returns every value together with its metadata. It does not count as a read.
+func (s *KVStore) GetAllWithMeta() (map[string]Item, error) {
+
+	return s.Snapshot().Items(), nil
+}
+
+// put stores value under key and updates its metadata. size is the length of the encoded value.
+// Like a SET in Redis, a write clears any expiry and flags the key had.
+func (s *KVStore) put(key string, value any, size int) {
+	s.putWith(key, value, size, time.Time{}, 0)
+}
+
+// putWith is put, with the key set to expire at expiresAt, or never if it is zero, and given flags.
+func (s *KVStore) putWith(key string, value any, size int, expiresAt time.Time, flags uint32) {
+	now := s.now()
+
+	m, ok := s.writable(key)
+	if !ok {
+		m = &meta{createdAt: now, epoch: s.epoch}
+	}
+	m.updatedAt = now
+	m.writes++
+	m.size = size
+	m.flags = flags
+	s.setExpiry(m, expiresAt)
+
+	s.setKey(key, value, m)
+	s.changed(key)
+}
+
+// remove deletes key, its metadata and its tags, returning the metadata so it can be kept in the trash.
+func (s *KVStore) remove(key string) *meta {
+	m := s.meta[key]
+	if m != nil {
+		s.unindexTags(key, m.tags)
+	}
+	s.deleteKey(key)
+	s.bury(key)
+	s.changed(key)
+	return m
+}
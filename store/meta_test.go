@@ -0,0 +1,123 @@
+package store
+
+import (
+	"errors"
+	"kvstore/helpers"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	store := NewKeyValueStore()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	store.Add("Key", []byte(`"Value"`))
+	created := now
+
+	now = now.Add(time.Minute)
+	store.Update("Key", []byte(`"Longer Value"`))
+	updated := now
+
+	now = now.Add(time.Minute)
+	store.Get("Key")
+	store.Get("Key")
+	accessed := now
+
+	got, err := store.Meta("Key")
+	if err != nil {
+		t.Fatalf("Meta() returned an error: %v", err)
+	}
+
+	want := Metadata{
+		CreatedAt:      created,
+		UpdatedAt:      updated,
+		LastAccessedAt: accessed,
+		ReadCount:      2,
+		WriteCount:     2,
+		Size:           len(`"Longer Value"`),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Meta() = %+v, want %+v", got, want)
+	}
+
+	if _, err := store.Meta("NotExist"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Meta() error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestMetaLifecycle(t *testing.T) {
+	store := NewKeyValueStore()
+	store.InitData()
+	store.Get("TestString")
+
+	store.Delete("TestNumber")
+	if _, err := store.Meta("TestNumber"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Meta() after Delete() error = %v, want %v", err, helpers.NotExistError)
+	}
+
+	store.SoftDelete("TestString")
+	store.Restore("TestString")
+	if got, _ := store.Meta("TestString"); got.ReadCount != 1 || got.WriteCount != 1 {
+		t.Errorf("Meta() after Restore() = %+v, want metadata kept from before the delete", got)
+	}
+
+	store.Clear()
+	if got, _ := store.GetAllWithMeta(); len(got) != 0 {
+		t.Errorf("GetAllWithMeta() after Clear() = %v, want empty", got)
+	}
+}
+
+func TestGetWithMeta(t *testing.T) {
+	store := NewKeyValueStore()
+	store.InitData()
+
+	got, err := store.GetWithMeta("TestString")
+	if err != nil || got.Value != "Value1" || got.Metadata.ReadCount != 1 {
+		t.Errorf("GetWithMeta() = %+v, %v, want Value1 read once", got, err)
+	}
+
+	all, _ := store.GetAllWithMeta()
+	if len(all) != 3 || all["TestString"].Metadata.ReadCount != 1 {
+		t.Errorf("GetAllWithMeta() = %+v, want 3 items", all)
+	}
+}
+
+func TestReadAlongsideWrites(t *testing.T) {
+	store := NewKeyValueStore()
+	store.Add("Key", []byte(`1`))
+
+	// Reads race with writes, expiries and snapshots; run with -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 1000 {
+			store.Upsert("Key", []byte(`2`))
+			store.Upsert("Other", []byte(`1`))
+			store.Expire("Other", time.Hour)
+			if i%10 == 0 {
+				store.Snapshot()
+			}
+			store.Delete("Other")
+		}
+	}()
+	const reads = 1000
+	for range reads {
+		if _, err := store.Read("Key"); err != nil {
+			t.Fatalf("Read() returned an error: %v", err)
+		}
+	}
+	<-done
+
+	if md, _ := store.Meta("Key"); md.ReadCount != reads {
+		t.Errorf("ReadCount = %d, want %d", md.ReadCount, reads)
+	}
+
+	store.Expire("Key", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err := store.Read("Key"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Read() of an expired key = %v, want NotExistError", err)
+	}
+}
@@ -0,0 +1,352 @@
+package store
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"kvstore/helpers"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxDeliveries is how many failed deliveries a message survives before it is dead-lettered.
+	DefaultMaxDeliveries = 5
+
+	// DefaultVisibility is how long a dequeued message stays hidden when the client gives no timeout.
+	DefaultVisibility = 30 * time.Second
+
+	// DeadLetterSuffix is appended to a queue's name to form the name of its dead-letter queue.
+	DeadLetterSuffix = ".dlq"
+)
+
+// Message is an entry in a queue.
+type Message struct {
+	ID         string    `json:"id"`
+	Body       any       `json:"body"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	Deliveries int       `json:"deliveries"`        // Times the message has been handed out
+	Receipt    string    `json:"receipt,omitempty"` // Set on a dequeued message, needed to ack or nack it
+
+	seq       uint64
+	visibleAt time.Time // When an in-flight message goes back to the queue
+}
+
+// QueueStats describes the state of a queue.
+type QueueStats struct {
+	Name     string `json:"name"`
+	Depth    int    `json:"depth"`     // Messages waiting to be dequeued
+	InFlight int    `json:"in_flight"` // Messages dequeued but not yet acked
+	Dead     int    `json:"dead"`      // Messages in the dead-letter queue
+	Enqueued uint64 `json:"enqueued"`  // Messages ever enqueued
+	Acked    uint64 `json:"acked"`     // Messages ever acked
+}
+
+// queue is a FIFO of messages with the messages currently handed out to consumers.
+type queue struct {
+	ready    []*Message
+	inFlight map[string]*Message // By receipt
+	seq      uint64
+	enqueued uint64
+	acked    uint64
+}
+
+// Enqueue appends a JSON message to the named queue, creating the queue if needed.
+func (s *KVStore) Enqueue(name string, v []byte) (Message, error) {
+
+	if name == "" {
+		return Message{}, helpers.MissingKeyError
+	}
+
+	// Parse the value from JSON
+	body, err := helpers.ParseJSON(v)
+	if err != nil {
+		return Message{}, err // Return early if parsing fails
+	}
+
+	q := s.queue(name)
+	q.seq++
+	q.enqueued++
+	msg := &Message{ID: strconv.FormatUint(q.seq, 10), Body: body, EnqueuedAt: s.now(), seq: q.seq}
+	q.ready = append(q.ready, msg)
+	s.queueChanged(name)
+
+	return *msg, nil
+}
+
+// Dequeue hands out the oldest message of a queue. The message is hidden from other consumers until
+// it is acked, nacked or the visibility timeout passes, after which it is delivered again.
+func (s *KVStore) Dequeue(name string, visibility time.Duration) (Message, error) {
+
+	if visibility <= 0 {
+		return Message{}, fmt.Errorf("%w: visibility timeout must be positive", helpers.InvalidParamError)
+	}
+
+	q, ok := s.queues[name]
+	if !ok {
+		return Message{}, helpers.QueueEmptyError
+	}
+	s.requeueExpired(name, q)
+
+	if len(q.ready) == 0 {
+		return Message{}, helpers.QueueEmptyError
+	}
+
+	msg := q.ready[0]
+	q.ready[0] = nil
+	q.ready = q.ready[1:]
+
+	msg.Deliveries++
+	msg.Receipt = s.receipt(name, msg)
+	msg.visibleAt = s.now().Add(visibility)
+	q.inFlight[msg.Receipt] = msg
+	s.queueChanged(name)
+
+	return *msg, nil
+}
+
+// Ack removes a delivered message from its queue for good.
+func (s *KVStore) Ack(name, receipt string) error {
+
+	q, msg, err := s.inFlight(name, receipt)
+	if err != nil {
+		return err
+	}
+
+	delete(q.inFlight, msg.Receipt)
+	q.acked++
+	s.queueChanged(name)
+	return nil
+}
+
+// Nack returns a delivered message to the front of its queue, or to the dead-letter queue once it has
+// failed MaxDeliveries times.
+func (s *KVStore) Nack(name, receipt string) error {
+
+	q, msg, err := s.inFlight(name, receipt)
+	if err != nil {
+		return err
+	}
+
+	delete(q.inFlight, msg.Receipt)
+	s.redeliver(name, q, []*Message{msg})
+	s.queueChanged(name)
+	return nil
+}
+
+// QueueStats returns the depth and in-flight count of a queue.
+func (s *KVStore) QueueStats(name string) (QueueStats, error) {
+
+	q, ok := s.queues[name]
+	if !ok {
+		return QueueStats{}, helpers.NotExistError
+	}
+	s.requeueExpired(name, q)
+
+	return s.queueStats(name, q), nil
+}
+
+// AllQueueStats returns the stats of every queue, sorted by name.
+func (s *KVStore) AllQueueStats() ([]QueueStats, error) {
+
+	stats := make([]QueueStats, 0, len(s.queues))
+	for name, q := range s.queues {
+		s.requeueExpired(name, q)
+		stats = append(stats, s.queueStats(name, q))
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats, nil
+}
+
+func (s *KVStore) queueStats(name string, q *queue) QueueStats {
+	stats := QueueStats{
+		Name:     name,
+		Depth:    len(q.ready),
+		InFlight: len(q.inFlight),
+		Enqueued: q.enqueued,
+		Acked:    q.acked,
+	}
+	if dlq, ok := s.queues[name+DeadLetterSuffix]; ok {
+		stats.Dead = len(dlq.ready) + len(dlq.inFlight)
+	}
+	return stats
+}
+
+// queue returns the named queue, creating it if needed.
+func (s *KVStore) queue(name string) *queue {
+	q, ok := s.queues[name]
+	if !ok {
+		q = &queue{inFlight: make(map[string]*Message)}
+		s.queues[name] = q
+	}
+	return q
+}
+
+// inFlight returns a delivered message by its receipt. Receipts stop working once the visibility
+// timeout has passed, because the message may already have gone to another consumer.
+func (s *KVStore) inFlight(name, receipt string) (*queue, *Message, error) {
+	q, ok := s.queues[name]
+	if !ok {
+		return nil, nil, helpers.NotExistError
+	}
+	s.requeueExpired(name, q)
+
+	msg, ok := q.inFlight[receipt]
+	if !ok {
+		return nil, nil, helpers.InvalidReceiptError
+	}
+	return q, msg, nil
+}
+
+// requeueExpired returns in-flight messages whose visibility timeout has passed to the queue.
+func (s *KVStore) requeueExpired(name string, q *queue) {
+	now := s.now()
+
+	var expired []*Message
+	for receipt, msg := range q.inFlight {
+		if !now.Before(msg.visibleAt) {
+			expired = append(expired, msg)
+			delete(q.inFlight, receipt)
+		}
+	}
+	if len(expired) > 0 {
+		s.redeliver(name, q, expired)
+		s.queueChanged(name)
+	}
+}
+
+// redeliver puts failed messages back at the front of the queue in their original order, moving any
+// that have used up their deliveries to the dead-letter queue. Messages failing in a dead-letter queue
+// stay there.
+func (s *KVStore) redeliver(name string, q *queue, failed []*Message) {
+	slices.SortFunc(failed, func(a, b *Message) int { return cmp.Compare(a.seq, b.seq) })
+
+	var retry []*Message
+	dead := false
+	for _, msg := range failed {
+		msg.Receipt = ""
+		if s.MaxDeliveries > 0 && msg.Deliveries >= s.MaxDeliveries && !strings.HasSuffix(name, DeadLetterSuffix) {
+			dlq := s.queue(name + DeadLetterSuffix)
+			dlq.seq++
+			dlq.enqueued++
+			msg.seq = dlq.seq
+			msg.Deliveries = 0 // Counted afresh by the dead-letter queue
+			dlq.ready = append(dlq.ready, msg)
+			dead = true
+			continue
+		}
+		retry = append(retry, msg)
+	}
+
+	q.ready = append(retry, q.ready...)
+	if dead {
+		s.queueChanged(name + DeadLetterSuffix)
+	}
+}
+
+// receipt returns the receipt of a message handed out from the named queue. Receipts are keyed with a
+// secret of the store, so that only the consumer the message went to can ack or nack it.
+func (s *KVStore) receipt(name string, msg *Message) string {
+	if len(s.receiptKey) == 0 {
+		s.SeedReceipts(rand.Text())
+	}
+	mac := hmac.New(sha256.New, s.receiptKey)
+	fmt.Fprintf(mac, "%s\x00%s\x00%d", name, msg.ID, msg.Deliveries)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// SeedReceipts sets the secret receipts are keyed with, unless the store already has one. The store
+// makes up its own when it first hands out a message; members of a cluster are given the same one
+// instead, so that they hand out the same receipts.
+func (s *KVStore) SeedReceipts(key string) {
+	if len(s.receiptKey) == 0 && key != "" {
+		s.receiptKey = []byte(key)
+	}
+}
+
+// requeueAllExpired runs requeueExpired over every queue.
+func (s *KVStore) requeueAllExpired() {
+	for name, q := range s.queues {
+		s.requeueExpired(name, q)
+	}
+}
+
+// QueueState is a queue as kept in State, with the messages waiting in it and those handed out.
+type QueueState struct {
+	Name     string         `json:"name"`
+	Ready    []MessageState `json:"ready,omitempty"`
+	InFlight []MessageState `json:"in_flight,omitempty"` // Ordered by sequence
+	Seq      uint64         `json:"seq"`
+	Enqueued uint64         `json:"enqueued"`
+	Acked    uint64         `json:"acked"`
+}
+
+// MessageState is a message together with its place in the queue and, once handed out, when it goes back.
+type MessageState struct {
+	Message
+	Seq       uint64    `json:"seq"`
+	VisibleAt time.Time `json:"visible_at,omitzero"`
+}
+
+func messageState(msg *Message) MessageState {
+	return MessageState{Message: *msg, Seq: msg.seq, VisibleAt: msg.visibleAt}
+}
+
+func (ms MessageState) message() *Message {
+	msg := ms.Message
+	msg.seq, msg.visibleAt = ms.Seq, ms.VisibleAt
+	return &msg
+}
+
+// queueStates returns a copy of every queue, sorted by name.
+func (s *KVStore) queueStates() []QueueState {
+	states := make([]QueueState, 0, len(s.queues))
+	for _, name := range slices.Sorted(maps.Keys(s.queues)) {
+		states = append(states, queueState(name, s.queues[name]))
+	}
+	return states
+}
+
+func queueState(name string, q *queue) QueueState {
+	qs := QueueState{Name: name, Seq: q.seq, Enqueued: q.enqueued, Acked: q.acked}
+	for _, msg := range q.ready {
+		qs.Ready = append(qs.Ready, messageState(msg))
+	}
+	for _, msg := range q.inFlight {
+		qs.InFlight = append(qs.InFlight, messageState(msg))
+	}
+	slices.SortFunc(qs.InFlight, func(a, b MessageState) int { return cmp.Compare(a.Seq, b.Seq) })
+	return qs
+}
+
+// loadQueues replaces the queues of the store with those of states.
+func (s *KVStore) loadQueues(states []QueueState) {
+	clear(s.queues)
+	for _, qs := range states {
+		s.loadQueue(qs)
+	}
+}
+
+// loadQueue adds the queue qs to the store.
+func (s *KVStore) loadQueue(qs QueueState) {
+	s.queues[qs.Name] = newQueue(qs)
+}
+
+func newQueue(qs QueueState) *queue {
+	q := &queue{inFlight: make(map[string]*Message, len(qs.InFlight)), seq: qs.Seq, enqueued: qs.Enqueued, acked: qs.Acked}
+	for _, ms := range qs.Ready {
+		q.ready = append(q.ready, ms.message())
+	}
+	for _, ms := range qs.InFlight {
+		q.inFlight[ms.Receipt] = ms.message()
+	}
+	return q
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"errors"
+	"kvstore/helpers"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueFIFO(t *testing.T) {
+	store := NewKeyValueStore()
+
+	for _, v := range []string{`"first"`, `"second"`, `"third"`} {
+		if _, err := store.Enqueue("jobs", []byte(v)); err != nil {
+			t.Fatalf("Enqueue() returned an error: %v", err)
+		}
+	}
+
+	for _, want := range []string{"first", "second", "third"} {
+		msg, err := store.Dequeue("jobs", time.Minute)
+		if err != nil || msg.Body != want {
+			t.Fatalf("Dequeue() = %v, %v, want %v", msg.Body, err, want)
+		}
+		if err := store.Ack("jobs", msg.Receipt); err != nil {
+			t.Errorf("Ack() returned an error: %v", err)
+		}
+	}
+
+	if _, err := store.Dequeue("jobs", time.Minute); !errors.Is(err, helpers.QueueEmptyError) {
+		t.Errorf("Dequeue() on an empty queue error = %v, want %v", err, helpers.QueueEmptyError)
+	}
+
+	stats, _ := store.QueueStats("jobs")
+	if stats.Enqueued != 3 || stats.Acked != 3 || stats.Depth != 0 || stats.InFlight != 0 {
+		t.Errorf("QueueStats() = %+v, want 3 enqueued and acked", stats)
+	}
+}
+
+func TestQueueVisibilityTimeout(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	store.Enqueue("jobs", []byte(`"first"`))
+	store.Enqueue("jobs", []byte(`"second"`))
+
+	first, _ := store.Dequeue("jobs", time.Minute)
+	if stats, _ := store.QueueStats("jobs"); stats.Depth != 1 || stats.InFlight != 1 {
+		t.Errorf("QueueStats() = %+v, want depth 1 and 1 in flight", stats)
+	}
+
+	// After the timeout the message is delivered again, ahead of newer messages
+	now = now.Add(2 * time.Minute)
+	again, err := store.Dequeue("jobs", time.Minute)
+	if err != nil || again.ID != first.ID || again.Deliveries != 2 {
+		t.Errorf("Dequeue() after timeout = %+v, %v, want message %s delivered twice", again, err, first.ID)
+	}
+
+	// The first receipt no longer works
+	if err := store.Ack("jobs", first.Receipt); !errors.Is(err, helpers.InvalidReceiptError) {
+		t.Errorf("Ack() with a stale receipt error = %v, want %v", err, helpers.InvalidReceiptError)
+	}
+	if err := store.Ack("jobs", again.Receipt); err != nil {
+		t.Errorf("Ack() returned an error: %v", err)
+	}
+}
+
+func TestQueueDeadLetter(t *testing.T) {
+	store := NewKeyValueStore()
+	store.MaxDeliveries = 2
+
+	store.Enqueue("jobs", []byte(`"poison"`))
+
+	for range 2 {
+		msg, err := store.Dequeue("jobs", time.Minute)
+		if err != nil {
+			t.Fatalf("Dequeue() returned an error: %v", err)
+		}
+		if err := store.Nack("jobs", msg.Receipt); err != nil {
+			t.Fatalf("Nack() returned an error: %v", err)
+		}
+	}
+
+	if _, err := store.Dequeue("jobs", time.Minute); !errors.Is(err, helpers.QueueEmptyError) {
+		t.Errorf("Dequeue() after dead-lettering error = %v, want %v", err, helpers.QueueEmptyError)
+	}
+	if stats, _ := store.QueueStats("jobs"); stats.Dead != 1 {
+		t.Errorf("QueueStats() = %+v, want 1 dead", stats)
+	}
+
+	// Deliveries are counted afresh in the dead-letter queue, which keeps its messages however often they fail
+	for i := range 3 {
+		dead, err := store.Dequeue("jobs"+DeadLetterSuffix, time.Minute)
+		if err != nil || dead.Body != "poison" || dead.Deliveries != i+1 {
+			t.Fatalf("Dequeue() from the dead-letter queue = %+v, %v, want poison delivered %d times", dead, err, i+1)
+		}
+		store.Nack("jobs"+DeadLetterSuffix, dead.Receipt)
+	}
+	if _, err := store.QueueStats("jobs" + DeadLetterSuffix + DeadLetterSuffix); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("QueueStats() of a dead-letter queue of the dead-letter queue error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestQueueReceipts(t *testing.T) {
+	a, b := NewKeyValueStore(), NewKeyValueStore()
+	a.SeedReceipts("secret")
+	b.SeedReceipts("secret")
+
+	a.Enqueue("jobs", []byte(`1`))
+	b.Enqueue("jobs", []byte(`1`))
+	msgA, _ := a.Dequeue("jobs", time.Minute)
+	msgB, _ := b.Dequeue("jobs", time.Minute)
+	if msgA.Receipt != msgB.Receipt {
+		t.Errorf("receipts with the same secret = %s and %s, want the same", msgA.Receipt, msgB.Receipt)
+	}
+	if strings.HasPrefix(msgA.Receipt, msgA.ID+"-") {
+		t.Errorf("receipt %s is made from the message ID", msgA.Receipt)
+	}
+
+	// A store makes up its own secret, which it keeps in its state
+	c := NewKeyValueStore()
+	c.Enqueue("jobs", []byte(`1`))
+	msgC, _ := c.Dequeue("jobs", time.Minute)
+	if msgC.Receipt == msgA.Receipt {
+		t.Errorf("receipt without a secret set = %s, the same as with the secret", msgC.Receipt)
+	}
+	st := c.State()
+	if st.ReceiptKey == "" {
+		t.Fatalf("State() has no receipt secret")
+	}
+	d := NewKeyValueStore()
+	d.LoadState(st)
+	d.SeedReceipts("other")
+	if err := d.Nack("jobs", msgC.Receipt); err != nil {
+		t.Fatalf("Nack() after LoadState() = %v", err)
+	}
+	c.Nack("jobs", msgC.Receipt)
+	again, _ := c.Dequeue("jobs", time.Minute)
+	if loaded, _ := d.Dequeue("jobs", time.Minute); loaded.Receipt != again.Receipt {
+		t.Errorf("receipt after LoadState() = %s, want %s", loaded.Receipt, again.Receipt)
+	}
+}
+
+func TestQueueErrors(t *testing.T) {
+	store := NewKeyValueStore()
+
+	if _, err := store.Enqueue("", []byte(`1`)); !errors.Is(err, helpers.MissingKeyError) {
+		t.Errorf("Enqueue() without a name error = %v, want %v", err, helpers.MissingKeyError)
+	}
+	if _, err := store.Enqueue("jobs", []byte(`{`)); err == nil {
+		t.Errorf("Enqueue() with invalid JSON error = nil, want an error")
+	}
+	if _, err := store.Dequeue("jobs", 0); !errors.Is(err, helpers.InvalidParamError) {
+		t.Errorf("Dequeue() without visibility error = %v, want %v", err, helpers.InvalidParamError)
+	}
+	if err := store.Ack("missing", "1-1"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Ack() on a missing queue error = %v, want %v", err, helpers.NotExistError)
+	}
+}
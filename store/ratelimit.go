@@ -0,0 +1,258 @@
+package store
+
+import (
+	"fmt"
+	"kvstore/helpers"
+	"maps"
+	"math"
+	"slices"
+	"time"
+)
+
+// RateAlgorithm picks how a rate limit counts requests.
+type RateAlgorithm string
+
+const (
+	// TokenBucket allows bursts of up to Capacity requests and refills at Rate tokens per second.
+	TokenBucket RateAlgorithm = "token_bucket"
+
+	// SlidingWindow allows Capacity requests in any window of Capacity/Rate seconds. It weighs the count
+	// of the previous fixed window by how much of it still overlaps the sliding one.
+	SlidingWindow RateAlgorithm = "sliding_window"
+)
+
+// MaxRateWindow is the longest a rate limit may take to refill from empty, Capacity/Rate seconds. It keeps
+// the waits worked out from a limit within what a time.Duration holds.
+const MaxRateWindow = 365 * 24 * time.Hour
+
+// ParseRateAlgorithm returns the algorithm named s, defaulting to TokenBucket.
+func ParseRateAlgorithm(s string) (RateAlgorithm, error) {
+	switch RateAlgorithm(s) {
+	case "", TokenBucket:
+		return TokenBucket, nil
+	case SlidingWindow:
+		return SlidingWindow, nil
+	}
+	return "", fmt.Errorf("%w: unknown rate limit algorithm %q", helpers.InvalidParamError, s)
+}
+
+// RateLimit describes a limit of Capacity requests refilled at Rate per second.
+type RateLimit struct {
+	Algorithm RateAlgorithm
+	Capacity  int
+	Rate      float64 // Tokens per second
+	Cost      int     // Tokens taken by this request, 1 if zero
+}
+
+// RateLimitResult is the decision on a single request.
+type RateLimitResult struct {
+	Allowed      bool  `json:"allowed"`
+	Limit        int   `json:"limit"`
+	Remaining    int   `json:"remaining"`      // Requests that would still be allowed right now
+	RetryAfterMs int64 `json:"retry_after_ms"` // How long until a denied request would be allowed
+}
+
+// rateState is the state of one limited key. It is dropped once it has no effect any more, at which point
+// a fresh state behaves the same.
+type rateState struct {
+	limit     RateLimit
+	expiresAt time.Time
+
+	// Token bucket
+	tokens float64
+	last   time.Time
+
+	// Sliding window
+	windowStart time.Time
+	prev, curr  float64
+}
+
+// AllowRate takes limit.Cost tokens from the rate limit of key and reports whether the request is allowed.
+// The check and the update happen in one step, so concurrent callers can never overspend a limit.
+// Changing the algorithm, capacity or rate of a key starts it afresh.
+func (s *KVStore) AllowRate(key string, limit RateLimit) (RateLimitResult, error) {
+
+	if key == "" {
+		return RateLimitResult{}, helpers.MissingKeyError
+	}
+	if limit.Algorithm == "" {
+		limit.Algorithm = TokenBucket
+	}
+	if limit.Cost == 0 {
+		limit.Cost = 1
+	}
+	if err := validateRateLimit(limit); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	now := s.now()
+	st, ok := s.rateLimits[key]
+	if !ok || !now.Before(st.expiresAt) || st.limit.Algorithm != limit.Algorithm ||
+		st.limit.Capacity != limit.Capacity || st.limit.Rate != limit.Rate {
+		st = &rateState{limit: limit, tokens: float64(limit.Capacity), last: now}
+		s.rateLimits[key] = st
+	}
+
+	var res RateLimitResult
+	if limit.Algorithm == SlidingWindow {
+		res = st.slidingWindow(now, limit.Cost)
+	} else {
+		res = st.tokenBucket(now, limit.Cost)
+	}
+	res.Limit = limit.Capacity
+	s.rateLimitChanged(key)
+
+	return res, nil
+}
+
+func (st *rateState) tokenBucket(now time.Time, cost int) RateLimitResult {
+	capacity, rate := float64(st.limit.Capacity), st.limit.Rate
+
+	st.tokens = min(capacity, st.tokens+now.Sub(st.last).Seconds()*rate)
+	st.last = now
+
+	var res RateLimitResult
+	if st.tokens >= float64(cost) {
+		st.tokens -= float64(cost)
+		res.Allowed = true
+	} else {
+		res.RetryAfterMs = secondsToMs((float64(cost) - st.tokens) / rate)
+	}
+	res.Remaining = int(st.tokens)
+
+	// Once the bucket is full again the state is no different from a new one
+	st.expiresAt = now.Add(time.Duration((capacity - st.tokens) / rate * float64(time.Second)))
+	return res
+}
+
+func (st *rateState) slidingWindow(now time.Time, cost int) RateLimitResult {
+	capacity := float64(st.limit.Capacity)
+	window := max(time.Millisecond, time.Duration(capacity/st.limit.Rate*float64(time.Second)))
+
+	// Move to the fixed window containing now
+	start := now.Truncate(window)
+	if !start.Equal(st.windowStart) {
+		if start.Sub(st.windowStart) == window {
+			st.prev = st.curr
+		} else {
+			st.prev = 0
+		}
+		st.curr = 0
+		st.windowStart = start
+	}
+
+	elapsed := now.Sub(start).Seconds() / window.Seconds()
+	used := st.prev*(1-elapsed) + st.curr
+
+	var res RateLimitResult
+	if used+float64(cost) <= capacity {
+		st.curr += float64(cost)
+		used += float64(cost)
+		res.Allowed = true
+	} else {
+		res.RetryAfterMs = secondsToMs(st.retryAfter(now, window, float64(cost)))
+	}
+	res.Remaining = max(0, int(capacity-used))
+
+	// After two more windows nothing is left of the current count
+	st.expiresAt = start.Add(2 * window)
+	return res
+}
+
+// retryAfter returns the seconds until a request of cost fits in the sliding window.
+func (st *rateState) retryAfter(now time.Time, window time.Duration, cost float64) float64 {
+	free := float64(st.limit.Capacity) - cost
+	w := window.Seconds()
+
+	// Wait for enough of the previous window to slide out
+	if room := free - st.curr; room >= 0 && st.prev > 0 {
+		at := st.windowStart.Add(time.Duration(w * (1 - room/st.prev) * float64(time.Second)))
+		return max(0, at.Sub(now).Seconds())
+	}
+
+	// Or for the next window, once enough of the current one has slid out
+	at := st.windowStart.Add(window)
+	if st.curr > 0 {
+		at = at.Add(time.Duration(w * (1 - free/st.curr) * float64(time.Second)))
+	}
+	return max(0, at.Sub(now).Seconds())
+}
+
+// purgeExpiredRateLimits drops rate state that no longer limits anything.
+func (s *KVStore) purgeExpiredRateLimits() {
+	now := s.now()
+	for key, st := range s.rateLimits {
+		if !now.Before(st.expiresAt) {
+			delete(s.rateLimits, key)
+			s.rateLimitChanged(key)
+		}
+	}
+}
+
+func validateRateLimit(limit RateLimit) error {
+	if limit.Capacity <= 0 {
+		return fmt.Errorf("%w: capacity must be positive", helpers.InvalidParamError)
+	}
+	if limit.Rate <= 0 || math.IsInf(limit.Rate, 0) || math.IsNaN(limit.Rate) {
+		return fmt.Errorf("%w: rate must be positive", helpers.InvalidParamError)
+	}
+	if float64(limit.Capacity)/limit.Rate > MaxRateWindow.Seconds() {
+		return fmt.Errorf("%w: rate must refill the capacity within %s", helpers.InvalidParamError, MaxRateWindow)
+	}
+	if limit.Cost < 0 || limit.Cost > limit.Capacity {
+		return fmt.Errorf("%w: cost must be between 1 and the capacity", helpers.InvalidParamError)
+	}
+	if _, err := ParseRateAlgorithm(string(limit.Algorithm)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// secondsToMs rounds seconds up to whole milliseconds, so a client waiting that long is never early.
+func secondsToMs(seconds float64) int64 {
+	return int64(math.Ceil(seconds * 1000))
+}
+
+// RateLimitState is the state of the rate limit of a key as kept in State.
+type RateLimitState struct {
+	Key         string    `json:"key"`
+	Limit       RateLimit `json:"limit"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Tokens      float64   `json:"tokens,omitempty"`
+	Last        time.Time `json:"last,omitzero"`
+	WindowStart time.Time `json:"window_start,omitzero"`
+	Prev        float64   `json:"prev,omitempty"`
+	Curr        float64   `json:"curr,omitempty"`
+}
+
+// rateLimitStates returns a copy of the rate limit state of every key, sorted by key.
+func (s *KVStore) rateLimitStates() []RateLimitState {
+	states := make([]RateLimitState, 0, len(s.rateLimits))
+	for _, key := range slices.Sorted(maps.Keys(s.rateLimits)) {
+		states = append(states, rateLimitState(key, s.rateLimits[key]))
+	}
+	return states
+}
+
+func rateLimitState(key string, st *rateState) RateLimitState {
+	return RateLimitState{
+		Key: key, Limit: st.limit, ExpiresAt: st.expiresAt, Tokens: st.tokens, Last: st.last,
+		WindowStart: st.windowStart, Prev: st.prev, Curr: st.curr,
+	}
+}
+
+// loadRateLimits replaces the rate limit state of the store with states.
+func (s *KVStore) loadRateLimits(states []RateLimitState) {
+	clear(s.rateLimits)
+	for _, rs := range states {
+		s.loadRateLimit(rs)
+	}
+}
+
+// loadRateLimit adds the rate limit state rs to the store.
+func (s *KVStore) loadRateLimit(rs RateLimitState) {
+	s.rateLimits[rs.Key] = &rateState{
+		limit: rs.Limit, expiresAt: rs.ExpiresAt, tokens: rs.Tokens, last: rs.Last,
+		windowStart: rs.WindowStart, prev: rs.Prev, curr: rs.Curr,
+	}
+}
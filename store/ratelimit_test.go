@@ -0,0 +1,115 @@
+package store
+
+import (
+	"errors"
+	"kvstore/helpers"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Algorithm: TokenBucket, Capacity: 3, Rate: 1}
+
+	// The full bucket allows a burst of capacity requests
+	for i := range 3 {
+		res, _ := store.AllowRate("api", limit)
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("AllowRate() #%d = %+v, want allowed with %d remaining", i, res, 2-i)
+		}
+	}
+
+	res, _ := store.AllowRate("api", limit)
+	if res.Allowed || res.RetryAfterMs != 1000 {
+		t.Errorf("AllowRate() on an empty bucket = %+v, want denied with retry after 1000ms", res)
+	}
+
+	// Tokens come back at the refill rate
+	now = now.Add(1500 * time.Millisecond)
+	if res, _ := store.AllowRate("api", limit); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("AllowRate() after refill = %+v, want allowed with 0 remaining", res)
+	}
+
+	// Other keys have their own bucket
+	if res, _ := store.AllowRate("other", limit); !res.Allowed {
+		t.Errorf("AllowRate() on another key = %+v, want allowed", res)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Algorithm: SlidingWindow, Capacity: 4, Rate: 1} // 4 requests per 4s
+
+	for range 4 {
+		if res, _ := store.AllowRate("api", limit); !res.Allowed {
+			t.Fatalf("AllowRate() within the limit = %+v, want allowed", res)
+		}
+	}
+	res, _ := store.AllowRate("api", limit)
+	if res.Allowed || res.RetryAfterMs != 5000 {
+		t.Fatalf("AllowRate() over the limit = %+v, want denied with retry after 5000ms", res)
+	}
+
+	// Half way into the next window half of the previous one still counts
+	now = now.Add(6 * time.Second)
+	for i := range 2 {
+		if res, _ := store.AllowRate("api", limit); !res.Allowed {
+			t.Fatalf("AllowRate() #%d in the next window = %+v, want allowed", i, res)
+		}
+	}
+	if res, _ := store.AllowRate("api", limit); res.Allowed || res.RetryAfterMs != 1000 {
+		t.Errorf("AllowRate() over the sliding limit = %+v, want denied with retry after 1000ms", res)
+	}
+}
+
+func TestRateLimitExpiry(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	store.AllowRate("bucket", RateLimit{Capacity: 10, Rate: 1})
+	store.AllowRate("window", RateLimit{Algorithm: SlidingWindow, Capacity: 10, Rate: 1})
+
+	now = now.Add(time.Second)
+	store.Sweep()
+	if _, ok := store.rateLimits["window"]; !ok || len(store.rateLimits) != 1 {
+		t.Errorf("rate state after 1s = %v, want only the sliding window", store.rateLimits)
+	}
+
+	now = now.Add(time.Minute)
+	store.Sweep()
+	if len(store.rateLimits) != 0 {
+		t.Errorf("rate state after a minute = %v, want none", store.rateLimits)
+	}
+}
+
+func TestRateLimitErrors(t *testing.T) {
+	store := NewKeyValueStore()
+
+	tests := []struct {
+		description string
+		key         string
+		limit       RateLimit
+		wantErr     error
+	}{
+		{"TestMissingKey", "", RateLimit{Capacity: 1, Rate: 1}, helpers.MissingKeyError},
+		{"TestNoCapacity", "api", RateLimit{Rate: 1}, helpers.InvalidParamError},
+		{"TestNoRate", "api", RateLimit{Capacity: 1}, helpers.InvalidParamError},
+		{"TestTinyRate", "api", RateLimit{Capacity: 1, Rate: 1e-12}, helpers.InvalidParamError},
+		{"TestTinyRateSlidingWindow", "api", RateLimit{Algorithm: SlidingWindow, Capacity: 1, Rate: 1e-12}, helpers.InvalidParamError},
+		{"TestCostOverCapacity", "api", RateLimit{Capacity: 1, Rate: 1, Cost: 2}, helpers.InvalidParamError},
+		{"TestUnknownAlgorithm", "api", RateLimit{Algorithm: "leaky", Capacity: 1, Rate: 1}, helpers.InvalidParamError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			if _, err := store.AllowRate(tt.key, tt.limit); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AllowRate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"fmt"
+	"kvstore/helpers"
+)
+
+// ChangeOp is the kind of a Change.
+type ChangeOp string
+
+const (
+	ChangeSet    ChangeOp = "set"    // The key now holds Item
+	ChangeDelete ChangeOp = "delete" // The key is gone
+	ChangeClear  ChangeOp = "clear"  // Every key is gone
+
+	// The state outside the key space, with Key naming the trashed key, lease, queue, stream or rate
+	// limited key. A nil state means it is gone.
+	ChangeTrash     ChangeOp = "trash"      // The deleted versions of the key are Trash
+	ChangeLease     ChangeOp = "lease"      // The lease is now Lease
+	ChangeQueue     ChangeOp = "queue"      // The queue is now Queue
+	ChangeStream    ChangeOp = "stream"     // The stream and its groups are now Stream
+	ChangeRateLimit ChangeOp = "rate_limit" // The rate limit of the key is now RateLimit
+)
+
+// Change is the state of a key after a mutation. Changes carry the full value and metadata rather than
+// the operation that caused them, so applying one is idempotent and does not depend on the clock.
+// Likewise a change to a queue or stream carries the whole queue or stream.
+type Change struct {
+	Op   ChangeOp `json:"op"`
+	Key  string   `json:"key,omitempty"`
+	Item *Item    `json:"item,omitempty"`
+
+	Trash      []TrashState    `json:"trash,omitempty"`
+	ClearSeq   uint64          `json:"clear_seq,omitempty"` // Sent with ChangeTrash
+	Lease      *Lease          `json:"lease,omitempty"`
+	LeaseSeq   uint64          `json:"lease_seq,omitempty"` // Sent with ChangeLease
+	Queue      *QueueState     `json:"queue,omitempty"`
+	ReceiptKey string          `json:"receipt_key,omitempty"` // Sent with ChangeQueue
+	Stream     *StreamState    `json:"stream,omitempty"`
+	RateLimit  *RateLimitState `json:"rate_limit,omitempty"`
+}
+
+// changed reports the current state of key to OnChange and marks it for the Merkle tree.
+func (s *KVStore) changed(key string) {
+	s.version++
+	s.merkle.dirty[key] = struct{}{}
+	if s.OnChange == nil {
+		return
+	}
+
+	m, ok := s.meta[key]
+	if !ok {
+		s.OnChange(Change{Op: ChangeDelete, Key: key})
+		return
+	}
+	s.OnChange(Change{Op: ChangeSet, Key: key, Item: &Item{Value: s.store[key], Metadata: m.snapshot()}})
+}
+
+// trashChanged reports the deleted versions of key to OnChange.
+func (s *KVStore) trashChanged(key string) {
+	if s.OnChange != nil {
+		s.OnChange(Change{Op: ChangeTrash, Key: key, Trash: s.trashStates(key), ClearSeq: s.clearSeq})
+	}
+}
+
+// leaseChanged reports the current state of a lease to OnChange.
+func (s *KVStore) leaseChanged(name string) {
+	if s.OnChange == nil {
+		return
+	}
+	c := Change{Op: ChangeLease, Key: name, LeaseSeq: s.leaseSeq}
+	if l, ok := s.leases[name]; ok {
+		lease := *l
+		c.Lease = &lease
+	}
+	s.OnChange(c)
+}
+
+// queueChanged reports the current state of a queue to OnChange.
+func (s *KVStore) queueChanged(name string) {
+	if s.OnChange == nil {
+		return
+	}
+	c := Change{Op: ChangeQueue, Key: name, ReceiptKey: string(s.receiptKey)}
+	if q, ok := s.queues[name]; ok {
+		qs := queueState(name, q)
+		c.Queue = &qs
+	}
+	s.OnChange(c)
+}
+
+// streamChanged reports the current state of a stream to OnChange.
+func (s *KVStore) streamChanged(name string) {
+	if s.OnChange == nil {
+		return
+	}
+	c := Change{Op: ChangeStream, Key: name}
+	if st, ok := s.streams[name]; ok {
+		ss := streamState(name, st)
+		c.Stream = &ss
+	}
+	s.OnChange(c)
+}
+
+// rateLimitChanged reports the current rate limit state of key to OnChange.
+func (s *KVStore) rateLimitChanged(key string) {
+	if s.OnChange == nil {
+		return
+	}
+	c := Change{Op: ChangeRateLimit, Key: key}
+	if st, ok := s.rateLimits[key]; ok {
+		rs := rateLimitState(key, st)
+		c.RateLimit = &rs
+	}
+	s.OnChange(c)
+}
+
+// SnapshotChanges returns the whole key space as set changes, sorted by key. Applied to an empty
+// store they reproduce this one.
+func (s *KVStore) SnapshotChanges() []Change {
+
+	return s.Snapshot().Changes()
+}
+
+// ApplyChange applies a change made on another store, keeping its metadata as it was there.
+func (s *KVStore) ApplyChange(c Change) error {
+
+	if c.Op != ChangeClear && c.Key == "" {
+		return helpers.MissingKeyError
+	}
+
+	switch c.Op {
+	case ChangeClear:
+		s.Clear()
+		return nil
+	case ChangeTrash:
+		s.loadTrash(c.Key, c.Trash)
+		s.clearSeq = c.ClearSeq
+		s.trashChanged(c.Key)
+		return nil
+	case ChangeLease:
+		delete(s.leases, c.Key)
+		if c.Lease != nil {
+			s.loadLease(*c.Lease)
+		}
+		s.leaseSeq = c.LeaseSeq
+		s.leaseChanged(c.Key)
+		return nil
+	case ChangeQueue:
+		delete(s.queues, c.Key)
+		if c.Queue != nil {
+			s.loadQueue(*c.Queue)
+		}
+		s.receiptKey = []byte(c.ReceiptKey)
+		s.queueChanged(c.Key)
+		return nil
+	case ChangeStream:
+		delete(s.streams, c.Key)
+		if c.Stream != nil {
+			s.loadStream(*c.Stream)
+		}
+		s.streamChanged(c.Key)
+		return nil
+	case ChangeRateLimit:
+		delete(s.rateLimits, c.Key)
+		if c.RateLimit != nil {
+			s.loadRateLimit(*c.RateLimit)
+		}
+		s.rateLimitChanged(c.Key)
+		return nil
+	case ChangeDelete:
+		s.remove(c.Key)
+		return nil
+	case ChangeSet:
+		if c.Item == nil {
+			return fmt.Errorf("%w: set change without an item", helpers.InvalidParamError)
+		}
+	default:
+		return fmt.Errorf("%w: unknown change %q", helpers.InvalidParamError, c.Op)
+	}
+
+	m := newMeta(c.Item.Metadata)
+	m.epoch = s.epoch
+	if old, ok := s.meta[c.Key]; ok {
+		s.unindexTags(c.Key, old.tags)
+	}
+	s.setKey(c.Key, c.Item.Value, m)
+	s.indexTags(c.Key, m.tags)
+	s.changed(c.Key)
+
+	return nil
+}
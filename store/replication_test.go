@@ -0,0 +1,75 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOnChange(t *testing.T) {
+	store := NewKeyValueStore()
+
+	var changes []Change
+	store.OnChange = func(c Change) { changes = append(changes, c) }
+
+	store.Add("a", []byte(`1`))
+	store.SetTags("a", map[string]string{"env": "prod"})
+	store.Add("b", []byte(`2`))
+	store.Delete("b")
+	store.Clear()
+
+	want := []struct {
+		op  ChangeOp
+		key string
+	}{{ChangeSet, "a"}, {ChangeSet, "a"}, {ChangeSet, "b"}, {ChangeDelete, "b"}, {ChangeClear, ""}}
+	if len(changes) != len(want) {
+		t.Fatalf("OnChange got %d changes, want %d: %+v", len(changes), len(want), changes)
+	}
+	for i, w := range want {
+		if changes[i].Op != w.op || changes[i].Key != w.key {
+			t.Errorf("change %d = %s %q, want %s %q", i, changes[i].Op, changes[i].Key, w.op, w.key)
+		}
+	}
+	if tags := changes[1].Item.Metadata.Tags; tags["env"] != "prod" {
+		t.Errorf("change after SetTags() carries tags %v, want env=prod", tags)
+	}
+}
+
+func TestApplyChange(t *testing.T) {
+	leader := NewKeyValueStore()
+	follower := NewKeyValueStore()
+	leader.OnChange = func(c Change) {
+		if err := follower.ApplyChange(c); err != nil {
+			t.Fatalf("ApplyChange() returned an error: %v", err)
+		}
+	}
+
+	leader.InitData()
+	leader.SetTags("TestString", map[string]string{"env": "prod"})
+	leader.Update("TestNumber", []byte(`2`))
+	leader.SoftDelete("TestMap")
+	leader.Restore("TestMap")
+	leader.Delete("TestNumber")
+	leader.AcquireLease("lock", "a", time.Minute)
+	leader.Enqueue("jobs", []byte(`1`))
+	leader.Dequeue("jobs", time.Minute)
+	leader.StreamAdd("events", []byte(`1`), 0)
+	leader.CreateGroup("events", "workers", "0")
+	leader.ReadGroup("events", "workers", "w1", 0)
+	leader.AllowRate("api", RateLimit{Capacity: 5, Rate: 1})
+	leader.Add("gone", []byte(`1`))
+	leader.SoftDelete("gone")
+
+	got, _ := json.Marshal(follower.State())
+	want, _ := json.Marshal(leader.State())
+	if string(got) != string(want) {
+		t.Errorf("follower = %s, want %s", got, want)
+	}
+
+	// The tag index is rebuilt on the follower
+	sel, _ := ParseTagSelector("env=prod")
+	if keys, _ := follower.FindByTags(sel); !slices.Equal(keys, []string{"TestString"}) {
+		t.Errorf("FindByTags() on the follower = %v, want [TestString]", keys)
+	}
+}
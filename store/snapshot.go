@@ -0,0 +1,225 @@
+package store
+
+import (
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshot is an immutable view of the key space as it was at one point in time. Taking a snapshot
+// costs nothing: it shares the maps of the store, and the store copies them before its next change
+// instead (copy-on-write). Snapshots can therefore be read from any goroutine, for as long as needed,
+// while the request loop carries on with writes.
+//
+// Values and write metadata are those at the time of the snapshot. Read counts and access times keep
+// counting reads made after it, as they are updated in place. Keys that have expired by the time the
+// snapshot is read are left out, as they are by Get, though the store may not have removed them yet.
+type Snapshot struct {
+	Version uint64 // Number of changes made to the store before the snapshot
+
+	values map[string]any
+	meta   map[string]*meta
+	now    func() time.Time // Clock of the store
+
+	sortOnce sync.Once
+	sorted   []string // Every key in order, once a scan has needed them
+}
+
+// Snapshot returns a snapshot of the key space. Snapshots taken with no change in between are the same.
+func (s *KVStore) Snapshot() *Snapshot {
+	if s.snap == nil || s.snap.Version != s.version {
+		s.snap = &Snapshot{Version: s.version, values: s.store, meta: s.meta, now: s.clock}
+		s.shared = true
+		s.epoch++
+	}
+	return s.snap
+}
+
+// clock returns the time of the store. Snapshots read it from other goroutines, so it is read under the lock.
+func (s *KVStore) clock() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.now()
+}
+
+// Len returns the number of keys in the snapshot.
+func (snap *Snapshot) Len() int {
+	now := snap.now()
+	n := 0
+	for _, m := range snap.meta {
+		if !m.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// Get returns the value of key in the snapshot.
+func (snap *Snapshot) Get(key string) (any, bool) {
+	m, ok := snap.meta[key]
+	if !ok || m.expired(snap.now()) {
+		return nil, false
+	}
+	return snap.values[key], true
+}
+
+// Values returns every value by key. The map may be shared with the snapshot and must not be modified.
+func (snap *Snapshot) Values() map[string]any {
+	now := snap.now()
+	for _, m := range snap.meta {
+		if m.expired(now) {
+			return snap.live(now)
+		}
+	}
+	return snap.values
+}
+
+// live returns a copy of the values of the keys that have not expired by now.
+func (snap *Snapshot) live(now time.Time) map[string]any {
+	values := make(map[string]any, len(snap.values))
+	for k, v := range snap.values {
+		if !snap.meta[k].expired(now) {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+// Items returns every value together with its metadata.
+func (snap *Snapshot) Items() map[string]Item {
+	now := snap.now()
+	items := make(map[string]Item, len(snap.values))
+	for k, v := range snap.values {
+		if m := snap.meta[k]; !m.expired(now) {
+			items[k] = Item{Value: v, Metadata: m.snapshot()}
+		}
+	}
+	return items
+}
+
+// allItems is Items with the keys that have expired but are yet to be removed, which are still part of
+// the state of the store.
+func (snap *Snapshot) allItems() map[string]Item {
+	items := make(map[string]Item, len(snap.values))
+	for k, v := range snap.values {
+		items[k] = Item{Value: v, Metadata: snap.meta[k].snapshot()}
+	}
+	return items
+}
+
+// Scan yields every key starting with prefix, in key order, with its value and metadata.
+func (snap *Snapshot) Scan(prefix string) iter.Seq2[string, Item] {
+	return snap.ScanAfter(prefix, "")
+}
+
+// ScanAfter is Scan from the first key after after. The keys of a snapshot are sorted once, by the
+// first scan, so paging through a snapshot costs each call only the keys it yields.
+func (snap *Snapshot) ScanAfter(prefix, after string) iter.Seq2[string, Item] {
+	return func(yield func(string, Item) bool) {
+		keys, now := snap.keys(), snap.now()
+		i, _ := slices.BinarySearch(keys, prefix)
+		if after != "" {
+			j, found := slices.BinarySearch(keys, after)
+			if found {
+				j++
+			}
+			i = max(i, j)
+		}
+
+		for _, k := range keys[i:] {
+			if !strings.HasPrefix(k, prefix) {
+				return
+			}
+			if snap.meta[k].expired(now) {
+				continue
+			}
+			if !yield(k, Item{Value: snap.values[k], Metadata: snap.meta[k].snapshot()}) {
+				return
+			}
+		}
+	}
+}
+
+// keys returns every key of the snapshot in order.
+func (snap *Snapshot) keys() []string {
+	snap.sortOnce.Do(func() {
+		snap.sorted = slices.Sorted(maps.Keys(snap.values))
+	})
+	return snap.sorted
+}
+
+// Export returns every entry whose key starts with prefix, sorted by key.
+func (snap *Snapshot) Export(prefix string) []Entry {
+	entries := make([]Entry, 0)
+	for k, item := range snap.Scan(prefix) {
+		entries = append(entries, Entry{Key: k, Value: item.Value, Metadata: item.Metadata})
+	}
+	return entries
+}
+
+// Changes returns the snapshot as set changes, sorted by key. Applied to an empty store they
+// reproduce the snapshot.
+func (snap *Snapshot) Changes() []Change {
+	changes := make([]Change, 0, len(snap.values))
+	for k, item := range snap.Scan("") {
+		changes = append(changes, Change{Op: ChangeSet, Key: k, Item: &item})
+	}
+	return changes
+}
+
+// unshare copies the maps of the key space if a snapshot holds them, so they can be changed.
+func (s *KVStore) unshare() {
+	if s.shared {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.store = maps.Clone(s.store)
+		s.meta = maps.Clone(s.meta)
+		s.shared = false
+	}
+}
+
+// writable returns the metadata of key for a change, copying it first if a snapshot may hold it.
+func (s *KVStore) writable(key string) (*meta, bool) {
+	m, ok := s.meta[key]
+	if !ok {
+		return nil, false
+	}
+	if m.epoch != s.epoch {
+		s.unshare()
+		// Copy and swap in one step, so no read counted by Read in between is lost
+		s.mu.Lock()
+		m = s.copyMeta(m)
+		s.meta[key] = m
+		s.mu.Unlock()
+	}
+	return m, true
+}
+
+// copyMeta returns a copy of m that no snapshot holds.
+func (s *KVStore) copyMeta(m *meta) *meta {
+	c := newMeta(m.snapshot())
+	c.epoch = s.epoch
+	return c
+}
+
+// setKey stores value under key with metadata m, which no snapshot may hold.
+func (s *KVStore) setKey(key string, value any, m *meta) {
+	s.unshare()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store[key] = value
+	s.meta[key] = m
+	delete(s.tombstones, key)
+}
+
+// deleteKey removes key and its metadata.
+func (s *KVStore) deleteKey(key string) {
+	s.unshare()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.store, key)
+	delete(s.meta, key)
+}
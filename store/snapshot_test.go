@@ -0,0 +1,191 @@
+package store
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	store := NewKeyValueStore()
+	store.Upsert("a", []byte(`1`))
+	store.Upsert("b", []byte(`2`))
+	store.SetTags("a", map[string]string{"env": "prod"})
+	store.SoftDelete("b")
+	store.Upsert("c", []byte(`3`))
+
+	snap := store.Snapshot()
+	want := snap.Items()
+
+	// Every kind of change after the snapshot leaves it as it was
+	store.Upsert("a", []byte(`10`))
+	store.SetTags("a", map[string]string{"env": "dev"})
+	store.RemoveTags("a", []string{"env"})
+	store.Restore("b")
+	store.Upsert("b", []byte(`20`))
+	store.Delete("c")
+	store.Upsert("d", []byte(`4`))
+
+	if got := snap.Items(); !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot changed after writes:\ngot  %+v\nwant %+v", got, want)
+	}
+	if v, ok := snap.Get("a"); !ok || v != float64(1) {
+		t.Errorf("Get(a) = %v, %v, want 1", v, ok)
+	}
+
+	store.Clear()
+	if got := snap.Items(); !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot changed after Clear(): %+v", got)
+	}
+	if all, _ := store.GetAll(); len(all.(map[string]any)) != 0 {
+		t.Errorf("GetAll() after Clear() = %v, want empty", all)
+	}
+}
+
+func TestSnapshotVersion(t *testing.T) {
+	store := NewKeyValueStore()
+	store.Upsert("a", []byte(`1`))
+
+	first := store.Snapshot()
+	if store.Snapshot() != first {
+		t.Error("snapshots with no change in between differ")
+	}
+	store.Get("a")
+	if store.Snapshot() != first {
+		t.Error("a read made the next snapshot differ")
+	}
+
+	store.Upsert("a", []byte(`2`))
+	second := store.Snapshot()
+	if second == first || second.Version <= first.Version {
+		t.Errorf("snapshot after a write has version %d, want after %d", second.Version, first.Version)
+	}
+}
+
+func TestSnapshotScan(t *testing.T) {
+	store := NewKeyValueStore()
+	for _, k := range []string{"user:2", "order:1", "user:1", "user:3"} {
+		store.Upsert(k, []byte(`true`))
+	}
+	snap := store.Snapshot()
+
+	var keys []string
+	for k := range snap.Scan("user:") {
+		keys = append(keys, k)
+		if k == "user:2" {
+			break
+		}
+	}
+	if want := []string{"user:1", "user:2"}; !slices.Equal(keys, want) {
+		t.Errorf("Scan(user:) = %v, want %v", keys, want)
+	}
+	if got := snap.Export("order:"); len(got) != 1 || got[0].Key != "order:1" {
+		t.Errorf("Export(order:) = %+v", got)
+	}
+	if got := snap.Changes(); len(got) != 4 || got[0].Key != "order:1" || got[0].Op != ChangeSet {
+		t.Errorf("Changes() = %+v", got)
+	}
+}
+
+func TestSnapshotExpired(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	store.Upsert("a", []byte(`1`))
+	store.Upsert("b", []byte(`2`))
+	store.Expire("b", time.Minute)
+	snap := store.Snapshot()
+
+	// The expired key is still in the store, but no longer in the snapshot
+	now = now.Add(time.Minute)
+	if _, ok := snap.Get("b"); ok {
+		t.Errorf("Get(b) found an expired key")
+	}
+	if n := snap.Len(); n != 1 {
+		t.Errorf("Len() = %d, want 1", n)
+	}
+	if n, _ := store.Count(); n != 1 {
+		t.Errorf("Count() = %d, want 1", n)
+	}
+	if all, _ := store.GetAll(); !maps.Equal(all.(map[string]any), map[string]any{"a": float64(1)}) {
+		t.Errorf("GetAll() = %v, want only a", all)
+	}
+	if got := snap.Export(""); len(got) != 1 || got[0].Key != "a" {
+		t.Errorf("Export() = %+v, want only a", got)
+	}
+	if items, _ := store.GetAllWithMeta(); len(items) != 1 {
+		t.Errorf("GetAllWithMeta() = %+v, want only a", items)
+	}
+}
+
+func TestSnapshotScanAfter(t *testing.T) {
+	store := NewKeyValueStore()
+	for _, k := range []string{"a", "b:1", "b:2", "b:3", "c"} {
+		store.Upsert(k, []byte(`true`))
+	}
+	snap := store.Snapshot()
+
+	for _, tt := range []struct {
+		prefix, after string
+		want          []string
+	}{
+		{"b:", "", []string{"b:1", "b:2", "b:3"}},
+		{"b:", "b:1", []string{"b:2", "b:3"}},
+		{"b:", "b:15", []string{"b:2", "b:3"}},
+		{"b:", "a", []string{"b:1", "b:2", "b:3"}},
+		{"b:", "b:3", nil},
+		{"", "b:2", []string{"b:3", "c"}},
+	} {
+		var keys []string
+		for k := range snap.ScanAfter(tt.prefix, tt.after) {
+			keys = append(keys, k)
+		}
+		if !slices.Equal(keys, tt.want) {
+			t.Errorf("ScanAfter(%q, %q) = %v, want %v", tt.prefix, tt.after, keys, tt.want)
+		}
+	}
+}
+
+// Snapshots are read while the store keeps changing. Run with -race.
+func TestSnapshotConcurrentReads(t *testing.T) {
+	store := NewKeyValueStore()
+	for i := range 100 {
+		store.Upsert(fmt.Sprint(i), []byte(`0`))
+	}
+
+	snaps := make(chan *Snapshot)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for snap := range snaps {
+				// Every snapshot is taken between rounds of writes, so all its values are equal
+				var values []float64
+				for v := range maps.Values(snap.Values()) {
+					values = append(values, v.(float64))
+				}
+				if slices.Min(values) != slices.Max(values) {
+					t.Errorf("snapshot %d is torn", snap.Version)
+				}
+				snap.Export("")
+			}
+		}()
+	}
+
+	for round := range 50 {
+		snaps <- store.Snapshot()
+		for i := range 100 {
+			store.Upsert(fmt.Sprint(i), fmt.Appendf(nil, "%d", round+1))
+			if i%10 == 0 {
+				store.SetTags(fmt.Sprint(i), map[string]string{"round": fmt.Sprint(round)})
+			}
+		}
+	}
+	close(snaps)
+	wg.Wait()
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// State is everything a replica of the store holds: the keys with their metadata, the trash and the soft
+// clear sequence, and the leases, queues, streams and rate limits. Replicated keys are not part of it,
+// see crdt.go.
+type State struct {
+	Items    map[string]Item `json:"items"`
+	Trash    []TrashState    `json:"trash,omitempty"`
+	ClearSeq uint64          `json:"clear_seq,omitempty"`
+
+	Leases     []Lease          `json:"leases,omitempty"`
+	LeaseSeq   uint64           `json:"lease_seq,omitempty"` // Last fencing token handed out
+	Queues     []QueueState     `json:"queues,omitempty"`
+	ReceiptKey string           `json:"receipt_key,omitempty"` // Secret queue receipts are made with
+	Streams    []StreamState    `json:"streams,omitempty"`
+	RateLimits []RateLimitState `json:"rate_limits,omitempty"`
+}
+
+// TrashState is a trash entry together with the metadata it will be restored with.
+type TrashState struct {
+	TrashEntry
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// State returns a copy of the replicated state of the store.
+func (s *KVStore) State() State {
+
+	st := State{Items: s.Snapshot().allItems(), ClearSeq: s.clearSeq}
+
+	for _, key := range slices.Sorted(maps.Keys(s.trash)) {
+		st.Trash = append(st.Trash, s.trashStates(key)...)
+	}
+
+	st.Leases, st.LeaseSeq = s.leaseStates(), s.leaseSeq
+	st.Queues, st.ReceiptKey = s.queueStates(), string(s.receiptKey)
+	st.Streams = s.streamStates()
+	st.RateLimits = s.rateLimitStates()
+
+	return st
+}
+
+// LoadState replaces the replicated state of the store with st.
+func (s *KVStore) LoadState(st State) {
+
+	s.Clear()
+	for k, item := range st.Items {
+		m := newMeta(item.Metadata)
+		m.epoch = s.epoch
+		s.setKey(k, item.Value, m)
+		s.indexTags(k, m.tags)
+		s.changed(k)
+	}
+
+	clear(s.trash)
+	for _, ts := range st.Trash {
+		e := ts.TrashEntry
+		if ts.Metadata != nil {
+			e.meta = newMeta(*ts.Metadata)
+		}
+		s.trash[e.Key] = append(s.trash[e.Key], e)
+	}
+	s.clearSeq = st.ClearSeq
+
+	s.loadLeases(st.Leases, st.LeaseSeq)
+	s.loadQueues(st.Queues)
+	s.receiptKey = []byte(st.ReceiptKey)
+	s.loadStreams(st.Streams)
+	s.loadRateLimits(st.RateLimits)
+}
+
+// At runs f with the clock of the store stopped at t, so that a mutation replayed on several stores
+// leaves the same timestamps on each of them.
+func (s *KVStore) At(t time.Time, f func()) {
+	s.mu.Lock()
+	now := s.now
+	s.now = func() time.Time { return t }
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.now = now
+		s.mu.Unlock()
+	}()
+	f()
+}
+
+// newMeta rebuilds the bookkeeping of a key from its metadata.
+func newMeta(md Metadata) *meta {
+	m := &meta{createdAt: md.CreatedAt, updatedAt: md.UpdatedAt, writes: md.WriteCount, size: md.Size, tags: maps.Clone(md.Tags), expiresAt: md.ExpiresAt, flags: md.Flags}
+	m.reads.Store(md.ReadCount)
+	if !md.LastAccessedAt.IsZero() {
+		m.lastAccessed.Store(md.LastAccessedAt.UnixNano())
+	}
+	return m
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLoadState(t *testing.T) {
+	source := NewKeyValueStore()
+	source.InitData()
+	source.SetTags("TestString", map[string]string{"env": "prod"})
+	source.SoftDelete("TestString")
+	source.Add("other", []byte(`1`))
+	source.SoftClear()
+
+	source.AcquireLease("lock", "a", time.Minute)
+	source.Enqueue("jobs", []byte(`1`))
+	source.Enqueue("jobs", []byte(`2`))
+	msg, _ := source.Dequeue("jobs", time.Minute)
+	source.StreamAdd("events", []byte(`"x"`), 0)
+	source.CreateGroup("events", "g", "0")
+	source.ReadGroup("events", "g", "c", 1)
+	source.AllowRate("api", RateLimit{Capacity: 1, Rate: 0.001})
+
+	// The state survives a round trip through JSON, as it does in a snapshot
+	b, err := json.Marshal(source.State())
+	if err != nil {
+		t.Fatalf("json.Marshal() returned an error: %v", err)
+	}
+	var st State
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatalf("json.Unmarshal() returned an error: %v", err)
+	}
+
+	target := NewKeyValueStore()
+	target.Add("stale", []byte(`1`))
+	target.LoadState(st)
+
+	got, _ := json.Marshal(target.State())
+	if string(got) != string(b) {
+		t.Errorf("State() after LoadState() = %s, want %s", got, b)
+	}
+
+	// Trash keeps its metadata and the clear sequence carries on
+	if _, err := target.Restore("TestString"); err != nil {
+		t.Fatalf("Restore() returned an error: %v", err)
+	}
+	if keys, _ := target.FindByTags(TagSelector{{{Name: "env", Value: "prod"}}}); len(keys) != 1 {
+		t.Errorf("FindByTags() after Restore() = %v, want TestString", keys)
+	}
+	if id, _ := target.SoftClear(); id != "clear-2" {
+		t.Errorf("SoftClear() = %q, want clear-2", id)
+	}
+
+	// Fencing tokens never go backwards, and in-flight messages and pending entries can be acked
+	target.ReleaseLease("lock", "a")
+	if l, _ := target.AcquireLease("lock", "b", time.Minute); l.Token != 2 {
+		t.Errorf("AcquireLease() token = %d, want 2", l.Token)
+	}
+	if err := target.Ack("jobs", msg.Receipt); err != nil {
+		t.Errorf("Ack() returned an error: %v", err)
+	}
+	if next, err := target.Dequeue("jobs", time.Minute); err != nil || next.Body != float64(2) {
+		t.Errorf("Dequeue() = %+v, %v, want the second message", next, err)
+	}
+	if pending, _ := target.Pending("events", "g"); len(pending) != 1 {
+		t.Errorf("Pending() = %v, want one entry", pending)
+	}
+	if res, _ := target.AllowRate("api", RateLimit{Capacity: 1, Rate: 0.001}); res.Allowed {
+		t.Error("AllowRate() allowed a request over the limit")
+	}
+}
+
+func TestAt(t *testing.T) {
+	store := NewKeyValueStore()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store.At(at, func() { store.Add("a", []byte(`1`)) })
+	store.Add("b", []byte(`1`))
+
+	if md, _ := store.Meta("a"); !md.CreatedAt.Equal(at) {
+		t.Errorf("Meta(a).CreatedAt = %v, want %v", md.CreatedAt, at)
+	}
+	if md, _ := store.Meta("b"); md.CreatedAt.Equal(at) {
+		t.Errorf("Meta(b).CreatedAt = %v, want the clock restored", md.CreatedAt)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"kvstore/helpers"
 	"testing"
+	"time"
 )
 
 func TestAdd(t *testing.T) {
@@ -462,3 +463,145 @@ func compareMaps(got, want map[string]any) bool {
 func equal(a, b any) bool {
 	return a == b
 }
+
+func TestExport(t *testing.T) {
+	store := NewKeyValueStore()
+	store.InitData()
+
+	tests := []struct {
+		description string
+		prefix      string
+		want        []string
+	}{
+		{
+			description: "TestExportAll",
+			prefix:      "",
+			want:        []string{"TestMap", "TestNumber", "TestString"},
+		},
+		{
+			description: "TestExportPrefix",
+			prefix:      "TestN",
+			want:        []string{"TestNumber"},
+		},
+		{
+			description: "TestExportNoMatch",
+			prefix:      "Missing",
+			want:        []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			got, err := store.Export(tt.prefix)
+			if err != nil {
+				t.Errorf("Export() returned an error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Export() returned %d entries, want %d", len(got), len(tt.want))
+			}
+			for i, e := range got {
+				if e.Key != tt.want[i] {
+					t.Errorf("Export()[%d] = %v, want %v", i, e.Key, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		description string
+		key         string
+		value       []byte
+		opts        ImportOptions
+		want        any
+		stored      any
+	}{
+		{
+			description: "TestImportNew",
+			key:         "NewKey",
+			value:       []byte(`"New"`),
+			opts:        ImportOptions{Mode: ConflictSkip},
+			want:        ImportAdded,
+			stored:      "New",
+		},
+		{
+			description: "TestImportSkip",
+			key:         "TestString",
+			value:       []byte(`"Other"`),
+			opts:        ImportOptions{Mode: ConflictSkip},
+			want:        ImportSkipped,
+			stored:      "Value1",
+		},
+		{
+			description: "TestImportOverwrite",
+			key:         "TestString",
+			value:       []byte(`"Other"`),
+			opts:        ImportOptions{Mode: ConflictOverwrite},
+			want:        ImportOverwritten,
+			stored:      "Other",
+		},
+		{
+			description: "TestImportFail",
+			key:         "TestString",
+			value:       []byte(`"Other"`),
+			opts:        ImportOptions{Mode: ConflictFail},
+			want:        helpers.DuplicateKeyError,
+			stored:      "Value1",
+		},
+		{
+			description: "TestImportDryRun",
+			key:         "TestString",
+			value:       []byte(`"Other"`),
+			opts:        ImportOptions{Mode: ConflictOverwrite, DryRun: true},
+			want:        ImportOverwritten,
+			stored:      "Value1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			store := NewKeyValueStore()
+			store.InitData()
+
+			got, err := store.Import(tt.key, tt.value, tt.opts)
+			switch expected := tt.want.(type) {
+			case ImportResult:
+				if err != nil || got != expected {
+					t.Errorf("Import() = %v, %v, want %v", got, err, expected)
+				}
+			case error:
+				if !errors.Is(err, expected) {
+					t.Errorf("Import() error = %v, want %v", err, expected)
+				}
+			}
+
+			if stored, _ := store.Get(tt.key); stored != tt.stored {
+				t.Errorf("Get() after Import() = %v, want %v", stored, tt.stored)
+			}
+		})
+	}
+}
+
+func TestImportExpiry(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	// A key that has expired but is yet to be swept is no conflict
+	store.Add("old", []byte(`1`))
+	store.Expire("old", time.Minute)
+	now = now.Add(time.Minute)
+	if got, err := store.Import("old", []byte(`2`), ImportOptions{Mode: ConflictFail}); err != nil || got != ImportAdded {
+		t.Errorf("Import() over an expired key = %v, %v, want %v", got, err, ImportAdded)
+	}
+
+	// A key exported with an expiry that has passed since is not imported
+	md := &Metadata{ExpiresAt: now}
+	if got, err := store.Import("gone", []byte(`1`), ImportOptions{Metadata: md}); err != nil || got != ImportSkipped {
+		t.Errorf("Import() of an expired key = %v, %v, want %v", got, err, ImportSkipped)
+	}
+	if _, err := store.Get("gone"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Get() of an expired import error = %v, want %v", err, helpers.NotExistError)
+	}
+}
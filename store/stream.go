@@ -0,0 +1,519 @@
+package store
+
+import (
+	"cmp"
+	"fmt"
+	"kvstore/helpers"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamID orders stream entries. It is the millisecond time the entry was added plus a sequence
+// number for entries added within the same millisecond, written as "<ms>-<seq>".
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+func (id StreamID) compare(other StreamID) int {
+	if c := cmp.Compare(id.Ms, other.Ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.Seq, other.Seq)
+}
+
+// next returns the smallest ID after id.
+func (id StreamID) next() StreamID {
+	return StreamID{id.Ms, id.Seq + 1}
+}
+
+var maxStreamID = StreamID{^uint64(0), ^uint64(0)}
+
+// ParseStreamID parses "<ms>-<seq>" or "<ms>", where a missing sequence is 0. The special IDs "-" and "+"
+// stand for the lowest and highest possible IDs.
+func ParseStreamID(s string) (StreamID, error) {
+	switch s {
+	case "-", "0":
+		return StreamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("%w: invalid stream id %q", helpers.InvalidParamError, s)
+	}
+
+	var seq uint64
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return StreamID{}, fmt.Errorf("%w: invalid stream id %q", helpers.InvalidParamError, s)
+		}
+	}
+	return StreamID{ms, seq}, nil
+}
+
+// StreamEntry is a single entry of a stream.
+type StreamEntry struct {
+	ID    string `json:"id"`
+	Value any    `json:"value"`
+
+	id StreamID
+}
+
+// PendingEntry is an entry delivered to a consumer of a group but not yet acknowledged.
+type PendingEntry struct {
+	ID          string    `json:"id"`
+	Consumer    string    `json:"consumer"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	Deliveries  int       `json:"deliveries"`
+
+	id StreamID
+}
+
+// StreamInfo describes a stream and its consumer groups.
+type StreamInfo struct {
+	Name    string      `json:"name"`
+	Length  int         `json:"length"`
+	FirstID string      `json:"first_id,omitempty"`
+	LastID  string      `json:"last_id,omitempty"`
+	Groups  []GroupInfo `json:"groups"`
+}
+
+// GroupInfo describes a consumer group.
+type GroupInfo struct {
+	Name          string   `json:"name"`
+	LastDelivered string   `json:"last_delivered"`
+	Pending       int      `json:"pending"`
+	Consumers     []string `json:"consumers"`
+}
+
+type stream struct {
+	entries []StreamEntry // Ordered by ID
+	lastID  StreamID      // Highest ID ever added, even if trimmed since
+	groups  map[string]*consumerGroup
+}
+
+type consumerGroup struct {
+	lastDelivered StreamID
+	pending       map[StreamID]*PendingEntry
+	consumers     map[string]struct{}
+}
+
+// StreamAdd appends a JSON value to the named stream, creating it if needed, and returns the new entry.
+// With maxLen above zero the oldest entries are trimmed so the stream holds at most maxLen entries.
+func (s *KVStore) StreamAdd(name string, v []byte, maxLen int) (StreamEntry, error) {
+
+	if name == "" {
+		return StreamEntry{}, helpers.MissingKeyError
+	}
+
+	// Parse the value from JSON
+	value, err := helpers.ParseJSON(v)
+	if err != nil {
+		return StreamEntry{}, err // Return early if parsing fails
+	}
+
+	st, ok := s.streams[name]
+	if !ok {
+		st = &stream{groups: make(map[string]*consumerGroup)}
+		s.streams[name] = st
+	}
+
+	// IDs never go backwards, even if the clock does
+	id := StreamID{Ms: uint64(s.now().UnixMilli())}
+	if id.compare(st.lastID) <= 0 {
+		id = st.lastID.next()
+	}
+	st.lastID = id
+
+	e := StreamEntry{ID: id.String(), Value: value, id: id}
+	st.entries = append(st.entries, e)
+	if maxLen > 0 {
+		st.trim(maxLen)
+	}
+	s.streamChanged(name)
+
+	return e, nil
+}
+
+// StreamRange returns up to count entries (all if count is zero) with IDs between start and end inclusive.
+func (s *KVStore) StreamRange(name, start, end string, count int) ([]StreamEntry, error) {
+
+	st, ok := s.streams[name]
+	if !ok {
+		return nil, helpers.NotExistError
+	}
+
+	from, err := ParseStreamID(start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := ParseStreamID(end)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []StreamEntry{}
+	for _, e := range st.entries[st.search(from):] {
+		if e.id.compare(to) > 0 || (count > 0 && len(entries) == count) {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// StreamRead returns up to count entries added after the given ID. An after of "$" means the end of the
+// stream, so only entries added later will be returned.
+func (s *KVStore) StreamRead(name, after string, count int) ([]StreamEntry, error) {
+
+	st, ok := s.streams[name]
+	if !ok {
+		return nil, helpers.NotExistError
+	}
+
+	from := st.lastID
+	if after != "$" {
+		id, err := ParseStreamID(after)
+		if err != nil {
+			return nil, err
+		}
+		from = id
+	}
+
+	entries := []StreamEntry{}
+	for _, e := range st.entries[st.search(from.next()):] {
+		if count > 0 && len(entries) == count {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// StreamTail returns the last count entries of a stream, oldest first.
+func (s *KVStore) StreamTail(name string, count int) ([]StreamEntry, error) {
+
+	st, ok := s.streams[name]
+	if !ok {
+		return nil, helpers.NotExistError
+	}
+
+	if count <= 0 || count > len(st.entries) {
+		count = len(st.entries)
+	}
+	return slices.Clone(st.entries[len(st.entries)-count:]), nil
+}
+
+// StreamTrim drops the oldest entries so the stream holds at most maxLen, returning how many were removed.
+func (s *KVStore) StreamTrim(name string, maxLen int) (int, error) {
+
+	st, ok := s.streams[name]
+	if !ok {
+		return 0, helpers.NotExistError
+	}
+	if maxLen < 0 {
+		return 0, fmt.Errorf("%w: maxlen must not be negative", helpers.InvalidParamError)
+	}
+
+	n := st.trim(maxLen)
+	if n > 0 {
+		s.streamChanged(name)
+	}
+	return n, nil
+}
+
+// StreamInfo returns the length, ID range and consumer groups of a stream.
+func (s *KVStore) StreamInfo(name string) (StreamInfo, error) {
+
+	st, ok := s.streams[name]
+	if !ok {
+		return StreamInfo{}, helpers.NotExistError
+	}
+
+	info := StreamInfo{Name: name, Length: len(st.entries), Groups: []GroupInfo{}}
+	if len(st.entries) > 0 {
+		info.FirstID = st.entries[0].ID
+		info.LastID = st.entries[len(st.entries)-1].ID
+	}
+	for gname, g := range st.groups {
+		gi := GroupInfo{Name: gname, LastDelivered: g.lastDelivered.String(), Pending: len(g.pending), Consumers: []string{}}
+		for c := range g.consumers {
+			gi.Consumers = append(gi.Consumers, c)
+		}
+		sort.Strings(gi.Consumers)
+		info.Groups = append(info.Groups, gi)
+	}
+	sort.Slice(info.Groups, func(i, j int) bool { return info.Groups[i].Name < info.Groups[j].Name })
+
+	return info, nil
+}
+
+// CreateGroup adds a consumer group to a stream. The group delivers entries after start, where "$"
+// means only entries added from now on and "0" means the whole stream.
+func (s *KVStore) CreateGroup(name, group, start string) error {
+
+	st, ok := s.streams[name]
+	if !ok {
+		return helpers.NotExistError
+	}
+	if group == "" {
+		return fmt.Errorf("%w: group not provided", helpers.InvalidParamError)
+	}
+	if _, ok := st.groups[group]; ok {
+		return helpers.DuplicateKeyError
+	}
+
+	last := st.lastID
+	if start != "$" {
+		id, err := ParseStreamID(start)
+		if err != nil {
+			return err
+		}
+		last = id
+	}
+
+	st.groups[group] = &consumerGroup{
+		lastDelivered: last,
+		pending:       make(map[StreamID]*PendingEntry),
+		consumers:     make(map[string]struct{}),
+	}
+	s.streamChanged(name)
+	return nil
+}
+
+// ReadGroup delivers up to count entries the group has not seen yet to consumer and records them as
+// pending until they are acknowledged.
+func (s *KVStore) ReadGroup(name, group, consumer string, count int) ([]StreamEntry, error) {
+
+	st, g, err := s.group(name, group)
+	if err != nil {
+		return nil, err
+	}
+	if consumer == "" {
+		return nil, fmt.Errorf("%w: consumer not provided", helpers.InvalidParamError)
+	}
+	g.consumers[consumer] = struct{}{}
+
+	now := s.now()
+	entries := []StreamEntry{}
+	for _, e := range st.entries[st.search(g.lastDelivered.next()):] {
+		if count > 0 && len(entries) == count {
+			break
+		}
+		entries = append(entries, e)
+		g.lastDelivered = e.id
+		g.pending[e.id] = &PendingEntry{ID: e.ID, Consumer: consumer, DeliveredAt: now, Deliveries: 1, id: e.id}
+	}
+	s.streamChanged(name)
+	return entries, nil
+}
+
+// StreamAck acknowledges entries delivered to a group and returns how many were pending.
+func (s *KVStore) StreamAck(name, group string, ids []string) (int, error) {
+
+	_, g, err := s.group(name, group)
+	if err != nil {
+		return 0, err
+	}
+
+	acked := 0
+	defer func() {
+		if acked > 0 {
+			s.streamChanged(name)
+		}
+	}()
+	for _, raw := range ids {
+		id, err := ParseStreamID(raw)
+		if err != nil {
+			return acked, err
+		}
+		if _, ok := g.pending[id]; ok {
+			delete(g.pending, id)
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+// Pending lists the entries delivered to a group but not yet acknowledged, oldest first.
+func (s *KVStore) Pending(name, group string) ([]PendingEntry, error) {
+
+	_, g, err := s.group(name, group)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]PendingEntry, 0, len(g.pending))
+	for _, p := range g.pending {
+		pending = append(pending, *p)
+	}
+	slices.SortFunc(pending, func(a, b PendingEntry) int { return a.id.compare(b.id) })
+	return pending, nil
+}
+
+// Claim hands pending entries that have been idle for at least minIdle over to consumer, so that entries
+// delivered to a consumer that died can be processed by another. With no ids every idle entry is claimed.
+// Entries that have been trimmed from the stream in the meantime are dropped from the pending list.
+func (s *KVStore) Claim(name, group, consumer string, minIdle time.Duration, ids []string) ([]StreamEntry, error) {
+
+	st, g, err := s.group(name, group)
+	if err != nil {
+		return nil, err
+	}
+	if consumer == "" {
+		return nil, fmt.Errorf("%w: consumer not provided", helpers.InvalidParamError)
+	}
+	g.consumers[consumer] = struct{}{}
+
+	var candidates []StreamID
+	if len(ids) == 0 {
+		for id := range g.pending {
+			candidates = append(candidates, id)
+		}
+	} else {
+		for _, raw := range ids {
+			id, err := ParseStreamID(raw)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, id)
+		}
+	}
+	slices.SortFunc(candidates, StreamID.compare)
+
+	now := s.now()
+	claimed := []StreamEntry{}
+	for _, id := range candidates {
+		p, ok := g.pending[id]
+		if !ok || now.Sub(p.DeliveredAt) < minIdle {
+			continue
+		}
+
+		i := st.search(id)
+		if i == len(st.entries) || st.entries[i].id != id {
+			delete(g.pending, id)
+			continue
+		}
+
+		p.Consumer = consumer
+		p.DeliveredAt = now
+		p.Deliveries++
+		claimed = append(claimed, st.entries[i])
+	}
+	s.streamChanged(name)
+	return claimed, nil
+}
+
+func (s *KVStore) group(name, group string) (*stream, *consumerGroup, error) {
+	st, ok := s.streams[name]
+	if !ok {
+		return nil, nil, helpers.NotExistError
+	}
+	g, ok := st.groups[group]
+	if !ok {
+		return nil, nil, helpers.NotExistError
+	}
+	return st, g, nil
+}
+
+// search returns the index of the first entry with an ID of at least id.
+func (st *stream) search(id StreamID) int {
+	return sort.Search(len(st.entries), func(i int) bool { return st.entries[i].id.compare(id) >= 0 })
+}
+
+// trim drops the oldest entries beyond maxLen and returns how many were removed.
+func (st *stream) trim(maxLen int) int {
+	n := len(st.entries) - maxLen
+	if n <= 0 {
+		return 0
+	}
+	st.entries = slices.Clone(st.entries[n:])
+	return n
+}
+
+// StreamState is a stream as kept in State, with its consumer groups.
+type StreamState struct {
+	Name    string        `json:"name"`
+	Entries []StreamEntry `json:"entries,omitempty"`
+	LastID  string        `json:"last_id"`
+	Groups  []GroupState  `json:"groups,omitempty"`
+}
+
+// GroupState is a consumer group as kept in State.
+type GroupState struct {
+	Name          string         `json:"name"`
+	LastDelivered string         `json:"last_delivered"`
+	Pending       []PendingEntry `json:"pending,omitempty"` // Ordered by ID
+	Consumers     []string       `json:"consumers,omitempty"`
+}
+
+// streamStates returns a copy of every stream, sorted by name.
+func (s *KVStore) streamStates() []StreamState {
+	states := make([]StreamState, 0, len(s.streams))
+	for _, name := range slices.Sorted(maps.Keys(s.streams)) {
+		states = append(states, streamState(name, s.streams[name]))
+	}
+	return states
+}
+
+func streamState(name string, st *stream) StreamState {
+	ss := StreamState{Name: name, Entries: slices.Clone(st.entries), LastID: st.lastID.String()}
+	for _, gname := range slices.Sorted(maps.Keys(st.groups)) {
+		g := st.groups[gname]
+		gs := GroupState{Name: gname, LastDelivered: g.lastDelivered.String(), Consumers: slices.Sorted(maps.Keys(g.consumers))}
+		for _, p := range g.pending {
+			gs.Pending = append(gs.Pending, *p)
+		}
+		slices.SortFunc(gs.Pending, func(a, b PendingEntry) int { return a.id.compare(b.id) })
+		ss.Groups = append(ss.Groups, gs)
+	}
+	return ss
+}
+
+// loadStreams replaces the streams of the store with those of states. The IDs in states were written
+// by streamStates, so they parse.
+func (s *KVStore) loadStreams(states []StreamState) {
+	clear(s.streams)
+	for _, ss := range states {
+		s.loadStream(ss)
+	}
+}
+
+// loadStream adds the stream ss to the store.
+func (s *KVStore) loadStream(ss StreamState) {
+	s.streams[ss.Name] = newStream(ss)
+}
+
+// newStream builds a stream from ss, whose IDs were written by streamState, so they parse.
+func newStream(ss StreamState) *stream {
+	st := &stream{groups: make(map[string]*consumerGroup, len(ss.Groups))}
+	st.lastID, _ = ParseStreamID(ss.LastID)
+	for _, e := range ss.Entries {
+		e.id, _ = ParseStreamID(e.ID)
+		st.entries = append(st.entries, e)
+	}
+	for _, gs := range ss.Groups {
+		g := &consumerGroup{pending: make(map[StreamID]*PendingEntry, len(gs.Pending)), consumers: make(map[string]struct{})}
+		g.lastDelivered, _ = ParseStreamID(gs.LastDelivered)
+		for _, p := range gs.Pending {
+			p.id, _ = ParseStreamID(p.ID)
+			g.pending[p.id] = &p
+		}
+		for _, c := range gs.Consumers {
+			g.consumers[c] = struct{}{}
+		}
+		st.groups[gs.Name] = g
+	}
+	return st
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"errors"
+	"kvstore/helpers"
+	"testing"
+	"time"
+)
+
+func entryIDs(entries []StreamEntry) []string {
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+func TestStreamIDs(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.UnixMilli(1000)
+	store.now = func() time.Time { return now }
+
+	var ids []string
+	for _, step := range []time.Duration{0, 0, time.Millisecond, -time.Second} {
+		now = now.Add(step)
+		e, err := store.StreamAdd("events", []byte(`1`), 0)
+		if err != nil {
+			t.Fatalf("StreamAdd() returned an error: %v", err)
+		}
+		ids = append(ids, e.ID)
+	}
+
+	// Same millisecond bumps the sequence and a clock going backwards never reorders entries
+	want := []string{"1000-0", "1000-1", "1001-0", "1001-1"}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("StreamAdd() IDs = %v, want %v", ids, want)
+			break
+		}
+	}
+}
+
+func TestStreamReads(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.UnixMilli(1000)
+	store.now = func() time.Time { now = now.Add(time.Millisecond); return now }
+
+	for _, v := range []string{`"a"`, `"b"`, `"c"`, `"d"`} {
+		store.StreamAdd("events", []byte(v), 0)
+	}
+
+	tests := []struct {
+		description string
+		read        func() ([]StreamEntry, error)
+		want        []string
+	}{
+		{
+			description: "TestRangeAll",
+			read:        func() ([]StreamEntry, error) { return store.StreamRange("events", "-", "+", 0) },
+			want:        []string{"1001-0", "1002-0", "1003-0", "1004-0"},
+		},
+		{
+			description: "TestRangeBounds",
+			read:        func() ([]StreamEntry, error) { return store.StreamRange("events", "1002", "1003-0", 0) },
+			want:        []string{"1002-0", "1003-0"},
+		},
+		{
+			description: "TestRangeCount",
+			read:        func() ([]StreamEntry, error) { return store.StreamRange("events", "-", "+", 1) },
+			want:        []string{"1001-0"},
+		},
+		{
+			description: "TestReadAfter",
+			read:        func() ([]StreamEntry, error) { return store.StreamRead("events", "1002-0", 0) },
+			want:        []string{"1003-0", "1004-0"},
+		},
+		{
+			description: "TestReadEnd",
+			read:        func() ([]StreamEntry, error) { return store.StreamRead("events", "$", 0) },
+			want:        []string{},
+		},
+		{
+			description: "TestTail",
+			read:        func() ([]StreamEntry, error) { return store.StreamTail("events", 2) },
+			want:        []string{"1003-0", "1004-0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			got, err := tt.read()
+			if err != nil {
+				t.Fatalf("read returned an error: %v", err)
+			}
+			ids := entryIDs(got)
+			if len(ids) != len(tt.want) {
+				t.Fatalf("read = %v, want %v", ids, tt.want)
+			}
+			for i := range ids {
+				if ids[i] != tt.want[i] {
+					t.Errorf("read = %v, want %v", ids, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	if _, err := store.StreamRange("events", "x", "+", 0); !errors.Is(err, helpers.InvalidParamError) {
+		t.Errorf("StreamRange() with an invalid ID error = %v, want %v", err, helpers.InvalidParamError)
+	}
+	if _, err := store.StreamTail("missing", 1); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("StreamTail() on a missing stream error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	store := NewKeyValueStore()
+
+	for range 5 {
+		store.StreamAdd("events", []byte(`1`), 3)
+	}
+	if info, _ := store.StreamInfo("events"); info.Length != 3 {
+		t.Errorf("StreamInfo() after StreamAdd() with maxlen = %+v, want length 3", info)
+	}
+
+	if n, _ := store.StreamTrim("events", 1); n != 2 {
+		t.Errorf("StreamTrim() = %v, want 2", n)
+	}
+	if info, _ := store.StreamInfo("events"); info.Length != 1 || info.FirstID != info.LastID {
+		t.Errorf("StreamInfo() after StreamTrim() = %+v, want a single entry", info)
+	}
+}
+
+func TestConsumerGroups(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	store.StreamAdd("events", []byte(`"old"`), 0)
+	if err := store.CreateGroup("events", "workers", "$"); err != nil {
+		t.Fatalf("CreateGroup() returned an error: %v", err)
+	}
+	if err := store.CreateGroup("events", "workers", "$"); !errors.Is(err, helpers.DuplicateKeyError) {
+		t.Errorf("CreateGroup() twice error = %v, want %v", err, helpers.DuplicateKeyError)
+	}
+
+	for _, v := range []string{`"a"`, `"b"`, `"c"`} {
+		store.StreamAdd("events", []byte(v), 0)
+	}
+
+	// Consumers of a group split the entries between them, skipping those added before the group
+	first, _ := store.ReadGroup("events", "workers", "alice", 2)
+	second, _ := store.ReadGroup("events", "workers", "bob", 0)
+	if len(first) != 2 || first[0].Value != "a" || len(second) != 1 || second[0].Value != "c" {
+		t.Fatalf("ReadGroup() = %v and %v, want a, b and c", first, second)
+	}
+
+	if n, _ := store.StreamAck("events", "workers", []string{first[0].ID, first[0].ID}); n != 1 {
+		t.Errorf("StreamAck() = %v, want 1", n)
+	}
+	pending, _ := store.Pending("events", "workers")
+	if len(pending) != 2 || pending[0].ID != first[1].ID || pending[0].Consumer != "alice" {
+		t.Fatalf("Pending() = %+v, want b for alice and c for bob", pending)
+	}
+
+	// alice dies, bob takes over her entry once it has been idle long enough
+	if claimed, _ := store.Claim("events", "workers", "bob", time.Minute, nil); len(claimed) != 0 {
+		t.Errorf("Claim() before min idle = %v, want none", claimed)
+	}
+	now = now.Add(2 * time.Minute)
+	claimed, err := store.Claim("events", "workers", "bob", time.Minute, []string{first[1].ID})
+	if err != nil || len(claimed) != 1 || claimed[0].Value != "b" {
+		t.Fatalf("Claim() = %v, %v, want b", claimed, err)
+	}
+	pending, _ = store.Pending("events", "workers")
+	if pending[0].Consumer != "bob" || pending[0].Deliveries != 2 {
+		t.Errorf("Pending() after Claim() = %+v, want b delivered twice to bob", pending[0])
+	}
+
+	// A group reading from the start sees every entry
+	store.CreateGroup("events", "replay", "0")
+	if all, _ := store.ReadGroup("events", "replay", "carol", 0); len(all) != 4 {
+		t.Errorf("ReadGroup() from the start = %v, want 4 entries", all)
+	}
+
+	if _, err := store.ReadGroup("events", "missing", "alice", 0); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("ReadGroup() on a missing group error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestClaimTrimmedEntries(t *testing.T) {
+	store := NewKeyValueStore()
+
+	store.StreamAdd("events", []byte(`1`), 0)
+	store.CreateGroup("events", "workers", "0")
+	store.ReadGroup("events", "workers", "alice", 0)
+	store.StreamTrim("events", 0)
+
+	if claimed, _ := store.Claim("events", "workers", "bob", 0, nil); len(claimed) != 0 {
+		t.Errorf("Claim() of a trimmed entry = %v, want none", claimed)
+	}
+	if pending, _ := store.Pending("events", "workers"); len(pending) != 0 {
+		t.Errorf("Pending() after claiming a trimmed entry = %v, want none", pending)
+	}
+}
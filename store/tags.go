@@ -0,0 +1,247 @@
+package store
+
+import (
+	"fmt"
+	"kvstore/helpers"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Tag is a single name=value label. An empty Value in a selector matches any value of Name.
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// TagSelector matches keys by their tags. It is a list of alternatives (OR), each of which is a list of
+// tags that must all be present (AND). The string form is "env=prod,owner=payments|env=staging".
+type TagSelector [][]Tag
+
+// ParseTagSelector parses the string form of a TagSelector.
+func ParseTagSelector(s string) (TagSelector, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("%w: empty tag selector", helpers.InvalidParamError)
+	}
+
+	var sel TagSelector
+	for _, group := range strings.Split(s, "|") {
+		var and []Tag
+		for _, term := range strings.Split(group, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(term), "=")
+			if name == "" {
+				return nil, fmt.Errorf("%w: invalid tag selector %q", helpers.InvalidParamError, s)
+			}
+			and = append(and, Tag{Name: name, Value: value})
+		}
+		sel = append(sel, and)
+	}
+	return sel, nil
+}
+
+// Matches reports whether a set of tags satisfies the selector.
+func (sel TagSelector) Matches(tags map[string]string) bool {
+	for _, and := range sel {
+		if matchesAll(and, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(and []Tag, tags map[string]string) bool {
+	for _, t := range and {
+		v, ok := tags[t.Name]
+		if !ok || (t.Value != "" && v != t.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tags returns the tags of key.
+func (s *KVStore) Tags(key string) (map[string]string, error) {
+
+	if !s.live(key) {
+		return nil, helpers.NotExistError
+	}
+	return maps.Clone(s.meta[key].tagsOrEmpty()), nil
+}
+
+// SetTags adds tags to key, replacing the value of any tag that is already set, and returns the full set.
+func (s *KVStore) SetTags(key string, tags map[string]string) (map[string]string, error) {
+
+	if !s.live(key) {
+		return nil, helpers.NotExistError
+	}
+	m, ok := s.writable(key)
+	if !ok {
+		return nil, helpers.NotExistError
+	}
+
+	for name := range tags {
+		if name == "" {
+			return nil, fmt.Errorf("%w: empty tag name", helpers.InvalidParamError)
+		}
+	}
+
+	if m.tags == nil {
+		m.tags = make(map[string]string, len(tags))
+	}
+	for name, value := range tags {
+		if old, ok := m.tags[name]; ok {
+			s.unindexTag(key, name, old)
+		}
+		m.tags[name] = value
+		s.indexTag(key, name, value)
+	}
+	s.changed(key)
+
+	return maps.Clone(m.tags), nil
+}
+
+// RemoveTags removes the named tags from key and returns the remaining set.
+func (s *KVStore) RemoveTags(key string, names []string) (map[string]string, error) {
+
+	if !s.live(key) {
+		return nil, helpers.NotExistError
+	}
+	m, ok := s.writable(key)
+	if !ok {
+		return nil, helpers.NotExistError
+	}
+
+	for _, name := range names {
+		if value, ok := m.tags[name]; ok {
+			s.unindexTag(key, name, value)
+			delete(m.tags, name)
+		}
+	}
+	s.changed(key)
+
+	return maps.Clone(m.tagsOrEmpty()), nil
+}
+
+// FindByTags returns the sorted keys matching the selector. Expired keys match nothing.
+func (s *KVStore) FindByTags(sel TagSelector) ([]string, error) {
+
+	now := s.now()
+	found := make(map[string]struct{})
+	for _, and := range sel {
+		if len(and) == 0 {
+			continue
+		}
+
+		// Start from the smallest index entry and check the rest of the group against each key's tags
+		candidates := s.indexed(and[0])
+		for _, t := range and[1:] {
+			if c := s.indexed(t); len(c) < len(candidates) {
+				candidates = c
+			}
+		}
+		for k := range candidates {
+			if m := s.meta[k]; !m.expired(now) && matchesAll(and, m.tags) {
+				found[k] = struct{}{}
+			}
+		}
+	}
+
+	return slices.Sorted(maps.Keys(found)), nil
+}
+
+// CountByTags returns the number of keys matching the selector.
+func (s *KVStore) CountByTags(sel TagSelector) (int, error) {
+
+	keys, err := s.FindByTags(sel)
+	return len(keys), err
+}
+
+// DeleteByTags deletes every key matching the selector and returns the deleted entries.
+// With soft set the keys are moved to the trash instead.
+func (s *KVStore) DeleteByTags(sel TagSelector, soft bool) ([]Entry, error) {
+
+	keys, err := s.FindByTags(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make([]Entry, 0, len(keys))
+	for _, k := range keys {
+		deleted = append(deleted, Entry{Key: k, Value: s.store[k], Metadata: s.meta[k].snapshot()})
+		if soft {
+			s.SoftDelete(k)
+		} else {
+			s.Delete(k)
+		}
+	}
+
+	return deleted, nil
+}
+
+func (m *meta) tagsOrEmpty() map[string]string {
+	if m.tags == nil {
+		return map[string]string{}
+	}
+	return m.tags
+}
+
+// indexed returns the keys carrying tag t. A tag without a value matches any value of its name.
+func (s *KVStore) indexed(t Tag) map[string]struct{} {
+	if t.Value != "" {
+		return s.tagIndex[t.Name+"="+t.Value]
+	}
+	return s.tagNames[t.Name]
+}
+
+func (s *KVStore) indexTag(key, name, value string) {
+	addToSet(s.tagIndex, name+"="+value, key)
+	addToSet(s.tagNames, name, key)
+}
+
+func (s *KVStore) unindexTag(key, name, value string) {
+	removeFromSet(s.tagIndex, name+"="+value, key)
+	removeFromSet(s.tagNames, name, key)
+}
+
+// indexTags adds every tag of key to the index, used when a key comes back from the trash.
+func (s *KVStore) indexTags(key string, tags map[string]string) {
+	for name, value := range tags {
+		s.indexTag(key, name, value)
+	}
+}
+
+// replaceTags gives key the tags, in place of those it had.
+func (s *KVStore) replaceTags(key string, tags map[string]string) {
+	m, ok := s.writable(key)
+	if !ok {
+		return
+	}
+	s.unindexTags(key, m.tags)
+	m.tags = maps.Clone(tags)
+	s.indexTags(key, m.tags)
+	s.changed(key)
+}
+
+// unindexTags removes every tag of key from the index, used when a key leaves the store.
+func (s *KVStore) unindexTags(key string, tags map[string]string) {
+	for name, value := range tags {
+		s.unindexTag(key, name, value)
+	}
+}
+
+func addToSet(sets map[string]map[string]struct{}, name, key string) {
+	set, ok := sets[name]
+	if !ok {
+		set = make(map[string]struct{})
+		sets[name] = set
+	}
+	set[key] = struct{}{}
+}
+
+func removeFromSet(sets map[string]map[string]struct{}, name, key string) {
+	set := sets[name]
+	delete(set, key)
+	if len(set) == 0 {
+		delete(sets, name)
+	}
+}
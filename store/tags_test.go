@@ -0,0 +1,197 @@
+package store
+
+import (
+	"errors"
+	"kvstore/helpers"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTaggedStore() *KVStore {
+	store := NewKeyValueStore()
+	store.InitData()
+	store.SetTags("TestString", map[string]string{"env": "prod", "owner": "payments"})
+	store.SetTags("TestNumber", map[string]string{"env": "prod", "owner": "search"})
+	store.SetTags("TestMap", map[string]string{"env": "staging"})
+	return store
+}
+
+func TestParseTagSelector(t *testing.T) {
+	tests := []struct {
+		description string
+		selector    string
+		want        TagSelector
+		wantErr     error
+	}{
+		{
+			description: "TestSingle",
+			selector:    "env=prod",
+			want:        TagSelector{{{"env", "prod"}}},
+		},
+		{
+			description: "TestAndOr",
+			selector:    "env=prod, owner=payments|env=staging",
+			want:        TagSelector{{{"env", "prod"}, {"owner", "payments"}}, {{"env", "staging"}}},
+		},
+		{
+			description: "TestNameOnly",
+			selector:    "owner",
+			want:        TagSelector{{{"owner", ""}}},
+		},
+		{
+			description: "TestEmpty",
+			selector:    "",
+			wantErr:     helpers.InvalidParamError,
+		},
+		{
+			description: "TestMissingName",
+			selector:    "env=prod,=x",
+			wantErr:     helpers.InvalidParamError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			got, err := ParseTagSelector(tt.selector)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseTagSelector() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseTagSelector() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !slices.Equal(got[i], tt.want[i]) {
+					t.Errorf("ParseTagSelector()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFindByTags(t *testing.T) {
+	store := newTaggedStore()
+
+	tests := []struct {
+		description string
+		selector    string
+		want        []string
+	}{
+		{
+			description: "TestAnd",
+			selector:    "env=prod,owner=payments",
+			want:        []string{"TestString"},
+		},
+		{
+			description: "TestOr",
+			selector:    "owner=search|env=staging",
+			want:        []string{"TestMap", "TestNumber"},
+		},
+		{
+			description: "TestAnyValue",
+			selector:    "owner",
+			want:        []string{"TestNumber", "TestString"},
+		},
+		{
+			description: "TestNoMatch",
+			selector:    "env=dev",
+			want:        []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			sel, _ := ParseTagSelector(tt.selector)
+			got, _ := store.FindByTags(sel)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("FindByTags() = %v, want %v", got, tt.want)
+			}
+			if count, _ := store.CountByTags(sel); count != len(tt.want) {
+				t.Errorf("CountByTags() = %v, want %v", count, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestTagIndexConsistency(t *testing.T) {
+	store := newTaggedStore()
+	prod, _ := ParseTagSelector("env=prod")
+
+	// Changing a tag value moves the key in the index
+	store.SetTags("TestNumber", map[string]string{"env": "dev"})
+	if got, _ := store.FindByTags(prod); !slices.Equal(got, []string{"TestString"}) {
+		t.Errorf("FindByTags() after SetTags() = %v, want [TestString]", got)
+	}
+
+	store.RemoveTags("TestString", []string{"env"})
+	if got, _ := store.FindByTags(prod); len(got) != 0 {
+		t.Errorf("FindByTags() after RemoveTags() = %v, want none", got)
+	}
+
+	// Updating the value keeps the tags
+	store.SetTags("TestString", map[string]string{"env": "prod"})
+	store.Update("TestString", []byte(`"Changed"`))
+	if got, _ := store.FindByTags(prod); !slices.Equal(got, []string{"TestString"}) {
+		t.Errorf("FindByTags() after Update() = %v, want [TestString]", got)
+	}
+
+	// Deleted keys leave the index and come back with a restore
+	store.SoftDelete("TestString")
+	if got, _ := store.FindByTags(prod); len(got) != 0 {
+		t.Errorf("FindByTags() after SoftDelete() = %v, want none", got)
+	}
+	store.Restore("TestString")
+	if got, _ := store.FindByTags(prod); !slices.Equal(got, []string{"TestString"}) {
+		t.Errorf("FindByTags() after Restore() = %v, want [TestString]", got)
+	}
+
+	store.Delete("TestString")
+	store.Add("TestString", []byte(`"New"`))
+	if got, _ := store.FindByTags(prod); len(got) != 0 {
+		t.Errorf("FindByTags() after Delete() and Add() = %v, want none", got)
+	}
+
+	store.Clear()
+	if len(store.tagIndex) != 0 || len(store.tagNames) != 0 {
+		t.Errorf("tag index after Clear() = %v %v, want empty", store.tagIndex, store.tagNames)
+	}
+}
+
+func TestDeleteByTags(t *testing.T) {
+	store := newTaggedStore()
+	prod, _ := ParseTagSelector("env=prod")
+
+	got, err := store.DeleteByTags(prod, true)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("DeleteByTags() = %v, %v, want 2 entries", got, err)
+	}
+	if count, _ := store.Count(); count != 1 {
+		t.Errorf("Count() after DeleteByTags() = %v, want 1", count)
+	}
+	if trash, _ := store.Trash(); len(trash) != 2 {
+		t.Errorf("Trash() after soft DeleteByTags() = %v, want 2 entries", trash)
+	}
+
+	if _, err := store.SetTags("TestString", map[string]string{"env": "prod"}); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("SetTags() on a deleted key error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestTagsOfExpiredKeys(t *testing.T) {
+	store := newTaggedStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	store.Expire("TestString", time.Minute)
+	now = now.Add(time.Minute)
+
+	prod, _ := ParseTagSelector("env=prod")
+	if got, _ := store.FindByTags(prod); !slices.Equal(got, []string{"TestNumber"}) {
+		t.Errorf("FindByTags() = %v, want the key that has not expired", got)
+	}
+	if _, err := store.Tags("TestString"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Tags() of an expired key error = %v, want %v", err, helpers.NotExistError)
+	}
+	if got, _ := store.DeleteByTags(prod, false); len(got) != 1 || got[0].Key != "TestNumber" {
+		t.Errorf("DeleteByTags() = %v, want only the key that has not expired", got)
+	}
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"fmt"
+	"kvstore/helpers"
+	"time"
+)
+
+// DefaultTombstoneRetention is how long the deletion of a key is remembered unless configured otherwise.
+const DefaultTombstoneRetention = 24 * time.Hour
+
+// A deleted key leaves a tombstone: the time it was deleted, kept for TombstoneRetention. A repair
+// against another replica holding the key compares the two, so that a key deleted here after its last
+// write there is deleted there too rather than copied back, see the antientropy package. Writing the
+// key again removes its tombstone.
+
+// bury records that key has just been deleted.
+func (s *KVStore) bury(key string) {
+	s.tombstones[key] = s.now()
+}
+
+// MerkleTombstones returns when each key deleted in the given leaves of the Merkle tree was deleted.
+func (s *KVStore) MerkleTombstones(leaves []int) (map[string]time.Time, error) {
+	want := make(map[int]bool, len(leaves))
+	for _, l := range leaves {
+		if l < 0 || l >= 1<<MerkleDepth {
+			return nil, fmt.Errorf("%w: leaf %d outside the tree", helpers.InvalidParamError, l)
+		}
+		want[l] = true
+	}
+
+	deleted := make(map[string]time.Time)
+	for k, t := range s.tombstones {
+		if want[MerkleLeaf(k)] {
+			deleted[k] = t
+		}
+	}
+	return deleted, nil
+}
+
+// purgeExpiredTombstones forgets deletions older than the retention.
+func (s *KVStore) purgeExpiredTombstones() {
+	if s.TombstoneRetention <= 0 {
+		return
+	}
+	cutoff := s.now().Add(-s.TombstoneRetention)
+	for k, t := range s.tombstones {
+		if t.Before(cutoff) {
+			delete(s.tombstones, k)
+		}
+	}
+}
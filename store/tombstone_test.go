@@ -0,0 +1,38 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTombstones(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	leaves := []int{MerkleLeaf("a"), MerkleLeaf("b")}
+
+	store.Add("a", []byte(`1`))
+	store.Add("b", []byte(`1`))
+	store.Delete("a")
+	if deleted, _ := store.MerkleTombstones(leaves); len(deleted) != 1 || !deleted["a"].Equal(now) {
+		t.Errorf("MerkleTombstones() after Delete() = %v, want a", deleted)
+	}
+
+	// Writing the key again removes its tombstone, and a clear buries every key
+	store.Add("a", []byte(`2`))
+	now = now.Add(time.Hour)
+	store.Clear()
+	if deleted, _ := store.MerkleTombstones(leaves); len(deleted) != 2 || !deleted["a"].Equal(now) {
+		t.Errorf("MerkleTombstones() after Clear() = %v, want a and b", deleted)
+	}
+
+	now = now.Add(store.TombstoneRetention + time.Second)
+	store.Sweep()
+	if deleted, _ := store.MerkleTombstones(leaves); len(deleted) != 0 {
+		t.Errorf("MerkleTombstones() after the retention = %v, want none", deleted)
+	}
+
+	if _, err := store.MerkleTombstones([]int{-1}); err == nil {
+		t.Error("MerkleTombstones() with a negative leaf returned no error")
+	}
+}
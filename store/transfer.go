@@ -0,0 +1,97 @@
+package store
+
+import (
+	"fmt"
+	"kvstore/helpers"
+	"strings"
+)
+
+// ConflictMode decides what Import does when a key already exists.
+type ConflictMode string
+
+const (
+	ConflictSkip      ConflictMode = "skip"      // Keep the existing value
+	ConflictOverwrite ConflictMode = "overwrite" // Replace the existing value
+	ConflictFail      ConflictMode = "fail"      // Return DuplicateKeyError
+)
+
+// ImportResult describes what Import did (or would have done) with a key.
+type ImportResult string
+
+const (
+	ImportAdded       ImportResult = "added"
+	ImportOverwritten ImportResult = "overwritten"
+	ImportSkipped     ImportResult = "skipped"
+)
+
+// ImportOptions controls the behaviour of Import.
+type ImportOptions struct {
+	Mode     ConflictMode
+	DryRun   bool      // Report the outcome without changing the store
+	Metadata *Metadata `json:",omitempty"` // Exported with the key; its tags, expiry and flags are restored
+}
+
+// Entry is a single key/value pair as returned by Export.
+type Entry struct {
+	Key      string
+	Value    any
+	Metadata Metadata
+}
+
+// ParseConflictMode converts a string into a ConflictMode, defaulting to skip.
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch mode := ConflictMode(strings.ToLower(s)); mode {
+	case "":
+		return ConflictSkip, nil
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown conflict mode %q", s)
+	}
+}
+
+// Export returns every entry whose key starts with prefix, sorted by key.
+func (s *KVStore) Export(prefix string) ([]Entry, error) {
+
+	return s.Snapshot().Export(prefix), nil
+}
+
+// Import adds a single key, resolving an existing key according to opts.Mode. A key exported with an
+// expiry that has since passed is skipped.
+func (s *KVStore) Import(key string, v []byte, opts ImportOptions) (ImportResult, error) {
+
+	// Parse the value from JSON
+	value, err := helpers.ParseJSON(v)
+	if err != nil {
+		return "", err // Return early if parsing fails
+	}
+
+	var md Metadata
+	if opts.Metadata != nil {
+		md = *opts.Metadata
+	}
+	if !md.ExpiresAt.IsZero() && !s.now().Before(md.ExpiresAt) {
+		return ImportSkipped, nil
+	}
+
+	result := ImportAdded
+	if s.live(key) {
+		switch opts.Mode {
+		case ConflictOverwrite:
+			result = ImportOverwritten
+		case ConflictFail:
+			return "", helpers.DuplicateKeyError
+		default:
+			return ImportSkipped, nil
+		}
+	}
+
+	if !opts.DryRun {
+		s.putWith(key, value, len(v), md.ExpiresAt, md.Flags)
+		if opts.Metadata != nil {
+			s.replaceTags(key, md.Tags)
+		}
+	}
+
+	return result, nil
+}
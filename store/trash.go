@@ -0,0 +1,235 @@
+package store
+
+import (
+	"fmt"
+	"kvstore/helpers"
+	"slices"
+	"sort"
+	"time"
+)
+
+// DefaultTrashRetention is how long soft deleted keys are kept unless configured otherwise.
+const DefaultTrashRetention = 24 * time.Hour
+
+// TrashEntry is a soft deleted key, kept so it can be restored.
+type TrashEntry struct {
+	Key       string    `json:"key"`
+	Value     any       `json:"value"`
+	DeletedAt time.Time `json:"deleted_at"`
+	ClearID   string    `json:"clear_id,omitempty"` // Set when the key was removed by a soft Clear
+
+	meta *meta // Metadata at the time of deletion, reinstated on restore
+}
+
+// RestoreResult reports the outcome of restoring a soft Clear.
+type RestoreResult struct {
+	Restored  []string `json:"restored"`
+	Conflicts []string `json:"conflicts,omitempty"` // Keys left in the trash because they exist again
+}
+
+// SoftDelete removes a key from the store and moves it to the trash. A key deleted again after a restore
+// or a new write keeps every deleted version in the trash.
+func (s *KVStore) SoftDelete(key string) error {
+
+	if !s.live(key) {
+		return helpers.NotExistError
+	}
+
+	value := s.store[key]
+	s.trash[key] = append(s.trash[key], TrashEntry{Key: key, Value: value, DeletedAt: s.now(), meta: s.remove(key)})
+	s.trashChanged(key)
+	return nil
+}
+
+// SoftClear moves every key to the trash and returns an ID that can be used to restore them together.
+func (s *KVStore) SoftClear() (string, error) {
+
+	s.clearSeq++
+	id := fmt.Sprintf("clear-%d", s.clearSeq)
+	now := s.now()
+
+	for k, v := range s.store {
+		if !s.live(k) {
+			continue // Expired keys are gone already, so there is nothing to restore
+		}
+		s.trash[k] = append(s.trash[k], TrashEntry{Key: k, Value: v, DeletedAt: now, ClearID: id, meta: s.remove(k)})
+		s.trashChanged(k)
+	}
+
+	return id, nil
+}
+
+// Trash lists the soft deleted keys, most recently deleted first. A key deleted more than once is listed
+// once for each deleted version.
+func (s *KVStore) Trash() ([]TrashEntry, error) {
+
+	s.purgeExpiredTrash()
+
+	entries := make([]TrashEntry, 0, len(s.trash))
+	for _, versions := range s.trash {
+		entries = append(entries, versions...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].DeletedAt.Equal(entries[j].DeletedAt) {
+			return entries[i].DeletedAt.After(entries[j].DeletedAt)
+		}
+		return entries[i].Key < entries[j].Key
+	})
+
+	return entries, nil
+}
+
+// Restore moves the most recently deleted version of a key from the trash back into the store.
+func (s *KVStore) Restore(key string) (any, error) {
+
+	s.purgeExpiredTrash()
+
+	versions := s.trash[key]
+	if len(versions) == 0 {
+		return "", helpers.NotExistError
+	}
+
+	if s.live(key) {
+		return "", helpers.DuplicateKeyError
+	}
+
+	e := versions[len(versions)-1]
+	s.restore(e, len(versions)-1)
+
+	return e.Value, nil
+}
+
+// RestoreClear moves every key removed by the soft Clear with the given ID back into the store, as it
+// was when cleared, even if the key was deleted again since. Keys that have been added again since the
+// Clear are left in the trash and reported as conflicts.
+func (s *KVStore) RestoreClear(id string) (RestoreResult, error) {
+
+	s.purgeExpiredTrash()
+
+	result := RestoreResult{Restored: []string{}}
+	for k, versions := range s.trash {
+		i := slices.IndexFunc(versions, func(e TrashEntry) bool { return e.ClearID == id })
+		if i < 0 {
+			continue
+		}
+		if s.live(k) {
+			result.Conflicts = append(result.Conflicts, k)
+			continue
+		}
+		s.restore(versions[i], i)
+		result.Restored = append(result.Restored, k)
+	}
+
+	if len(result.Restored) == 0 && len(result.Conflicts) == 0 {
+		return result, helpers.NotExistError
+	}
+
+	sort.Strings(result.Restored)
+	sort.Strings(result.Conflicts)
+
+	return result, nil
+}
+
+// Purge permanently removes every deleted version of a key from the trash, or the whole trash when key
+// is empty. It returns the number of entries removed.
+func (s *KVStore) Purge(key string) (int, error) {
+
+	if key == "" {
+		n := 0
+		for k, versions := range s.trash {
+			n += len(versions)
+			delete(s.trash, k)
+			s.trashChanged(k)
+		}
+		return n, nil
+	}
+
+	n := len(s.trash[key])
+	if n == 0 {
+		return 0, helpers.NotExistError
+	}
+
+	delete(s.trash, key)
+	s.trashChanged(key)
+	return n, nil
+}
+
+// restore moves e, the trash entry at index i of the versions of its key, back into the store with the
+// metadata it had when deleted.
+func (s *KVStore) restore(e TrashEntry, i int) {
+	m := e.meta
+	switch {
+	case m == nil:
+		m = &meta{createdAt: e.DeletedAt, updatedAt: e.DeletedAt, epoch: s.epoch}
+	case m.epoch != s.epoch:
+		// A snapshot taken before the delete still holds the metadata
+		m = s.copyMeta(m)
+	}
+	// Restoring is a write, newer than the deletion that may have reached other replicas
+	m.updatedAt = s.now()
+
+	s.setKey(e.Key, e.Value, m)
+	s.indexTags(e.Key, m.tags)
+	s.dropTrash(e.Key, i)
+	s.changed(e.Key)
+}
+
+// dropTrash removes the version at index i from the trash of key.
+func (s *KVStore) dropTrash(key string, i int) {
+	versions := slices.Delete(s.trash[key], i, i+1)
+	if len(versions) == 0 {
+		delete(s.trash, key)
+	} else {
+		s.trash[key] = versions
+	}
+	s.trashChanged(key)
+}
+
+// purgeExpiredTrash drops trash entries older than TrashRetention.
+func (s *KVStore) purgeExpiredTrash() {
+	if s.TrashRetention <= 0 {
+		return
+	}
+
+	cutoff := s.now().Add(-s.TrashRetention)
+	for k, versions := range s.trash {
+		n := len(versions)
+		versions = slices.DeleteFunc(versions, func(e TrashEntry) bool { return e.DeletedAt.Before(cutoff) })
+		if len(versions) == n {
+			continue
+		}
+		if len(versions) == 0 {
+			delete(s.trash, k)
+		} else {
+			s.trash[k] = versions
+		}
+		s.trashChanged(k)
+	}
+}
+
+// trashStates returns the deleted versions of key, oldest first, with the metadata they will be restored with.
+func (s *KVStore) trashStates(key string) []TrashState {
+	var states []TrashState
+	for _, e := range s.trash[key] {
+		ts := TrashState{TrashEntry: e}
+		if e.meta != nil {
+			md := e.meta.snapshot()
+			ts.Metadata = &md
+		}
+		states = append(states, ts)
+	}
+	return states
+}
+
+// loadTrash replaces the deleted versions of key with states.
+func (s *KVStore) loadTrash(key string, states []TrashState) {
+	delete(s.trash, key)
+	for _, ts := range states {
+		e := ts.TrashEntry
+		if ts.Metadata != nil {
+			e.meta = newMeta(*ts.Metadata)
+		}
+		s.trash[key] = append(s.trash[key], e)
+	}
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"errors"
+	"kvstore/helpers"
+	"testing"
+	"time"
+)
+
+func TestSoftDelete(t *testing.T) {
+	store := NewKeyValueStore()
+	store.InitData()
+
+	if err := store.SoftDelete("TestString"); err != nil {
+		t.Fatalf("SoftDelete() returned an error: %v", err)
+	}
+
+	if _, err := store.Get("TestString"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Get() after SoftDelete() error = %v, want %v", err, helpers.NotExistError)
+	}
+	if ok, _ := store.Exists("TestString"); ok {
+		t.Errorf("Exists() after SoftDelete() = %v, want false", ok)
+	}
+	if count, _ := store.Count(); count != 2 {
+		t.Errorf("Count() after SoftDelete() = %v, want 2", count)
+	}
+
+	trash, _ := store.Trash()
+	if len(trash) != 1 || trash[0].Key != "TestString" || trash[0].Value != "Value1" {
+		t.Errorf("Trash() = %v, want TestString", trash)
+	}
+
+	if err := store.SoftDelete("NotExist"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("SoftDelete() error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	tests := []struct {
+		description string
+		readd       bool
+		key         string
+		want        any
+	}{
+		{
+			description: "TestRestore",
+			key:         "TestString",
+			want:        "Value1",
+		},
+		{
+			description: "TestRestoreConflict",
+			key:         "TestString",
+			readd:       true,
+			want:        helpers.DuplicateKeyError,
+		},
+		{
+			description: "TestRestoreNotInTrash",
+			key:         "TestNumber",
+			want:        helpers.NotExistError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			store := NewKeyValueStore()
+			store.InitData()
+			store.SoftDelete("TestString")
+			if tt.readd {
+				store.Add("TestString", []byte(`"Again"`))
+			}
+
+			got, err := store.Restore(tt.key)
+			switch expected := tt.want.(type) {
+			case string:
+				if got != expected {
+					t.Errorf("Restore() = %v, want %v", got, expected)
+				}
+				if v, _ := store.Get(tt.key); v != expected {
+					t.Errorf("Get() after Restore() = %v, want %v", v, expected)
+				}
+			case error:
+				if !errors.Is(err, expected) {
+					t.Errorf("Restore() error = %v, want %v", err, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreClear(t *testing.T) {
+	store := NewKeyValueStore()
+	store.InitData()
+
+	id, err := store.SoftClear()
+	if err != nil {
+		t.Fatalf("SoftClear() returned an error: %v", err)
+	}
+	if count, _ := store.Count(); count != 0 {
+		t.Errorf("Count() after SoftClear() = %v, want 0", count)
+	}
+
+	store.Add("TestNumber", []byte(`5`))
+
+	got, err := store.RestoreClear(id)
+	if err != nil {
+		t.Fatalf("RestoreClear() returned an error: %v", err)
+	}
+	if len(got.Restored) != 2 || len(got.Conflicts) != 1 || got.Conflicts[0] != "TestNumber" {
+		t.Errorf("RestoreClear() = %+v, want 2 restored and TestNumber in conflict", got)
+	}
+	if v, _ := store.Get("TestNumber"); v != float64(5) {
+		t.Errorf("Get() after RestoreClear() = %v, want 5", v)
+	}
+
+	if _, err := store.RestoreClear("clear-unknown"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("RestoreClear() error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestTrashRetention(t *testing.T) {
+	store := NewKeyValueStore()
+	store.InitData()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	store.TrashRetention = time.Hour
+
+	store.SoftDelete("TestString")
+	now = now.Add(30 * time.Minute)
+	store.SoftDelete("TestNumber")
+	now = now.Add(45 * time.Minute)
+
+	store.Sweep()
+
+	trash, _ := store.Trash()
+	if len(trash) != 1 || trash[0].Key != "TestNumber" {
+		t.Errorf("Trash() after Sweep() = %v, want only TestNumber", trash)
+	}
+}
+
+func TestTrashExpiredKeys(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	store.Add("c", []byte(`4`))
+	id, _ := store.SoftClear()
+	store.Add("c", []byte(`5`))
+	store.Expire("c", time.Minute)
+	store.Add("a", []byte(`1`))
+	store.SoftDelete("a")
+	store.Add("a", []byte(`2`))
+	store.Expire("a", time.Minute)
+	store.Add("b", []byte(`3`))
+	store.Expire("b", time.Minute)
+	now = now.Add(time.Minute)
+
+	// Expired keys can no longer be deleted, and no longer stand in the way of a restore
+	if err := store.SoftDelete("b"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("SoftDelete() of an expired key error = %v, want %v", err, helpers.NotExistError)
+	}
+	if v, err := store.Restore("a"); err != nil || v != float64(1) {
+		t.Errorf("Restore() over an expired key = %v, %v, want 1", v, err)
+	}
+	if res, err := store.RestoreClear(id); err != nil || len(res.Conflicts) != 0 {
+		t.Errorf("RestoreClear() over an expired key = %+v, %v, want no conflicts", res, err)
+	}
+	if v, _ := store.Get("c"); v != float64(4) {
+		t.Errorf("Get(c) after RestoreClear() = %v, want 4", v)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	store := NewKeyValueStore()
+	store.InitData()
+	store.SoftClear()
+
+	if n, err := store.Purge("TestString"); err != nil || n != 1 {
+		t.Errorf("Purge() = %v, %v, want 1", n, err)
+	}
+	if _, err := store.Purge("TestString"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Purge() error = %v, want %v", err, helpers.NotExistError)
+	}
+	if n, err := store.Purge(""); err != nil || n != 2 {
+		t.Errorf("Purge() = %v, %v, want 2", n, err)
+	}
+}
+
+func TestTrashVersions(t *testing.T) {
+	store := NewKeyValueStore()
+	store.InitData()
+
+	id, _ := store.SoftClear()
+	store.Add("TestString", []byte(`"Again"`))
+	store.SoftDelete("TestString")
+
+	if trash, _ := store.Trash(); len(trash) != 4 {
+		t.Errorf("Trash() = %v, want 4 entries, two of TestString", trash)
+	}
+
+	// The clear restores the version it removed, not the one deleted since
+	got, err := store.RestoreClear(id)
+	if err != nil || len(got.Restored) != 3 {
+		t.Fatalf("RestoreClear() = %+v, %v, want 3 restored", got, err)
+	}
+	if v, _ := store.Get("TestString"); v != "Value1" {
+		t.Errorf("Get() after RestoreClear() = %v, want Value1", v)
+	}
+
+	store.Delete("TestString")
+	if v, err := store.Restore("TestString"); err != nil || v != "Again" {
+		t.Errorf("Restore() = %v, %v, want the version deleted last", v, err)
+	}
+	if _, err := store.Restore("TestString"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("second Restore() error = %v, want %v", err, helpers.NotExistError)
+	}
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"reflect"
+	"strings"
+)
+
+// Codec converts between a Go type and the JSON document the store keeps for it.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error) // Must return a JSON document
+	Decode(data []byte, v *T) error
+}
+
+// JSONCodec encodes values with encoding/json. A Strict codec fails to decode documents with fields
+// T does not have, instead of dropping them.
+type JSONCodec[T any] struct {
+	Strict bool
+}
+
+func (c JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c JSONCodec[T]) Decode(data []byte, v *T) error {
+	if !c.Strict {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// DecodeError reports a stored value that does not decode into the type asked for.
+type DecodeError struct {
+	Key  string
+	Type reflect.Type
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("store: value of %q does not decode into %s: %s", e.Key, e.Type, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decode converts value, as returned by Get, into a T with codec. It fails with a *DecodeError naming
+// key if the value does not fit.
+func Decode[T any](codec Codec[T], key string, value any) (T, error) {
+	var v T
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = codec.Decode(data, &v)
+	}
+	if err != nil {
+		return v, &DecodeError{Key: key, Type: reflect.TypeFor[T](), Err: err}
+	}
+	return v, nil
+}
+
+// ScanAs yields every key starting with prefix in snap, in key order, without the prefix and with its
+// value decoded into a T. The scan stops at the first value that does not decode; the returned
+// function then reports its *DecodeError.
+func ScanAs[T any](snap *Snapshot, prefix string, codec Codec[T]) (iter.Seq2[string, T], func() error) {
+	var err error
+	seq := func(yield func(string, T) bool) {
+		err = nil
+		for k, item := range snap.Scan(prefix) {
+			var v T
+			if v, err = Decode(codec, k, item.Value); err != nil {
+				return
+			}
+			if !yield(strings.TrimPrefix(k, prefix), v) {
+				return
+			}
+		}
+	}
+	return seq, func() error { return err }
+}
+
+// TypedStore is a view of the keys of a store under a prefix as values of type T. Keys are given
+// without the prefix. Like the store, it must only be used by the goroutine that owns the store.
+type TypedStore[T any] struct {
+	store  *KVStore
+	prefix string
+	codec  Codec[T]
+}
+
+// NewTypedStore returns the view of the keys of s under prefix, encoded with codec, or as JSON if nil.
+func NewTypedStore[T any](s *KVStore, prefix string, codec Codec[T]) *TypedStore[T] {
+	if codec == nil {
+		codec = JSONCodec[T]{}
+	}
+	return &TypedStore[T]{store: s, prefix: prefix, codec: codec}
+}
+
+// Get returns the value of id.
+func (t *TypedStore[T]) Get(id string) (T, error) {
+	value, err := t.store.Get(t.prefix + id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return Decode(t.codec, t.prefix+id, value)
+}
+
+// Add stores v under a new id. It fails with helpers.DuplicateKeyError if id exists.
+func (t *TypedStore[T]) Add(id string, v T) error {
+	return t.write(id, v, t.store.Add)
+}
+
+// Update replaces the value of an existing id. It fails with helpers.NotExistError if there is none.
+func (t *TypedStore[T]) Update(id string, v T) error {
+	return t.write(id, v, t.store.Update)
+}
+
+// Put stores v under id, whether it exists or not.
+func (t *TypedStore[T]) Put(id string, v T) error {
+	return t.write(id, v, t.store.Upsert)
+}
+
+func (t *TypedStore[T]) write(id string, v T, fn func(string, []byte) (any, error)) error {
+	data, err := t.codec.Encode(v)
+	if err != nil {
+		return fmt.Errorf("store: encoding %q: %w", t.prefix+id, err)
+	}
+	_, err = fn(t.prefix+id, data)
+	return err
+}
+
+// Delete removes id.
+func (t *TypedStore[T]) Delete(id string) error {
+	return t.store.Delete(t.prefix + id)
+}
+
+// Scan yields every id with its value, in order, from a snapshot taken now. See ScanAs.
+func (t *TypedStore[T]) Scan() (iter.Seq2[string, T], func() error) {
+	return ScanAs(t.store.Snapshot(), t.prefix, t.codec)
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kvstore/helpers"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type account struct {
+	Name    string `json:"name"`
+	Balance int    `json:"balance"`
+}
+
+func TestTypedStore(t *testing.T) {
+	s := NewKeyValueStore()
+	accounts := NewTypedStore[account](s, "account:", nil)
+
+	if err := accounts.Add("1", account{"ada", 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.Add("1", account{}); !errors.Is(err, helpers.DuplicateKeyError) {
+		t.Errorf("Add() of an existing id = %v, want DuplicateKeyError", err)
+	}
+	accounts.Put("2", account{"bob", 20})
+	if err := accounts.Update("3", account{}); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Update() of a missing id = %v, want NotExistError", err)
+	}
+
+	if a, err := accounts.Get("1"); err != nil || a != (account{"ada", 10}) {
+		t.Errorf("Get(1) = %+v, %v", a, err)
+	}
+	if _, ok := s.Peek("account:2"); !ok {
+		t.Error("Put(2) did not store account:2")
+	}
+
+	s.Upsert("other:1", []byte(`"not an account"`))
+	seq, errf := accounts.Scan()
+	got := maps.Collect(seq)
+	want := map[string]account{"1": {"ada", 10}, "2": {"bob", 20}}
+	if err := errf(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan() = %v, %v, want %v", got, err, want)
+	}
+
+	accounts.Delete("1")
+	if _, err := accounts.Get("1"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Get() of a deleted id = %v, want NotExistError", err)
+	}
+}
+
+func TestTypedStoreDecodeErrors(t *testing.T) {
+	s := NewKeyValueStore()
+	s.Upsert("account:1", []byte(`{"name":"ada","balance":1}`))
+	s.Upsert("account:2", []byte(`{"name":"bob","balance":"lots"}`))
+	s.Upsert("account:3", []byte(`{"name":"cy","balance":3}`))
+
+	accounts := NewTypedStore[account](s, "account:", nil)
+	_, err := accounts.Get("2")
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Key != "account:2" || de.Type != reflect.TypeFor[account]() {
+		t.Fatalf("Get() of a bad value = %v, want a DecodeError for account:2", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, `"account:2"`) || !strings.Contains(msg, "store.account") {
+		t.Errorf("error %q does not name the key and type", msg)
+	}
+
+	seq, errf := accounts.Scan()
+	var ids []string
+	for id := range seq {
+		ids = append(ids, id)
+	}
+	if !slices.Equal(ids, []string{"1"}) || !errors.As(errf(), &de) {
+		t.Errorf("Scan() yielded %v, %v, want to stop at account:2", ids, errf())
+	}
+
+	strict := NewTypedStore[account](s, "account:", JSONCodec[account]{Strict: true})
+	s.Upsert("account:4", []byte(`{"name":"di","balance":4,"extra":true}`))
+	if _, err := strict.Get("4"); !errors.As(err, &de) {
+		t.Errorf("strict Get() of a value with an unknown field = %v, want a DecodeError", err)
+	}
+}
+
+// centsCodec stores amounts as JSON strings of cents.
+type centsCodec struct{}
+
+func (centsCodec) Encode(v float64) ([]byte, error) {
+	return json.Marshal(strconv.Itoa(int(v*100 + 0.5)))
+}
+
+func (centsCodec) Decode(data []byte, v *float64) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	cents, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("not cents: %w", err)
+	}
+	*v = float64(cents) / 100
+	return nil
+}
+
+func TestTypedStoreCodec(t *testing.T) {
+	s := NewKeyValueStore()
+	prices := NewTypedStore[float64](s, "price:", centsCodec{})
+	prices.Put("tea", 2.5)
+
+	if raw, _ := s.Get("price:tea"); raw != "250" {
+		t.Errorf("stored %v, want the codec's encoding 250", raw)
+	}
+	if v, err := prices.Get("tea"); err != nil || v != 2.5 {
+		t.Errorf("Get() = %v, %v, want 2.5", v, err)
+	}
+}
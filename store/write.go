@@ -0,0 +1,70 @@
+package store
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"kvstore/helpers"
+	"time"
+)
+
+// WriteOptions are the conditions and extras of a Write. The zero value writes the key whether or not
+// it exists, as Upsert does.
+type WriteOptions struct {
+	IfMissing bool          `json:"if_missing,omitempty"` // Fail with DuplicateKeyError if the key exists, as Add does
+	IfExists  bool          `json:"if_exists,omitempty"`  // Fail with NotExistError unless the key exists, as Update does
+	Revision  uint64        `json:"revision,omitempty"`   // Fail with RevisionMismatchError unless the key is at this revision
+	TTL       time.Duration `json:"ttl,omitempty"`        // Expire the key once this has passed; negative removes it at once
+	KeepTTL   bool          `json:"keep_ttl,omitempty"`   // Keep the expiry the key has instead of TTL
+	Flags     uint32        `json:"flags,omitempty"`      // Kept with the value, see Metadata.Flags
+}
+
+// Write stores v under key if the conditions of opts hold. It is the write of the Redis and memcached
+// protocols, whose commands carry a time to live or flags with the value.
+func (s *KVStore) Write(key string, v []byte, opts WriteOptions) (any, error) {
+
+	exists := s.live(key)
+	switch {
+	case opts.IfMissing && exists:
+		return "", helpers.DuplicateKeyError
+	case (opts.IfExists || opts.Revision != 0) && !exists:
+		return "", helpers.NotExistError
+	case opts.Revision != 0 && s.meta[key].snapshot().Revision() != opts.Revision:
+		return "", helpers.RevisionMismatchError
+	}
+
+	value, err := helpers.ParseJSON(v)
+	if err != nil {
+		return "", err // Return early if parsing fails
+	}
+
+	var expiresAt time.Time
+	switch {
+	case opts.KeepTTL && exists:
+		expiresAt = s.meta[key].expiresAt
+	case opts.TTL < 0:
+		// Written and expired at once, which leaves the key gone
+		if exists {
+			s.remove(key)
+		}
+		return value, nil
+	case opts.TTL > 0:
+		expiresAt = s.now().Add(opts.TTL)
+	}
+	s.putWith(key, value, len(v), expiresAt, opts.Flags)
+
+	return value, nil
+}
+
+// Revision identifies the value of a key as it was written. It changes with every write, but not with
+// changes to expiry or tags, and is the same on every server holding the same write, so it serves as
+// the token of a compare-and-swap. It is never zero.
+func (md Metadata) Revision() uint64 {
+	var b [24]byte
+	binary.LittleEndian.PutUint64(b[0:], uint64(md.CreatedAt.UnixNano()))
+	binary.LittleEndian.PutUint64(b[8:], uint64(md.UpdatedAt.UnixNano()))
+	binary.LittleEndian.PutUint64(b[16:], uint64(md.WriteCount))
+
+	h := fnv.New64a()
+	h.Write(b[:])
+	return max(h.Sum64(), 1)
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"kvstore/helpers"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	store := NewKeyValueStore()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	if _, err := store.Write("Key", []byte(`"Value"`), WriteOptions{IfExists: true}); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Write(IfExists) of a missing key error = %v, want %v", err, helpers.NotExistError)
+	}
+	if _, err := store.Write("Key", []byte(`"Value"`), WriteOptions{IfMissing: true, TTL: time.Minute, Flags: 5}); err != nil {
+		t.Fatalf("Write(IfMissing) = %v", err)
+	}
+	if _, err := store.Write("Key", []byte(`"Other"`), WriteOptions{IfMissing: true}); !errors.Is(err, helpers.DuplicateKeyError) {
+		t.Errorf("Write(IfMissing) of an existing key error = %v, want %v", err, helpers.DuplicateKeyError)
+	}
+	md, _ := store.Meta("Key")
+	if md.Flags != 5 || !md.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Meta() = %+v, want flags 5 expiring in a minute", md)
+	}
+
+	// A write at the revision read succeeds once, and moves the key to a new revision
+	rev := md.Revision()
+	now = now.Add(time.Second)
+	if _, err := store.Write("Key", []byte(`"Swapped"`), WriteOptions{Revision: rev, KeepTTL: true}); err != nil {
+		t.Errorf("Write(Revision) = %v", err)
+	}
+	if _, err := store.Write("Key", []byte(`"Stale"`), WriteOptions{Revision: rev}); !errors.Is(err, helpers.RevisionMismatchError) {
+		t.Errorf("Write(Revision) of a changed key error = %v, want %v", err, helpers.RevisionMismatchError)
+	}
+	md, _ = store.Meta("Key")
+	if v, _ := store.Get("Key"); v != "Swapped" || md.Revision() == rev || !md.ExpiresAt.Equal(now.Add(time.Minute-time.Second)) {
+		t.Errorf("Get() = %v with %+v, want Swapped at a new revision keeping its expiry", v, md)
+	}
+
+	// A write without an expiry clears the one the key had, and a negative one removes the key
+	store.Write("Key", []byte(`"Plain"`), WriteOptions{})
+	if md, _ := store.Meta("Key"); !md.ExpiresAt.IsZero() || md.Flags != 0 {
+		t.Errorf("Meta() after a plain Write() = %+v, want no expiry or flags", md)
+	}
+	if _, err := store.Write("Key", []byte(`"Gone"`), WriteOptions{TTL: -1}); err != nil {
+		t.Errorf("Write(TTL: -1) = %v", err)
+	}
+	if _, err := store.Get("Key"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Get() after Write(TTL: -1) error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	store := NewKeyValueStore()
+	store.Add("Key", []byte(`1`))
+
+	if ok, err := store.Persist("Key"); ok || err != nil {
+		t.Errorf("Persist() of a key without expiry = %v, %v, want false", ok, err)
+	}
+	store.Expire("Key", time.Minute)
+	if ok, err := store.Persist("Key"); !ok || err != nil {
+		t.Errorf("Persist() = %v, %v, want true", ok, err)
+	}
+	if md, _ := store.Meta("Key"); !md.ExpiresAt.IsZero() {
+		t.Errorf("Meta().ExpiresAt after Persist() = %v, want none", md.ExpiresAt)
+	}
+	if _, err := store.Persist("Missing"); !errors.Is(err, helpers.NotExistError) {
+		t.Errorf("Persist() of a missing key error = %v, want %v", err, helpers.NotExistError)
+	}
+}
+
+func TestEncodeBytes(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte("hello"), `"hello"`},
+		{[]byte(""), `""`},
+		{[]byte("\xff\x00"), `{"$base64":"/wA="}`},
+	}
+	for _, tt := range tests {
+		got := EncodeBytes(tt.in)
+		if string(got) != tt.want {
+			t.Errorf("EncodeBytes(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+		value, _ := helpers.ParseJSON(got)
+		if back := DecodeBytes(value); !bytes.Equal(back, tt.in) {
+			t.Errorf("DecodeBytes(%v) = %q, want %q", value, back, tt.in)
+		}
+	}
+
+	if got := DecodeBytes(map[string]any{"a": 1.0}); string(got) != `{"a":1}` {
+		t.Errorf("DecodeBytes() of a document = %s, want its JSON", got)
+	}
+}
@@ -0,0 +1,298 @@
+// Package transfer provides streaming NDJSON and CSV encoding of the keyspace for import and export.
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"kvstore/helpers"
+	"kvstore/store"
+	"strings"
+)
+
+// Format is a supported import/export encoding.
+type Format string
+
+const (
+	NDJSON Format = "ndjson"
+	CSV    Format = "csv"
+)
+
+var csvHeader = []string{"key", "value", "metadata"}
+
+// Record is a single line of an import or export stream.
+type Record struct {
+	Key      string          `json:"key"`
+	Value    json.RawMessage `json:"value"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// Summary is the report returned at the end of an import.
+type Summary struct {
+	Mode        store.ConflictMode `json:"mode"`
+	DryRun      bool               `json:"dry_run"`
+	Total       int                `json:"total"`
+	Added       int                `json:"added"`
+	Overwritten int                `json:"overwritten"`
+	Skipped     int                `json:"skipped"`
+	Failed      int                `json:"failed"`
+	Errors      []string           `json:"errors,omitempty"`
+	Aborted     string             `json:"aborted,omitempty"`
+}
+
+// ApplyFunc stores a single record and reports what happened to it.
+type ApplyFunc func(rec Record, opts store.ImportOptions) (store.ImportResult, error)
+
+// ParseFormat converts a string into a Format, defaulting to NDJSON.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case "":
+		return NDJSON, nil
+	case NDJSON, CSV:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", s)
+	}
+}
+
+// ContentType returns the MIME type used when serving the format over HTTP.
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// Writer encodes records to an underlying stream.
+type Writer interface {
+	Write(rec Record) error
+	Flush() error
+}
+
+// NewWriter returns a Writer for the given format.
+func NewWriter(w io.Writer, f Format) Writer {
+	if f == CSV {
+		return &csvWriter{w: csv.NewWriter(w)}
+	}
+	bw := bufio.NewWriter(w)
+	return &ndjsonWriter{buf: bw, enc: json.NewEncoder(bw)}
+}
+
+type ndjsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(rec Record) error {
+	return w.enc.Encode(rec)
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (w *csvWriter) Write(rec Record) error {
+	if !w.wroteHeader {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	return w.w.Write([]string{rec.Key, string(rec.Value), string(rec.Metadata)})
+}
+
+func (w *csvWriter) Flush() error {
+	if !w.wroteHeader {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// Export writes every item yielded by items to w as a record, flushes w and returns the number of
+// records written. Items are encoded as they are yielded, so an export never holds more than one in
+// memory.
+func Export(w Writer, items iter.Seq2[string, store.Item]) (int, error) {
+	n := 0
+	for key, item := range items {
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", key, err)
+		}
+		metadata, err := json.Marshal(item.Metadata)
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", key, err)
+		}
+		if err := w.Write(Record{Key: key, Value: value, Metadata: metadata}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, w.Flush()
+}
+
+// Reader decodes records from an underlying stream. Read returns io.EOF once the stream is exhausted.
+type Reader interface {
+	Read() (Record, error)
+}
+
+// NewReader returns a Reader for the given format.
+func NewReader(r io.Reader, f Format) Reader {
+	if f == CSV {
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		return &csvReader{r: cr}
+	}
+	return &ndjsonReader{dec: json.NewDecoder(r)}
+}
+
+type ndjsonReader struct {
+	dec *json.Decoder
+}
+
+func (r *ndjsonReader) Read() (Record, error) {
+	var rec Record
+	if err := r.dec.Decode(&rec); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, io.EOF
+		}
+		return Record{}, fmt.Errorf("invalid ndjson record: %w", err)
+	}
+	return rec, nil
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func (r *csvReader) Read() (Record, error) {
+	if r.columns == nil {
+		header, err := r.r.Read()
+		if err != nil {
+			return Record{}, err
+		}
+		r.columns = make(map[string]int, len(header))
+		for i, name := range header {
+			r.columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		if _, ok := r.columns["key"]; !ok {
+			return Record{}, errors.New("invalid csv header: missing key column")
+		}
+		if _, ok := r.columns["value"]; !ok {
+			return Record{}, errors.New("invalid csv header: missing value column")
+		}
+	}
+
+	row, err := r.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, io.EOF
+		}
+		return Record{}, fmt.Errorf("invalid csv record: %w", err)
+	}
+
+	return Record{
+		Key:      r.field(row, "key"),
+		Value:    json.RawMessage(r.field(row, "value")),
+		Metadata: json.RawMessage(r.field(row, "metadata")),
+	}, nil
+}
+
+func (r *csvReader) field(row []string, name string) string {
+	i, ok := r.columns[name]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// Import reads every record from src and stores it with apply, returning a summary of the outcome.
+// The metadata of a record, as Export writes it, is passed to apply in opts so its tags and expiry
+// are restored. In ConflictFail mode the first error stops the import; otherwise failures are counted
+// and skipped.
+func Import(src Reader, opts store.ImportOptions, apply ApplyFunc) (Summary, error) {
+	summary := Summary{Mode: opts.Mode, DryRun: opts.DryRun}
+	seen := make(map[string]bool) // Keys written earlier in a dry run
+
+	for {
+		rec, err := src.Read()
+		if errors.Is(err, io.EOF) {
+			return summary, nil
+		}
+		if err != nil {
+			summary.Aborted = err.Error()
+			return summary, err
+		}
+		summary.Total++
+
+		result, err := applyRecord(rec, opts, apply, seen)
+		if err != nil {
+			summary.Failed++
+			summary.Errors = append(summary.Errors, fmt.Sprintf("%s: %s", rec.Key, err))
+			if opts.Mode == store.ConflictFail {
+				summary.Aborted = err.Error()
+				return summary, err
+			}
+			continue
+		}
+
+		switch result {
+		case store.ImportAdded:
+			summary.Added++
+		case store.ImportOverwritten:
+			summary.Overwritten++
+		case store.ImportSkipped:
+			summary.Skipped++
+		}
+	}
+}
+
+func applyRecord(rec Record, opts store.ImportOptions, apply ApplyFunc, seen map[string]bool) (store.ImportResult, error) {
+	if rec.Key == "" {
+		return "", helpers.MissingKeyError
+	}
+	if len(rec.Value) == 0 {
+		return "", helpers.MissingValueError
+	}
+	if len(rec.Metadata) > 0 && string(rec.Metadata) != "null" {
+		var md store.Metadata
+		if err := json.Unmarshal(rec.Metadata, &md); err != nil {
+			return "", fmt.Errorf("%w: invalid metadata: %s", helpers.InvalidParamError, err)
+		}
+		opts.Metadata = &md
+	}
+
+	// A dry run never writes, so repeated keys in the input must be tracked here
+	if opts.DryRun && seen[rec.Key] {
+		switch opts.Mode {
+		case store.ConflictOverwrite:
+			return store.ImportOverwritten, nil
+		case store.ConflictFail:
+			return "", helpers.DuplicateKeyError
+		default:
+			return store.ImportSkipped, nil
+		}
+	}
+
+	result, err := apply(rec, opts)
+	if err != nil {
+		return "", err
+	}
+	if opts.DryRun && result != store.ImportSkipped {
+		seen[rec.Key] = true
+	}
+	return result, nil
+}
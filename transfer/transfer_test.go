@@ -0,0 +1,163 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"kvstore/helpers"
+	"kvstore/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	records := []Record{
+		{Key: "a", Value: []byte(`"one"`)},
+		{Key: "b", Value: []byte(`{"name":"Layton","tags":["x","y"]}`)},
+		{Key: "c,with comma", Value: []byte(`2`)},
+	}
+
+	for _, format := range []Format{NDJSON, CSV} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, format)
+			for _, rec := range records {
+				if err := w.Write(rec); err != nil {
+					t.Fatalf("Write() returned an error: %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() returned an error: %v", err)
+			}
+
+			r := NewReader(&buf, format)
+			for _, want := range records {
+				got, err := r.Read()
+				if err != nil {
+					t.Fatalf("Read() returned an error: %v", err)
+				}
+				if got.Key != want.Key || string(got.Value) != string(want.Value) {
+					t.Errorf("Read() = %s %s, want %s %s", got.Key, got.Value, want.Key, want.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	s := store.NewKeyValueStore()
+	s.Add("user:1", []byte(`{"name":"ada"}`))
+	s.Add("user:2", []byte(`2`))
+	s.Add("order:1", []byte(`3`))
+	snap := s.Snapshot()
+	s.Delete("user:2") // Not seen by the export, which reads the snapshot
+
+	var buf bytes.Buffer
+	n, err := Export(NewWriter(&buf, NDJSON), snap.Scan("user:"))
+	if err != nil || n != 2 {
+		t.Fatalf("Export() = %d, %v, want 2", n, err)
+	}
+	r := NewReader(&buf, NDJSON)
+	for _, want := range []string{"user:1", "user:2"} {
+		rec, err := r.Read()
+		if err != nil || rec.Key != want || len(rec.Metadata) == 0 {
+			t.Errorf("Read() = %+v, %v, want %s with its metadata", rec, err, want)
+		}
+	}
+}
+
+func TestExportImportMetadata(t *testing.T) {
+	from := store.NewKeyValueStore()
+	from.Add("a", []byte(`1`))
+	from.SetTags("a", map[string]string{"env": "prod"})
+	from.Expire("a", time.Hour)
+	from.Add("b", []byte(`2`))
+	want, _ := from.GetWithMeta("a")
+
+	for _, format := range []Format{NDJSON, CSV} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := Export(NewWriter(&buf, format), from.Snapshot().Scan("")); err != nil {
+				t.Fatalf("Export() returned an error: %v", err)
+			}
+
+			to := store.NewKeyValueStore()
+			to.Add("b", []byte(`0`))
+			to.SetTags("b", map[string]string{"stale": "yes"})
+			opts := store.ImportOptions{Mode: store.ConflictOverwrite}
+			summary, err := Import(NewReader(&buf, format), opts, func(rec Record, opts store.ImportOptions) (store.ImportResult, error) {
+				return to.Import(rec.Key, rec.Value, opts)
+			})
+			if err != nil || summary.Added != 1 || summary.Overwritten != 1 {
+				t.Fatalf("Import() = %+v, %v", summary, err)
+			}
+
+			got, _ := to.GetWithMeta("a")
+			if got.Metadata.Tags["env"] != "prod" || !got.Metadata.ExpiresAt.Equal(want.Metadata.ExpiresAt) {
+				t.Errorf("metadata after import = %+v, want the tags and expiry of %+v", got.Metadata, want.Metadata)
+			}
+			if tags, _ := to.Tags("b"); len(tags) != 0 {
+				t.Errorf("tags of an overwritten key = %v, want those exported", tags)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	input := `{"key":"a","value":1}
+{"key":"b","value":"two"}
+{"key":"a","value":3}
+{"key":"","value":4}
+`
+	existing := map[string]bool{"b": true}
+
+	tests := []struct {
+		description string
+		opts        store.ImportOptions
+		want        Summary
+		wantErr     error
+	}{
+		{
+			description: "TestSkipDryRun",
+			opts:        store.ImportOptions{Mode: store.ConflictSkip, DryRun: true},
+			want:        Summary{Total: 4, Added: 1, Skipped: 2, Failed: 1},
+		},
+		{
+			description: "TestOverwriteDryRun",
+			opts:        store.ImportOptions{Mode: store.ConflictOverwrite, DryRun: true},
+			want:        Summary{Total: 4, Added: 1, Overwritten: 2, Failed: 1},
+		},
+		{
+			description: "TestFailDryRun",
+			opts:        store.ImportOptions{Mode: store.ConflictFail, DryRun: true},
+			want:        Summary{Total: 2, Added: 1, Failed: 1},
+			wantErr:     helpers.DuplicateKeyError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			apply := func(rec Record, opts store.ImportOptions) (store.ImportResult, error) {
+				if !existing[rec.Key] {
+					return store.ImportAdded, nil
+				}
+				switch opts.Mode {
+				case store.ConflictOverwrite:
+					return store.ImportOverwritten, nil
+				case store.ConflictFail:
+					return "", helpers.DuplicateKeyError
+				}
+				return store.ImportSkipped, nil
+			}
+
+			got, err := Import(NewReader(strings.NewReader(input), NDJSON), tt.opts, apply)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Import() error = %v, want %v", err, tt.wantErr)
+			}
+			if got.Total != tt.want.Total || got.Added != tt.want.Added || got.Overwritten != tt.want.Overwritten ||
+				got.Skipped != tt.want.Skipped || got.Failed != tt.want.Failed {
+				t.Errorf("Import() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
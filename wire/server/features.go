@@ -0,0 +1,359 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/store"
+	"kvstore/transfer"
+	"kvstore/wire"
+)
+
+// The operations beyond the key space take their arguments as a wire.Args in the value and answer with
+// JSON, with the same defaults as the HTTP endpoints.
+
+// args reads the arguments of a request, which may be left out when none are needed.
+func args(req wire.Request) (wire.Args, error) {
+	var a wire.Args
+	if len(req.Value) == 0 {
+		return a, nil
+	}
+	if err := json.Unmarshal(req.Value, &a); err != nil {
+		return a, fmt.Errorf("%w: arguments: %v", wire.ErrMalformed, err)
+	}
+	return a, nil
+}
+
+// reply returns the value of resp as JSON.
+func reply(resp channels.Response) ([]byte, error) {
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return json.Marshal(resp.Value)
+}
+
+func (c *conn) softDelete(req wire.Request) ([]byte, error) {
+	return nil, channels.SoftDeleteRequest(req.Key, c.caller).Error
+}
+
+func (c *conn) softClear(req wire.Request) ([]byte, error) {
+	return reply(channels.SoftClearRequest(c.caller))
+}
+
+func (c *conn) trash(req wire.Request) ([]byte, error) {
+	return reply(channels.TrashRequest())
+}
+
+func (c *conn) restore(req wire.Request) ([]byte, error) {
+	return reply(channels.RestoreRequest(req.Key, c.caller))
+}
+
+func (c *conn) restoreClear(req wire.Request) ([]byte, error) {
+	return reply(channels.RestoreClearRequest(req.Key, c.caller))
+}
+
+func (c *conn) purge(req wire.Request) ([]byte, error) {
+	return reply(channels.PurgeRequest(req.Key, c.caller))
+}
+
+func (c *conn) tags(req wire.Request) ([]byte, error) {
+	return reply(channels.TagsRequest(req.Key))
+}
+
+func (c *conn) setTags(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.SetTagsRequest(req.Key, a.Tags, c.caller))
+}
+
+func (c *conn) removeTags(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(a.Names) == 0 {
+		return nil, fmt.Errorf("%w: no tag names provided", helpers.InvalidParamError)
+	}
+	return reply(channels.RemoveTagsRequest(req.Key, a.Names, c.caller))
+}
+
+// selector reads the tag selector of a request.
+func selector(req wire.Request) (store.TagSelector, bool, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, false, err
+	}
+	sel, err := store.ParseTagSelector(a.Selector)
+	return sel, a.Soft, err
+}
+
+func (c *conn) findByTags(req wire.Request) ([]byte, error) {
+	sel, _, err := selector(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.FindByTagsRequest(sel))
+}
+
+func (c *conn) countByTags(req wire.Request) ([]byte, error) {
+	sel, _, err := selector(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.CountByTagsRequest(sel))
+}
+
+func (c *conn) deleteByTags(req wire.Request) ([]byte, error) {
+	sel, soft, err := selector(req)
+	if err != nil {
+		return nil, err
+	}
+	resp := channels.DeleteByTagsRequest(sel, soft, c.caller)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	entries := resp.Value.([]store.Entry)
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+	}
+	return json.Marshal(keys)
+}
+
+func (c *conn) lease(req wire.Request) ([]byte, error) {
+	return reply(channels.GetLeaseRequest(req.Key))
+}
+
+func (c *conn) acquireLease(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.AcquireLeaseRequest(req.Key, a.Owner, a.TTL, a.Wait, c.caller))
+}
+
+func (c *conn) renewLease(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.RenewLeaseRequest(req.Key, a.Owner, a.TTL, c.caller))
+}
+
+func (c *conn) releaseLease(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, channels.ReleaseLeaseRequest(req.Key, a.Owner, c.caller).Error
+}
+
+// value returns the JSON value in the arguments of a request.
+func value(a wire.Args) ([]byte, error) {
+	if len(a.Value) == 0 {
+		return nil, helpers.MissingValueError
+	}
+	return a.Value, nil
+}
+
+func (c *conn) enqueue(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	v, err := value(a)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.EnqueueRequest(req.Key, v, c.caller))
+}
+
+func (c *conn) dequeue(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	if a.TTL == 0 {
+		a.TTL = store.DefaultVisibility
+	}
+	return reply(channels.DequeueRequest(req.Key, a.TTL, c.caller))
+}
+
+func (c *conn) ack(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, channels.AckRequest(req.Key, a.Receipt, c.caller).Error
+}
+
+func (c *conn) nack(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, channels.NackRequest(req.Key, a.Receipt, c.caller).Error
+}
+
+func (c *conn) queueStats(req wire.Request) ([]byte, error) {
+	return reply(channels.QueueStatsRequest(req.Key))
+}
+
+func (c *conn) streamInfo(req wire.Request) ([]byte, error) {
+	return reply(channels.StreamInfoRequest(req.Key))
+}
+
+func (c *conn) streamAdd(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	v, err := value(a)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.StreamAddRequest(req.Key, v, a.MaxLen, c.caller))
+}
+
+func (c *conn) streamRange(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.StreamRangeRequest(req.Key, or(a.Start, "-"), or(a.End, "+"), a.Count))
+}
+
+func (c *conn) streamRead(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.StreamReadRequest(req.Key, or(a.Start, "$"), a.Count, a.Wait))
+}
+
+func (c *conn) streamTail(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.StreamTailRequest(req.Key, a.Count))
+}
+
+func (c *conn) streamTrim(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.StreamTrimRequest(req.Key, a.MaxLen, c.caller))
+}
+
+func (c *conn) createGroup(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, channels.CreateGroupRequest(req.Key, a.Group, or(a.Start, "$"), c.caller).Error
+}
+
+func (c *conn) readGroup(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.ReadGroupRequest(req.Key, a.Group, a.Consumer, a.Count, c.caller))
+}
+
+func (c *conn) streamAck(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.StreamAckRequest(req.Key, a.Group, a.IDs, c.caller))
+}
+
+func (c *conn) pending(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.PendingRequest(req.Key, a.Group))
+}
+
+func (c *conn) claim(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.ClaimRequest(req.Key, a.Group, a.Consumer, a.MinIdle, a.IDs, c.caller))
+}
+
+func (c *conn) rateLimit(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	a.Limit.Algorithm, err = store.ParseRateAlgorithm(string(a.Limit.Algorithm))
+	if err != nil {
+		return nil, err
+	}
+	return reply(channels.RateLimitRequest(req.Key, a.Limit))
+}
+
+// importKey imports a single record. Clients import a file by sending its records one at a time.
+func (c *conn) importKey(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	v, err := value(a)
+	if err != nil {
+		return nil, err
+	}
+	a.Import.Mode, err = store.ParseConflictMode(string(a.Import.Mode))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", helpers.InvalidParamError, err)
+	}
+	return reply(channels.ImportRequest(req.Key, v, a.Import, c.caller))
+}
+
+// export writes every key starting with the key of the request, as of a snapshot.
+func (c *conn) export(req wire.Request) ([]byte, error) {
+	a, err := args(req)
+	if err != nil {
+		return nil, err
+	}
+	format, err := transfer.ParseFormat(string(a.Format))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", helpers.InvalidParamError, err)
+	}
+
+	var b frameBuffer
+	if _, err := transfer.Export(transfer.NewWriter(&b, format), channels.ExportRequest(req.Key)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// frameBuffer is a buffer that fails with wire.ErrFrameTooLarge once it would not fit in a frame.
+type frameBuffer struct {
+	bytes.Buffer
+}
+
+func (b *frameBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > wire.MaxFrameSize {
+		return 0, wire.ErrFrameTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+// or returns s, or def if s is empty.
+func or(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kvstore/channels"
+	"kvstore/helpers"
+	"kvstore/store"
+	"kvstore/wire"
+	"log"
+	"math"
+	"time"
+)
+
+// op is an operation the server understands.
+type op struct {
+	key   bool // Takes a key, so fails with helpers.MissingKeyError without one
+	write bool // Changes the store, so followers refuse it
+	read  bool // Reads the store, so cluster members wait for a read barrier first
+	run   func(c *conn, req wire.Request) ([]byte, error)
+}
+
+// ops are the operations by opcode.
+var ops = map[wire.Op]op{
+	wire.OpPing:         {run: (*conn).ping},
+	wire.OpGet:          {key: true, read: true, run: (*conn).get},
+	wire.OpGetWithMeta:  {key: true, read: true, run: (*conn).getWithMeta},
+	wire.OpMeta:         {key: true, read: true, run: (*conn).meta},
+	wire.OpExists:       {key: true, read: true, run: (*conn).exists},
+	wire.OpCount:        {read: true, run: (*conn).count},
+	wire.OpGetAll:       {read: true, run: (*conn).getAll},
+	wire.OpAdd:          {key: true, write: true, run: (*conn).add},
+	wire.OpUpdate:       {key: true, write: true, run: (*conn).update},
+	wire.OpUpsert:       {key: true, write: true, run: (*conn).upsert},
+	wire.OpDelete:       {key: true, write: true, run: (*conn).delete},
+	wire.OpClear:        {write: true, run: (*conn).clear},
+	wire.OpIncr:         {key: true, write: true, run: (*conn).incr},
+	wire.OpExpire:       {key: true, write: true, run: (*conn).expire},
+	wire.OpPersist:      {key: true, write: true, run: (*conn).persist},
+	wire.OpWrite:        {key: true, write: true, run: (*conn).write},
+	wire.OpSoftDelete:   {key: true, write: true, run: (*conn).softDelete},
+	wire.OpSoftClear:    {write: true, run: (*conn).softClear},
+	wire.OpTrash:        {read: true, run: (*conn).trash},
+	wire.OpRestore:      {key: true, write: true, run: (*conn).restore},
+	wire.OpRestoreClear: {key: true, write: true, run: (*conn).restoreClear},
+	wire.OpPurge:        {write: true, run: (*conn).purge},
+	wire.OpTags:         {key: true, read: true, run: (*conn).tags},
+	wire.OpSetTags:      {key: true, write: true, run: (*conn).setTags},
+	wire.OpRemoveTags:   {key: true, write: true, run: (*conn).removeTags},
+	wire.OpFindByTags:   {read: true, run: (*conn).findByTags},
+	wire.OpCountByTags:  {read: true, run: (*conn).countByTags},
+	wire.OpDeleteByTags: {write: true, run: (*conn).deleteByTags},
+	wire.OpLease:        {key: true, read: true, run: (*conn).lease},
+	wire.OpAcquireLease: {key: true, write: true, run: (*conn).acquireLease},
+	wire.OpRenewLease:   {key: true, write: true, run: (*conn).renewLease},
+	wire.OpReleaseLease: {key: true, write: true, run: (*conn).releaseLease},
+	wire.OpEnqueue:      {key: true, write: true, run: (*conn).enqueue},
+	wire.OpDequeue:      {key: true, write: true, run: (*conn).dequeue},
+	wire.OpAck:          {key: true, write: true, run: (*conn).ack},
+	wire.OpNack:         {key: true, write: true, run: (*conn).nack},
+	wire.OpQueueStats:   {read: true, run: (*conn).queueStats},
+	wire.OpStreamInfo:   {key: true, read: true, run: (*conn).streamInfo},
+	wire.OpStreamAdd:    {key: true, write: true, run: (*conn).streamAdd},
+	wire.OpStreamRange:  {key: true, read: true, run: (*conn).streamRange},
+	wire.OpStreamRead:   {key: true, read: true, run: (*conn).streamRead},
+	wire.OpStreamTail:   {key: true, read: true, run: (*conn).streamTail},
+	wire.OpStreamTrim:   {key: true, write: true, run: (*conn).streamTrim},
+	wire.OpCreateGroup:  {key: true, write: true, run: (*conn).createGroup},
+	wire.OpReadGroup:    {key: true, write: true, run: (*conn).readGroup},
+	wire.OpStreamAck:    {key: true, write: true, run: (*conn).streamAck},
+	wire.OpPending:      {key: true, read: true, run: (*conn).pending},
+	wire.OpClaim:        {key: true, write: true, run: (*conn).claim},
+	wire.OpRateLimit:    {key: true, write: true, run: (*conn).rateLimit},
+	wire.OpImport:       {key: true, write: true, run: (*conn).importKey},
+	wire.OpExport:       {read: true, run: (*conn).export},
+}
+
+// exec runs a request and returns its response.
+func (c *conn) exec(req wire.Request) wire.Response {
+	value, status, err := c.run(req)
+	if err != nil {
+		if status == wire.StatusInternal {
+			log.Printf("Wire Error: %s %s: %s", req.Op, req.Key, err)
+		}
+		return wire.Response{ID: req.ID, Status: status, Value: []byte(err.Error())}
+	}
+	return wire.Response{ID: req.ID, Status: wire.StatusOK, Value: value}
+}
+
+func (c *conn) run(req wire.Request) ([]byte, wire.Status, error) {
+	o, ok := ops[req.Op]
+	if !ok {
+		return nil, wire.StatusUnknownOp, fmt.Errorf("unknown operation %s", req.Op)
+	}
+	if o.key && req.Key == "" {
+		return nil, wire.StatusMissingKey, helpers.MissingKeyError
+	}
+
+	if err := channels.Admit(context.Background(), o.write, o.read); err != nil {
+		return nil, wire.StatusOf(err), err
+	}
+
+	value, err := o.run(c, req)
+	switch {
+	case errors.Is(err, wire.ErrMalformed):
+		return nil, wire.StatusBadRequest, err
+	case err != nil:
+		return nil, wire.StatusOf(err), err
+	}
+	return value, wire.StatusOK, nil
+}
+
+// encodeValue returns a value read from the store as the request asked for it.
+func encodeValue(req wire.Request, value any) ([]byte, error) {
+	if req.Flags&wire.FlagRaw != 0 {
+		return store.DecodeBytes(value), nil
+	}
+	return json.Marshal(value)
+}
+
+// decodeValue returns the JSON document the store keeps for the value of a request.
+func decodeValue(req wire.Request) ([]byte, error) {
+	switch {
+	case req.Flags&wire.FlagRaw != 0:
+		return store.EncodeBytes(req.Value), nil
+	case len(req.Value) == 0:
+		return nil, helpers.MissingValueError
+	case !json.Valid(req.Value):
+		return nil, fmt.Errorf("%w: value is not JSON", helpers.InvalidParamError)
+	}
+	return req.Value, nil
+}
+
+func boolByte(ok bool) []byte {
+	if ok {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func (c *conn) ping(req wire.Request) ([]byte, error) {
+	return []byte("kvstore"), nil
+}
+
+func (c *conn) get(req wire.Request) ([]byte, error) {
+	resp := channels.GetRequest(req.Key)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return encodeValue(req, resp.Value)
+}
+
+func (c *conn) getWithMeta(req wire.Request) ([]byte, error) {
+	resp := channels.GetWithMetaRequest(req.Key)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	item := resp.Value.(store.Item)
+	md, err := json.Marshal(item.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	value, err := encodeValue(req, item.Value)
+	if err != nil {
+		return nil, err
+	}
+	b := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(md)+len(value)), uint32(len(md)))
+	return append(append(b, md...), value...), nil
+}
+
+func (c *conn) meta(req wire.Request) ([]byte, error) {
+	resp := channels.MetaRequest(req.Key)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return json.Marshal(resp.Value)
+}
+
+func (c *conn) exists(req wire.Request) ([]byte, error) {
+	resp := channels.ExistsRequest(req.Key)
+	switch {
+	case errors.Is(resp.Error, helpers.NotExistError):
+		return boolByte(false), nil
+	case resp.Error != nil:
+		return nil, resp.Error
+	}
+	return boolByte(true), nil
+}
+
+func (c *conn) count(req wire.Request) ([]byte, error) {
+	resp := channels.CountRequest()
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return binary.BigEndian.AppendUint64(nil, uint64(resp.Value.(int))), nil
+}
+
+// getAll returns every key and value, as of a snapshot.
+func (c *conn) getAll(req wire.Request) ([]byte, error) {
+	var b []byte
+	for key, value := range channels.SnapshotRequest().Values() {
+		v, err := encodeValue(req, value)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) > math.MaxUint16 {
+			return nil, fmt.Errorf("%w: key %.32q... is too long for the protocol", helpers.InvalidParamError, key)
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(len(key)))
+		b = append(b, key...)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+		b = append(b, v...)
+		if len(b) > wire.MaxFrameSize {
+			return nil, wire.ErrFrameTooLarge
+		}
+	}
+	return b, nil
+}
+
+func (c *conn) add(req wire.Request) ([]byte, error) {
+	return c.put(req, channels.AddRequest)
+}
+
+func (c *conn) update(req wire.Request) ([]byte, error) {
+	return c.put(req, channels.UpdateRequest)
+}
+
+func (c *conn) upsert(req wire.Request) ([]byte, error) {
+	return c.put(req, channels.UpsertRequest)
+}
+
+func (c *conn) put(req wire.Request, send func(string, []byte, channels.Caller) channels.Response) ([]byte, error) {
+	value, err := decodeValue(req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, send(req.Key, value, c.caller).Error
+}
+
+func (c *conn) delete(req wire.Request) ([]byte, error) {
+	return nil, channels.DeleteRequest(req.Key, c.caller).Error
+}
+
+func (c *conn) clear(req wire.Request) ([]byte, error) {
+	return nil, channels.ClearRequest(c.caller).Error
+}
+
+func (c *conn) incr(req wire.Request) ([]byte, error) {
+	delta, err := wire.Int64(req.Extras)
+	if err != nil {
+		return nil, err
+	}
+	resp := channels.IncrRequest(req.Key, delta, c.caller)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return wire.AppendInt64(nil, resp.Value.(int64)), nil
+}
+
+func (c *conn) expire(req wire.Request) ([]byte, error) {
+	ttl, err := wire.Int64(req.Extras)
+	if err != nil {
+		return nil, err
+	}
+	return nil, channels.ExpireRequest(req.Key, time.Duration(ttl), c.caller).Error
+}
+
+func (c *conn) persist(req wire.Request) ([]byte, error) {
+	resp := channels.PersistRequest(req.Key, c.caller)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return boolByte(resp.Value.(bool)), nil
+}
+
+func (c *conn) write(req wire.Request) ([]byte, error) {
+	opts, err := wire.WriteOptions(req.Extras)
+	if err != nil {
+		return nil, err
+	}
+	value, err := decodeValue(req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, channels.WriteRequest(req.Key, value, opts, c.caller).Error
+}
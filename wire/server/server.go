@@ -0,0 +1,186 @@
+// Package server serves the key/value store over the binary protocol of package wire. Requests go
+// through the request loop like the HTTP handlers, so both see the same keys.
+//
+// The requests of a connection are run concurrently, up to MaxInFlight at once, and their responses
+// are written as they complete, so a slow request does not hold up the others. Responses completed
+// together are sent together.
+package server
+
+import (
+	"bufio"
+	"errors"
+	"kvstore/channels"
+	"kvstore/wire"
+	"log"
+	"net"
+	"sync"
+)
+
+// MaxInFlight caps the requests of a connection being run at once. Once it is reached the server
+// reads no further requests from the connection until one completes.
+const MaxInFlight = 256
+
+// ErrServerClosed is returned by Serve and ListenAndServe once Close has been called.
+var ErrServerClosed = errors.New("wire: server closed")
+
+// Server accepts connections speaking the binary protocol, serving each on its own goroutines.
+type Server struct {
+	Addr string // Address to listen on, wire.DefaultAddr if empty
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// ListenAndServe listens on s.Addr and serves connections until Close is called.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = wire.DefaultAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the connections accepted by l until Close is called. It closes l when it returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+	defer l.Close()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nc, true) {
+			nc.Close()
+			continue
+		}
+		go s.serve(nc)
+	}
+}
+
+// Close stops the server, closing its listener and every connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for nc := range s.conns {
+		nc.Close()
+	}
+	clear(s.conns)
+	return err
+}
+
+// track adds or removes a connection, reporting false if one is added after Close.
+func (s *Server) track(nc net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.conns, nc)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[nc] = struct{}{}
+	return true
+}
+
+// conn is a client connection.
+type conn struct {
+	caller    channels.Caller
+	responses chan wire.Response // Completed requests, written by writeLoop
+	inFlight  chan struct{}      // Holds a token for each request being run
+}
+
+func (s *Server) serve(nc net.Conn) {
+	defer s.track(nc, false)
+	defer nc.Close()
+
+	c := &conn{
+		caller:    channels.Caller{Addr: nc.RemoteAddr().String()},
+		responses: make(chan wire.Response, MaxInFlight),
+		inFlight:  make(chan struct{}, MaxInFlight),
+	}
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		c.writeLoop(nc)
+	}()
+
+	var running sync.WaitGroup
+	r := bufio.NewReader(nc)
+	for {
+		req, err := wire.ReadRequest(r)
+		if err != nil {
+			if errors.Is(err, wire.ErrFrameTooLarge) || errors.Is(err, wire.ErrMalformed) {
+				log.Printf("Wire Error: %s: %s", c.caller.Addr, err)
+			}
+			break
+		}
+		c.inFlight <- struct{}{}
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			c.responses <- c.exec(req)
+			<-c.inFlight
+		}()
+	}
+
+	// Requests already read are answered before the connection is closed, if it can still be written
+	running.Wait()
+	close(c.responses)
+	<-written
+}
+
+// writeLoop writes responses as they complete, flushing once none are waiting. After a write fails it
+// drops the responses left, so the requests still running can finish.
+func (c *conn) writeLoop(nc net.Conn) {
+	w := bufio.NewWriter(nc)
+	var buf []byte
+	var failed bool
+	for resp := range c.responses {
+		if failed {
+			continue
+		}
+		buf = resp.Append(buf[:0])
+		if _, err := w.Write(buf); err != nil {
+			failed = true
+			nc.Close()
+			continue
+		}
+		if len(c.responses) == 0 {
+			if err := w.Flush(); err != nil {
+				failed = true
+				nc.Close()
+			}
+		}
+	}
+}
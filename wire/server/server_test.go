@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bufio"
+	"kvstore/channels"
+	"kvstore/wire"
+	"net"
+	"os"
+	"testing"
+)
+
+var addr string
+
+// TestMain serves the global store, through the request loop, on a free port.
+func TestMain(m *testing.M) {
+	go channels.Requests()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	srv := &Server{}
+	go srv.Serve(l)
+	addr = l.Addr().String()
+
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
+
+// TestRaw writes requests in a single write and checks each is answered under its own ID. The requests
+// run concurrently, so none depends on another.
+func TestRaw(t *testing.T) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+
+	upsert := wire.Request{ID: 1, Op: wire.OpUpsert, Key: "raw:k", Value: []byte(`{"a":1}`)}
+	nc.Write(upsert.Append(nil))
+	if resp, err := wire.ReadResponse(r); err != nil || resp.ID != 1 || resp.Status != wire.StatusOK {
+		t.Fatalf("upsert = %+v, %v", resp, err)
+	}
+
+	reqs := []struct {
+		req  wire.Request
+		want wire.Status
+	}{
+		{wire.Request{ID: 2, Op: wire.OpGet, Key: "raw:k"}, wire.StatusOK},
+		{wire.Request{ID: 3, Op: wire.OpGet, Key: "raw:missing"}, wire.StatusNotExist},
+		{wire.Request{ID: 4, Op: wire.OpGet}, wire.StatusMissingKey},
+		{wire.Request{ID: 5, Op: wire.OpUpsert, Key: "raw:k", Value: []byte(`{`)}, wire.StatusInvalidParam},
+		{wire.Request{ID: 6, Op: wire.OpUpsert, Key: "raw:k"}, wire.StatusMissingValue},
+		{wire.Request{ID: 7, Op: wire.OpIncr, Key: "raw:n", Extras: []byte{1}}, wire.StatusBadRequest},
+		{wire.Request{ID: 8, Op: 200}, wire.StatusUnknownOp},
+		{wire.Request{ID: 9, Op: wire.OpAdd, Key: "raw:k", Value: []byte(`1`)}, wire.StatusDuplicateKey},
+		{wire.Request{ID: 10, Op: wire.OpDequeue, Key: "raw:empty"}, wire.StatusQueueEmpty},
+		{wire.Request{ID: 11, Op: wire.OpEnqueue, Key: "raw:q", Value: []byte(`{"value":`)}, wire.StatusBadRequest},
+		{wire.Request{ID: 12, Op: wire.OpEnqueue, Key: "raw:q", Value: []byte(`{}`)}, wire.StatusMissingValue},
+		{wire.Request{ID: 13, Op: wire.OpAcquireLease}, wire.StatusMissingKey},
+		{wire.Request{ID: 14, Op: wire.OpFindByTags, Value: []byte(`{"selector":"="}`)}, wire.StatusInvalidParam},
+	}
+	var b []byte
+	for _, r := range reqs {
+		b = r.req.Append(b)
+	}
+	if _, err := nc.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[uint32]wire.Response)
+	for range reqs {
+		resp, err := wire.ReadResponse(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[resp.ID] = resp
+	}
+	for _, r := range reqs {
+		if resp := got[r.req.ID]; resp.Status != r.want {
+			t.Errorf("%s %q status = %d (%s), want %d", r.req.Op, r.req.Key, resp.Status, resp.Value, r.want)
+		}
+	}
+	if v := got[2].Value; string(v) != `{"a":1}` {
+		t.Errorf("get value = %s, want {\"a\":1}", v)
+	}
+}
@@ -0,0 +1,399 @@
+// Package wire is the binary protocol of the store, for clients to which the cost of JSON over HTTP
+// matters, such as those reading and writing many small keys. It is spoken over a single TCP
+// connection, on which a client may have any number of requests in flight at once.
+//
+// Every frame starts with the length of the rest of the frame, and every number is big-endian:
+//
+//	request:  length uint32 | id uint32 | op uint8 | flags uint8 | extras length uint8 | key length uint16 | extras | key | value
+//	response: length uint32 | id uint32 | status uint8 | value
+//
+// The server answers each request with a response carrying its ID. Requests on one connection are
+// run concurrently, and their responses may come back in any order. Keys and values are bytes: a value
+// is a JSON document unless the request has FlagRaw, and the extras carry the arguments of an
+// operation beyond its key and value, such as the delta of OpIncr.
+//
+// The operations on the key space have compact layouts of their own. The others, on the trash, tags,
+// leases, queues, streams and rate limits, and import and export, are less sensitive to their cost:
+// they take their arguments as an Args JSON object in the value and answer with JSON, as the HTTP
+// endpoint of the same operation does.
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kvstore/helpers"
+	"kvstore/store"
+	"kvstore/transfer"
+	"math"
+	"time"
+)
+
+const (
+	// DefaultAddr is the address the server listens on.
+	DefaultAddr = ":7070"
+
+	// MaxFrameSize caps the length of a frame.
+	MaxFrameSize = 64 << 20
+
+	requestHeader  = 4 + 1 + 1 + 1 + 2 // id, op, flags, extras length, key length
+	responseHeader = 4 + 1             // id, status
+)
+
+var (
+	// ErrFrameTooLarge is returned when a frame is longer than MaxFrameSize.
+	ErrFrameTooLarge = errors.New("wire: frame too large")
+
+	// ErrMalformed is returned when a frame is shorter than the lengths it holds.
+	ErrMalformed = errors.New("wire: malformed frame")
+)
+
+// Op is the operation of a request.
+type Op uint8
+
+// The operations and what their responses hold. Values are sent and returned as described by Flags.
+const (
+	OpPing        Op = iota + 1 // The name of the server
+	OpGet                       // The value of the key
+	OpGetWithMeta               // The metadata of the key as JSON, prefixed by its uint32 length, then the value
+	OpMeta                      // The metadata of the key as JSON
+	OpExists                    // One byte, 1 if the key exists and 0 if not
+	OpCount                     // The number of keys, a uint64
+	OpGetAll                    // Every key and value: key length uint16, key, value length uint32, value
+	OpAdd                       // Nothing; fails with helpers.DuplicateKeyError if the key exists
+	OpUpdate                    // Nothing; fails with helpers.NotExistError unless the key exists
+	OpUpsert                    // Nothing
+	OpDelete                    // Nothing
+	OpClear                     // Nothing
+	OpIncr                      // The result, an int64; the extras hold the delta, an int64
+	OpExpire                    // Nothing; the extras hold the time to live in nanoseconds, an int64
+	OpPersist                   // One byte, 1 if the key had an expiry
+	OpWrite                     // Nothing; the extras hold the store.WriteOptions, see AppendWriteOptions
+
+	// The key of these is the key, lease, queue or stream the operation is on, and the value holds the
+	// Args named, if any. They answer with JSON.
+	OpSoftDelete   // Nothing
+	OpSoftClear    // The ID that restores every key cleared, a string; takes no key
+	OpTrash        // The store.TrashEntry list; takes no key
+	OpRestore      // The value of the key restored
+	OpRestoreClear // The store.RestoreResult; the key is the ID of the soft clear
+	OpPurge        // The number of keys purged, the whole trash if there is no key
+	OpTags         // The tags of the key
+	OpSetTags      // Every tag of the key; takes Tags
+	OpRemoveTags   // The tags left; takes Names
+	OpFindByTags   // The keys matching Selector; takes no key
+	OpCountByTags  // The number of keys matching Selector; takes no key
+	OpDeleteByTags // The keys deleted; takes Selector and Soft, and no key
+	OpLease        // The store.Lease
+	OpAcquireLease // The store.Lease; takes Owner, TTL and Wait
+	OpRenewLease   // The store.Lease; takes Owner and TTL
+	OpReleaseLease // Nothing; takes Owner
+	OpEnqueue      // The store.Message; takes Value
+	OpDequeue      // The store.Message; takes TTL, the visibility timeout, store.DefaultVisibility if zero
+	OpAck          // Nothing; takes Receipt
+	OpNack         // Nothing; takes Receipt
+	OpQueueStats   // The store.QueueStats, or a list of those of every queue if there is no key
+	OpStreamInfo   // The store.StreamInfo
+	OpStreamAdd    // The store.StreamEntry; takes Value and MaxLen
+	OpStreamRange  // The store.StreamEntry list; takes Start, End and Count
+	OpStreamRead   // The store.StreamEntry list; takes Start, the ID to read after, Count and Wait
+	OpStreamTail   // The store.StreamEntry list; takes Count
+	OpStreamTrim   // The number of entries dropped; takes MaxLen
+	OpCreateGroup  // Nothing; takes Group and Start
+	OpReadGroup    // The store.StreamEntry list; takes Group, Consumer and Count
+	OpStreamAck    // The number of entries acknowledged; takes Group and IDs
+	OpPending      // The store.PendingEntry list; takes Group
+	OpClaim        // The store.StreamEntry list; takes Group, Consumer, MinIdle and IDs
+	OpRateLimit    // The store.RateLimitResult, which is not an error when denied; takes Limit
+	OpImport       // The store.ImportResult; takes Value and Import
+	OpExport       // Every key starting with the key, as package transfer writes them; takes Format
+)
+
+var opNames = [...]string{
+	OpPing: "ping", OpGet: "get", OpGetWithMeta: "get_with_meta", OpMeta: "meta", OpExists: "exists",
+	OpCount: "count", OpGetAll: "get_all", OpAdd: "add", OpUpdate: "update", OpUpsert: "upsert",
+	OpDelete: "delete", OpClear: "clear", OpIncr: "incr", OpExpire: "expire", OpPersist: "persist",
+	OpWrite: "write", OpSoftDelete: "soft_delete", OpSoftClear: "soft_clear", OpTrash: "trash",
+	OpRestore: "restore", OpRestoreClear: "restore_clear", OpPurge: "purge", OpTags: "tags",
+	OpSetTags: "set_tags", OpRemoveTags: "remove_tags", OpFindByTags: "find_by_tags",
+	OpCountByTags: "count_by_tags", OpDeleteByTags: "delete_by_tags", OpLease: "lease",
+	OpAcquireLease: "acquire_lease", OpRenewLease: "renew_lease", OpReleaseLease: "release_lease",
+	OpEnqueue: "enqueue", OpDequeue: "dequeue", OpAck: "ack", OpNack: "nack", OpQueueStats: "queue_stats",
+	OpStreamInfo: "stream_info", OpStreamAdd: "stream_add", OpStreamRange: "stream_range",
+	OpStreamRead: "stream_read", OpStreamTail: "stream_tail", OpStreamTrim: "stream_trim",
+	OpCreateGroup: "create_group", OpReadGroup: "read_group", OpStreamAck: "stream_ack",
+	OpPending: "pending", OpClaim: "claim", OpRateLimit: "rate_limit", OpImport: "import",
+	OpExport: "export",
+}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) && opNames[op] != "" {
+		return opNames[op]
+	}
+	return fmt.Sprintf("op(%d)", uint8(op))
+}
+
+// Flags change how a request is served.
+type Flags uint8
+
+const (
+	// FlagRaw sends values as raw bytes rather than JSON. They are kept as store.EncodeBytes keeps
+	// them, the same as values set through the Redis and memcached protocols.
+	FlagRaw Flags = 1 << iota
+)
+
+// Status is the outcome of a request. The value of a response that is not StatusOK holds a message.
+type Status uint8
+
+const (
+	StatusOK Status = iota
+	StatusNotExist
+	StatusDuplicateKey
+	StatusRevisionMismatch
+	StatusMissingKey
+	StatusMissingValue
+	StatusInvalidParam
+	StatusNotLeader
+	StatusReadOnly   // The server is a follower, which takes no writes
+	StatusUnknownOp  // The server does not know the operation
+	StatusBadRequest // The extras or value do not fit the operation
+	StatusInternal
+	StatusLeaseHeld
+	StatusNotLeaseOwner
+	StatusQueueEmpty
+	StatusInvalidReceipt
+)
+
+// statusErrors are the errors of the helpers package that statuses stand for.
+var statusErrors = map[Status]error{
+	StatusNotExist:         helpers.NotExistError,
+	StatusDuplicateKey:     helpers.DuplicateKeyError,
+	StatusRevisionMismatch: helpers.RevisionMismatchError,
+	StatusMissingKey:       helpers.MissingKeyError,
+	StatusMissingValue:     helpers.MissingValueError,
+	StatusInvalidParam:     helpers.InvalidParamError,
+	StatusNotLeader:        helpers.NotLeaderError,
+	StatusReadOnly:         helpers.ReadOnlyError,
+	StatusLeaseHeld:        helpers.LeaseHeldError,
+	StatusNotLeaseOwner:    helpers.NotLeaseOwnerError,
+	StatusQueueEmpty:       helpers.QueueEmptyError,
+	StatusInvalidReceipt:   helpers.InvalidReceiptError,
+}
+
+// StatusOf returns the status a server answers err with.
+func StatusOf(err error) Status {
+	if err == nil {
+		return StatusOK
+	}
+	for status, sentinel := range statusErrors {
+		if errors.Is(err, sentinel) {
+			return status
+		}
+	}
+	return StatusInternal
+}
+
+// Error is a response that was not StatusOK. It unwraps to the error of the helpers package its
+// status stands for, so callers can test for it with errors.Is.
+type Error struct {
+	Status  Status
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "kvstore: " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return statusErrors[e.Status]
+}
+
+// Args are the arguments of the operations beyond the key space, sent as a JSON object in the value.
+// Each operation reads the fields its comment names, and durations are in nanoseconds.
+type Args struct {
+	Value    json.RawMessage     `json:"value,omitempty"` // The JSON value enqueued, added or imported
+	Owner    string              `json:"owner,omitempty"`
+	TTL      time.Duration       `json:"ttl,omitempty"`
+	Wait     time.Duration       `json:"wait,omitempty"` // How long to block for a lease or new entries
+	Receipt  string              `json:"receipt,omitempty"`
+	Tags     map[string]string   `json:"tags,omitempty"`
+	Names    []string            `json:"names,omitempty"`
+	Selector string              `json:"selector,omitempty"` // As store.ParseTagSelector reads it
+	Soft     bool                `json:"soft,omitempty"`
+	Group    string              `json:"group,omitempty"`
+	Consumer string              `json:"consumer,omitempty"`
+	Start    string              `json:"start,omitempty"`
+	End      string              `json:"end,omitempty"`
+	Count    int                 `json:"count,omitempty"`
+	MaxLen   int                 `json:"max_len,omitempty"`
+	MinIdle  time.Duration       `json:"min_idle,omitempty"`
+	IDs      []string            `json:"ids,omitempty"`
+	Limit    store.RateLimit     `json:"limit,omitzero"`
+	Import   store.ImportOptions `json:"import,omitzero"`
+	Format   transfer.Format     `json:"format,omitempty"` // transfer.NDJSON if empty
+}
+
+// Request is a request frame.
+type Request struct {
+	ID     uint32
+	Op     Op
+	Flags  Flags
+	Extras []byte // At most 255 bytes
+	Key    string // At most 65535 bytes
+	Value  []byte
+}
+
+// Append appends the frame of r to b.
+func (r *Request) Append(b []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(requestHeader+len(r.Extras)+len(r.Key)+len(r.Value)))
+	b = binary.BigEndian.AppendUint32(b, r.ID)
+	b = append(b, byte(r.Op), byte(r.Flags), byte(len(r.Extras)))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(r.Key)))
+	b = append(b, r.Extras...)
+	b = append(b, r.Key...)
+	return append(b, r.Value...)
+}
+
+// Validate reports whether r fits in a frame.
+func (r *Request) Validate() error {
+	switch {
+	case len(r.Extras) > math.MaxUint8:
+		return fmt.Errorf("%w: extras longer than %d bytes", helpers.InvalidParamError, math.MaxUint8)
+	case len(r.Key) > math.MaxUint16:
+		return fmt.Errorf("%w: key longer than %d bytes", helpers.InvalidParamError, math.MaxUint16)
+	case requestHeader+len(r.Extras)+len(r.Key)+len(r.Value) > MaxFrameSize:
+		return ErrFrameTooLarge
+	}
+	return nil
+}
+
+// ReadRequest reads a request frame.
+func ReadRequest(r *bufio.Reader) (Request, error) {
+	b, err := readFrame(r, requestHeader)
+	if err != nil {
+		return Request{}, err
+	}
+	req := Request{
+		ID:    binary.BigEndian.Uint32(b),
+		Op:    Op(b[4]),
+		Flags: Flags(b[5]),
+	}
+	extras, key := int(b[6]), int(binary.BigEndian.Uint16(b[7:]))
+	b = b[requestHeader:]
+	if len(b) < extras+key {
+		return Request{}, ErrMalformed
+	}
+	req.Extras, req.Key, req.Value = b[:extras], string(b[extras:extras+key]), b[extras+key:]
+	return req, nil
+}
+
+// Response is a response frame.
+type Response struct {
+	ID     uint32
+	Status Status
+	Value  []byte
+}
+
+// Append appends the frame of r to b.
+func (r *Response) Append(b []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(responseHeader+len(r.Value)))
+	b = binary.BigEndian.AppendUint32(b, r.ID)
+	b = append(b, byte(r.Status))
+	return append(b, r.Value...)
+}
+
+// Err returns the error of r, nil for StatusOK.
+func (r *Response) Err() error {
+	if r.Status == StatusOK {
+		return nil
+	}
+	return &Error{Status: r.Status, Message: string(r.Value)}
+}
+
+// ReadResponse reads a response frame.
+func ReadResponse(r *bufio.Reader) (Response, error) {
+	b, err := readFrame(r, responseHeader)
+	if err != nil {
+		return Response{}, err
+	}
+	return Response{ID: binary.BigEndian.Uint32(b), Status: Status(b[4]), Value: b[responseHeader:]}, nil
+}
+
+// readFrame reads a frame of at least header bytes, without its length.
+func readFrame(r *bufio.Reader, header int) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	switch {
+	case n > MaxFrameSize:
+		return nil, ErrFrameTooLarge
+	case n < uint32(header):
+		return nil, ErrMalformed
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// AppendInt64 appends n, as the extras of OpIncr and OpExpire and the response of OpIncr hold it.
+func AppendInt64(b []byte, n int64) []byte {
+	return binary.BigEndian.AppendUint64(b, uint64(n))
+}
+
+// Int64 reads an int64 written by AppendInt64.
+func Int64(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, ErrMalformed
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+// The conditions of a write, as the first byte of the extras of OpWrite.
+const (
+	writeIfMissing = 1 << iota
+	writeIfExists
+	writeKeepTTL
+)
+
+// writeOptionsSize is the size of the extras of OpWrite: conditions, revision, time to live and flags.
+const writeOptionsSize = 1 + 8 + 8 + 4
+
+// AppendWriteOptions appends opts as the extras of OpWrite hold them.
+func AppendWriteOptions(b []byte, opts store.WriteOptions) []byte {
+	var cond byte
+	if opts.IfMissing {
+		cond |= writeIfMissing
+	}
+	if opts.IfExists {
+		cond |= writeIfExists
+	}
+	if opts.KeepTTL {
+		cond |= writeKeepTTL
+	}
+	b = append(b, cond)
+	b = binary.BigEndian.AppendUint64(b, opts.Revision)
+	b = binary.BigEndian.AppendUint64(b, uint64(opts.TTL))
+	return binary.BigEndian.AppendUint32(b, opts.Flags)
+}
+
+// WriteOptions reads the options written by AppendWriteOptions.
+func WriteOptions(b []byte) (store.WriteOptions, error) {
+	if len(b) != writeOptionsSize {
+		return store.WriteOptions{}, ErrMalformed
+	}
+	return store.WriteOptions{
+		IfMissing: b[0]&writeIfMissing != 0,
+		IfExists:  b[0]&writeIfExists != 0,
+		KeepTTL:   b[0]&writeKeepTTL != 0,
+		Revision:  binary.BigEndian.Uint64(b[1:]),
+		TTL:       time.Duration(binary.BigEndian.Uint64(b[9:])),
+		Flags:     binary.BigEndian.Uint32(b[17:]),
+	}, nil
+}
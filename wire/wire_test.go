@@ -0,0 +1,77 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"kvstore/helpers"
+	"kvstore/store"
+	"testing"
+	"time"
+)
+
+func TestFrames(t *testing.T) {
+	reqs := []Request{
+		{ID: 1, Op: OpGet, Key: "key"},
+		{ID: 2, Op: OpUpsert, Flags: FlagRaw, Key: "a\x00b", Value: []byte("\xff\r\n")},
+		{ID: 3, Op: OpIncr, Extras: AppendInt64(nil, -5), Key: "n"},
+		{ID: 4, Op: OpCount},
+	}
+	var b []byte
+	for _, req := range reqs {
+		b = req.Append(b)
+	}
+	r := bufio.NewReader(bytes.NewReader(b))
+	for _, want := range reqs {
+		got, err := ReadRequest(r)
+		if err != nil || got.ID != want.ID || got.Op != want.Op || got.Flags != want.Flags || got.Key != want.Key ||
+			!bytes.Equal(got.Extras, want.Extras) || !bytes.Equal(got.Value, want.Value) {
+			t.Errorf("ReadRequest() = %+v, %v, want %+v", got, err, want)
+		}
+	}
+
+	resp := Response{ID: 9, Status: StatusNotExist, Value: []byte("key not found")}
+	got, err := ReadResponse(bufio.NewReader(bytes.NewReader(resp.Append(nil))))
+	if err != nil || got.ID != 9 || !errors.Is(got.Err(), helpers.NotExistError) {
+		t.Errorf("ReadResponse() = %+v, %v, want a key not found", got, err)
+	}
+}
+
+func TestMalformedFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  error
+	}{
+		{"too large", []byte{0xff, 0xff, 0xff, 0xff}, ErrFrameTooLarge},
+		{"shorter than a header", []byte{0, 0, 0, 2, 0, 0}, ErrMalformed},
+		{"key past the end", []byte{0, 0, 0, 9, 0, 0, 0, 1, byte(OpGet), 0, 0, 0, 5}, ErrMalformed},
+	}
+	for _, tt := range tests {
+		if _, err := ReadRequest(bufio.NewReader(bytes.NewReader(tt.frame))); !errors.Is(err, tt.want) {
+			t.Errorf("ReadRequest() of a frame %s error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWriteOptions(t *testing.T) {
+	want := store.WriteOptions{IfExists: true, KeepTTL: true, Revision: 42, TTL: -time.Second, Flags: 7}
+	got, err := WriteOptions(AppendWriteOptions(nil, want))
+	if err != nil || got != want {
+		t.Errorf("WriteOptions() = %+v, %v, want %+v", got, err, want)
+	}
+	if _, err := WriteOptions([]byte{1}); !errors.Is(err, ErrMalformed) {
+		t.Errorf("WriteOptions() of a short buffer error = %v, want %v", err, ErrMalformed)
+	}
+}
+
+func TestStatusOf(t *testing.T) {
+	for status, err := range statusErrors {
+		if got := StatusOf(errors.Join(errors.New("wrapped"), err)); got != status {
+			t.Errorf("StatusOf(%v) = %d, want %d", err, got, status)
+		}
+	}
+	if got := StatusOf(errors.New("other")); got != StatusInternal {
+		t.Errorf("StatusOf() of another error = %d, want %d", got, StatusInternal)
+	}
+}